@@ -0,0 +1,50 @@
+package pigeon
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrAcceptRateLimited 超出接入速率，连接被推迟.
+var ErrAcceptRateLimited = errors.New("accept rate limit exceeded")
+
+// 新建接入限流器，未配置时返回nil
+func newAcceptLimiter(conf *Config) *TokenBucket {
+	if conf.AcceptRate <= 0 {
+		return nil
+	}
+	burst := conf.AcceptBurst
+	if burst <= 0 {
+		burst = int(math.Ceil(conf.AcceptRate))
+	}
+	return newTokenBucket(conf.AcceptRate, burst, newClock(conf))
+}
+
+// 接入限流，超出速率时响应503并在Retry-After中给出带抖动的重试时间，将重连分散到更长的时间窗口
+func (p *Pigeon) throttleAccept(w http.ResponseWriter) error {
+	if p.acceptLimiter == nil {
+		return nil
+	}
+	ok, wait := p.acceptLimiter.Reserve()
+	if ok {
+		return nil
+	}
+	atomic.AddUint64(&p.counters.acceptDeferred, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter(wait)))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	return ErrAcceptRateLimited
+}
+
+// 计算重试秒数，在等待时间的1到2倍之间随机取值，至少1秒
+func retryAfter(wait time.Duration) int {
+	if wait < time.Second {
+		wait = time.Second
+	}
+	wait += time.Duration(rand.Int63n(int64(wait)))
+	return int(math.Ceil(wait.Seconds()))
+}
@@ -0,0 +1,196 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// AdminHandler 运维管理接口，需由调用方自行鉴权后挂载.
+//
+//	GET  {prefix}/stats                       运行统计
+//	GET  {prefix}/rooms                       各房间的运行统计，需开启Config.RoomMetrics
+//	GET  {prefix}/hub                         hub事件循环的统计，需开启Config.HubMetrics
+//	GET  {prefix}/sessions/top?by=bytes_in&n=10 按指标排序的会话用量，by取值见Metric
+//	GET  {prefix}/sessions/rooms?id=xxx       会话ID或身份所在的房间
+//	POST {prefix}/sessions/close?id=xxx       关闭会话
+//	POST {prefix}/sessions/close-where?code=4000&reason=x 关闭满足请求体中Where谓词的会话，返回关闭的数量
+//	POST {prefix}/compact                     立即压缩历史记录，返回各压缩器释放的条数和字节数
+//	GET  {prefix}/locks                       各类锁的争用统计，需开启Config.LockProfiling
+//	POST {prefix}/locks/reset                 清零锁的争用统计
+//	GET  {prefix}/rooms/members?room=x        房间内会话的快照
+//	GET  {prefix}/rooms/mode?room=x           房间模式
+//	POST {prefix}/rooms/mode?room=x&mode=read_only 设置房间模式，取值为open、read_only或moderated
+//	GET  {prefix}/rooms/moderation?room=x     审核队列中的信息
+//	POST {prefix}/rooms/moderation/approve?room=x&id=y 批准并广播审核队列中的信息
+//	POST {prefix}/rooms/moderation/reject?room=x&id=y  丢弃审核队列中的信息
+func (p *Pigeon) AdminHandler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, prefix) {
+		case "/stats":
+			writeJSON(w, p.Stats())
+		case "/rooms":
+			writeJSON(w, p.RoomStats())
+		case "/hub":
+			writeJSON(w, p.HubStats())
+		case "/sessions/top":
+			p.adminTop(w, r)
+		case "/sessions/rooms":
+			writeJSON(w, p.RoomsOf(r.URL.Query().Get("id")))
+		case "/sessions/close":
+			p.adminClose(w, r)
+		case "/sessions/close-where":
+			p.adminCloseWhere(w, r)
+		case "/compact":
+			if r.Method != http.MethodPost {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, p.Compact())
+		case "/locks":
+			writeJSON(w, p.LockProfile())
+		case "/locks/reset":
+			if r.Method != http.MethodPost {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			p.ResetLockProfile()
+			w.WriteHeader(http.StatusNoContent)
+		case "/rooms/members":
+			writeJSON(w, p.RoomMembers(r.URL.Query().Get("room")))
+		case "/rooms/mode":
+			p.adminRoomMode(w, r)
+		case "/rooms/moderation":
+			writeJSON(w, p.ModerationQueue(r.URL.Query().Get("room")))
+		case "/rooms/moderation/approve":
+			p.adminModerate(w, r, p.ApproveMessage)
+		case "/rooms/moderation/reject":
+			p.adminModerate(w, r, p.RejectMessage)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (p *Pigeon) adminTop(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	by := MetricHandlerTime
+	if name := q.Get("by"); name != "" {
+		m, ok := metricNames[name]
+		if !ok {
+			http.Error(w, "unknown metric "+name, http.StatusBadRequest)
+			return
+		}
+		by = m
+	}
+	n := 10
+	if v := q.Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, p.TopSessions(by, n))
+}
+
+func (p *Pigeon) adminClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	var found *Session
+	p.hub.iterator(func(s *Session) bool {
+		if s.id == id {
+			found = s
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := found.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Pigeon) adminCloseWhere(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var where Where
+	if err := json.NewDecoder(r.Body).Decode(&where); err != nil {
+		http.Error(w, "invalid where: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := where.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 空谓词匹配全部会话，关闭全部会话应使用Close
+	if where.Key == "" && len(where.And) == 0 && len(where.Or) == 0 {
+		http.Error(w, "empty where", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	code := websocket.CloseNormalClosure
+	if v := q.Get("code"); v != "" {
+		var err error
+		if code, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, map[string]int{"closed": p.CloseWhere(where.Match, code, q.Get("reason"))})
+}
+
+func (p *Pigeon) adminRoomMode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	room := q.Get("room")
+	if room == "" {
+		http.Error(w, "missing room", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		mode, ok := ParseRoomMode(q.Get("mode"))
+		if !ok {
+			http.Error(w, "unknown mode "+q.Get("mode"), http.StatusBadRequest)
+			return
+		}
+		p.SetRoomMode(room, mode)
+	}
+	writeJSON(w, map[string]string{"room": room, "mode": p.RoomMode(room).String()})
+}
+
+func (p *Pigeon) adminModerate(w http.ResponseWriter, r *http.Request, fn func(room, id string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if err := fn(q.Get("room"), q.Get("id")); err != nil {
+		if err == ErrModerationNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,105 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ControlReconnect 服务端建议客户端重连的控制指令，内容见Advice.
+const ControlReconnect = "reconnect"
+
+// 建议重连的原因.
+const (
+	AdviceDrain    = "drain"    // 实例正在排空.
+	AdviceOverload = "overload" // 实例过载.
+	AdviceMaxAge   = "max_age"  // 连接即将达到Config.MaxConnectionAge.
+)
+
+// Advice 重连建议，客户端应在Delay加上[0, Jitter)的随机时间后重连，Host非空时改为连接该地址.
+type Advice struct {
+	Reason string        // 原因.
+	Delay  time.Duration // 重连前的等待时间.
+	Jitter time.Duration // 随机附加等待时间的上限，避免客户端同时重连.
+	Host   string        // 重连使用的主机，如 ws2.example.com:443，为空时使用原地址.
+}
+
+type reconnectAdviceFunc func(s *Session, reason string) Advice
+
+// 发送给客户端的重连建议，时间单位为毫秒
+type adviceControl struct {
+	Op     string `json:"op"`
+	Reason string `json:"reason,omitempty"`
+	Delay  int64  `json:"delay,omitempty"`
+	Jitter int64  `json:"jitter,omitempty"`
+	Host   string `json:"host,omitempty"`
+}
+
+// HandleReconnectAdvice 按会话和原因生成重连建议的策略，未设置时只发送原因.
+func (p *Pigeon) HandleReconnectAdvice(fn func(s *Session, reason string) Advice) {
+	p.reconnectAdvice = fn
+}
+
+// AdviseReconnect 按重连建议策略建议会话重连，不关闭会话.
+func (s *Session) AdviseReconnect(reason string) error {
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	msg, err := s.encodeAdvice(reason)
+	if err != nil {
+		return err
+	}
+	// 设置可选项的信息单独成帧，不与其他信息合并发送
+	s.writeMessage(&envelope{t: websocket.TextMessage, message: msg, opts: &SendOptions{}})
+	s.transition(StateDraining)
+	return nil
+}
+
+// AdviseReconnect 建议全部会话重连，如配合Drain在排空时使用，返回建议的会话数量.
+func (p *Pigeon) AdviseReconnect(reason string) int {
+	n := 0
+	p.hub.iterator(func(s *Session) bool {
+		if err := s.AdviseReconnect(reason); err == nil {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// 编码重连建议
+func (s *Session) encodeAdvice(reason string) ([]byte, error) {
+	advice := Advice{Reason: reason}
+	if fn := s.pigeon.reconnectAdvice; fn != nil {
+		advice = fn(s, reason)
+		if advice.Reason == "" {
+			advice.Reason = reason
+		}
+	}
+	return json.Marshal(&adviceControl{
+		Op:     ControlReconnect,
+		Reason: advice.Reason,
+		Delay:  advice.Delay.Milliseconds(),
+		Jitter: advice.Jitter.Milliseconds(),
+		Host:   advice.Host,
+	})
+}
+
+// ParseAdvice 解析服务端发送的重连建议，不是重连建议时返回false.
+func ParseAdvice(msg []byte) (Advice, bool) {
+	if len(msg) == 0 || msg[0] != '{' {
+		return Advice{}, false
+	}
+	c := &adviceControl{}
+	if err := json.Unmarshal(msg, c); err != nil || c.Op != ControlReconnect {
+		return Advice{}, false
+	}
+	return Advice{
+		Reason: c.Reason,
+		Delay:  time.Duration(c.Delay) * time.Millisecond,
+		Jitter: time.Duration(c.Jitter) * time.Millisecond,
+		Host:   c.Host,
+	}, true
+}
@@ -0,0 +1,65 @@
+package pigeon
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Ban 封禁IP，封禁期间拒绝该IP的新连接，并关闭该IP已有的会话. 同时清理已过期的封禁.
+func (p *Pigeon) Ban(ip string, d time.Duration) {
+	p.banMu.Lock()
+	now := p.now()
+	for banned, until := range p.bans {
+		if now.After(until) {
+			delete(p.bans, banned)
+		}
+	}
+	p.bans[ip] = now.Add(d)
+	p.banMu.Unlock()
+
+	p.Range(func(s *Session) bool {
+		if s.RemoteIP() == ip {
+			go s.CloseWithMsg(websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "banned"))
+		}
+		return true
+	})
+}
+
+// Unban 解除IP封禁.
+func (p *Pigeon) Unban(ip string) {
+	p.banMu.Lock()
+	defer p.banMu.Unlock()
+	delete(p.bans, ip)
+}
+
+// IsBanned 判断IP是否被封禁.
+func (p *Pigeon) IsBanned(ip string) bool {
+	p.banMu.Lock()
+	defer p.banMu.Unlock()
+	until, ok := p.bans[ip]
+	if !ok {
+		return false
+	}
+	if p.now().After(until) {
+		delete(p.bans, ip)
+		return false
+	}
+	return true
+}
+
+// RemoteIP 获取请求的客户端IP，不解析代理头.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RemoteIP 获取会话的客户端IP.
+func (s *Session) RemoteIP() string {
+	return RemoteIP(s.Request)
+}
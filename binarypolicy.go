@@ -0,0 +1,42 @@
+package pigeon
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// BinaryPolicy 未设置二进制信息处理方法时收到二进制信息的处理策略，见Config.UnexpectedBinary.
+type BinaryPolicy int
+
+// 二进制信息的处理策略.
+const (
+	BinaryDrop   BinaryPolicy = iota // 丢弃，默认.
+	BinaryClose                      // 以1003（不支持的数据）关闭会话.
+	BinaryAsText                     // 交给HandleMessage的处理方法，用于不区分帧类型的客户端.
+)
+
+var binaryPolicyNames = map[BinaryPolicy]string{
+	BinaryDrop:   "drop",
+	BinaryClose:  "close",
+	BinaryAsText: "as_text",
+}
+
+func (bp BinaryPolicy) String() string {
+	if name, ok := binaryPolicyNames[bp]; ok {
+		return name
+	}
+	return "policy(" + strconv.Itoa(int(bp)) + ")"
+}
+
+// 没有处理方法接收的二进制信息按策略处理并计数
+func (p *Pigeon) unexpectedBinary(s *Session, msg []byte) {
+	atomic.AddUint64(&p.counters.unexpectedBinary, 1)
+	switch p.Config.UnexpectedBinary {
+	case BinaryClose:
+		s.CloseWithMsg(websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "binary messages are not supported"))
+	case BinaryAsText:
+		p.messageHandlerOf(s)(s, msg)
+	}
+}
@@ -0,0 +1,152 @@
+package pigeon
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BridgeStats 桥接一个方向的统计.
+type BridgeStats struct {
+	Forwarded uint64 `json:"forwarded"` // 转发的广播数.
+	Filtered  uint64 `json:"filtered"`  // 被过滤器拒绝的广播数.
+	Failed    uint64 `json:"failed"`    // 目标实例已关闭等原因转发失败的广播数.
+}
+
+// 桥接的一个方向
+type bridgeDirection struct {
+	forwarded uint64
+	filtered  uint64
+	failed    uint64
+	to        *Pigeon
+	filter    func([]byte) bool
+	mu        *sync.RWMutex
+}
+
+// BridgeLink 两个信鸽实例之间的双向桥接.
+type BridgeLink struct {
+	a, b   *Pigeon
+	aToB   *bridgeDirection
+	bToA   *bridgeDirection
+	closed int32
+}
+
+// Bridge 在同一进程的两个信鸽实例之间双向转发广播，filter为nil时全部转发.
+// 广播保留房间、过滤器和发送选项，多个实例互相桥接时每个实例也只收到一次，不会循环.
+func Bridge(a, b *Pigeon, filter func(msg []byte) bool) *BridgeLink {
+	l := &BridgeLink{
+		a:    a,
+		b:    b,
+		aToB: &bridgeDirection{to: b, filter: filter, mu: &sync.RWMutex{}},
+		bToA: &bridgeDirection{to: a, filter: filter, mu: &sync.RWMutex{}},
+	}
+	a.addBridge(l.aToB)
+	b.addBridge(l.bToA)
+	return l
+}
+
+// FilterAToB 设置从a到b方向的过滤器.
+func (l *BridgeLink) FilterAToB(fn func(msg []byte) bool) {
+	l.aToB.setFilter(fn)
+}
+
+// FilterBToA 设置从b到a方向的过滤器.
+func (l *BridgeLink) FilterBToA(fn func(msg []byte) bool) {
+	l.bToA.setFilter(fn)
+}
+
+// Stats 获取两个方向的统计.
+func (l *BridgeLink) Stats() (aToB, bToA BridgeStats) {
+	return l.aToB.stats(), l.bToA.stats()
+}
+
+// Close 断开桥接.
+func (l *BridgeLink) Close() {
+	if atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		l.a.removeBridge(l.aToB)
+		l.b.removeBridge(l.bToA)
+	}
+}
+
+func (d *bridgeDirection) setFilter(fn func([]byte) bool) {
+	d.mu.Lock()
+	d.filter = fn
+	d.mu.Unlock()
+}
+
+func (d *bridgeDirection) allow(msg []byte) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.filter == nil || d.filter(msg)
+}
+
+func (d *bridgeDirection) stats() BridgeStats {
+	return BridgeStats{
+		Forwarded: atomic.LoadUint64(&d.forwarded),
+		Filtered:  atomic.LoadUint64(&d.filtered),
+		Failed:    atomic.LoadUint64(&d.failed),
+	}
+}
+
+func (p *Pigeon) addBridge(d *bridgeDirection) {
+	p.bridgeMu.Lock()
+	defer p.bridgeMu.Unlock()
+	p.bridges = append(p.bridges, d)
+}
+
+func (p *Pigeon) removeBridge(d *bridgeDirection) {
+	p.bridgeMu.Lock()
+	defer p.bridgeMu.Unlock()
+	for i, b := range p.bridges {
+		if b == d {
+			p.bridges = append(p.bridges[:i:i], p.bridges[i+1:]...)
+			return
+		}
+	}
+}
+
+// 一次广播经桥接到达过的实例，所有转发副本共享
+type bridgeTrail struct {
+	visited map[*Pigeon]struct{}
+	mu      *sync.Mutex
+}
+
+// 标记实例，已到达过时返回false
+func (t *bridgeTrail) claim(p *Pigeon) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.visited[p]; ok {
+		return false
+	}
+	t.visited[p] = struct{}{}
+	return true
+}
+
+// 将广播转发到桥接的实例，每个实例最多收到一次
+func (p *Pigeon) forward(m *envelope) {
+	p.bridgeMu.RLock()
+	bridges := p.bridges
+	p.bridgeMu.RUnlock()
+	if len(bridges) == 0 {
+		return
+	}
+
+	trail := m.trail
+	if trail == nil {
+		trail = &bridgeTrail{visited: map[*Pigeon]struct{}{p: {}}, mu: &sync.Mutex{}}
+	}
+	for _, d := range bridges {
+		if !d.allow(m.message) {
+			atomic.AddUint64(&d.filtered, 1)
+			continue
+		}
+		if !trail.claim(d.to) {
+			continue
+		}
+		fwd := &envelope{t: m.t, message: m.message, filter: m.filter, where: m.where, exclude: m.exclude, event: m.event, payloads: m.payloads, rooms: m.rooms, tags: m.tags, opts: m.opts, trail: trail}
+		if err := d.to.dispatch(fwd); err != nil {
+			atomic.AddUint64(&d.failed, 1)
+			continue
+		}
+		atomic.AddUint64(&d.forwarded, 1)
+	}
+}
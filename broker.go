@@ -0,0 +1,293 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// 去重时记住的最近广播ID数量
+const brokerDedupSize = 4096
+
+// BrokerMessage 经集群消息代理在节点之间传递的广播，可序列化.
+type BrokerMessage struct {
+	Origin   string            `json:"origin"`             // 发布节点的ID，见Pigeon.NodeID.
+	ID       string            `json:"id"`                 // 广播ID，同一广播被代理重复投递时据此去重.
+	Rooms    []string          `json:"rooms,omitempty"`    // 目标房间，与Tags均为空时广播给全部会话.
+	Tags     []string          `json:"tags,omitempty"`     // 目标标签，与Rooms取并集.
+	Binary   bool              `json:"binary,omitempty"`   // 是否为二进制信息.
+	Data     []byte            `json:"data"`               // 信息内容.
+	Where    *Where            `json:"where,omitempty"`    // 过滤谓词.
+	Exclude  string            `json:"exclude,omitempty"`  // 排除的发送者会话ID.
+	Payloads map[string][]byte `json:"payloads,omitempty"` // 注册了多个编解码器时EmitRoom按编解码器名编码的事件，接收节点只投递给使用其中编解码器的会话.
+	Event    string            `json:"event,omitempty"`    // 通过EmitRoom发送的事件名，用于匹配事件TTL.
+	Trace    string            `json:"trace,omitempty"`    // 编码的追踪上下文，广播被追踪时设置，见UseTracer.
+}
+
+// Broker 集群消息代理，如基于Redis Pub/Sub或NATS实现. 多数代理会把信息也投递回发布的节点，
+// 信鸽按BrokerMessage.Origin丢弃自己发布的信息，本节点的会话只由本地投递收到一次.
+type Broker interface {
+	// Publish 向全部节点发布广播.
+	Publish(msg *BrokerMessage) error
+	// Subscribe 订阅其他节点发布的广播，返回取消订阅的方法.
+	Subscribe(fn func(msg *BrokerMessage)) (cancel func(), err error)
+}
+
+// BrokerPinger 可选接口，健康检查时用于探测与代理的连接.
+type BrokerPinger interface {
+	Ping() error
+}
+
+// BrokerStats 集群消息代理的统计.
+type BrokerStats struct {
+	Published  uint64 `json:"published"`  // 发布到代理的广播数.
+	Received   uint64 `json:"received"`   // 从代理收到并在本节点投递的广播数.
+	Echoes     uint64 `json:"echoes"`     // 丢弃的本节点发布的广播数.
+	Duplicates uint64 `json:"duplicates"` // 丢弃的重复广播数.
+	Skipped    uint64 `json:"skipped"`    // 使用过滤闭包等无法序列化、只在本节点投递的广播数.
+	Failed     uint64 `json:"failed"`     // 发布失败的广播数.
+}
+
+// 节点的集群消息代理状态
+type brokerState struct {
+	published  uint64
+	received   uint64
+	echoes     uint64
+	duplicates uint64
+	skipped    uint64
+	failed     uint64
+	failing    int32 // 最近一次发布是否失败.
+	lastErr    error // 最近一次发布失败的原因，受mu保护.
+	broker     Broker
+	cancel     func()
+	seen       map[string]struct{}
+	recent     []string // 按到达顺序记住的广播ID，循环覆盖.
+	next       int
+	mu         *sync.RWMutex
+}
+
+func newBrokerState() *brokerState {
+	return &brokerState{mu: &sync.RWMutex{}}
+}
+
+// NodeID 获取本节点的ID，Config.NodeID为空时为新建实例时随机生成的ID.
+func (p *Pigeon) NodeID() string {
+	return p.nodeID
+}
+
+// UseBroker 通过集群消息代理在节点之间转发广播，为nil时停止转发. 替换时先取消原代理的订阅.
+// 过滤器为闭包的广播无法序列化，只在本节点投递，需跨节点过滤时使用BroadcastWhere.
+func (p *Pigeon) UseBroker(b Broker) error {
+	st := p.brokers
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.cancel != nil {
+		st.cancel()
+		st.cancel = nil
+	}
+	st.broker = nil
+	st.lastErr = nil
+	atomic.StoreInt32(&st.failing, 0)
+	if b == nil {
+		return nil
+	}
+	cancel, err := b.Subscribe(p.receiveBroker)
+	if err != nil {
+		return err
+	}
+	st.broker, st.cancel = b, cancel
+	st.resetDedup()
+	return nil
+}
+
+// BrokerStats 获取集群消息代理的统计.
+func (p *Pigeon) BrokerStats() BrokerStats {
+	st := p.brokers
+	return BrokerStats{
+		Published:  atomic.LoadUint64(&st.published),
+		Received:   atomic.LoadUint64(&st.received),
+		Echoes:     atomic.LoadUint64(&st.echoes),
+		Duplicates: atomic.LoadUint64(&st.duplicates),
+		Skipped:    atomic.LoadUint64(&st.skipped),
+		Failed:     atomic.LoadUint64(&st.failed),
+	}
+}
+
+// 将本节点发起的广播发布到代理，来自代理或桥接的广播不再发布
+func (p *Pigeon) publishBroker(m *envelope) {
+	st := p.brokers
+	st.mu.RLock()
+	b := st.broker
+	st.mu.RUnlock()
+	if b == nil || m.remote || m.trail != nil {
+		return
+	}
+	if (m.filter != nil && m.where == nil) || len(m.parts) > 0 || (m.t != websocket.TextMessage && m.t != websocket.BinaryMessage) {
+		atomic.AddUint64(&st.skipped, 1)
+		return
+	}
+	msg := &BrokerMessage{
+		Origin:  p.NodeID(),
+		ID:      newID(),
+		Rooms:   m.rooms,
+		Tags:    m.tags,
+		Binary:  m.t == websocket.BinaryMessage,
+		Data:    m.message,
+		Where:   m.where,
+		Exclude: m.exclude,
+		Event:   m.event,
+		Trace:   m.trace,
+	}
+	if m.payloads != nil {
+		msg.Payloads = make(map[string][]byte, len(m.payloads))
+		for name, payload := range m.payloads {
+			msg.Payloads[name] = payload.message
+		}
+	}
+	if err := b.Publish(msg); err != nil {
+		atomic.AddUint64(&st.failed, 1)
+		st.setError(err)
+		p.reportError(nil, err)
+		return
+	}
+	atomic.AddUint64(&st.published, 1)
+	if atomic.LoadInt32(&st.failing) == 1 {
+		st.setError(nil)
+	}
+}
+
+// 记录最近一次发布的错误，为nil时表示已恢复
+func (st *brokerState) setError(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastErr = err
+	if err != nil {
+		atomic.StoreInt32(&st.failing, 1)
+	} else {
+		atomic.StoreInt32(&st.failing, 0)
+	}
+}
+
+// 检查代理状态，代理实现了BrokerPinger时探测连接，否则返回最近一次发布的错误. 未使用代理时返回false
+func (st *brokerState) check() (bool, error) {
+	st.mu.RLock()
+	b, err := st.broker, st.lastErr
+	st.mu.RUnlock()
+	if b == nil {
+		return false, nil
+	}
+	if pinger, ok := b.(BrokerPinger); ok {
+		return true, pinger.Ping()
+	}
+	return true, err
+}
+
+// 投递代理转发的广播，丢弃本节点发布的和重复的广播
+func (p *Pigeon) receiveBroker(msg *BrokerMessage) {
+	st := p.brokers
+	if msg.Origin == p.NodeID() {
+		atomic.AddUint64(&st.echoes, 1)
+		return
+	}
+	if !st.claim(msg.ID) {
+		atomic.AddUint64(&st.duplicates, 1)
+		return
+	}
+	m := &envelope{t: websocket.TextMessage, message: msg.Data, rooms: msg.Rooms, tags: msg.Tags, exclude: msg.Exclude, event: msg.Event, remote: true, trace: msg.Trace}
+	if msg.Binary {
+		m.t = websocket.BinaryMessage
+	}
+	if msg.Payloads != nil {
+		m.payloads = make(map[string]*envelope, len(msg.Payloads))
+		for name, data := range msg.Payloads {
+			if c, ok := p.codecs[name]; ok {
+				m.payloads[name] = &envelope{t: c.MessageType(), message: data, event: msg.Event}
+			}
+		}
+	}
+	if msg.Where != nil {
+		if err := msg.Where.validate(); err != nil {
+			p.reportError(nil, err)
+			return
+		}
+		m.where = msg.Where
+		m.filter = msg.Where.Match
+	}
+	if err := p.dispatch(m); err != nil {
+		p.reportError(nil, err)
+		return
+	}
+	atomic.AddUint64(&st.received, 1)
+}
+
+// 清空记住的广播ID
+func (st *brokerState) resetDedup() {
+	st.seen = make(map[string]struct{}, brokerDedupSize)
+	st.recent = make([]string, brokerDedupSize)
+	st.next = 0
+}
+
+// 记录广播ID，已记录过时返回false
+func (st *brokerState) claim(id string) bool {
+	if id == "" {
+		return true
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.seen == nil {
+		return true
+	}
+	if _, ok := st.seen[id]; ok {
+		return false
+	}
+	if old := st.recent[st.next]; old != "" {
+		delete(st.seen, old)
+	}
+	st.recent[st.next] = id
+	st.next = (st.next + 1) % len(st.recent)
+	st.seen[id] = struct{}{}
+	return true
+}
+
+// 进程内的消息代理，投递给包括发布者在内的全部订阅者，用于单机部署多个实例或测试
+type memoryBroker struct {
+	subscribers map[int]func(*BrokerMessage)
+	next        int
+	mu          *sync.RWMutex
+}
+
+// NewMemoryBroker 新建进程内的集群消息代理，与真实代理一样会把广播投递回发布的实例.
+func NewMemoryBroker() Broker {
+	return &memoryBroker{subscribers: make(map[int]func(*BrokerMessage)), mu: &sync.RWMutex{}}
+}
+
+func (b *memoryBroker) Publish(msg *BrokerMessage) error {
+	if msg == nil {
+		return errors.New("nil broker message")
+	}
+	b.mu.RLock()
+	subscribers := make([]func(*BrokerMessage), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(msg)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(fn func(*BrokerMessage)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}, nil
+}
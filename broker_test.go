@@ -0,0 +1,191 @@
+package pigeon_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+)
+
+// 内存中的集群消息代理，像多数代理一样把信息也投递回发布的节点，每条信息投递copies次
+type memBroker struct {
+	copies int
+	subs   map[int]func(*pigeon.BrokerMessage)
+	next   int
+	mu     sync.Mutex
+}
+
+func newMemBroker(copies int) *memBroker {
+	return &memBroker{copies: copies, subs: make(map[int]func(*pigeon.BrokerMessage))}
+}
+
+func (b *memBroker) Publish(msg *pigeon.BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	subs := make([]func(*pigeon.BrokerMessage), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.Unlock()
+	for i := 0; i < b.copies; i++ {
+		for _, fn := range subs {
+			m := &pigeon.BrokerMessage{}
+			if err := json.Unmarshal(data, m); err != nil {
+				return err
+			}
+			fn(m)
+		}
+	}
+	return nil
+}
+
+func (b *memBroker) Subscribe(fn func(*pigeon.BrokerMessage)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}, nil
+}
+
+// 投递两个节点的全部广播后各自在本节点发送结束标记，返回客户端在标记前收到的信息
+func receivedAll(t *testing.T, nodes []*pigeontest.Server, clients [][]*pigeontest.Client) [][]string {
+	t.Helper()
+	for _, n := range nodes {
+		n.Flush()
+	}
+	var all [][]string
+	for i, n := range nodes {
+		// 闭包过滤的广播不经过代理，只在本节点投递
+		n.Pigeon.BroadcastFilter([]byte("end"), func(*pigeon.Session) bool { return true })
+		n.Flush()
+		for _, c := range clients[i] {
+			var got []string
+			for {
+				m := string(c.Next(t).Data)
+				if m == "end" {
+					break
+				}
+				got = append(got, m)
+			}
+			sort.Strings(got)
+			all = append(all, got)
+		}
+	}
+	return all
+}
+
+func TestBrokerExactlyOnce(t *testing.T) {
+	broker := newMemBroker(2)
+	var nodes []*pigeontest.Server
+	for _, id := range []string{"a", "b"} {
+		conf := pigeon.DefaultConfig()
+		conf.NodeID = id
+		srv := newTargetServer(t, conf)
+		if err := srv.Pigeon.UseBroker(broker); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, srv)
+	}
+	clients := [][]*pigeontest.Client{
+		{nodes[0].Dial(t, "/?rooms=r&tags=x&name=a1"), nodes[0].Dial(t, "/?name=a2")},
+		{nodes[1].Dial(t, "/?rooms=r&tags=x&name=b1"), nodes[1].Dial(t, "/?name=b2")},
+	}
+
+	a, b := nodes[0].Pigeon, nodes[1].Pigeon
+	a.Broadcast([]byte("all"))
+	a.BroadcastRoom("r", []byte("room"))
+	b.BroadcastSelect(pigeon.Selector{Rooms: []string{"r"}, Tags: []string{"x"}}, []byte("select"))
+	b.BroadcastWhere([]byte("where"), pigeon.Where{Key: "name", Op: pigeon.Eq, Value: "a2"})
+	a.BroadcastFilter([]byte("local"), func(*pigeon.Session) bool { return true })
+
+	got := receivedAll(t, nodes, clients)
+	want := [][]string{
+		{"all", "local", "room", "select"},
+		{"all", "local", "where"},
+		{"all", "room", "select"},
+		{"all"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+
+	// 每条广播被代理投递两次：发布节点丢弃两次回环，另一节点丢弃一次重复
+	sa, sb := a.BrokerStats(), b.BrokerStats()
+	if sa.Published != 2 || sa.Echoes != 4 || sa.Skipped < 1 || sa.Received != 2 || sa.Duplicates != 2 {
+		t.Errorf("node a stats %+v", sa)
+	}
+	if sb.Published != 2 || sb.Echoes != 4 || sb.Received != 2 || sb.Duplicates != 2 {
+		t.Errorf("node b stats %+v", sb)
+	}
+}
+
+// 发布可按需失败的代理
+type flakyBroker struct {
+	*memBroker
+	err error
+}
+
+func (b *flakyBroker) Publish(msg *pigeon.BrokerMessage) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.memBroker.Publish(msg)
+}
+
+// 实现了BrokerPinger的代理
+type pingBroker struct {
+	*memBroker
+	err error
+}
+
+func (b *pingBroker) Ping() error {
+	return b.err
+}
+
+func TestHealthBroker(t *testing.T) {
+	srv := pigeontest.NewServer(nil)
+	defer srv.Close()
+	p := srv.Pigeon
+	if h := p.Health(); h.Broker != "" || h.BrokerError != "" {
+		t.Fatalf("health without a broker = %+v", h)
+	}
+
+	b := &flakyBroker{memBroker: newMemBroker(1)}
+	if err := p.UseBroker(b); err != nil {
+		t.Fatal(err)
+	}
+	if h := p.Health(); h.Broker != "ok" {
+		t.Fatalf("health with a working broker = %+v", h)
+	}
+	b.err = errors.New("broker is down")
+	p.Broadcast([]byte("a"))
+	if h := p.Health(); h.Status != "ok" || h.Broker != "error" || h.BrokerError != "broker is down" {
+		t.Fatalf("health after a failed publish = %+v", h)
+	}
+	b.err = nil
+	p.Broadcast([]byte("b"))
+	if h := p.Health(); h.Broker != "ok" || h.BrokerError != "" {
+		t.Fatalf("health after the broker recovered = %+v", h)
+	}
+
+	pb := &pingBroker{memBroker: newMemBroker(1), err: errors.New("ping timeout")}
+	if err := p.UseBroker(pb); err != nil {
+		t.Fatal(err)
+	}
+	if h := p.Health(); h.Broker != "error" || h.BrokerError != "ping timeout" {
+		t.Fatalf("health with a failing ping = %+v", h)
+	}
+}
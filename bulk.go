@@ -0,0 +1,57 @@
+package pigeon
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// 一次遍历收集满足条件的会话，在遍历结束后再逐个操作，避免在持有会话集合的锁时执行关闭、加入房间等操作
+func (p *Pigeon) collect(pred func(*Session) bool) []*Session {
+	if pred == nil {
+		return nil
+	}
+	var matched []*Session
+	p.hub.iterator(func(s *Session) bool {
+		if pred(s) {
+			matched = append(matched, s)
+		}
+		return true
+	})
+	return matched
+}
+
+// CloseWhere 以关闭码和说明关闭满足条件的会话，返回关闭的会话数量，pred为nil时不关闭任何会话.
+// 可传入Where.Match按会话Keys筛选.
+func (p *Pigeon) CloseWhere(pred func(*Session) bool, code int, reason string) int {
+	msg := websocket.FormatCloseMessage(code, reason)
+	n := 0
+	for _, s := range p.collect(pred) {
+		if s.closed() {
+			continue
+		}
+		// 写入关闭帧失败时连接已不可用，同样视为已关闭
+		s.CloseWithMsg(msg)
+		n++
+	}
+	return n
+}
+
+// JoinAll 让满足条件的会话加入房间，返回加入的会话数量，已在房间中的会话也计入.
+// 房间已满或进入等待队列的会话不计入.
+func (p *Pigeon) JoinAll(pred func(*Session) bool, room string) int {
+	n := 0
+	for _, s := range p.collect(pred) {
+		if s.Join(room) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// SetKeyWhere 为满足条件的会话设置key/value，返回设置的会话数量.
+func (p *Pigeon) SetKeyWhere(pred func(*Session) bool, key string, value interface{}) int {
+	matched := p.collect(pred)
+	for _, s := range matched {
+		s.Set(key, value)
+	}
+	return len(matched)
+}
@@ -0,0 +1,607 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// CBOR的主类型
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// 解码时允许的最大嵌套深度
+const cborMaxDepth = 256
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// CBORCodec 紧凑的CBOR编解码器，以二进制信息发送，子协议名为cbor，适用于关注流量的移动端.
+//
+// 事件编码为数组[事件, 数据, ID, 版本]，末尾为空的项省略，中间为空的项以null占位，事件名通过RegisterEvent注册了编号时以整数发送.
+// 客户端须使用相同的编号表. 同一房间中JSON与CBOR会话混合时，EmitRoom按各自的编解码器分别编码.
+//
+// 事件以外的值先按encoding/json编码再转换为CBOR，因此json标签同样生效，[]byte字段按JSON的规则为base64字符串；
+// 直接编码[]byte时为CBOR字节串.
+type CBORCodec struct {
+	ids   map[string]uint64
+	names map[uint64]string
+	mu    *sync.RWMutex
+}
+
+// NewCBORCodec 新建CBOR编解码器.
+func NewCBORCodec() *CBORCodec {
+	return &CBORCodec{ids: make(map[string]uint64), names: make(map[uint64]string), mu: &sync.RWMutex{}}
+}
+
+// RegisterEvent 为事件名注册编号，编号或事件名已注册时返回错误.
+func (c *CBORCodec) RegisterEvent(id uint64, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.names[id]; ok {
+		return errors.New("cbor: event id " + strconv.FormatUint(id, 10) + " already registered")
+	}
+	if _, ok := c.ids[name]; ok {
+		return errors.New("cbor: event " + name + " already registered")
+	}
+	c.ids[name] = id
+	c.names[id] = name
+	return nil
+}
+
+// Events 获取已注册的事件编号表，可下发给客户端.
+func (c *CBORCodec) Events() map[uint64]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	events := make(map[uint64]string, len(c.names))
+	for id, name := range c.names {
+		events[id] = name
+	}
+	return events
+}
+
+// Name 实现Codec.
+func (c *CBORCodec) Name() string { return "cbor" }
+
+// MessageType 实现Codec.
+func (c *CBORCodec) MessageType() int { return websocket.BinaryMessage }
+
+// Marshal 实现Codec.
+func (c *CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case *Event:
+		return c.marshalEvent(v)
+	case Event:
+		return c.marshalEvent(&v)
+	case []byte:
+		return append(appendCBORHead(nil, cborBytes, uint64(len(v))), v...), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, tree)
+}
+
+// Unmarshal 实现Codec.
+func (c *CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *Event:
+		return c.unmarshalEvent(data, v)
+	case *[]byte:
+		if len(data) > 0 && data[0]>>5 == cborBytes {
+			d := &cborDecoder{data: data}
+			b, err := d.item(0, true)
+			if err != nil {
+				return err
+			}
+			if d.pos != len(data) {
+				return errors.New("cbor: trailing data")
+			}
+			*v = b.([]byte)
+			return nil
+		}
+	}
+	tree, err := decodeCBOR(data)
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+func (c *CBORCodec) marshalEvent(ev *Event) ([]byte, error) {
+	n := uint64(1)
+	switch {
+	case ev.V != 0:
+		n = 4
+	case ev.ID != "":
+		n = 3
+	case len(ev.Data) > 0:
+		n = 2
+	}
+	b := appendCBORHead(make([]byte, 0, 24+len(ev.Name)+len(ev.Data)+len(ev.ID)), cborArray, n)
+
+	c.mu.RLock()
+	id, ok := c.ids[ev.Name]
+	c.mu.RUnlock()
+	if ok {
+		b = appendCBORHead(b, cborUint, id)
+	} else {
+		b = appendCBORText(b, ev.Name)
+	}
+
+	if len(ev.Data) > 0 {
+		// Data须为本编解码器编码的单个CBOR值
+		d := &cborDecoder{data: ev.Data}
+		if _, err := d.item(0, false); err != nil || d.pos != len(ev.Data) {
+			return nil, errors.New("cbor: event data is not a single cbor value")
+		}
+		b = append(b, ev.Data...)
+	} else if n > 2 {
+		b = append(b, 0xf6)
+	}
+	if ev.ID != "" {
+		b = appendCBORText(b, ev.ID)
+	} else if n > 3 {
+		b = append(b, 0xf6)
+	}
+	if ev.V != 0 {
+		if ev.V < 0 {
+			return nil, errors.New("cbor: event version must not be negative")
+		}
+		b = appendCBORHead(b, cborUint, uint64(ev.V))
+	}
+	return b, nil
+}
+
+func (c *CBORCodec) unmarshalEvent(data []byte, ev *Event) error {
+	d := &cborDecoder{data: data}
+	n, err := d.head(cborArray)
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > 4 {
+		return errors.New("cbor: event must be an array of 1 to 4 items")
+	}
+
+	name, err := d.item(0, true)
+	if err != nil {
+		return err
+	}
+	switch name := name.(type) {
+	case string:
+		ev.Name = name
+	case uint64:
+		c.mu.RLock()
+		ev.Name = c.names[name]
+		c.mu.RUnlock()
+		if ev.Name == "" {
+			return errors.New("cbor: unknown event id " + strconv.FormatUint(name, 10))
+		}
+	default:
+		return errors.New("cbor: event must be a string or an id")
+	}
+
+	if n >= 2 {
+		start := d.pos
+		if _, err := d.item(0, false); err != nil {
+			return err
+		}
+		if raw := data[start:d.pos]; !(len(raw) == 1 && raw[0] == 0xf6) {
+			ev.Data = append(json.RawMessage(nil), raw...)
+		}
+	}
+	if n >= 3 {
+		id, err := d.item(0, true)
+		if err != nil {
+			return err
+		}
+		switch id := id.(type) {
+		case string:
+			ev.ID = id
+		case nil:
+		default:
+			return errors.New("cbor: event id must be a string")
+		}
+	}
+	if n == 4 {
+		v, err := d.item(0, true)
+		if err != nil {
+			return err
+		}
+		u, ok := v.(uint64)
+		if !ok || u > math.MaxInt32 {
+			return errors.New("cbor: event version must be an unsigned integer")
+		}
+		ev.V = int(u)
+	}
+	if d.pos != len(data) {
+		return errors.New("cbor: trailing data")
+	}
+	return nil
+}
+
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, m|27), n)
+}
+
+func appendCBORText(b []byte, s string) []byte {
+	return append(appendCBORHead(b, cborText, uint64(len(s))), s...)
+}
+
+// 编码以json.Number解码的JSON值
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if i >= 0 {
+				return appendCBORHead(b, cborUint, uint64(i)), nil
+			}
+			return appendCBORHead(b, cborNegint, uint64(-1-i)), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendCBORHead(b, cborUint, u), nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		if float64(float32(f)) == f {
+			return binary.BigEndian.AppendUint32(append(b, 0xfa), math.Float32bits(float32(f))), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f)), nil
+	case string:
+		return appendCBORText(b, v), nil
+	case []interface{}:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		var err error
+		for _, item := range v {
+			if b, err = appendCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendCBORHead(b, cborMap, uint64(len(v)))
+		var err error
+		for _, k := range keys {
+			b = appendCBORText(b, k)
+			if b, err = appendCBOR(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported type %T", v)
+}
+
+// 将单个CBOR值解码为可被JSON编码的值
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item(0, true)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// 读取参数
+func (d *cborDecoder) arg(ai byte) (uint64, error) {
+	if ai < 24 {
+		return uint64(ai), nil
+	}
+	size := 0
+	switch ai {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, errors.New("cbor: invalid additional information")
+	}
+	if len(d.data)-d.pos < size {
+		return 0, errCBORTruncated
+	}
+	var n uint64
+	for _, c := range d.data[d.pos : d.pos+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.pos += size
+	return n, nil
+}
+
+// 读取定长的指定主类型的头部
+func (d *cborDecoder) head(major byte) (uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, errCBORTruncated
+	}
+	ib := d.data[d.pos]
+	if ib>>5 != major || ib&0x1f == 31 {
+		return 0, fmt.Errorf("cbor: expected major type %d", major)
+	}
+	d.pos++
+	return d.arg(ib & 0x1f)
+}
+
+// 读取字符串内容
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// 解码一个值，build为false时只校验并跳过
+func (d *cborDecoder) item(depth int, build bool) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	if d.pos >= len(d.data) {
+		return nil, errCBORTruncated
+	}
+	ib := d.data[d.pos]
+	d.pos++
+	major, ai := ib>>5, ib&0x1f
+
+	if major == cborSimple {
+		return d.simple(ai)
+	}
+	if ai == 31 {
+		return d.indefinite(major, depth, build)
+	}
+	n, err := d.arg(ai)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegint:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		b, err := d.take(n)
+		if err != nil || !build {
+			return nil, err
+		}
+		if major == cborText {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case cborArray:
+		// 每个元素至少占一个字节，避免按伪造的长度分配
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		var items []interface{}
+		if build {
+			items = make([]interface{}, 0, n)
+		}
+		for i := uint64(0); i < n; i++ {
+			v, err := d.item(depth+1, build)
+			if err != nil {
+				return nil, err
+			}
+			if build {
+				items = append(items, v)
+			}
+		}
+		return items, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORTruncated
+		}
+		var m map[string]interface{}
+		if build {
+			m = make(map[string]interface{}, n)
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := d.entry(m, depth, build); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cborTag:
+		return d.item(depth+1, build)
+	}
+	return nil, errors.New("cbor: invalid major type")
+}
+
+// 解码映射的一个键值对，非字符串的键转换为字符串
+func (d *cborDecoder) entry(m map[string]interface{}, depth int, build bool) error {
+	k, err := d.item(depth+1, build)
+	if err != nil {
+		return err
+	}
+	v, err := d.item(depth+1, build)
+	if err != nil {
+		return err
+	}
+	if build {
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return nil
+}
+
+// 解码简单值和浮点数
+func (d *cborDecoder) simple(ai byte) (interface{}, error) {
+	switch ai {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		n, err := d.arg(ai)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(uint16(n)), nil
+	case 26:
+		n, err := d.arg(ai)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		n, err := d.arg(ai)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+	}
+	return nil, errors.New("cbor: unsupported simple value")
+}
+
+// 解码不定长的字符串、数组和映射
+func (d *cborDecoder) indefinite(major byte, depth int, build bool) (interface{}, error) {
+	var chunks []byte
+	var items []interface{}
+	var m map[string]interface{}
+	if build && major == cborMap {
+		m = make(map[string]interface{})
+	}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errCBORTruncated
+		}
+		if d.data[d.pos] == 0xff {
+			d.pos++
+			break
+		}
+		switch major {
+		case cborBytes, cborText:
+			n, err := d.head(major)
+			if err != nil {
+				return nil, err
+			}
+			b, err := d.take(n)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, b...)
+		case cborArray:
+			v, err := d.item(depth+1, build)
+			if err != nil {
+				return nil, err
+			}
+			if build {
+				items = append(items, v)
+			}
+		case cborMap:
+			if err := d.entry(m, depth, build); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("cbor: invalid indefinite length")
+		}
+	}
+	switch major {
+	case cborText:
+		return string(chunks), nil
+	case cborBytes:
+		if chunks == nil {
+			chunks = []byte{}
+		}
+		return chunks, nil
+	case cborArray:
+		if build && items == nil {
+			items = []interface{}{}
+		}
+		return items, nil
+	}
+	return m, nil
+}
+
+// 半精度浮点数转换为float64
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,230 @@
+// Package chat 基于信鸽事件协议的聊天室组件，提供房间、昵称、历史记录、输入状态和在线状态.
+//
+// 使用前需启用Config.EventProtocol，并在信鸽的HandleDisconnect中调用Server.Disconnect.
+package chat
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+// 客户端发送的事件.
+const (
+	EventJoin   = "chat:join"   // 加入房间，数据为Join.
+	EventLeave  = "chat:leave"  // 离开房间，数据为Leave.
+	EventSend   = "chat:send"   // 发送信息，数据为Send.
+	EventTyping = "chat:typing" // 输入状态，数据为Typing，服务端转发给房间.
+)
+
+// 服务端发送的事件.
+const (
+	EventMessage  = "chat:message"  // 新信息，数据为Message.
+	EventHistory  = "chat:history"  // 加入房间后的历史记录，数据为History.
+	EventPresence = "chat:presence" // 成员上线或下线，数据为Presence.
+	EventMembers  = "chat:members"  // 加入房间后的成员列表，数据为Members.
+)
+
+// 会话中保存昵称的key
+const nickKey = "chat.nick"
+
+// 默认发送的历史记录数量
+const defaultHistorySize = 50
+
+// Join 加入房间.
+type Join struct {
+	Room string `json:"room"`
+	Nick string `json:"nick"`
+}
+
+// Leave 离开房间.
+type Leave struct {
+	Room string `json:"room"`
+}
+
+// Send 发送信息.
+type Send struct {
+	Room string `json:"room"`
+	Text string `json:"text"`
+}
+
+// Typing 输入状态.
+type Typing struct {
+	Room   string `json:"room"`
+	Nick   string `json:"nick,omitempty"`
+	Typing bool   `json:"typing"`
+}
+
+// Message 聊天信息.
+type Message struct {
+	ID   string    `json:"id"`
+	Room string    `json:"room"`
+	Nick string    `json:"nick"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// History 历史记录.
+type History struct {
+	Room     string    `json:"room"`
+	Messages []Message `json:"messages"`
+}
+
+// Presence 在线状态.
+type Presence struct {
+	Room   string `json:"room"`
+	Nick   string `json:"nick"`
+	Online bool   `json:"online"`
+}
+
+// Members 成员列表.
+type Members struct {
+	Room  string   `json:"room"`
+	Nicks []string `json:"nicks"`
+}
+
+// Store 聊天记录存储.
+type Store interface {
+	Append(room string, m Message) error
+	History(room string, limit int) ([]Message, error)
+}
+
+// Server 聊天室.
+type Server struct {
+	p           *pigeon.Pigeon
+	store       Store
+	historySize int
+	seq         uint64
+	mu          *sync.Mutex
+}
+
+// New 在信鸽实例上注册聊天室事件，store为nil时使用进程内存储. store实现了pigeon.Compactor时以chat为名注册为压缩器.
+func New(p *pigeon.Pigeon, store Store) *Server {
+	if store == nil {
+		store = NewMemoryStore(defaultHistorySize)
+	}
+	s := &Server{p: p, store: store, historySize: defaultHistorySize, mu: &sync.Mutex{}}
+	p.On(EventJoin, s.join)
+	p.On(EventLeave, s.leave)
+	p.On(EventSend, s.send)
+	p.On(EventTyping, s.typing)
+	if c, ok := store.(pigeon.Compactor); ok {
+		p.AddCompactor("chat", c)
+	}
+	return s
+}
+
+// SetHistorySize 设置加入房间时发送的历史记录数量.
+func (s *Server) SetHistorySize(n int) {
+	s.historySize = n
+}
+
+// Nick 获取会话的昵称.
+func Nick(sess *pigeon.Session) string {
+	if v, ok := sess.Get(nickKey); ok {
+		nick, _ := v.(string)
+		return nick
+	}
+	return ""
+}
+
+// Disconnect 会话断开时通知其所在房间，需在信鸽的HandleDisconnect中调用.
+func (s *Server) Disconnect(sess *pigeon.Session) {
+	nick := Nick(sess)
+	if nick == "" {
+		return
+	}
+	for _, room := range sess.Rooms() {
+		s.p.EmitRoom(room, EventPresence, &Presence{Room: room, Nick: nick, Online: false})
+	}
+}
+
+func (s *Server) join(sess *pigeon.Session, ev *pigeon.Event) error {
+	var req Join
+	if err := sess.Decode(ev.Data, &req); err != nil {
+		return err
+	}
+	if req.Room == "" || req.Nick == "" {
+		return errors.New("chat: room and nick are required")
+	}
+	sess.Set(nickKey, req.Nick)
+	if err := sess.Join(req.Room); err != nil {
+		return err
+	}
+
+	history, err := s.store.History(req.Room, s.historySize)
+	if err != nil {
+		return err
+	}
+	sess.Emit(EventHistory, &History{Room: req.Room, Messages: history})
+	sess.Emit(EventMembers, &Members{Room: req.Room, Nicks: s.members(req.Room)})
+	return s.p.EmitRoom(req.Room, EventPresence, &Presence{Room: req.Room, Nick: req.Nick, Online: true})
+}
+
+func (s *Server) leave(sess *pigeon.Session, ev *pigeon.Event) error {
+	var req Leave
+	if err := sess.Decode(ev.Data, &req); err != nil {
+		return err
+	}
+	if err := sess.Leave(req.Room); err != nil {
+		return err
+	}
+	return s.p.EmitRoom(req.Room, EventPresence, &Presence{Room: req.Room, Nick: Nick(sess), Online: false})
+}
+
+func (s *Server) send(sess *pigeon.Session, ev *pigeon.Event) error {
+	var req Send
+	if err := sess.Decode(ev.Data, &req); err != nil {
+		return err
+	}
+	if !sess.InRoom(req.Room) {
+		return errors.New("chat: not in room " + req.Room)
+	}
+	m := Message{
+		ID:   s.nextID(),
+		Room: req.Room,
+		Nick: Nick(sess),
+		Text: req.Text,
+		Time: time.Now(),
+	}
+	if err := s.store.Append(req.Room, m); err != nil {
+		return err
+	}
+	return s.p.EmitRoom(req.Room, EventMessage, &m)
+}
+
+func (s *Server) typing(sess *pigeon.Session, ev *pigeon.Event) error {
+	var req Typing
+	if err := sess.Decode(ev.Data, &req); err != nil {
+		return err
+	}
+	if !sess.InRoom(req.Room) {
+		return errors.New("chat: not in room " + req.Room)
+	}
+	req.Nick = Nick(sess)
+	return s.p.EmitRoom(req.Room, EventTyping, &req)
+}
+
+// 获取房间成员的昵称
+func (s *Server) members(room string) []string {
+	var nicks []string
+	s.p.Range(func(sess *pigeon.Session) bool {
+		if sess.InRoom(room) {
+			if nick := Nick(sess); nick != "" {
+				nicks = append(nicks, nick)
+			}
+		}
+		return true
+	})
+	return nicks
+}
+
+func (s *Server) nextID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return strconv.FormatUint(s.seq, 10)
+}
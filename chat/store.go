@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// 估算每条信息除字符串内容外占用的字节数
+const messageOverhead = 112
+
+// 进程内存储，每个房间保留最近的信息
+type memoryStore struct {
+	rooms map[string][]Message
+	limit int
+	ttl   time.Duration // 信息的保留时间，为0时不过期.
+	mu    *sync.RWMutex
+}
+
+// NewMemoryStore 新建进程内存储，每个房间最多保留limit条信息.
+func NewMemoryStore(limit int) Store {
+	return NewMemoryStoreTTL(limit, 0)
+}
+
+// NewMemoryStoreTTL 新建进程内存储，每个房间最多保留limit条信息，信息超过ttl后过期，为0时不过期.
+// 过期的信息不再出现在历史记录中，由信鸽的压缩器定时清除，见pigeon.Config.CompactInterval.
+func NewMemoryStoreTTL(limit int, ttl time.Duration) Store {
+	return &memoryStore{
+		rooms: make(map[string][]Message),
+		limit: limit,
+		ttl:   ttl,
+		mu:    &sync.RWMutex{},
+	}
+}
+
+func (m *memoryStore) Append(room string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := append(m.rooms[room], msg)
+	if len(msgs) > m.limit {
+		msgs = msgs[len(msgs)-m.limit:]
+	}
+	m.rooms[room] = msgs
+	return nil
+}
+
+func (m *memoryStore) History(room string, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msgs := m.rooms[room]
+	if m.ttl > 0 {
+		msgs = msgs[m.expired(msgs, time.Now()):]
+	}
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	history := make([]Message, len(msgs))
+	copy(history, msgs)
+	return history, nil
+}
+
+// Compact 清除过期的信息，并将每个房间的信息复制到新的数组中，释放裁剪后仍被底层数组引用的旧信息.
+// 释放的字节数按过期信息和数组空余容量估算，不含裁剪前的旧信息.
+func (m *memoryStore) Compact(now time.Time) (int, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, bytes := 0, int64(0)
+	for room, msgs := range m.rooms {
+		n := 0
+		if m.ttl > 0 {
+			n = m.expired(msgs, now)
+		}
+		for _, msg := range msgs[:n] {
+			bytes += messageSize(msg)
+		}
+		entries += n
+		bytes += int64(cap(msgs)-len(msgs)) * messageOverhead
+
+		live := msgs[n:]
+		if len(live) == 0 {
+			delete(m.rooms, room)
+			continue
+		}
+		compacted := make([]Message, len(live))
+		copy(compacted, live)
+		m.rooms[room] = compacted
+	}
+	return entries, bytes, nil
+}
+
+// 获取过期信息的数量，信息按时间顺序追加
+func (m *memoryStore) expired(msgs []Message, now time.Time) int {
+	deadline := now.Add(-m.ttl)
+	i := 0
+	for i < len(msgs) && msgs[i].Time.Before(deadline) {
+		i++
+	}
+	return i
+}
+
+func messageSize(msg Message) int64 {
+	return messageOverhead + int64(len(msg.ID)+len(msg.Room)+len(msg.Nick)+len(msg.Text))
+}
@@ -0,0 +1,157 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ControlConfig 服务端下发客户端配置的控制指令，内容见ClientConfig.
+const ControlConfig = "config"
+
+// ClientConfig 下发给客户端的配置，由客户端自行应用.
+type ClientConfig struct {
+	Version   uint64                 // 配置版本，由SetClientConfig递增，客户端可据此忽略重复或过期的配置.
+	Heartbeat time.Duration          // 客户端发送心跳的间隔，为0时由客户端自行决定.
+	Batch     bool                   // 客户端是否可以合并发送信息.
+	Features  map[string]bool        // 功能开关.
+	Values    map[string]interface{} // 其他配置项，须可JSON编码.
+}
+
+type handleClientConfigFunc func(*Session, ClientConfig) ClientConfig
+
+// 发送给客户端的配置，时间单位为毫秒
+type configControl struct {
+	Op        string                 `json:"op"`
+	Version   uint64                 `json:"version"`
+	Heartbeat int64                  `json:"heartbeat,omitempty"`
+	Batch     bool                   `json:"batch,omitempty"`
+	Features  map[string]bool        `json:"features,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+}
+
+// 当前的客户端配置
+type clientConfigState struct {
+	config  *ClientConfig
+	version uint64
+	mu      *sync.RWMutex
+}
+
+func newClientConfigState() *clientConfigState {
+	return &clientConfigState{mu: &sync.RWMutex{}}
+}
+
+// SetClientConfig 设置客户端配置并推送给当前全部会话，返回推送的会话数量，之后连接的会话在连接时收到.
+// cfg.Version被忽略，由信鸽递增.
+func (p *Pigeon) SetClientConfig(cfg ClientConfig) int {
+	c := p.clientConfig
+	c.mu.Lock()
+	c.version++
+	cfg.Version = c.version
+	c.config = &cfg
+	c.mu.Unlock()
+	return p.PushClientConfigWhere(func(*Session) bool { return true })
+}
+
+// ClientConfig 获取当前的客户端配置，未设置时返回false.
+func (p *Pigeon) ClientConfig() (ClientConfig, bool) {
+	c := p.clientConfig
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.config == nil {
+		return ClientConfig{}, false
+	}
+	return *c.config, true
+}
+
+// HandleClientConfig 推送前按会话调整配置的处理方法，如按用户分组开启功能，传入的配置可修改后返回.
+// 设置后即使未调用SetClientConfig，会话在连接时也会收到配置.
+func (p *Pigeon) HandleClientConfig(fn func(s *Session, cfg ClientConfig) ClientConfig) {
+	p.clientConfigHandler = fn
+}
+
+// PushClientConfigWhere 向满足条件的会话重新推送当前配置，返回推送的会话数量.
+// 用于HandleClientConfig的结果随会话状态变化后局部更新.
+func (p *Pigeon) PushClientConfigWhere(pred func(*Session) bool) int {
+	n := 0
+	for _, s := range p.collect(pred) {
+		if s.PushClientConfig() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// PushClientConfig 向会话推送当前配置，未设置配置和处理方法时返回错误.
+func (s *Session) PushClientConfig() error {
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	p := s.pigeon
+	cfg, ok := p.ClientConfig()
+	if !ok && p.clientConfigHandler == nil {
+		return errors.New("client config is not set")
+	}
+	if p.clientConfigHandler != nil {
+		cfg = p.clientConfigHandler(s, cfg.clone())
+	}
+	msg, err := json.Marshal(&configControl{
+		Op:        ControlConfig,
+		Version:   cfg.Version,
+		Heartbeat: cfg.Heartbeat.Milliseconds(),
+		Batch:     cfg.Batch,
+		Features:  cfg.Features,
+		Values:    cfg.Values,
+	})
+	if err != nil {
+		return err
+	}
+	// 单独成帧，不与其他信息合并发送
+	s.writeMessage(&envelope{t: websocket.TextMessage, message: msg, opts: &SendOptions{}})
+	return nil
+}
+
+// 复制配置的映射，处理方法修改时不影响其他会话，为nil的映射替换为空映射以便直接写入
+func (cfg ClientConfig) clone() ClientConfig {
+	features := make(map[string]bool, len(cfg.Features))
+	for k, v := range cfg.Features {
+		features[k] = v
+	}
+	values := make(map[string]interface{}, len(cfg.Values))
+	for k, v := range cfg.Values {
+		values[k] = v
+	}
+	cfg.Features, cfg.Values = features, values
+	return cfg
+}
+
+// 连接时推送配置，未设置配置和处理方法时跳过
+func (p *Pigeon) pushClientConfigOnConnect(s *Session) {
+	if _, ok := p.ClientConfig(); !ok && p.clientConfigHandler == nil {
+		return
+	}
+	if err := s.PushClientConfig(); err != nil {
+		p.reportError(s, err)
+	}
+}
+
+// ParseClientConfig 解析服务端下发的客户端配置，不是配置指令时返回false.
+func ParseClientConfig(msg []byte) (ClientConfig, bool) {
+	if len(msg) == 0 || msg[0] != '{' {
+		return ClientConfig{}, false
+	}
+	c := &configControl{}
+	if err := json.Unmarshal(msg, c); err != nil || c.Op != ControlConfig {
+		return ClientConfig{}, false
+	}
+	return ClientConfig{
+		Version:   c.Version,
+		Heartbeat: time.Duration(c.Heartbeat) * time.Millisecond,
+		Batch:     c.Batch,
+		Features:  c.Features,
+		Values:    c.Values,
+	}, true
+}
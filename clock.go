@@ -0,0 +1,180 @@
+package pigeon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clock 信鸽使用的时钟，默认为真实时间，测试时可替换为可手动推进的虚拟时钟，见pigeontest.Clock.
+// 写入流程的ping等计时器、PongWait等读取期限、限流器、事件TTL、恢复窗口与恢复令牌、封禁、Session.After等定时器、
+// 分批投递、摘要、压缩、统计推送、outbox和webhook的重试都使用该时钟.
+// 使用虚拟时钟时读取期限由时钟的定时器计时，到期后才使底层连接的读取超时；写入期限WriteWait、
+// 写入重试的退避和webhook签名的时间戳仍使用真实时间，延迟、锁争用等耗时统计也始终按真实时间测量.
+type Clock interface {
+	// Now 获取当前时间.
+	Now() time.Time
+	// NewTicker 新建周期为d的计时器.
+	NewTicker(d time.Duration) ClockTicker
+	// NewTimer 新建d后触发一次的定时器.
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// ClockTicker Clock的周期计时器.
+type ClockTicker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// ClockTimer Clock的定时器，Reset和Stop的语义与time.Timer相同.
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// 真实时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) ClockTicker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) NewTimer(d time.Duration) ClockTimer { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// 获取配置的时钟，未设置时为真实时钟
+func newClock(conf *Config) Clock {
+	if conf.Clock != nil {
+		return conf.Clock
+	}
+	return realClock{}
+}
+
+// 是否使用真实时钟，此时读取期限直接设置在底层连接上
+func (p *Pigeon) realTime() bool {
+	_, ok := p.clock.(realClock)
+	return ok
+}
+
+// 按信鸽的时钟获取当前时间
+func (p *Pigeon) now() time.Time {
+	return p.clock.Now()
+}
+
+// 设置读取期限为d之后. 使用虚拟时钟时由会话的读取定时器计时，到期时将底层连接的期限设为当前真实时间，使读取立即超时
+func (s *Session) setReadTimeout(conn *websocket.Conn, d time.Duration) {
+	if s.pigeon.realTime() {
+		conn.SetReadDeadline(time.Now().Add(d))
+		return
+	}
+	s.mu.Lock()
+	t := s.readTimer
+	if t == nil {
+		t = s.pigeon.clock.NewTimer(d)
+		s.readTimer = t
+		s.mu.Unlock()
+		go s.watchReadTimeout(t)
+		return
+	}
+	s.mu.Unlock()
+	// 定时器仍在计时时原有的等待协程继续等待
+	if !t.Reset(d) {
+		go s.watchReadTimeout(t)
+	}
+}
+
+// 等待读取定时器到期，会话关闭时退出
+func (s *Session) watchReadTimeout(t ClockTimer) {
+	select {
+	case <-t.C():
+		s.connection().SetReadDeadline(time.Now())
+	case <-s.closedCh:
+		t.Stop()
+	}
+}
+
+// 到期时执行函数的定时器，对应time.AfterFunc. 使用真实时钟时直接使用time.AfterFunc，
+// 否则由一个协程等待时钟的定时器并执行fn，fn中未重新计时时协程随即退出
+type funcTimer struct {
+	real    *time.Timer
+	timer   ClockTimer
+	fn      func()
+	waiting bool // 等待协程正在运行.
+	rearmed bool // 触发后又调用了Reset.
+	stopped bool
+	stop    chan struct{}
+	mu      *sync.Mutex
+}
+
+// 在clock的d之后执行fn
+func afterFunc(clock Clock, d time.Duration, fn func()) *funcTimer {
+	if _, ok := clock.(realClock); ok {
+		return &funcTimer{real: time.AfterFunc(d, fn)}
+	}
+	f := &funcTimer{timer: clock.NewTimer(d), fn: fn, waiting: true, stop: make(chan struct{}), mu: &sync.Mutex{}}
+	go f.wait()
+	return f
+}
+
+func (f *funcTimer) wait() {
+	for {
+		select {
+		case <-f.timer.C():
+		case <-f.stop:
+			return
+		}
+		f.mu.Lock()
+		f.rearmed = false
+		f.mu.Unlock()
+		f.fn()
+		f.mu.Lock()
+		again := f.rearmed && !f.stopped
+		f.waiting = again
+		f.mu.Unlock()
+		if !again {
+			return
+		}
+	}
+}
+
+// 重新计时，与time.Timer.Reset相同
+func (f *funcTimer) Reset(d time.Duration) bool {
+	if f.real != nil {
+		return f.real.Reset(d)
+	}
+	f.mu.Lock()
+	f.rearmed = true
+	start := !f.waiting && !f.stopped
+	if start {
+		f.waiting = true
+	}
+	f.mu.Unlock()
+	active := f.timer.Reset(d)
+	if start {
+		go f.wait()
+	}
+	return active
+}
+
+// 停止定时器，停止后不能再重新计时
+func (f *funcTimer) Stop() bool {
+	if f.real != nil {
+		return f.real.Stop()
+	}
+	f.mu.Lock()
+	if !f.stopped {
+		f.stopped = true
+		close(f.stop)
+	}
+	f.mu.Unlock()
+	return f.timer.Stop()
+}
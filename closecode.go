@@ -0,0 +1,81 @@
+package pigeon
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseReason 服务端主动关闭会话的原因分类.
+type CloseReason int
+
+const (
+	CloseWriteTimeout   CloseReason = iota + 1 // 写入超时.
+	CloseBufferOverflow                        // 缓冲区已满.
+	CloseRateLimited                           // 超出频率限制.
+	CloseIdle                                  // 空闲超时.
+	CloseAuthExpired                           // 认证过期.
+	CloseCreditExceeded                        // 超出流量控制额度.
+	CloseMaxAge                                // 超过连接最长存活时间.
+	CloseMemoryBudget                          // 缓冲区占用超出全局内存预算.
+)
+
+// CloseCode 关闭原因对应的关闭码及说明.
+type CloseCode struct {
+	Code int
+	Text string
+}
+
+// 默认关闭码，使用应用自定义的4000段
+func defaultCloseCodes() map[CloseReason]CloseCode {
+	return map[CloseReason]CloseCode{
+		CloseWriteTimeout:   {Code: 4000, Text: "write timeout"},
+		CloseBufferOverflow: {Code: 4001, Text: "buffer overflow"},
+		CloseRateLimited:    {Code: 4002, Text: "rate limited"},
+		CloseIdle:           {Code: 4003, Text: "idle timeout"},
+		CloseAuthExpired:    {Code: 4004, Text: "auth expired"},
+		CloseCreditExceeded: {Code: 4005, Text: "credit exceeded"},
+		CloseMaxAge:         {Code: 4006, Text: "max connection age"},
+		CloseMemoryBudget:   {Code: 4007, Text: "memory budget"},
+	}
+}
+
+// SetCloseCode 设置关闭原因对应的关闭码及说明.
+func (p *Pigeon) SetCloseCode(reason CloseReason, code int, text string) {
+	p.closeCodes[reason] = CloseCode{Code: code, Text: text}
+}
+
+// 获取关闭原因对应的关闭信息
+func (p *Pigeon) closeMessage(reason CloseReason) []byte {
+	c, ok := p.closeCodes[reason]
+	if !ok {
+		return websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "")
+	}
+	return websocket.FormatCloseMessage(c.Code, c.Text)
+}
+
+// CloseWithReason 按关闭原因对应的关闭码关闭会话.
+func (s *Session) CloseWithReason(reason CloseReason) error {
+	return s.CloseWithMsg(s.pigeon.closeMessage(reason))
+}
+
+// 不经过缓冲区直接发送关闭帧并断开连接，用于缓冲区已满或写入失败等情况，只执行一次
+func (s *Session) abort(reason CloseReason) {
+	if !atomic.CompareAndSwapInt32(&s.aborted, 0, 1) {
+		return
+	}
+	msg := s.pigeon.closeMessage(reason)
+	s.markServerClose(msg)
+	conn := s.connection()
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.pigeon.Config.WriteWait))
+	conn.Close()
+}
+
+// 判断是否为超时错误
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,173 @@
+package pigeon
+
+import (
+	"sync"
+	"time"
+)
+
+// 从共享令牌桶取出的令牌在本节点的有效期，过期未用完的令牌作废，避免单个节点长期占用额度
+const clusterLeaseTTL = time.Second
+
+// RateLimitStore 集群共享的令牌桶存储，如Redis. 实现须保证Take在集群内是原子的.
+type RateLimitStore interface {
+	// Take 从键的令牌桶中取出至多n个令牌，返回实际取出的数量. 令牌桶每秒补充rate个令牌，容量为burst，不存在时为满.
+	Take(key string, n int, rate float64, burst int) (int, error)
+}
+
+// RedisTokenBucketScript 实现RateLimitStore.Take的Redis Lua脚本，以EVALSHA执行:
+// KEYS[1]为令牌桶的键，ARGV依次为n、rate和burst，返回取出的令牌数. 时间取自Redis服务器，不受节点时钟偏差影响，
+// 令牌桶补满后自动过期. 需Redis 5及以上版本.
+const RedisTokenBucketScript = `
+local n = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local taken = math.min(n, math.floor(tokens))
+tokens = tokens - taken
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+if rate > 0 then
+	redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+end
+return taken
+`
+
+// 本节点从共享令牌桶取出的令牌
+type clusterLease struct {
+	tokens  int
+	expires time.Time
+}
+
+// 集群限流器，每次从共享令牌桶取出一批令牌在本节点使用，减少对存储的访问
+type clusterLimiter struct {
+	store      RateLimitStore
+	rate       float64
+	burst      int
+	localBurst int
+	leases     map[string]*clusterLease
+	fallback   Limiter
+	clock      Clock
+	mu         *sync.Mutex
+}
+
+// NewClusterLimiter 新建在集群内共享额度的按键限流器，每个键每秒补充rate个令牌，容量为burst.
+// localBurst为每次从存储取出的令牌数，取出的令牌在本节点一秒内有效，越大访问存储越少，
+// 但各节点合计最多可能短暂超出localBurst乘以节点数. 存储出错时退化为只在本节点生效的限流.
+func NewClusterLimiter(store RateLimitStore, rate float64, burst, localBurst int) Limiter {
+	if localBurst < 1 {
+		localBurst = 1
+	}
+	return &clusterLimiter{
+		store:      store,
+		rate:       rate,
+		burst:      burst,
+		localBurst: localBurst,
+		leases:     make(map[string]*clusterLease),
+		fallback:   NewLimiter(rate, burst),
+		clock:      realClock{},
+		mu:         &sync.Mutex{},
+	}
+}
+
+func (l *clusterLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.mu.Unlock()
+	if l.takeLocal(key, now) {
+		return true
+	}
+	n, err := l.store.Take(key, l.localBurst, l.rate, l.burst)
+	if err != nil {
+		return l.fallback.Allow(key)
+	}
+	if n <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, ok := l.leases[key]
+	if !ok || now.After(lease.expires) {
+		if len(l.leases) >= limiterSweepSize {
+			l.sweep(now)
+		}
+		lease = &clusterLease{}
+		l.leases[key] = lease
+	}
+	lease.tokens += n - 1
+	lease.expires = now.Add(clusterLeaseTTL)
+	return true
+}
+
+// 改用clock，存储和本节点的退化限流器由信鸽包提供时一并改用
+func (l *clusterLimiter) useClock(clock Clock) {
+	l.mu.Lock()
+	l.clock = clock
+	l.leases = make(map[string]*clusterLease)
+	l.mu.Unlock()
+	if c, ok := l.fallback.(clockUser); ok {
+		c.useClock(clock)
+	}
+	if c, ok := l.store.(clockUser); ok {
+		c.useClock(clock)
+	}
+}
+
+// 使用本节点未过期的令牌
+func (l *clusterLimiter) takeLocal(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, ok := l.leases[key]
+	if !ok || lease.tokens <= 0 || now.After(lease.expires) {
+		return false
+	}
+	lease.tokens--
+	return true
+}
+
+// 回收用完或过期的令牌
+func (l *clusterLimiter) sweep(now time.Time) {
+	for key, lease := range l.leases {
+		if lease.tokens <= 0 || now.After(lease.expires) {
+			delete(l.leases, key)
+		}
+	}
+}
+
+// 进程内的令牌桶存储
+type memoryRateLimitStore struct {
+	buckets map[string]*TokenBucket
+	clock   Clock
+	mu      *sync.Mutex
+}
+
+// NewMemoryRateLimitStore 新建进程内的令牌桶存储，用于单机部署多个实例或测试.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*TokenBucket), clock: realClock{}, mu: &sync.Mutex{}}
+}
+
+func (m *memoryRateLimitStore) Take(key string, n int, rate float64, burst int) (int, error) {
+	m.mu.Lock()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = newTokenBucket(rate, burst, m.clock)
+		m.buckets[key] = b
+	}
+	m.mu.Unlock()
+	taken := 0
+	for taken < n && b.Allow() {
+		taken++
+	}
+	return taken, nil
+}
+
+// 改用clock并清空已有的令牌桶
+func (m *memoryRateLimitStore) useClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock
+	m.buckets = make(map[string]*TokenBucket)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 压测信息的前缀，格式为 prefix 连接编号 序号 发送时间(UnixNano) 填充
+const probePrefix = "pigeonctl"
+
+type loadResult struct {
+	sent      uint64
+	received  uint64
+	errors    uint64
+	latencies []time.Duration
+	mu        sync.Mutex
+}
+
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost:5000/ws", "服务端地址")
+	conns := fs.Int("conns", 10, "并发连接数")
+	rate := fs.Float64("rate", 1, "每个连接每秒发送的信息数，为0时只接收")
+	duration := fs.Duration("duration", 10*time.Second, "发送持续时间")
+	size := fs.Int("size", 64, "每条信息的最小字节数")
+	mode := fs.String("mode", "echo", "服务端的转发方式，echo为回显给发送者，broadcast为广播给所有连接，用于计算丢失")
+	setup := fs.String("setup", "", "连接后先发送的信息，多条以 ; 分隔，如 {\"op\":\"join\",\"room\":\"a\"}")
+	ramp := fs.Duration("ramp", 0, "建立全部连接所用的时间")
+	fs.Parse(args)
+
+	if *mode != "echo" && *mode != "broadcast" {
+		return errors.New("mode must be echo or broadcast")
+	}
+
+	res := &loadResult{}
+	clients := make([]*websocket.Conn, 0, *conns)
+	var readers sync.WaitGroup
+	for i := 0; i < *conns; i++ {
+		c, _, err := websocket.DefaultDialer.Dial(*url, nil)
+		if err != nil {
+			return fmt.Errorf("dial %d: %w", i, err)
+		}
+		for _, msg := range strings.Split(*setup, ";") {
+			if msg = strings.TrimSpace(msg); msg != "" {
+				c.WriteMessage(websocket.TextMessage, []byte(msg))
+			}
+		}
+		clients = append(clients, c)
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			res.read(c)
+		}()
+		if *ramp > 0 {
+			time.Sleep(*ramp / time.Duration(*conns))
+		}
+	}
+	fmt.Printf("opened %d connections\n", len(clients))
+
+	start := time.Now()
+	var writers sync.WaitGroup
+	if *rate > 0 {
+		for i, c := range clients {
+			writers.Add(1)
+			go func(id int, c *websocket.Conn) {
+				defer writers.Done()
+				res.write(c, id, *rate, *duration, *size)
+			}(i, c)
+		}
+	}
+	writers.Wait()
+	if *rate <= 0 {
+		time.Sleep(*duration)
+	}
+	elapsed := time.Since(start)
+
+	// 等待在途信息
+	time.Sleep(time.Second)
+	for _, c := range clients {
+		c.Close()
+	}
+	readers.Wait()
+
+	res.report(elapsed, *mode, len(clients))
+	return nil
+}
+
+func (r *loadResult) write(c *websocket.Conn, id int, rate float64, duration time.Duration, size int) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+	for seq := 0; ; seq++ {
+		select {
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+		msg := fmt.Sprintf("%s %d %d %d ", probePrefix, id, seq, time.Now().UnixNano())
+		if pad := size - len(msg); pad > 0 {
+			msg += strings.Repeat("x", pad)
+		}
+		if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			atomic.AddUint64(&r.errors, 1)
+			return
+		}
+		atomic.AddUint64(&r.sent, 1)
+	}
+}
+
+func (r *loadResult) read(c *websocket.Conn) {
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(msg))
+		if len(fields) < 4 || fields[0] != probePrefix {
+			continue
+		}
+		ts, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		atomic.AddUint64(&r.received, 1)
+		r.mu.Lock()
+		r.latencies = append(r.latencies, time.Since(time.Unix(0, ts)))
+		r.mu.Unlock()
+	}
+}
+
+func (r *loadResult) report(elapsed time.Duration, mode string, conns int) {
+	sent, received := atomic.LoadUint64(&r.sent), atomic.LoadUint64(&r.received)
+	expected := sent
+	if mode == "broadcast" {
+		expected = sent * uint64(conns)
+	}
+	var dropped uint64
+	if expected > received {
+		dropped = expected - received
+	}
+
+	fmt.Printf("duration:   %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("sent:       %d (%.1f/s)\n", sent, float64(sent)/elapsed.Seconds())
+	fmt.Printf("received:   %d (%.1f/s)\n", received, float64(received)/elapsed.Seconds())
+	fmt.Printf("dropped:    %d of %d expected\n", dropped, expected)
+	fmt.Printf("errors:     %d\n", atomic.LoadUint64(&r.errors))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == 0 {
+		return
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	pct := func(q float64) time.Duration {
+		return r.latencies[int(q*float64(len(r.latencies)-1))]
+	}
+	fmt.Printf("latency:    p50=%s p90=%s p99=%s max=%s\n", pct(0.5), pct(0.9), pct(0.99), r.latencies[len(r.latencies)-1])
+}
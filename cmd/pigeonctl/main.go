@@ -0,0 +1,40 @@
+// pigeonctl 信鸽服务端的压测与调试工具.
+//
+//	pigeonctl load -url ws://localhost:5000/ws -conns 100 -rate 10 -duration 30s -mode broadcast
+//	pigeonctl tail -url ws://localhost:5000/ws -send '{"op":"join","room":"news"}'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "load":
+		err = runLoad(os.Args[2:])
+	case "tail":
+		err = runTail(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pigeonctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pigeonctl <load|tail> [flags]")
+	fmt.Fprintln(os.Stderr, "  load  打开多个并发连接按脚本发送信息，统计延迟、吞吐量和丢失")
+	fmt.Fprintln(os.Stderr, "  tail  打开一个连接并打印收到的全部信息")
+	flag.PrintDefaults()
+}
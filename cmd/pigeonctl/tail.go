@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func runTail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost:5000/ws", "服务端地址")
+	send := fs.String("send", "", "连接后先发送的信息，多条以 ; 分隔")
+	stamp := fs.Bool("time", true, "打印收到信息的时间")
+	fs.Parse(args)
+
+	c, _, err := websocket.DefaultDialer.Dial(*url, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, msg := range strings.Split(*send, ";") {
+		if msg = strings.TrimSpace(msg); msg != "" {
+			if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		t, msg, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		prefix := ""
+		if *stamp {
+			prefix = time.Now().Format("15:04:05.000") + " "
+		}
+		if t == websocket.BinaryMessage {
+			fmt.Printf("%s[binary %d bytes] %x\n", prefix, len(msg), msg)
+			continue
+		}
+		fmt.Printf("%s%s\n", prefix, msg)
+	}
+}
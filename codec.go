@@ -0,0 +1,81 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// CodecQueryParam 通过查询参数指定编解码器时使用的参数名.
+const CodecQueryParam = "codec"
+
+// Codec 信息编解码器.
+type Codec interface {
+	Name() string                               // 名称，同时作为协商使用的子协议名.
+	MessageType() int                           // 编码后的信息类型，websocket.TextMessage或websocket.BinaryMessage.
+	Marshal(v interface{}) ([]byte, error)      // 编码.
+	Unmarshal(data []byte, v interface{}) error // 解码.
+}
+
+// JSONCodec 默认的JSON编解码器.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) MessageType() int                           { return websocket.TextMessage }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// RegisterCodec 注册编解码器，名称加入升级时可协商的子协议，客户端可通过子协议或查询参数协商使用. JSONCodec已默认注册.
+func (p *Pigeon) RegisterCodec(c Codec) {
+	if c == nil {
+		return
+	}
+	_, registered := p.protocols[c.Name()]
+	if _, ok := p.codecs[c.Name()]; !ok && !registered {
+		p.UpGrader.Subprotocols = append(p.UpGrader.Subprotocols, c.Name())
+	}
+	p.codecs[c.Name()] = c
+}
+
+// 协商会话使用的编解码器，依次使用子协议、查询参数，否则使用JSON
+func (p *Pigeon) negotiateCodec(s *Session) Codec {
+	if c, ok := p.codecs[s.conn.Subprotocol()]; ok {
+		return c
+	}
+	if c, ok := p.codecs[s.Request.URL.Query().Get(CodecQueryParam)]; ok {
+		return c
+	}
+	return JSONCodec
+}
+
+// Codec 获取会话协商的编解码器.
+func (s *Session) Codec() Codec {
+	return s.codec
+}
+
+// WriteValue 使用会话的编解码器编码后写入.
+func (s *Session) WriteValue(v interface{}) error {
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.recordAlloc(len(data))
+	s.writeMessage(&envelope{t: s.codec.MessageType(), message: data})
+	return nil
+}
+
+// WriteJSON 同WriteValue，按会话协商的编解码器编码，未协商时为JSON.
+func (s *Session) WriteJSON(v interface{}) error {
+	return s.WriteValue(v)
+}
+
+// Decode 使用会话的编解码器解码收到的信息.
+func (s *Session) Decode(data []byte, v interface{}) error {
+	return s.codec.Unmarshal(data, v)
+}
@@ -0,0 +1,120 @@
+package pigeon
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Compactor 可压缩的历史记录或离线存储，清除过期的信息并释放不再使用的内存.
+type Compactor interface {
+	// Compact 清除now之前过期的信息，返回释放的信息条数和估算的字节数.
+	Compact(now time.Time) (entries int, bytes int64, err error)
+}
+
+// CompactorFunc 以函数实现Compactor.
+type CompactorFunc func(now time.Time) (int, int64, error)
+
+// Compact 实现Compactor.
+func (f CompactorFunc) Compact(now time.Time) (int, int64, error) {
+	return f(now)
+}
+
+// CompactResult 一次压缩的结果.
+type CompactResult struct {
+	Name     string        `json:"name"`
+	Entries  int           `json:"entries"`         // 释放的信息条数.
+	Bytes    int64         `json:"bytes"`           // 估算释放的字节数.
+	Duration time.Duration `json:"duration"`        // 压缩耗时.
+	Error    string        `json:"error,omitempty"` // 压缩失败的原因.
+}
+
+type handleCompactFunc func([]CompactResult)
+
+// 内置压缩器的名称
+const resumesCompactor = "resumes"
+
+// 已注册的压缩器
+type compactorTable struct {
+	compactors map[string]Compactor
+	mu         *sync.Mutex // 同时只进行一次压缩.
+}
+
+func newCompactorTable() *compactorTable {
+	return &compactorTable{compactors: make(map[string]Compactor), mu: &sync.Mutex{}}
+}
+
+// AddCompactor 以name为名注册压缩器，由Config.CompactInterval定时或Compact手动触发，名称重复时返回错误.
+// 内置的resumes压缩器清除过期的待恢复会话状态.
+func (p *Pigeon) AddCompactor(name string, c Compactor) error {
+	if name == "" || c == nil {
+		return errors.New("compactor requires a name")
+	}
+	t := p.compactors
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.compactors[name]; ok || name == resumesCompactor {
+		return errors.New("compactor " + name + " already exists")
+	}
+	t.compactors[name] = c
+	return nil
+}
+
+// RemoveCompactor 移除压缩器.
+func (p *Pigeon) RemoveCompactor(name string) {
+	t := p.compactors
+	t.mu.Lock()
+	delete(t.compactors, name)
+	t.mu.Unlock()
+}
+
+// HandleCompact 每次压缩完成后的处理方法，传入各压缩器的结果，用于上报释放的内存.
+func (p *Pigeon) HandleCompact(fn func([]CompactResult)) {
+	p.compactHandler = fn
+}
+
+// Compact 立即依次执行全部压缩器，返回按名称排序的结果. 正在压缩时等待其完成.
+func (p *Pigeon) Compact() []CompactResult {
+	t := p.compactors
+	t.mu.Lock()
+	names := make([]string, 0, len(t.compactors)+1)
+	for name := range t.compactors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := p.now()
+	results := make([]CompactResult, 0, len(names)+1)
+	start := time.Now()
+	results = append(results, CompactResult{Name: resumesCompactor, Entries: p.resumes.compact(), Duration: time.Since(start)})
+	for _, name := range names {
+		start := time.Now()
+		entries, bytes, err := t.compactors[name].Compact(now)
+		r := CompactResult{Name: name, Entries: entries, Bytes: bytes, Duration: time.Since(start)}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	t.mu.Unlock()
+
+	if p.compactHandler != nil {
+		p.compactHandler(results)
+	}
+	return results
+}
+
+// 定时压缩，信鸽关闭或重启后退出
+func (p *Pigeon) compactLoop(interval time.Duration) {
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	gen := p.hub.gen()
+	for range ticker.C() {
+		if p.hub.closed() || p.hub.gen() != gen {
+			return
+		}
+		p.Compact()
+	}
+}
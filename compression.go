@@ -0,0 +1,21 @@
+package pigeon
+
+import "github.com/gorilla/websocket"
+
+// 设置连接的压缩级别.
+//
+// 上下文接管和窗口大小无法配置，原因在gorilla/websocket v1.5.1的实现中：
+//   - Upgrader只检查客户端是否提供permessage-deflate，忽略其全部参数，应答头固定为
+//     "permessage-deflate; server_no_context_takeover; client_no_context_takeover"，没有可改写的钩子；
+//   - 每条信息写入时从按级别划分的sync.Pool取出新的flate.Writer，写完即归还，
+//     读取时每条信息新建解压器，连接上没有可跨信息保留的压缩上下文；
+//   - compress/flate的窗口固定为32KB，无法按server_max_window_bits缩小.
+//
+// 因此压缩上下文不会随连接常驻，每个连接的额外内存只有写入期间的一个压缩器，只开放级别.
+// 级别越高压缩率越高，但每条信息的CPU开销越大，连接数较多时建议使用1（默认）.
+func (p *Pigeon) tuneCompression(conn *websocket.Conn) error {
+	if !p.Config.EnableCompression || p.Config.CompressionLevel == 0 {
+		return nil
+	}
+	return conn.SetCompressionLevel(p.Config.CompressionLevel)
+}
@@ -0,0 +1,169 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 统计读取字节数的连接
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+// 基准测试的客户端，丢弃收到的信息，只记录数量和读取的字节数
+type benchClient struct {
+	read     int64
+	messages int64
+}
+
+// 等待客户端收到n条信息
+func (c *benchClient) wait(n int64) {
+	for atomic.LoadInt64(&c.messages) < n {
+		time.Sleep(10 * time.Microsecond)
+	}
+}
+
+// 连接到conf配置的实例，返回服务端会话和客户端
+func dialBench(b *testing.B, conf *Config) (*Session, *benchClient) {
+	// gorilla/websocket v1.5.1读完每条压缩信息后都会记录一条重复关闭解压器的日志
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	p := New(conf)
+	sessions := make(chan *Session, 1)
+	p.HandleConnect(func(s *Session) { sessions <- s })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.HandleRequest(w, r)
+	}))
+	b.Cleanup(func() {
+		p.Close()
+		srv.Close()
+	})
+
+	client := &benchClient{}
+	dialer := &websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			return countingConn{Conn: c, read: &client.read}, err
+		},
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			atomic.AddInt64(&client.messages, 1)
+		}
+	}()
+	b.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	return <-sessions, client
+}
+
+// 连接到conf配置的新实例n次，每个连接收到一条msg后返回平均每个连接占用的堆内存，包括测试客户端
+func connHeap(b *testing.B, conf *Config, msg []byte, n int) float64 {
+	p := New(conf)
+	sessions := make(chan *Session, 1)
+	p.HandleConnect(func(s *Session) { sessions <- s })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.HandleRequest(w, r)
+	}))
+	defer srv.Close()
+	defer p.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dialer := &websocket.Dialer{EnableCompression: true}
+	conns := make([]*websocket.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	ctx := context.Background()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns = append(conns, conn)
+		if err := (<-sessions).WriteSync(ctx, msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return float64(int64(after.HeapInuse)-int64(before.HeapInuse)) / float64(n)
+}
+
+// 压缩级别对写入开销和传输字节数的影响，wire-B/msg为客户端每条信息读取的字节数，heap-B/conn为每个连接占用的堆内存
+func BenchmarkCompression(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 4096; i++ {
+		buf.WriteString(`{"event":"tick","data":{"symbol":"PGN","seq":` + strconv.Itoa(i) + `,"price":101.25}}`)
+	}
+	msg := buf.Bytes()
+
+	for _, level := range []int{0, 1, 6, 9} {
+		name := "off"
+		if level > 0 {
+			name = "level" + strconv.Itoa(level)
+		}
+		b.Run(name, func(b *testing.B) {
+			conf := DefaultConfig()
+			conf.EnableCompression = level > 0
+			conf.CompressionLevel = level
+			s, client := dialBench(b, conf)
+			ctx := context.Background()
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			start := atomic.LoadInt64(&client.read)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.WriteSync(ctx, msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			client.wait(int64(b.N))
+			b.ReportMetric(float64(atomic.LoadInt64(&client.read)-start)/float64(b.N), "wire-B/msg")
+			b.ReportMetric(connHeap(b, conf, msg, 100), "heap-B/conn")
+		})
+	}
+}
@@ -4,15 +4,79 @@ import "time"
 
 // Config 信鸽的主要配置结构.
 type Config struct {
-	WriteWait         time.Duration // 写入超时时间.
-	PongWait          time.Duration // 响应超时时间.
-	PingPeriod        time.Duration // 两次ping之间的时间间隔.
-	MaxMessageSize    int64         // 信息最大传输容量.
-	MessageBufferSize int           // 缓冲区最大信息容量.
+	WriteWait                time.Duration     // 写入超时时间.
+	PongWait                 time.Duration     // 响应超时时间.
+	PingPeriod               time.Duration     // 两次ping之间的时间间隔.
+	MaxMessageSize           int64             // 信息最大传输容量.
+	MessageBufferSize        int               // 缓冲区最大信息容量.
+	ControlProtocol          bool              // 是否启用内置控制协议.
+	HubImplementation        HubImplementation // hub的实现方式.
+	EnableCompression        bool              // 是否启用permessage-deflate压缩.
+	StatsInterval            time.Duration     // 向SystemRoom推送运行统计的间隔，为0时不推送.
+	PacingThreshold          int               // 广播接收者超过该数量时分批投递，为0时不分批.
+	PacingWindow             time.Duration     // 分批投递的时间窗口.
+	PacingCohorts            int               // 分批数量，默认10.
+	LaneBufferSize           int               // 处理通道的缓冲容量，默认256.
+	ExpvarName               string            // 非空时以该名称通过expvar发布运行统计，同名的后建实例替换先前的实例，名称已被其他expvar变量占用时不发布并记为错误.
+	CloseOnOverflow          bool              // 缓冲区已满时以CloseBufferOverflow关闭会话.
+	AsyncQueueSize           int               // 异步处理方法每个执行协程的队列容量，默认1024.
+	AsyncWorkers             int               // 异步处理方法的执行协程数量，默认4.
+	SyncHandlers             bool              // 在读写流程中同步调用错误、pong及发送处理方法.
+	EventProtocol            bool              // 是否启用事件协议.
+	EventErrorReply          bool              // 事件未通过校验时是否自动回复EventError事件.
+	SessionLabels            []string          // 允许设置的会话标签名.
+	MaxLabelValues           int               // 每个标签允许的取值数量，默认50.
+	AllowBatch               bool              // 是否允许客户端声明合并接收文本信息.
+	ResumeWindow             time.Duration     // 导入的会话状态等待客户端恢复的时间，默认5分钟.
+	DeliverySampleRate       float64           // 统计投递延迟的广播采样比例，0到1.
+	CreditInterval           time.Duration     // 流量控制授予额度的周期，见Pigeon.UseCreditPolicy.
+	HubQueueSize             int               // hub广播队列的容量，为0时不缓冲，仅对ChannelHub生效.
+	HubOverflow              HubOverflow       // hub广播队列已满时的处理策略.
+	AckTimeout               time.Duration     // 可靠房间中未确认信息的重发间隔，默认5秒.
+	MaxUnacked               int               // 每个会话保留的未确认信息数量上限，超过时以CloseBufferOverflow关闭会话，默认1024.
+	AcceptRate               float64           // 每秒允许接入的连接数，超过时响应503并附带Retry-After，为0时不限制.
+	AcceptBurst              int               // 接入限流允许的突发连接数，默认与AcceptRate相同.
+	CompressionLevel         int               // 压缩级别，-2到9，见compress/flate，为0时使用默认级别1.
+	DisableWriteBufferPool   bool              // 不在连接间共享写缓冲区. 默认共享，空闲连接不持有写缓冲区，BenchmarkIdleConnection实测每个空闲连接约少占用1KB堆内存，每次建立连接的分配次数基本不变.
+	MaxConnectionAge         time.Duration     // 连接的最长存活时间，超过后关闭会话促使客户端重连到其他节点，为0时不限制.
+	MaxConnectionAgeJitter   float64           // 存活时间的随机缩短比例，0到1，将重连分散开，默认0.1.
+	MaxConnectionAgeGrace    time.Duration     // 到期时先发送ControlReconnect建议，等待该时间后再关闭，为0时直接关闭.
+	ResumeTokenTTL           time.Duration     // 恢复令牌的有效期，默认1小时.
+	RoomMetrics              bool              // 是否统计每个房间的广播、投递和丢弃数.
+	RoomMetricsLimit         int               // 统计的房间数量上限，超过的房间计入LabelOverflow，默认1000.
+	RoomMetricsHash          bool              // 统计中以房间名的哈希代替房间名，避免暴露房间名.
+	FairScheduling           bool              // 收到的信息和异步处理方法按身份公平调度，见Pigeon.HandleFairIdentity，每个身份的队列容量为AsyncQueueSize，收到的信息在队列已满时阻塞读取.
+	FairQueueLimit           int               // 公平调度时全部身份排队任务总数的上限，默认为AsyncQueueSize乘以AsyncWorkers.
+	HoldInbound              bool              // 暂存会话收到的信息直到调用Session.Ready，暂存超过MessageBufferSize条时以CloseBufferOverflow关闭会话.
+	PayloadCompressThreshold int               // 协商了应用层压缩的会话，超过该字节数的信息压缩后发送，为0时不启用，见HintPayloadCompression.
+	HighWatermark            float64           // 会话缓冲区占用达到该比例时触发HandleBackpressureOn，取值(0,1]，为0时不启用.
+	LowWatermark             float64           // 会话缓冲区占用回落到该比例时触发HandleBackpressureOff，默认为HighWatermark的一半.
+	TCPKeepAlive             time.Duration     // TCP连接空闲多久后开始保活探测，为负数时关闭保活，TCPKeepAlive、TCPKeepAliveInterval和TCPKeepAliveCount均为0时使用系统默认，找不到底层TCP连接时计入Stats.KeepAliveSkipped.
+	TCPKeepAliveInterval     time.Duration     // TCP保活探测的间隔，需Go 1.23及以上，之前的版本与TCPKeepAlive相同.
+	TCPKeepAliveCount        int               // TCP保活探测无响应多少次后断开，需Go 1.23及以上.
+	ProbeInterval            time.Duration     // 超过该时间未收到任何数据时立即发送ping探活，为0时只按PingPeriod发送.
+	ProbeTimeout             time.Duration     // 探活的ping在该时间内未收到pong时断开，应小于PongWait，默认与ProbeInterval相同.
+	HubMetrics               bool              // 统计hub事件循环各操作的处理耗时、排队等待时间和每秒循环次数，见Pigeon.HubStats，仅对ChannelHub生效.
+	CompactInterval          time.Duration     // 定时执行已注册压缩器的间隔，见Pigeon.AddCompactor，为0时只能通过Pigeon.Compact手动触发.
+	DigestLimit              int               // 房间摘要一个窗口内缓存的信息数量上限，达到后立即合并广播，默认1024，见Pigeon.Digest.
+	QuotaLeaseTTL            time.Duration     // 配额存储实现QuotaLeaseStore时每个连接的租约有效期，默认30秒.
+	SendBytesPerSecond       int               // 全局每秒发送的字节数上限，用于控制出口流量，为0时不限制，见Pigeon.SetSendLimiter.
+	SendBurst                int               // 全局发送限流的突发字节数，默认与SendBytesPerSecond相同.
+	DiagnosticInterval       time.Duration     // 传输诊断发送带序号ping的间隔，据往返延迟和应答是否成批到达评估代理缓冲等问题，见Session.TransportHealth，为0时不诊断.
+	LockProfiling            bool              // 记录hub、房间表、会话和执行器的锁争用，见LockProfile，有少量额外开销.
+	NodeID                   string            // 集群中本节点的ID，用于丢弃集群消息代理投递回本节点的广播，为空时随机生成.
+	UnexpectedBinary         BinaryPolicy      // 未设置HandleMessageBinary等二进制处理方法时收到二进制信息的处理策略，默认丢弃.
+	Rooms                    []RoomDefinition  // 新建实例时声明的常驻房间，见Pigeon.DeclareRoom.
+	TraceSampleRate          float64           // 开始追踪的广播比例，取值0到1，需设置Pigeon.UseTracer，见Tracer.
+	WriteRetries             int               // 底层连接写入遇到超时等暂时性错误时的重试次数，为0时不重试，TLS连接写入出错后不再可用，不重试.
+	WriteRetryBackoff        time.Duration     // 首次写入重试前的等待时间，之后每次翻倍，默认50毫秒.
+	WriteRetryGrace          time.Duration     // 重试后仍因暂时性错误断开的会话保留完整状态等待客户端携带ResumeQueryParam恢复的时间，为0时不保留.
+	QueuedBytesLimit         int64             // 全部会话缓冲区中待发送字节数的上限，超出时丢弃普通信息，仍无法回落时关闭占用最多的会话，为0时不限制.
+	Clock                    Clock             // 信鸽使用的时钟，用于ping和读取期限、限流、TTL、恢复窗口、会话定时器和定时任务，为nil时使用真实时间，见Clock.
 }
 
-// 默认配置
-func defaultConfig() *Config {
+// DefaultConfig 获取New在conf为nil时使用的默认配置，每次返回新的配置.
+func DefaultConfig() *Config {
 	return &Config{
 		WriteWait:         10 * time.Second,
 		PongWait:          60 * time.Second,
@@ -0,0 +1,63 @@
+package pigeon
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// HandleConn 接管已在外部完成websocket握手的连接，例如由自定义代理终结的连接.
+// req为原始的握手请求，用于协商压缩、编解码器等，其握手头部必须完整.
+// 与HandleRequestWithKeys一样会阻塞到会话结束，接入失败时关闭conn.
+func (p *Pigeon) HandleConn(conn net.Conn, req *http.Request, keys map[string]interface{}) error {
+	w := &hijackedWriter{conn: &handshakeConn{Conn: conn}, header: make(http.Header)}
+	if err := p.HandleRequestWithKeys(w, req, keys); err != nil {
+		if !w.hijacked {
+			conn.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// 将已升级的连接伪装为可劫持的ResponseWriter，劫持前写入的HTTP响应都被丢弃
+type hijackedWriter struct {
+	conn     *handshakeConn
+	header   http.Header
+	hijacked bool
+}
+
+func (w *hijackedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *hijackedWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *hijackedWriter) WriteHeader(int) {}
+
+func (w *hijackedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, errors.New("connection already hijacked")
+	}
+	w.hijacked = true
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// 丢弃升级时写入的握手响应，握手已由外部完成
+type handshakeConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *handshakeConn) Write(b []byte) (int, error) {
+	swallowed := false
+	c.once.Do(func() { swallowed = true })
+	if swallowed {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,96 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// 内置控制指令.
+const (
+	ControlJoin  = "join"  // 加入房间.
+	ControlLeave = "leave" // 离开房间.
+)
+
+// Control 客户端发送的控制指令.
+type Control struct {
+	Op    string `json:"op"`
+	Room  string `json:"room,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+}
+
+type controlParseFunc func([]byte) (*Control, bool)
+type controlAuthFunc func(*Session, *Control) error
+
+// 默认控制指令解析，格式为 {"op":"join","room":"x"} 或 {"op":"ack","seq":1}
+func parseControl(msg []byte) (*Control, bool) {
+	if len(msg) == 0 || msg[0] != '{' {
+		return nil, false
+	}
+	c := &Control{}
+	if err := json.Unmarshal(msg, c); err != nil {
+		return nil, false
+	}
+	switch c.Op {
+	case ControlJoin, ControlLeave:
+		return c, c.Room != ""
+	case ControlAck, ControlNack:
+		return c, c.Seq > 0
+	case ControlSubscribe, ControlUnsubscribe:
+		return c, c.Topic != ""
+	}
+	return nil, false
+}
+
+// HandleControlParse 自定义控制指令的解析方法，返回false表示不是控制指令.
+func (p *Pigeon) HandleControlParse(fn func([]byte) (*Control, bool)) {
+	if fn != nil {
+		p.controlParser = fn
+	}
+}
+
+// HandleControlAuth 控制指令的授权方法，返回错误时拒绝执行该指令.
+func (p *Pigeon) HandleControlAuth(fn func(*Session, *Control) error) {
+	p.controlAuthHandler = fn
+}
+
+// 处理控制指令，返回true表示信息已作为控制指令处理
+func (p *Pigeon) handleControl(s *Session, msg []byte) bool {
+	if !p.Config.ControlProtocol {
+		return false
+	}
+
+	c, ok := p.controlParser(msg)
+	if !ok {
+		return false
+	}
+
+	if p.controlAuthHandler != nil {
+		if err := p.controlAuthHandler(s, c); err != nil {
+			p.reportError(s, err)
+			return true
+		}
+	}
+
+	var err error
+	switch c.Op {
+	case ControlJoin:
+		err = s.Join(c.Room)
+	case ControlLeave:
+		err = s.Leave(c.Room)
+	case ControlAck:
+		s.ack(c.Seq)
+	case ControlNack:
+		s.nack(c.Seq)
+	case ControlSubscribe:
+		err = s.subscribe(c.Topic, true)
+	case ControlUnsubscribe:
+		err = s.subscribe(c.Topic, false)
+	default:
+		err = errors.New("unknown control op " + c.Op)
+	}
+	if err != nil && err != ErrRoomWaiting {
+		p.reportError(s, err)
+	}
+	return true
+}
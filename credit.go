@@ -0,0 +1,96 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// ControlCredit 服务端授予额度的控制指令.
+const ControlCredit = "credit"
+
+// Credit 客户端在一个周期内允许发送的信息数量和字节数，为0的项不限制.
+type Credit struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// CreditPolicy 流量控制的授信策略.
+type CreditPolicy interface {
+	// Grant 根据会话上一周期的用量返回下一周期的额度.
+	Grant(s *Session, used Credit) Credit
+}
+
+// FixedCredit 每个周期授予固定额度的策略.
+type FixedCredit Credit
+
+// Grant 返回固定额度.
+func (c FixedCredit) Grant(*Session, Credit) Credit {
+	return Credit(c)
+}
+
+// 发送给客户端的授信指令
+type creditControl struct {
+	Op string `json:"op"`
+	Credit
+}
+
+// 会话的流量控制状态，均为原子操作
+type creditState struct {
+	messages     int64 // 本周期剩余的信息数量，-1表示不限制.
+	bytes        int64 // 本周期剩余的字节数，-1表示不限制.
+	usedMessages int64
+	usedBytes    int64
+}
+
+// UseCreditPolicy 开启流量控制，每隔Config.CreditInterval按策略向客户端授予额度，超出额度的会话以CloseCreditExceeded关闭.
+func (p *Pigeon) UseCreditPolicy(policy CreditPolicy) {
+	p.creditPolicy = policy
+}
+
+// 判断是否开启流量控制
+func (p *Pigeon) flowControl() bool {
+	return p.creditPolicy != nil && p.Config.CreditInterval > 0
+}
+
+// 授予下一周期的额度并通知客户端，在写入流程中调用
+func (s *Session) grantCredit() error {
+	used := Credit{
+		Messages: atomic.SwapInt64(&s.credit.usedMessages, 0),
+		Bytes:    atomic.SwapInt64(&s.credit.usedBytes, 0),
+	}
+	grant := s.pigeon.creditPolicy.Grant(s, used)
+
+	messages, bytes := grant.Messages, grant.Bytes
+	if messages <= 0 {
+		messages = -1
+	}
+	if bytes <= 0 {
+		bytes = -1
+	}
+	atomic.StoreInt64(&s.credit.messages, messages)
+	atomic.StoreInt64(&s.credit.bytes, bytes)
+
+	msg, err := json.Marshal(&creditControl{Op: ControlCredit, Credit: grant})
+	if err != nil {
+		return err
+	}
+	return s.writeRaw(&envelope{t: websocket.TextMessage, message: msg})
+}
+
+// 消耗额度，超出额度时返回false
+func (s *Session) consumeCredit(size int) bool {
+	if !s.pigeon.flowControl() {
+		return true
+	}
+	atomic.AddInt64(&s.credit.usedMessages, 1)
+	atomic.AddInt64(&s.credit.usedBytes, int64(size))
+	if atomic.LoadInt64(&s.credit.messages) >= 0 && atomic.AddInt64(&s.credit.messages, -1) < 0 {
+		return false
+	}
+	if atomic.LoadInt64(&s.credit.bytes) >= 0 && atomic.AddInt64(&s.credit.bytes, -int64(size)) < 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package pigeon
+
+import "sort"
+
+// RoomDefinition 预先声明的常驻房间，如系统通知、公告等设置固定的房间.
+type RoomDefinition struct {
+	Name    string      // 房间名.
+	Options RoomOptions // 房间设置，包括只读等房间模式.
+}
+
+// DeclareRoom 声明常驻房间并应用设置. 常驻房间在没有成员时也不会被回收，
+// 房间统计和房间设置一直保留，已声明时覆盖原有设置. Config.Rooms中的房间在新建实例时自动声明.
+func (p *Pigeon) DeclareRoom(def RoomDefinition) {
+	t := p.hub.rooms
+	t.mu.Lock()
+	t.permanent[def.Name] = struct{}{}
+	t.options[def.Name] = def.Options
+	if _, ok := t.rooms[def.Name]; !ok {
+		t.rooms[def.Name] = make(map[*Session]struct{})
+	}
+	t.mu.Unlock()
+	if p.roomMetrics.enabled {
+		// 预先登记计数器，避免常驻房间因统计房间数量达到上限被计入溢出
+		p.roomMetrics.lookup(def.Name)
+	}
+}
+
+// UndeclareRoom 取消常驻房间的声明，保留当前设置和成员，没有成员时房间被回收.
+func (p *Pigeon) UndeclareRoom(room string) {
+	t := p.hub.rooms
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.permanent, room)
+	if members, ok := t.rooms[room]; ok && len(members) == 0 {
+		delete(t.rooms, room)
+	}
+}
+
+// DeclaredRooms 获取已声明的常驻房间，按名称排列.
+func (p *Pigeon) DeclaredRooms() []string {
+	t := p.hub.rooms
+	t.mu.RLock()
+	rooms := make([]string, 0, len(t.permanent))
+	for room := range t.permanent {
+		rooms = append(rooms, room)
+	}
+	t.mu.RUnlock()
+	sort.Strings(rooms)
+	return rooms
+}
+
+// 没有成员的房间是否需要保留
+func (t *roomTable) retained(room string) bool {
+	_, ok := t.permanent[room]
+	return ok
+}
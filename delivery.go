@@ -0,0 +1,108 @@
+package pigeon
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 保留的最近投递延迟样本数量
+const deliverySampleSize = 4096
+
+// 投递延迟直方图的桶上限，单位为秒
+var deliveryBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Latency 投递延迟分位数.
+type Latency struct {
+	Samples int           `json:"samples"` // 参与计算的样本数.
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	P99     time.Duration `json:"p99"`
+}
+
+// 投递延迟采样，保留最近的样本用于计算分位数，并累计直方图
+type deliveryTracker struct {
+	samples []time.Duration
+	next    int
+	buckets []uint64 // 与deliveryBuckets对应的非累积计数.
+	count   uint64
+	sum     time.Duration
+	mu      *sync.Mutex
+}
+
+// 投递延迟直方图的累积计数
+type deliveryHistogram struct {
+	buckets []uint64 // 不大于各桶上限的样本数.
+	count   uint64
+	sum     time.Duration
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{
+		samples: make([]time.Duration, 0, deliverySampleSize),
+		buckets: make([]uint64, len(deliveryBuckets)),
+		mu:      &sync.Mutex{},
+	}
+}
+
+// 记录样本
+func (t *deliveryTracker) record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+	t.sum += d
+	if i := sort.SearchFloat64s(deliveryBuckets, d.Seconds()); i < len(t.buckets) {
+		t.buckets[i]++
+	}
+	if len(t.samples) < deliverySampleSize {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % deliverySampleSize
+}
+
+// 计算分位数
+func (t *deliveryTracker) latency() Latency {
+	t.mu.Lock()
+	samples := make([]time.Duration, len(t.samples))
+	copy(samples, t.samples)
+	t.mu.Unlock()
+
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(q float64) time.Duration {
+		return samples[int(q*float64(len(samples)-1))]
+	}
+	return Latency{Samples: len(samples), P50: at(0.50), P95: at(0.95), P99: at(0.99)}
+}
+
+// 获取直方图的累积计数
+func (t *deliveryTracker) histogram() deliveryHistogram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := deliveryHistogram{buckets: make([]uint64, len(t.buckets)), count: t.count, sum: t.sum}
+	var cumulative uint64
+	for i, n := range t.buckets {
+		cumulative += n
+		h.buckets[i] = cumulative
+	}
+	return h
+}
+
+// 按Config.DeliverySampleRate标记需要采样的广播
+func (p *Pigeon) sample(m *envelope) {
+	if rate := p.Config.DeliverySampleRate; rate > 0 && (rate >= 1 || rand.Float64() < rate) {
+		m.sampledAt = time.Now()
+	}
+}
+
+// 信息写入后记录采样的投递延迟
+func (p *Pigeon) delivered(m *envelope) {
+	if !m.sampledAt.IsZero() {
+		p.delivery.record(time.Since(m.sampledAt))
+	}
+}
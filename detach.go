@@ -0,0 +1,86 @@
+package pigeon
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 会话已被Detach，读取流程结束
+var errDetached = errors.New("session detached")
+
+// Detach 将会话移出信鸽的管理并返回仍处于打开状态的底层连接，由调用方自行读写.
+// 会话被注销并离开全部房间，写入流程在写完当前信息后停止，缓冲区中尚未发送的信息被丢弃，不会调用断开处理方法.
+// websocket的读取无法在不损坏连接的情况下中断，因此只能在连接处理方法或同步执行的信息、事件处理方法中调用，
+// 在其他协程中调用时返回错误.
+func (s *Session) Detach() (*websocket.Conn, error) {
+	s.mu.Lock()
+	if !s.open {
+		s.mu.Unlock()
+		return nil, errors.New("session is closed")
+	}
+	if s.reading {
+		s.mu.Unlock()
+		return nil, errors.New("session can only be detached from a connect or message handler")
+	}
+	s.open = false
+	s.detached = true
+	from, changed := s.setState(StateClosed)
+	close(s.closedCh)
+	stop, done := s.writeStop, s.writeDone
+	s.writeStop, s.writeDone = nil, nil
+	conn := s.conn
+	s.mu.Unlock()
+	s.stopTimers()
+	if changed {
+		s.stateChanged(from, StateClosed)
+	}
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	s.discardQueued()
+
+	if !s.pigeon.hub.closed() {
+		s.pigeon.hub.remove(s)
+	}
+	s.releaseAllQueued()
+	s.leaveAll()
+	s.SetLane("")
+	s.clearLabels()
+	s.clearTags()
+	s.stopDiagnostics()
+
+	conn.SetCloseHandler(nil)
+	conn.SetPongHandler(nil)
+	conn.SetReadDeadline(time.Time{})
+	conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+// 开始读取下一条信息，会话已被Detach时返回false
+func (s *Session) beginRead() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.detached {
+		return false
+	}
+	s.reading = true
+	return true
+}
+
+// 读取结束
+func (s *Session) endRead() {
+	s.mu.Lock()
+	s.reading = false
+	s.mu.Unlock()
+}
+
+// 判断会话是否已被Detach
+func (s *Session) isDetached() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.detached
+}
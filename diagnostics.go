@@ -0,0 +1,243 @@
+package pigeon
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 传输健康状态.
+const (
+	TransportUnknown  = "unknown"  // 样本不足.
+	TransportOK       = "ok"       // 往返正常.
+	TransportSlow     = "slow"     // 往返延迟远高于基线或有探测丢失.
+	TransportBuffered = "buffered" // 探测应答成批到达，链路上的代理或中间设备在缓冲帧.
+)
+
+// 诊断用ping的载荷前缀，后接8字节序号
+const diagPrefix = "pigeon-diag:"
+
+// 判断状态需要的最少样本数
+const diagMinSamples = 3
+
+// TransportHealth 会话的传输健康评估，需开启Config.DiagnosticInterval.
+type TransportHealth struct {
+	Status   string        `json:"status"`   // 见TransportOK等.
+	Samples  int           `json:"samples"`  // 收到应答的探测数.
+	Lost     int           `json:"lost"`     // 未应答的探测数.
+	Batched  int           `json:"batched"`  // 与上一个应答几乎同时到达的延迟应答数.
+	Slow     int           `json:"slow"`     // 往返延迟远高于基线的应答数.
+	RTT      time.Duration `json:"rtt"`      // 最近一次往返延迟.
+	MinRTT   time.Duration `json:"min_rtt"`  // 最小往返延迟，作为基线.
+	AvgRTT   time.Duration `json:"avg_rtt"`  // 往返延迟的指数移动平均.
+	Jitter   time.Duration `json:"jitter"`   // 往返延迟变化的指数移动平均.
+	Assessed time.Time     `json:"assessed"` // 最近一次评估的时间.
+}
+
+type handleTransportHealthFunc func(*Session, TransportHealth)
+
+// 会话的传输诊断状态
+type transportDiag struct {
+	seq      uint64
+	pending  map[uint64]time.Time // 等待应答的探测及其发送时间.
+	lastSent time.Time            // 上一个收到应答的探测的发送时间.
+	lastPong time.Time            // 上一个应答到达的时间.
+	health   TransportHealth
+	ended    bool // 会话已结束，不再计入各状态的会话数量.
+	mu       *sync.Mutex
+}
+
+func newTransportDiag() *transportDiag {
+	return &transportDiag{
+		pending: make(map[uint64]time.Time),
+		health:  TransportHealth{Status: TransportUnknown},
+		mu:      &sync.Mutex{},
+	}
+}
+
+// HandleTransportHealth 会话的传输健康状态变化时的处理方法，在执行器中调用，需开启Config.DiagnosticInterval.
+func (p *Pigeon) HandleTransportHealth(fn func(*Session, TransportHealth)) {
+	p.transportHealthHandler = fn
+}
+
+// TransportHealth 获取会话的传输健康评估，未开启诊断时状态为TransportUnknown.
+func (s *Session) TransportHealth() TransportHealth {
+	if s.diag == nil {
+		return TransportHealth{Status: TransportUnknown}
+	}
+	s.diag.mu.Lock()
+	defer s.diag.mu.Unlock()
+	return s.diag.health
+}
+
+// 发送带序号的诊断ping，在写入流程中调用
+func (s *Session) diagnose() {
+	d := s.diag
+	now := s.pigeon.now()
+	d.mu.Lock()
+	d.seq++
+	seq := d.seq
+	d.pending[seq] = now
+	// 超过PongWait未应答的探测视为丢失
+	lost := 0
+	for n, sent := range d.pending {
+		if now.Sub(sent) > s.pigeon.Config.PongWait {
+			delete(d.pending, n)
+			lost++
+		}
+	}
+	d.health.Lost += lost
+	health, changed := s.assessTransport(now)
+	d.mu.Unlock()
+	if changed {
+		s.transportHealthChanged(health)
+	}
+
+	payload := binary.BigEndian.AppendUint64([]byte(diagPrefix), seq)
+	s.writeRaw(&envelope{t: websocket.PingMessage, message: payload})
+}
+
+// 收到pong时记录诊断ping的往返延迟，返回false表示不是诊断ping的应答
+func (s *Session) observePong(data string) bool {
+	d := s.diag
+	if d == nil || len(data) != len(diagPrefix)+8 || data[:len(diagPrefix)] != diagPrefix {
+		return false
+	}
+	seq := binary.BigEndian.Uint64([]byte(data[len(diagPrefix):]))
+	now := s.pigeon.now()
+	interval := s.pigeon.Config.DiagnosticInterval
+
+	d.mu.Lock()
+	sent, ok := d.pending[seq]
+	if !ok {
+		d.mu.Unlock()
+		return true
+	}
+	delete(d.pending, seq)
+	rtt := now.Sub(sent)
+	h := &d.health
+	h.Samples++
+	h.RTT = rtt
+	if h.MinRTT == 0 || rtt < h.MinRTT {
+		h.MinRTT = rtt
+	}
+	if h.AvgRTT == 0 {
+		h.AvgRTT = rtt
+	} else {
+		diff := rtt - h.AvgRTT
+		if diff < 0 {
+			diff = -diff
+		}
+		h.Jitter += (diff - h.Jitter) / 8
+		h.AvgRTT += (rtt - h.AvgRTT) / 8
+	}
+	// 发送间隔正常的两个探测，应答却几乎同时到达，说明前一个应答被中间设备扣留
+	if !d.lastPong.IsZero() {
+		sentGap, arriveGap := sent.Sub(d.lastSent), now.Sub(d.lastPong)
+		if sentGap >= interval/2 && arriveGap < sentGap/4 && rtt > sentGap/2 {
+			h.Batched++
+		}
+	}
+	if rtt > 4*h.MinRTT && rtt-h.MinRTT > interval/4 {
+		h.Slow++
+	}
+	d.lastSent, d.lastPong = sent, now
+	health, changed := s.assessTransport(now)
+	d.mu.Unlock()
+	if changed {
+		s.transportHealthChanged(health)
+	}
+	return true
+}
+
+// 按累计的样本评估状态并更新各状态的会话数量，返回状态是否变化，调用时须持有诊断状态的锁
+func (s *Session) assessTransport(now time.Time) (TransportHealth, bool) {
+	d := s.diag
+	h := &d.health
+	from := h.Status
+	h.Assessed = now
+	switch {
+	case h.Samples+h.Lost < diagMinSamples:
+		h.Status = TransportUnknown
+	case h.Batched*4 >= h.Samples && h.Batched > 0:
+		h.Status = TransportBuffered
+	case (h.Slow+h.Lost)*4 >= h.Samples+h.Lost:
+		h.Status = TransportSlow
+	default:
+		h.Status = TransportOK
+	}
+	if from == h.Status {
+		return *h, false
+	}
+	if !d.ended {
+		s.pigeon.transportHealth.move(from, h.Status)
+	}
+	return *h, true
+}
+
+func (s *Session) transportHealthChanged(health TransportHealth) {
+	if fn := s.pigeon.transportHealthHandler; fn != nil {
+		s.pigeon.call(s, func() { fn(s, health) })
+	}
+}
+
+// 开始诊断，计入未知状态的会话数量
+func (s *Session) startDiagnostics() {
+	if s.pigeon.Config.DiagnosticInterval <= 0 {
+		return
+	}
+	s.diag = newTransportDiag()
+	s.pigeon.transportHealth.move("", TransportUnknown)
+}
+
+// 结束诊断，会话关闭或被Detach后调用
+func (s *Session) stopDiagnostics() {
+	d := s.diag
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ended {
+		d.ended = true
+		s.pigeon.transportHealth.move(d.health.Status, "")
+	}
+}
+
+// 各传输健康状态的会话数量
+type transportHealthCounts struct {
+	counts map[string]int
+	mu     *sync.Mutex
+}
+
+func newTransportHealthCounts() *transportHealthCounts {
+	return &transportHealthCounts{counts: make(map[string]int), mu: &sync.Mutex{}}
+}
+
+func (c *transportHealthCounts) move(from, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if from != "" {
+		if c.counts[from]--; c.counts[from] <= 0 {
+			delete(c.counts, from)
+		}
+	}
+	if to != "" {
+		c.counts[to]++
+	}
+}
+
+func (c *transportHealthCounts) snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
@@ -0,0 +1,216 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 默认摘要缓存的信息数量上限
+const defaultDigestLimit = 1024
+
+// 房间摘要，窗口内的房间广播被合并成一条信息
+type digest struct {
+	queued   []*envelope
+	window   time.Duration
+	reduce   func([][]byte) []byte
+	stop     chan struct{}
+	closed   <-chan struct{} // 合并协程所属的信鸽关闭通知，为nil时协程未运行.
+	mu       *sync.Mutex
+	flushing *sync.Mutex // 保证先取出的信息先广播.
+}
+
+// Digest 为房间开启摘要：窗口内发往该房间的广播不立即投递，而是在每个窗口结束时经reduce合并成一条信息后广播.
+// 包括BroadcastRoom、BroadcastRoomBinary、BroadcastRooms、EmitRoom、抽样和过滤等所有房间广播，
+// 同时发往多个房间时进入第一个开启了摘要的房间，合并后仍发给原来的全部房间.
+// 连续的、类型和目标相同且没有过滤器的信息合并为一条，带过滤器的信息各自经reduce后按原目标广播；
+// 注册了多个编解码器时EmitRoom按编解码器分别合并. 缓存的信息达到Config.DigestLimit时立即合并广播.
+func (p *Pigeon) Digest(room string, window time.Duration, reduce func(msgs [][]byte) []byte) error {
+	if window <= 0 || reduce == nil {
+		return errors.New("digest requires a positive window and a reduce func")
+	}
+	d := &digest{window: window, reduce: reduce, stop: make(chan struct{}), mu: &sync.Mutex{}, flushing: &sync.Mutex{}}
+
+	p.digestMu.Lock()
+	if _, ok := p.digests[room]; ok {
+		p.digestMu.Unlock()
+		return errors.New("room " + room + " already has a digest")
+	}
+	p.digests[room] = d
+	p.digestMu.Unlock()
+
+	p.startDigest(d)
+	return nil
+}
+
+// StopDigest 关闭房间摘要，未发出的信息会立即合并广播.
+func (p *Pigeon) StopDigest(room string) {
+	p.digestMu.Lock()
+	d, ok := p.digests[room]
+	delete(p.digests, room)
+	p.digestMu.Unlock()
+
+	if ok {
+		close(d.stop)
+	}
+}
+
+// 信息进入房间摘要时返回true. 集群消息代理转来的广播已在来源节点合并，事务和标签广播不合并
+func (p *Pigeon) digested(m *envelope) bool {
+	if len(m.rooms) == 0 || len(m.tags) > 0 || len(m.parts) > 0 || m.remote || m.digest {
+		return false
+	}
+	var d *digest
+	p.digestMu.RLock()
+	for _, room := range m.rooms {
+		if d = p.digests[room]; d != nil {
+			break
+		}
+	}
+	p.digestMu.RUnlock()
+	if d == nil {
+		return false
+	}
+
+	limit := p.Config.DigestLimit
+	if limit <= 0 {
+		limit = defaultDigestLimit
+	}
+	d.mu.Lock()
+	d.queued = append(d.queued, m)
+	full := len(d.queued) >= limit
+	d.mu.Unlock()
+	if full {
+		p.flushDigest(d)
+	}
+	return true
+}
+
+// 启动摘要的定时合并，本次开启的协程已在运行时忽略. Pigeon.Restart时重新启动
+func (p *Pigeon) startDigest(d *digest) {
+	closed := p.hub.stop()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed == closed {
+		return
+	}
+	d.closed = closed
+	go p.runDigest(d, closed)
+}
+
+// 重新启动全部摘要的定时合并
+func (p *Pigeon) restartDigests() {
+	p.digestMu.RLock()
+	defer p.digestMu.RUnlock()
+	for _, d := range p.digests {
+		p.startDigest(d)
+	}
+}
+
+// 定时合并，摘要关闭时合并剩余信息后退出，信鸽关闭时丢弃剩余信息后退出
+func (p *Pigeon) runDigest(d *digest, closed <-chan struct{}) {
+	ticker := p.clock.NewTicker(d.window)
+	defer ticker.Stop()
+	defer func() {
+		d.mu.Lock()
+		if d.closed == closed {
+			d.closed = nil
+		}
+		d.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ticker.C():
+			p.flushDigest(d)
+		case <-d.stop:
+			p.flushDigest(d)
+			return
+		case <-closed:
+			d.mu.Lock()
+			d.queued = nil
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+// 合并并广播窗口内的信息
+func (p *Pigeon) flushDigest(d *digest) {
+	d.flushing.Lock()
+	defer d.flushing.Unlock()
+
+	d.mu.Lock()
+	queued := d.queued
+	d.queued = nil
+	d.mu.Unlock()
+
+	if len(queued) == 0 || p.hub.closed() {
+		return
+	}
+	for len(queued) > 0 {
+		n := 1
+		for n < len(queued) && sameAudience(queued[0], queued[n]) {
+			n++
+		}
+		p.flushDigestGroup(d, queued[:n])
+		queued = queued[n:]
+	}
+}
+
+// 合并一组目标相同的信息并按原目标广播
+func (p *Pigeon) flushDigestGroup(d *digest, group []*envelope) {
+	msgs := make([][]byte, len(group))
+	for i, m := range group {
+		msgs[i] = m.message
+	}
+	msg := d.reduce(msgs)
+	if msg == nil {
+		return
+	}
+	first := group[0]
+	m := &envelope{
+		t:       first.t,
+		message: msg,
+		rooms:   first.rooms,
+		filter:  first.filter,
+		where:   first.where,
+		opts:    first.opts,
+		exclude: first.exclude,
+		event:   first.event,
+		digest:  true,
+	}
+	if first.payloads != nil {
+		m.payloads = make(map[string]*envelope, len(first.payloads))
+		for name, payload := range first.payloads {
+			for i, g := range group {
+				msgs[i] = g.payloads[name].message
+			}
+			if msg := d.reduce(msgs); msg != nil {
+				m.payloads[name] = &envelope{t: payload.t, message: msg, event: first.event}
+			}
+		}
+	}
+	if err := p.dispatch(m); err != nil {
+		p.reportError(nil, err)
+	}
+}
+
+// 判断两条信息能否合并：类型、目标房间、排除的会话、编码的编解码器、事件和发送选项相同，且都没有过滤器
+func sameAudience(a, b *envelope) bool {
+	if a.filter != nil || b.filter != nil || a.t != b.t || a.exclude != b.exclude ||
+		a.event != b.event || a.opts != b.opts || len(a.rooms) != len(b.rooms) || len(a.payloads) != len(b.payloads) {
+		return false
+	}
+	for i := range a.rooms {
+		if a.rooms[i] != b.rooms[i] {
+			return false
+		}
+	}
+	for name := range a.payloads {
+		if b.payloads[name] == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package pigeon
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func joinDigest(msgs [][]byte) []byte {
+	return bytes.Join(msgs, []byte(","))
+}
+
+// 所有房间广播都进入摘要，连续的目标相同的信息合并，达到上限时立即合并广播
+func TestDigestRoutesRoomBroadcasts(t *testing.T) {
+	conf := DefaultConfig()
+	conf.HubImplementation = LockFree
+	conf.DigestLimit = 4
+	p := New(conf)
+	s := newFuzzSession(p)
+	p.hub.add(s)
+	defer func() {
+		p.hub.remove(s)
+		p.Close()
+	}()
+	if err := s.Join("r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Digest("r", time.Hour, joinDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	p.BroadcastRoom("r", []byte("1"))
+	p.BroadcastRoomBinary("r", []byte("2"))
+	p.BroadcastRooms([]string{"r"}, []byte("3"))
+	if len(s.output) != 0 {
+		t.Fatalf("%d messages delivered before the digest flushed", len(s.output))
+	}
+	p.BroadcastRoomOthers("r", []byte("4"), nil)
+
+	want := []struct {
+		t   int
+		msg string
+	}{
+		{websocket.TextMessage, "1"},
+		{websocket.BinaryMessage, "2"},
+		{websocket.TextMessage, "3,4"},
+	}
+	for _, w := range want {
+		select {
+		case m := <-s.output:
+			if m.t != w.t || string(m.message) != w.msg {
+				t.Fatalf("got %d %q, want %d %q", m.t, m.message, w.t, w.msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", w.msg)
+		}
+	}
+	if len(s.output) != 0 {
+		t.Fatalf("%d unexpected messages", len(s.output))
+	}
+}
+
+// 信鸽关闭时摘要协程退出，重启后恢复
+func TestDigestStopsWithPigeon(t *testing.T) {
+	p := New(DefaultConfig())
+	if err := p.Digest("r", time.Hour, joinDigest); err != nil {
+		t.Fatal(err)
+	}
+	d := p.digests["r"]
+	running := func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.closed != nil
+	}
+	waitFor := func(want bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for running() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("digest running = %v, want %v", !want, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	p.Close()
+	waitFor(false)
+	if err := p.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(true)
+	p.StopDigest("r")
+	waitFor(false)
+	p.Close()
+}
+
+// 注册了多个编解码器时EmitRoom的事件按编解码器分别合并，每个会话收到其编解码器的摘要
+func TestDigestEmitRoomPerCodec(t *testing.T) {
+	conf := DefaultConfig()
+	conf.HubImplementation = LockFree
+	conf.DigestLimit = 2
+	p := New(conf)
+	cbor := NewCBORCodec()
+	p.RegisterCodec(cbor)
+	js, cb := newFuzzSession(p), newFuzzSession(p)
+	cb.codec = cbor
+	for _, s := range []*Session{js, cb} {
+		p.hub.add(s)
+		if err := s.Join("r"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		p.hub.remove(js)
+		p.hub.remove(cb)
+		p.Close()
+	}()
+	if err := p.Digest("r", time.Hour, joinDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	p.EmitRoom("r", "tick", 1)
+	p.EmitRoom("r", "tick", 2)
+	for _, s := range []*Session{js, cb} {
+		a, _ := encodeEvent(s.codec, "tick", 1)
+		b, _ := encodeEvent(s.codec, "tick", 2)
+		select {
+		case m := <-s.output:
+			if m.t != s.codec.MessageType() || !bytes.Equal(m.message, joinDigest([][]byte{a, b})) {
+				t.Fatalf("%s session got %d %q", s.codec.Name(), m.t, m.message)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s session received no digest", s.codec.Name())
+		}
+		if len(s.output) != 0 {
+			t.Fatalf("%s session received %d extra messages", s.codec.Name(), len(s.output))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pigeon
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Initiator 断开连接的发起方.
+type Initiator int
+
+const (
+	InitiatorClient  Initiator = iota + 1 // 客户端发送关闭帧.
+	InitiatorServer                       // 服务端主动关闭.
+	InitiatorTimeout                      // 读取超时，通常是未按时收到pong.
+	InitiatorNetwork                      // 网络错误.
+)
+
+// String 发起方名称.
+func (i Initiator) String() string {
+	switch i {
+	case InitiatorClient:
+		return "client"
+	case InitiatorServer:
+		return "server"
+	case InitiatorTimeout:
+		return "timeout"
+	case InitiatorNetwork:
+		return "network"
+	}
+	return "unknown"
+}
+
+// DisconnectReason 会话断开的原因.
+type DisconnectReason struct {
+	Initiator Initiator     // 发起方.
+	Code      int           // 关闭码，没有关闭帧时为websocket.CloseAbnormalClosure.
+	Text      string        // 关闭说明.
+	Err       error         // 导致断开的读取错误.
+	Duration  time.Duration // 会话持续时间.
+}
+
+type handleDisconnectReasonFunc func(*Session, DisconnectReason)
+
+// HandleDisconnectReason 会话断开时的处理方法，可获取断开原因.
+func (p *Pigeon) HandleDisconnectReason(fn func(*Session, DisconnectReason)) {
+	p.disconnectReasonHandler = fn
+}
+
+// 记录服务端发出的关闭帧
+func (s *Session) markServerClose(msg []byte) {
+	code, text := websocket.CloseNoStatusReceived, ""
+	if len(msg) >= 2 {
+		code, text = int(binary.BigEndian.Uint16(msg)), string(msg[2:])
+	}
+	s.mu.Lock()
+	if s.serverClose == nil {
+		s.serverClose = &websocket.CloseError{Code: code, Text: text}
+	}
+	from, changed := s.setState(StateClosing)
+	s.mu.Unlock()
+	if changed {
+		s.stateChanged(from, StateClosing)
+	}
+}
+
+// 记录客户端先于服务端发出关闭帧
+func (s *Session) markClientClose() {
+	s.mu.Lock()
+	if s.serverClose == nil {
+		s.clientClose = true
+	}
+	from, changed := s.setState(StateClosing)
+	s.mu.Unlock()
+	if changed {
+		s.stateChanged(from, StateClosing)
+	}
+}
+
+// 根据读取流程结束时的错误判断断开原因
+func (s *Session) disconnectReason(err error) DisconnectReason {
+	s.mu.RLock()
+	serverClose, clientClose := s.serverClose, s.clientClose
+	s.mu.RUnlock()
+
+	reason := DisconnectReason{
+		Code:     websocket.CloseAbnormalClosure,
+		Err:      err,
+		Duration: s.pigeon.now().Sub(s.connectedAt),
+	}
+	var ce *websocket.CloseError
+	switch {
+	case clientClose && errors.As(err, &ce):
+		reason.Initiator = InitiatorClient
+		reason.Code, reason.Text = ce.Code, ce.Text
+	case serverClose != nil:
+		reason.Initiator = InitiatorServer
+		reason.Code, reason.Text = serverClose.Code, serverClose.Text
+	case errors.As(err, &ce):
+		reason.Initiator = InitiatorClient
+		reason.Code, reason.Text = ce.Code, ce.Text
+	case isTimeout(err):
+		reason.Initiator = InitiatorTimeout
+	default:
+		reason.Initiator = InitiatorNetwork
+	}
+	return reason
+}
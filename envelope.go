@@ -1,8 +1,49 @@
 package pigeon
 
+import (
+	"context"
+	"time"
+)
+
 // 信封
+// 广播时同一个信封由所有会话共享，message只读.
 type envelope struct {
 	t       int
 	message []byte
 	filter  filterFunc
+	rooms   []string
+	tags    []string // 目标标签，与rooms取并集.
+	opts    *SendOptions
+	where   *Where // 可序列化的过滤谓词，设置时filter为其求值方法.
+	exclude string // 排除的发送者会话ID.
+	event   string // EmitRoom发送的事件名，用于匹配事件TTL.
+
+	payloads map[string]*envelope // 注册了多个编解码器时EmitRoom按编解码器名编码的事件，只设置t和message，为nil时所有会话收到message.
+	variants map[string]*envelope // hub投递时由payloads生成的完整信封，按会话的编解码器选择.
+
+	room     *roomCounters // 单个房间广播的统计，未开启房间统计时为nil.
+	reliable bool          // 目标房间开启了可靠投递.
+	expires  time.Time     // 在离线存储中的过期时间，为零值时不过期，见SetEventTTL.
+	done     chan error    // WriteSync等待写入结果，容量为1.
+	trail    *bridgeTrail  // 经桥接转发时已到达过的实例.
+	remote   bool          // 来自集群消息代理，不再发布到代理.
+	parts    []*envelope   // 广播事务中按顺序投递的信息.
+	digest   bool          // 房间摘要合并后的信息，不再进入摘要.
+
+	sampledAt time.Time // 采样广播的提交时间，未采样时为零值.
+	queuedAt  time.Time // 进入hub队列的时间，未启用Config.HubMetrics时为零值.
+
+	trace    string          // 编码的追踪上下文，随集群消息代理传递，见UseTracer.
+	traceCtx context.Context // 采样追踪的广播span所在的上下文，未追踪时为nil.
+	span     TraceSpan       // 广播的span，hub投递完成时结束.
+}
+
+// 复制消息，广播的消息只复制一次
+func copyBytes(msg []byte) []byte {
+	if msg == nil {
+		return nil
+	}
+	c := make([]byte, len(msg))
+	copy(c, msg)
+	return c
 }
@@ -0,0 +1,159 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// Event 事件信封，使用会话协商的编解码器编解码.
+// 使用JSON编解码器时Data为原始JSON，其他编解码器按字节处理.
+type Event struct {
+	Name string          `json:"event"`
+	ID   string          `json:"id,omitempty"` // 请求应答的关联ID.
+	V    int             `json:"v,omitempty"`  // 事件版本，为0时视为1.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type handleEventFunc func(*Session, *Event) error
+type handleEventErrorFunc func(*Session, *Event, error)
+
+// On 注册事件的处理方法，需启用Config.EventProtocol. fn处理版本1及未携带版本的事件，可为nil，
+// 通过返回的EventRoute注册其他版本的处理方法.
+func (p *Pigeon) On(event string, fn func(*Session, *Event) error) *EventRoute {
+	r := newEventRoute(fn)
+	p.eventHandlers[event] = r.handle
+	return r
+}
+
+// HandleEventError 事件处理方法返回错误时的处理方法，未设置时交给HandleError的处理方法.
+func (p *Pigeon) HandleEventError(fn func(*Session, *Event, error)) {
+	p.eventErrorHandler = fn
+}
+
+// Emit 向会话发送事件，data使用会话的编解码器编码.
+func (s *Session) Emit(event string, data interface{}) error {
+	msg, err := s.encodeEvent(event, data)
+	if err != nil {
+		return err
+	}
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	s.recordAlloc(len(msg))
+	s.writeMessage(&envelope{t: s.codec.MessageType(), message: msg})
+	return nil
+}
+
+// EmitRoom 向房间内的会话发送事件，与其他房间广播一样经过摘要、镜像、集群消息代理和分批投递等.
+// 注册了多个编解码器时按每个编解码器分别编码后作为一次广播提交，投递时每个会话只收到其编解码器编码的一份；
+// 任一编解码器编码失败时返回错误，不发送.
+func (p *Pigeon) EmitRoom(room, event string, data interface{}) error {
+	m, err := p.encodeEvents(event, data)
+	if err != nil {
+		return err
+	}
+	m.rooms = []string{room}
+	return p.dispatch(m)
+}
+
+// 按已注册的编解码器编码事件. 注册了多个编解码器时payloads保存各编解码器的编码，
+// message为JSON的编码，未注册JSON时为名称排序第一个编解码器的编码，供镜像、旁路和webhook等使用
+func (p *Pigeon) encodeEvents(event string, data interface{}) (*envelope, error) {
+	names := make([]string, 0, len(p.codecs))
+	for name := range p.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	payloads := make(map[string]*envelope, len(names))
+	for _, name := range names {
+		c := p.codecs[name]
+		msg, err := encodeEvent(c, event, data)
+		if err != nil {
+			return nil, err
+		}
+		payloads[name] = &envelope{t: c.MessageType(), message: msg, event: event}
+	}
+	base, ok := payloads[JSONCodec.Name()]
+	if !ok {
+		base = payloads[names[0]]
+	}
+	if len(payloads) == 1 {
+		return base, nil
+	}
+	return &envelope{t: base.t, message: base.message, event: event, payloads: payloads}, nil
+}
+
+// 由payloads生成各编解码器的完整信封，hub投递前调用一次
+func (m *envelope) resolvePayloads() {
+	if m.payloads == nil {
+		return
+	}
+	m.variants = make(map[string]*envelope, len(m.payloads))
+	for name, payload := range m.payloads {
+		v := *m
+		v.t, v.message, v.payloads = payload.t, payload.message, nil
+		m.variants[name] = &v
+	}
+}
+
+// 获取会话要收到的信封
+func (m *envelope) variantFor(s *Session) *envelope {
+	if m.variants == nil {
+		return m
+	}
+	return m.variants[s.codec.Name()]
+}
+
+// 编码事件
+func (s *Session) encodeEvent(event string, data interface{}) ([]byte, error) {
+	return encodeEvent(s.codec, event, data)
+}
+
+// 使用编解码器编码事件
+func encodeEvent(c Codec, event string, data interface{}) ([]byte, error) {
+	ev := &Event{Name: event}
+	if data != nil {
+		raw, err := c.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		ev.Data = raw
+	}
+	return c.Marshal(ev)
+}
+
+// 按事件协议处理信息，返回true表示信息已作为事件处理
+func (p *Pigeon) handleEvent(s *Session, t int, msg []byte) bool {
+	if !p.Config.EventProtocol || t != s.codec.MessageType() {
+		return false
+	}
+	ev := &Event{}
+	if err := s.codec.Unmarshal(msg, ev); err != nil || ev.Name == "" {
+		return false
+	}
+	if ev.Name == EventReply && ev.ID != "" && p.calls.resolve(ev.ID, s, ev.Data) {
+		return true
+	}
+	fn, ok := p.eventHandlerOf(s, ev.Name)
+	if !ok {
+		return false
+	}
+	if err := p.validateEvent(s, ev); err != nil {
+		p.invalidEvent(s, ev, err)
+		return true
+	}
+	if err := fn(s, ev); err != nil {
+		p.eventError(s, ev, err)
+	}
+	return true
+}
+
+// 交给事件错误处理方法
+func (p *Pigeon) eventError(s *Session, ev *Event, err error) {
+	if p.eventErrorHandler != nil {
+		p.eventErrorHandler(s, ev, err)
+		return
+	}
+	p.reportError(s, err)
+}
@@ -0,0 +1,108 @@
+package pigeon_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+	"github.com/gorilla/websocket"
+)
+
+func TestEmitRoomDispatch(t *testing.T) {
+	broker := newMemBroker(1)
+	cbor := pigeon.NewCBORCodec()
+	var nodes []*pigeontest.Server
+	for _, id := range []string{"a", "b"} {
+		conf := pigeon.DefaultConfig()
+		conf.NodeID = id
+		srv := newTargetServer(t, conf)
+		srv.Pigeon.RegisterCodec(cbor)
+		if err := srv.Pigeon.UseBroker(broker); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, srv)
+	}
+	clients := [][]*pigeontest.Client{
+		{nodes[0].Dial(t, "/?rooms=r"), nodes[0].Dial(t, "/?rooms=r&codec=cbor"), nodes[0].Dial(t, "/")},
+		{nodes[1].Dial(t, "/?rooms=r"), nodes[1].Dial(t, "/?rooms=r&codec=cbor")},
+	}
+	codecs := []pigeon.Codec{pigeon.JSONCodec, cbor, pigeon.JSONCodec, pigeon.JSONCodec, cbor}
+
+	a := nodes[0].Pigeon
+	if err := a.EmitRoom("r", "hello", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	tx := a.BeginBroadcast("r")
+	if err := tx.Emit("tx", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务不经过代理，只在本节点投递
+	want := [][]string{{"hello", "tx"}, {"hello", "tx"}, nil, {"hello"}, {"hello"}}
+	var got [][]string
+	for i, msgs := range receivedAll(t, nodes, clients) {
+		var names []string
+		for _, msg := range msgs {
+			ev := &pigeon.Event{}
+			if err := codecs[i].Unmarshal([]byte(msg), ev); err != nil {
+				t.Fatalf("client %d received %q: %v", i, msg, err)
+			}
+			names = append(names, ev.Name)
+		}
+		sort.Strings(names)
+		got = append(got, names)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	if st := a.BrokerStats(); st.Published != 1 {
+		t.Fatalf("published %d events to the broker, want one for all codecs", st.Published)
+	}
+}
+
+// 编码失败的编解码器
+type failingCodec struct{}
+
+func (failingCodec) Name() string                        { return "failing" }
+func (failingCodec) MessageType() int                    { return websocket.BinaryMessage }
+func (failingCodec) Marshal(interface{}) ([]byte, error) { return nil, errors.New("marshal failed") }
+func (failingCodec) Unmarshal([]byte, interface{}) error { return errors.New("unmarshal failed") }
+
+// 任一编解码器编码失败时EmitRoom返回错误，其他编解码器的会话也收不到
+func TestEmitRoomCodecError(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	srv.Pigeon.RegisterCodec(failingCodec{})
+	c := srv.Dial(t, "/?rooms=r")
+	if err := srv.Pigeon.EmitRoom("r", "hello", nil); err == nil {
+		t.Fatal("EmitRoom succeeded with a failing codec")
+	}
+	if got := received(t, srv, c); len(got[0]) != 0 {
+		t.Fatalf("received %v after a failed emit", got[0])
+	}
+}
+
+// 包括json在内的全部编解码器都可通过子协议协商，重复注册不重复加入子协议
+func TestCodecSubprotocols(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	srv.Pigeon.RegisterCodec(failingCodec{})
+	srv.Pigeon.RegisterCodec(failingCodec{})
+	if got := srv.Pigeon.UpGrader.Subprotocols; !reflect.DeepEqual(got, []string{"json", "failing"}) {
+		t.Fatalf("subprotocols %v, want [json failing]", got)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"json"}}
+	conn, _, err := dialer.Dial(srv.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.Subprotocol(); got != "json" {
+		t.Fatalf("negotiated %q, want json", got)
+	}
+}
@@ -0,0 +1,94 @@
+package pigeon
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMessageExpired 信息超过所属事件的有效期，不再投递.
+var ErrMessageExpired = errors.New("message expired")
+
+// 各事件在离线存储中的有效期
+type eventTTLTable struct {
+	exact    map[string]time.Duration
+	prefixes map[string]time.Duration
+	mu       *sync.RWMutex
+}
+
+func newEventTTLTable() *eventTTLTable {
+	return &eventTTLTable{
+		exact:    make(map[string]time.Duration),
+		prefixes: make(map[string]time.Duration),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// SetEventTTL 设置事件在离线存储中的有效期. 离线存储指可靠房间中等待客户端确认的信息，
+// 包括会话断开后随会话状态保留到客户端恢复的部分.
+//
+// pattern为事件名，以*结尾时匹配该前缀的全部事件，单独的*匹配全部事件，多个匹配时使用最长的前缀.
+// ttl为0时事件不进入离线存储，只向在线的会话发送一次，如输入状态；大于0时超过有效期的信息不再重发或恢复.
+// 通过EmitRoom发送的事件按事件名匹配，其他广播按*匹配.
+func (p *Pigeon) SetEventTTL(pattern string, ttl time.Duration) {
+	t := p.eventTTLs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		t.prefixes[prefix] = ttl
+	} else {
+		t.exact[pattern] = ttl
+	}
+}
+
+// RemoveEventTTL 移除SetEventTTL设置的有效期.
+func (p *Pigeon) RemoveEventTTL(pattern string) {
+	t := p.eventTTLs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		delete(t.prefixes, prefix)
+	} else {
+		delete(t.exact, pattern)
+	}
+}
+
+// EventTTL 获取事件生效的有效期，没有匹配的设置时返回false.
+func (p *Pigeon) EventTTL(event string) (time.Duration, bool) {
+	t := p.eventTTLs
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if ttl, ok := t.exact[event]; ok {
+		return ttl, true
+	}
+	var ttl time.Duration
+	matched := -1
+	for prefix, d := range t.prefixes {
+		if len(prefix) > matched && strings.HasPrefix(event, prefix) {
+			ttl, matched = d, len(prefix)
+		}
+	}
+	return ttl, matched >= 0
+}
+
+// 按事件的有效期设置信封的过期时间，有效期为0时不进入离线存储
+func (p *Pigeon) applyEventTTL(m *envelope, event string) {
+	if !m.reliable {
+		return
+	}
+	ttl, ok := p.EventTTL(event)
+	if !ok {
+		return
+	}
+	if ttl <= 0 {
+		m.reliable = false
+		return
+	}
+	m.expires = p.now().Add(ttl)
+}
+
+// 判断信息是否已过期
+func (m *envelope) expired(now time.Time) bool {
+	return !m.expires.IsZero() && now.After(m.expires)
+}
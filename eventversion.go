@@ -0,0 +1,96 @@
+package pigeon
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrUnsupportedVersion 事件版本没有对应的处理方法，且无法升级到有处理方法的版本.
+var ErrUnsupportedVersion = errors.New("unsupported event version")
+
+// EventRoute 同一事件各版本的处理方法. 事件的版本取自Event.V，未携带版本时为1.
+//
+// 收到的版本没有处理方法时，依次执行Upgrade注册的升级方法直到某个版本有处理方法；
+// 仍找不到时交给Fallback的处理方法，未设置时以ErrUnsupportedVersion交给事件错误处理方法，ev.V为最后尝试的版本.
+type EventRoute struct {
+	handlers map[int]handleEventFunc
+	upgrades map[int]handleEventFunc
+	fallback handleEventFunc
+	mu       *sync.RWMutex
+}
+
+func newEventRoute(fn handleEventFunc) *EventRoute {
+	r := &EventRoute{
+		handlers: make(map[int]handleEventFunc),
+		upgrades: make(map[int]handleEventFunc),
+		mu:       &sync.RWMutex{},
+	}
+	if fn != nil {
+		r.handlers[1] = fn
+	}
+	return r
+}
+
+// OnV 注册版本v的处理方法.
+func (r *EventRoute) OnV(v int, fn func(*Session, *Event) error) *EventRoute {
+	r.mu.Lock()
+	r.handlers[v] = fn
+	r.mu.Unlock()
+	return r
+}
+
+// Upgrade 注册将事件从版本from升级为from+1的方法，fn修改ev.Data，返回错误时中止处理.
+// 可逐级注册，让旧客户端的事件由新版本的处理方法处理.
+func (r *EventRoute) Upgrade(from int, fn func(*Session, *Event) error) *EventRoute {
+	r.mu.Lock()
+	r.upgrades[from] = fn
+	r.mu.Unlock()
+	return r
+}
+
+// Fallback 设置没有处理方法且无法升级的版本的处理方法.
+func (r *EventRoute) Fallback(fn func(*Session, *Event) error) *EventRoute {
+	r.mu.Lock()
+	r.fallback = fn
+	r.mu.Unlock()
+	return r
+}
+
+// Versions 获取有处理方法的版本，按从小到大排列.
+func (r *EventRoute) Versions() []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := make([]int, 0, len(r.handlers))
+	for v := range r.handlers {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// 按事件版本选择处理方法，必要时逐级升级
+func (r *EventRoute) handle(s *Session, ev *Event) error {
+	if ev.V == 0 {
+		ev.V = 1
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for {
+		if fn, ok := r.handlers[ev.V]; ok {
+			return fn(s, ev)
+		}
+		upgrade, ok := r.upgrades[ev.V]
+		if !ok {
+			break
+		}
+		if err := upgrade(s, ev); err != nil {
+			return err
+		}
+		ev.V++
+	}
+	if r.fallback != nil {
+		return r.fallback(s, ev)
+	}
+	return ErrUnsupportedVersion
+}
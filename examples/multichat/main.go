@@ -18,14 +18,12 @@ func main() {
 		http.ServeFile(c.Writer, c.Request, "chan.html")
 	})
 
-	r.GET("/channel/:name/ws", func(c *gin.Context) {
-		m.HandleRequest(c.Writer, c.Request)
-	})
+	router := m.Router()
+	router.Mount(pigeon.Route{Pattern: "/channel/{name}/ws", Room: "{name}"})
+	r.GET("/channel/:name/ws", gin.WrapH(router))
 
 	m.HandleMessage(func(s *pigeon.Session, msg []byte) {
-		m.BroadcastFilter(msg, func(q *pigeon.Session) bool {
-			return q.Request.URL.Path == s.Request.URL.Path
-		})
+		m.BroadcastRooms(s.Rooms(), msg)
 	})
 
 	r.Run(":5000")
@@ -0,0 +1,159 @@
+package pigeon
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认执行队列容量及协程数量
+const (
+	defaultExecutorQueueSize = 1024
+	defaultExecutorWorkers   = 4
+)
+
+// 有界执行器，同一会话的任务固定由同一个协程按提交顺序执行，队列已满时丢弃.
+// 停止后提交的任务在调用方协程中直接执行，不会滞留到重启之后
+type executor struct {
+	workers []chan func()
+	size    int
+	fair    *fairScheduler
+	dropped *uint64
+	stopCh  chan struct{}
+	stopped bool
+	mu      *sync.RWMutex
+}
+
+func newExecutor(size, workers int, dropped *uint64) *executor {
+	if size <= 0 {
+		size = defaultExecutorQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultExecutorWorkers
+	}
+	e := &executor{workers: make([]chan func(), workers), size: size, dropped: dropped, mu: &sync.RWMutex{}}
+	e.start()
+	return e
+}
+
+// 启动执行协程，Pigeon.Restart时重新启动. 每次启动使用新的队列，上一次的协程执行完旧队列后退出
+func (e *executor) start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = false
+	if e.fair != nil {
+		e.fair.start()
+		return
+	}
+	e.stopCh = make(chan struct{})
+	for i := range e.workers {
+		e.workers[i] = make(chan func(), e.size)
+		go e.run(e.workers[i], e.stopCh)
+	}
+}
+
+// 停止执行协程，已排队的任务执行完毕后协程退出，在Pigeon.Close时调用
+func (e *executor) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+	if e.fair != nil {
+		e.fair.stop()
+		return
+	}
+	if e.stopCh != nil {
+		close(e.stopCh)
+		e.stopCh = nil
+	}
+}
+
+func (e *executor) run(queue chan func(), stop chan struct{}) {
+	for {
+		select {
+		case fn := <-queue:
+			fn()
+		case <-stop:
+			for {
+				select {
+				case fn := <-queue:
+					fn()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// 提交任务，不阻塞. 已停止时在调用方协程中执行
+func (e *executor) submit(s *Session, fn func()) bool {
+	e.mu.RLock()
+	if e.stopped {
+		e.mu.RUnlock()
+		fn()
+		return true
+	}
+	defer e.mu.RUnlock()
+	if e.fair != nil {
+		return e.submitFair(s, fn)
+	}
+	queue := e.workers[0]
+	if s != nil {
+		queue = e.workers[int(s.cohort*float64(len(e.workers)))]
+	}
+	select {
+	case queue <- fn:
+		return true
+	default:
+		atomic.AddUint64(e.dropped, 1)
+		return false
+	}
+}
+
+// 运行中的会话流程计数，Pigeon.Close等待会话流程结束后再停止执行器
+type serving struct {
+	n    int
+	idle chan struct{}
+	mu   *sync.Mutex
+}
+
+func newServing() *serving {
+	return &serving{mu: &sync.Mutex{}}
+}
+
+func (w *serving) add() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n == 0 {
+		w.idle = make(chan struct{})
+	}
+	w.n++
+}
+
+func (w *serving) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.n--
+	if w.n == 0 {
+		close(w.idle)
+	}
+}
+
+// 等待所有会话流程结束，超时返回false
+func (w *serving) wait(timeout time.Duration) bool {
+	w.mu.Lock()
+	if w.n == 0 {
+		w.mu.Unlock()
+		return true
+	}
+	idle := w.idle
+	w.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,147 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+)
+
+// ExperimentKeyPrefix 会话所在实验分组在Keys中的键前缀，完整的键为前缀加实验名，取值为分组名.
+// 将完整的键加入Config.SessionLabels后，Stats.Labels中会统计各分组的会话数量.
+const ExperimentKeyPrefix = "exp:"
+
+// ErrInvalidExperiment 实验名为空、没有分组或分组权重不为正数.
+var ErrInvalidExperiment = errors.New("experiment needs a name and variants with positive weights")
+
+// Experiment A/B实验，会话在连接时按身份稳定地分入各分组.
+type Experiment struct {
+	Name     string    // 实验名.
+	Salt     string    // 分组的盐值，为空时使用实验名，更换后全部身份重新分组.
+	Variants []Variant // 分组，按权重比例分配.
+}
+
+// Variant 实验分组.
+type Variant struct {
+	Name   string  // 分组名.
+	Weight float64 // 相对权重.
+}
+
+// 进行中的实验
+type experimentTable struct {
+	experiments []Experiment
+	mu          *sync.RWMutex
+}
+
+func newExperimentTable() *experimentTable {
+	return &experimentTable{mu: &sync.RWMutex{}}
+}
+
+// AddExperiment 添加实验，已连接和之后连接的会话都会分组，同名的实验被替换，已分组的会话保持原分组.
+// 分组由盐值和会话身份（见HandleIdentity，未设置时为会话ID）的哈希决定，同一身份在重连、其他节点上分组相同.
+func (p *Pigeon) AddExperiment(exp Experiment) error {
+	if exp.Name == "" || len(exp.Variants) == 0 {
+		return ErrInvalidExperiment
+	}
+	for _, v := range exp.Variants {
+		if !(v.Weight > 0) {
+			return ErrInvalidExperiment
+		}
+	}
+	if exp.Salt == "" {
+		exp.Salt = exp.Name
+	}
+	exp.Variants = append([]Variant(nil), exp.Variants...)
+
+	t := p.experiments
+	t.mu.Lock()
+	replaced := false
+	for i := range t.experiments {
+		if t.experiments[i].Name == exp.Name {
+			t.experiments[i], replaced = exp, true
+		}
+	}
+	if !replaced {
+		t.experiments = append(t.experiments, exp)
+	}
+	t.mu.Unlock()
+
+	for _, s := range p.collect(func(*Session) bool { return true }) {
+		s.assignVariant(exp)
+	}
+	return nil
+}
+
+// RemoveExperiment 移除实验，会话Keys中已有的分组保留.
+func (p *Pigeon) RemoveExperiment(name string) {
+	t := p.experiments
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.experiments {
+		if t.experiments[i].Name == name {
+			t.experiments = append(t.experiments[:i:i], t.experiments[i+1:]...)
+			return
+		}
+	}
+}
+
+// Variant 获取会话在实验中的分组，未分组时返回空字符串.
+func (s *Session) Variant(experiment string) string {
+	v, _ := s.Get(ExperimentKeyPrefix + experiment)
+	name, _ := v.(string)
+	return name
+}
+
+// ExperimentWhere 匹配实验分组的谓词，可与其他谓词组合后用于BroadcastWhere等.
+func ExperimentWhere(experiment, variant string) Where {
+	return Where{Key: ExperimentKeyPrefix + experiment, Op: Eq, Value: variant}
+}
+
+// BroadcastExperiment 向实验中指定分组的会话广播消息，用于定向发布.
+func (p *Pigeon) BroadcastExperiment(experiment, variant string, msg []byte) error {
+	return p.BroadcastWhere(msg, ExperimentWhere(experiment, variant))
+}
+
+// 连接时为会话分入全部实验
+func (p *Pigeon) assignExperiments(s *Session) {
+	t := p.experiments
+	t.mu.RLock()
+	experiments := t.experiments
+	t.mu.RUnlock()
+	for _, exp := range experiments {
+		s.assignVariant(exp)
+	}
+}
+
+// 为会话分组，已有分组时保持不变，如恢复的会话
+func (s *Session) assignVariant(exp Experiment) {
+	key := ExperimentKeyPrefix + exp.Name
+	if _, ok := s.Get(key); ok {
+		return
+	}
+	identity := s.Identity()
+	if identity == "" {
+		identity = s.id
+	}
+	variant := pickVariant(exp, sampleBucket(exp.Salt, identity))
+	s.Set(key, variant)
+	if s.pigeon.labels.allowed[key] {
+		if err := s.SetLabel(key, variant); err != nil {
+			s.pigeon.reportError(s, err)
+		}
+	}
+}
+
+// 按权重将[0,1)中的位置映射到分组
+func pickVariant(exp Experiment, x float64) string {
+	total := 0.0
+	for _, v := range exp.Variants {
+		total += v.Weight
+	}
+	x *= total
+	for _, v := range exp.Variants {
+		if x < v.Weight {
+			return v.Name
+		}
+		x -= v.Weight
+	}
+	return exp.Variants[len(exp.Variants)-1].Name
+}
@@ -0,0 +1,150 @@
+package pigeon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 判断是否为RFC 8441的扩展CONNECT请求，即HTTP/2流上的websocket握手
+func isExtendedConnect(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.ProtoMajor >= 2 && r.Header.Get(":protocol") == "websocket"
+}
+
+// 在HTTP/2流上接入会话. websocket帧在流的请求体和响应体中传输，握手改写为等价的HTTP/1.1升级请求后按原流程处理，
+// 升级响应中协商的子协议、扩展等头部以200响应发回客户端.
+// 需HTTP/2服务端开启扩展CONNECT，标准库为GODEBUG=http2xconnect=1
+func (p *Pigeon) serveExtendedConnect(w http.ResponseWriter, r *http.Request, keys map[string]interface{}, rooms []string) error {
+	key := make([]byte, 16)
+	rand.Read(key)
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	req.Header.Del(":protocol")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if req.Header.Get("Sec-WebSocket-Version") == "" {
+		req.Header.Set("Sec-WebSocket-Version", "13")
+	}
+	conn := newStreamConn(w, r)
+	defer conn.Close()
+	return p.handleRequest(&streamWriter{ResponseWriter: w, conn: conn}, req, keys, rooms)
+}
+
+// 劫持时返回HTTP/2流的ResponseWriter，劫持前的错误响应仍直接写给客户端
+type streamWriter struct {
+	http.ResponseWriter
+	conn     *streamConn
+	hijacked bool
+}
+
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, errors.New("stream already hijacked")
+	}
+	w.hijacked = true
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// HTTP/2流上的连接，读取请求体，写入响应体
+type streamConn struct {
+	w          http.ResponseWriter
+	body       io.ReadCloser
+	rc         *http.ResponseController
+	remote     net.Addr
+	handshaken bool
+	closed     bool
+	mu         *sync.Mutex
+}
+
+func newStreamConn(w http.ResponseWriter, r *http.Request) *streamConn {
+	return &streamConn{
+		w:      w,
+		body:   r.Body,
+		rc:     http.NewResponseController(w),
+		remote: streamAddr(r.RemoteAddr),
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+// 首次写入为升级响应，转换为200响应的头部，之后的写入为websocket帧
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if !c.handshaken {
+		c.handshaken = true
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+		if err != nil {
+			return 0, err
+		}
+		header := c.w.Header()
+		for k, v := range resp.Header {
+			switch k {
+			case "Upgrade", "Connection", "Sec-Websocket-Accept":
+				continue
+			}
+			header[k] = v
+		}
+		c.w.WriteHeader(http.StatusOK)
+		return len(b), c.rc.Flush()
+	}
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.body.Close()
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return streamAddr("")
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return c.rc.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return c.rc.SetWriteDeadline(t)
+}
+
+// HTTP/2流的地址
+type streamAddr string
+
+func (a streamAddr) Network() string { return "h2" }
+func (a streamAddr) String() string  { return string(a) }
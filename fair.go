@@ -0,0 +1,228 @@
+package pigeon
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type fairIdentityFunc func(*Session) string
+type fairWeightFunc func(identity string) int
+
+// 按身份排队的任务
+type fairQueue struct {
+	identity string
+	weight   int
+	tasks    []func()
+	running  bool
+}
+
+// 公平调度器，按任务计数的差额轮询：每轮从一个身份的队列中取出weight个任务执行，
+// 同一身份的任务由同一时刻最多一个协程按提交顺序执行
+type fairScheduler struct {
+	queues   map[string]*fairQueue
+	ready    []*fairQueue
+	size     int // 每个身份的队列容量.
+	limit    int // 全部身份排队任务总数的上限.
+	total    int
+	workers  int
+	running  int // 运行中的执行协程数量.
+	stopped  bool
+	identity func(*Session) string
+	weight   func(string) int
+	mu       *profiledMutex
+	cond     *sync.Cond // 有就绪的队列或已停止.
+	space    *sync.Cond // 有任务被取出执行或已停止.
+}
+
+func newFairScheduler(size, limit, workers int, identity func(*Session) string, weight func(string) int, stat *lockStat) *fairScheduler {
+	f := &fairScheduler{
+		queues:   make(map[string]*fairQueue),
+		size:     size,
+		limit:    limit,
+		workers:  workers,
+		identity: identity,
+		weight:   weight,
+		mu:       newProfiledMutex(stat),
+	}
+	f.cond = sync.NewCond(f.mu)
+	f.space = sync.NewCond(f.mu)
+	f.start()
+	return f
+}
+
+// 启动执行协程，补足停止后尚未退出的协程. 停止前残留的任务被丢弃，不在重启后执行
+func (f *fairScheduler) start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = false
+	f.queues = make(map[string]*fairQueue)
+	f.ready = nil
+	f.total = 0
+	for ; f.running < f.workers; f.running++ {
+		go f.run()
+	}
+}
+
+// 停止执行协程，已排队的任务执行完毕后协程退出，之后提交的任务被拒绝
+func (f *fairScheduler) stop() {
+	f.mu.Lock()
+	f.stopped = true
+	f.mu.Unlock()
+	f.cond.Broadcast()
+	f.space.Broadcast()
+}
+
+// 提交任务，身份的队列或全部队列已满、已停止时返回false
+func (f *fairScheduler) submit(s *Session, fn func()) bool {
+	id := f.identityOf(s)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped || f.total >= f.limit {
+		return false
+	}
+	return f.enqueue(id, fn)
+}
+
+// 提交任务，队列已满时阻塞等待，已停止时返回false. 用于收到的信息，读取流程阻塞形成背压
+func (f *fairScheduler) wait(s *Session, fn func()) bool {
+	id := f.identityOf(s)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if f.stopped {
+			return false
+		}
+		if f.total < f.limit && f.enqueue(id, fn) {
+			return true
+		}
+		f.space.Wait()
+	}
+}
+
+func (f *fairScheduler) identityOf(s *Session) string {
+	if s == nil {
+		return ""
+	}
+	return f.identity(s)
+}
+
+// 放入身份的队列，队列已满时返回false，须持有锁
+func (f *fairScheduler) enqueue(id string, fn func()) bool {
+	q, ok := f.queues[id]
+	if !ok {
+		weight := f.weight(id)
+		if weight <= 0 {
+			weight = 1
+		}
+		q = &fairQueue{identity: id, weight: weight}
+		f.queues[id] = q
+	}
+	if len(q.tasks) >= f.size {
+		return false
+	}
+	q.tasks = append(q.tasks, fn)
+	f.total++
+	if len(q.tasks) == 1 && !q.running {
+		f.ready = append(f.ready, q)
+		f.cond.Signal()
+	}
+	return true
+}
+
+func (f *fairScheduler) run() {
+	for {
+		f.mu.Lock()
+		for len(f.ready) == 0 && !f.stopped {
+			f.cond.Wait()
+		}
+		if len(f.ready) == 0 {
+			f.running--
+			f.mu.Unlock()
+			return
+		}
+		q := f.ready[0]
+		f.ready[0] = nil
+		f.ready = f.ready[1:]
+		n := q.weight
+		if n > len(q.tasks) {
+			n = len(q.tasks)
+		}
+		tasks := q.tasks[:n:n]
+		q.tasks = q.tasks[n:]
+		q.running = true
+		f.total -= n
+		f.mu.Unlock()
+		f.space.Broadcast()
+
+		for _, fn := range tasks {
+			fn()
+		}
+
+		f.mu.Lock()
+		q.running = false
+		if f.queues[q.identity] != q {
+			// 执行期间调度器已重启，队列已被丢弃
+		} else if len(q.tasks) > 0 {
+			f.ready = append(f.ready, q)
+			f.cond.Signal()
+		} else {
+			delete(f.queues, q.identity)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// HandleFairIdentity 设置Config.FairScheduling使用的会话身份，同一身份的会话共享调度份额，默认使用会话ID.
+func (p *Pigeon) HandleFairIdentity(fn func(*Session) string) {
+	p.fairIdentity = fn
+}
+
+// HandleFairWeight 设置身份的调度权重，每轮调度中该身份最多执行权重个任务，默认为1，可按用户等级返回不同权重.
+func (p *Pigeon) HandleFairWeight(fn func(identity string) int) {
+	p.fairWeight = fn
+}
+
+// 获取会话的调度身份
+func (p *Pigeon) fairIdentityOf(s *Session) string {
+	if p.fairIdentity != nil {
+		return p.fairIdentity(s)
+	}
+	return s.id
+}
+
+// 获取身份的调度权重
+func (p *Pigeon) fairWeightOf(identity string) int {
+	if p.fairWeight != nil {
+		return p.fairWeight(identity)
+	}
+	return 1
+}
+
+// 按身份公平调度的执行器
+func newFairExecutor(p *Pigeon, size, limit, workers int, dropped *uint64) *executor {
+	if size <= 0 {
+		size = defaultExecutorQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultExecutorWorkers
+	}
+	if limit <= 0 {
+		limit = size * workers
+	}
+	return &executor{fair: newFairScheduler(size, limit, workers, p.fairIdentityOf, p.fairWeightOf, p.locks.stat(LockExecutor)), dropped: dropped, mu: &sync.RWMutex{}}
+}
+
+// 开启公平调度时，将收到的信息交给调度器按身份排队处理，队列已满时阻塞读取流程. 未开启或已停止时返回false
+func (p *Pigeon) scheduleInbound(s *Session, fn func()) bool {
+	f := p.asyncExecutor.fair
+	return f != nil && f.wait(s, fn)
+}
+
+// 提交到公平调度器，队列已满时丢弃
+func (e *executor) submitFair(s *Session, fn func()) bool {
+	if e.fair.submit(s, fn) {
+		return true
+	}
+	atomic.AddUint64(e.dropped, 1)
+	return false
+}
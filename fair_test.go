@@ -0,0 +1,178 @@
+package pigeon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestScheduler(size, limit, workers int) *fairScheduler {
+	identity := func(s *Session) string { return s.id }
+	weight := func(string) int { return 1 }
+	return newFairScheduler(size, limit, workers, identity, weight, nil)
+}
+
+func TestFairSchedulerInterleaves(t *testing.T) {
+	f := newTestScheduler(100, 1000, 1)
+	defer f.stop()
+	chatty, quiet := &Session{id: "chatty"}, &Session{id: "quiet"}
+
+	// 占住唯一的执行协程，使任务全部排队后再开始调度
+	gate := make(chan struct{})
+	f.submit(chatty, func() { <-gate })
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		f.submit(chatty, func() {
+			mu.Lock()
+			order = append(order, "chatty")
+			mu.Unlock()
+		})
+	}
+	f.submit(quiet, func() {
+		mu.Lock()
+		order = append(order, "quiet")
+		mu.Unlock()
+		close(done)
+	})
+	close(gate)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, id := range order {
+		if id == "quiet" && i > 1 {
+			t.Fatalf("quiet identity ran after %d chatty tasks", i)
+		}
+	}
+}
+
+func TestFairSchedulerLimit(t *testing.T) {
+	f := newTestScheduler(10, 3, 1)
+	defer f.stop()
+	gate := make(chan struct{})
+	defer close(gate)
+	started := make(chan struct{})
+	f.submit(&Session{id: "a"}, func() { close(started); <-gate })
+	<-started
+	for i, id := range []string{"a", "b", "c"} {
+		if !f.submit(&Session{id: id}, func() {}) {
+			t.Fatalf("task %d rejected under the limit", i)
+		}
+	}
+	if f.submit(&Session{id: "d"}, func() {}) {
+		t.Fatal("task accepted over the global limit")
+	}
+
+	// 阻塞提交在有任务被取出后继续
+	accepted := make(chan bool, 1)
+	go func() { accepted <- f.wait(&Session{id: "e"}, func() {}) }()
+	select {
+	case <-accepted:
+		t.Fatal("wait returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	gate <- struct{}{}
+	if !<-accepted {
+		t.Fatal("wait rejected after space was freed")
+	}
+}
+
+func TestFairSchedulerStop(t *testing.T) {
+	f := newTestScheduler(10, 100, 2)
+	ran := make(chan struct{}, 1)
+	f.submit(&Session{id: "a"}, func() { ran <- struct{}{} })
+	f.stop()
+	<-ran
+	if f.submit(&Session{id: "a"}, func() {}) || f.wait(&Session{id: "a"}, func() {}) {
+		t.Fatal("task accepted after stop")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		running := f.running
+		f.mu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers still running after stop", running)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 停止前排队的任务由原协程执行完毕，停止后提交的任务在调用方协程中执行，重启后恢复异步执行
+func TestExecutorStopRunsInline(t *testing.T) {
+	conf := DefaultConfig()
+	conf.FairScheduling = true
+	p := New(conf)
+	defer p.Close()
+	var dropped uint64
+	executors := map[string]*executor{
+		"queue": newExecutor(4, 1, &dropped),
+		"fair":  p.asyncExecutor,
+	}
+	for name, e := range executors {
+		s := &Session{id: "a"}
+		release, queued := make(chan struct{}), make(chan struct{})
+		e.submit(s, func() { <-release })
+		e.submit(s, func() { close(queued) })
+		e.stop()
+
+		inline := false
+		e.submit(s, func() { inline = true })
+		if !inline {
+			t.Fatalf("%s: task submitted after stop did not run inline", name)
+		}
+		close(release)
+		select {
+		case <-queued:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: task queued before stop was lost", name)
+		}
+
+		e.start()
+		async := make(chan struct{})
+		e.submit(s, func() { close(async) })
+		select {
+		case <-async:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: task not run after restart", name)
+		}
+	}
+	if dropped != 0 {
+		t.Fatalf("dropped %d tasks", dropped)
+	}
+}
+
+func TestFairInboundOrder(t *testing.T) {
+	conf := DefaultConfig()
+	conf.FairScheduling = true
+	p := New(conf)
+	defer p.Close()
+	p.HandleMessage(func(s *Session, msg []byte) { s.Write(msg) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { p.HandleRequest(w, r) }))
+	defer srv.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, msg := range []string{"1", "2", "3"} {
+		c.WriteMessage(websocket.TextMessage, []byte(msg))
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []string{"1", "2", "3"} {
+		_, got, err := c.ReadMessage()
+		if err != nil || string(got) != want {
+			t.Fatalf("got %q, %v, want %q", got, err, want)
+		}
+	}
+}
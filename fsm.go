@@ -0,0 +1,101 @@
+package pigeon
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// ConnState 会话连接的状态. 各状态允许的操作：
+//
+//	StateConnecting 已升级，正在注册、恢复房间；可写入（进入缓冲区）和加入房间.
+//	StateOpen       正常读写.
+//	StateDraining   已建议客户端重连；仍可正常读写.
+//	StateResuming   正在恢复导入的状态或替换底层连接；写入进入缓冲区，在新连接上发送.
+//	StateClosing    已发出或收到关闭帧；除关闭帧外不再接受写入和加入房间，读取持续到连接断开.
+//	StateClosed     终态，所有操作返回错误.
+type ConnState int
+
+const (
+	StateConnecting ConnState = iota
+	StateOpen
+	StateDraining
+	StateResuming
+	StateClosing
+	StateClosed
+)
+
+var connStateNames = [...]string{"connecting", "open", "draining", "resuming", "closing", "closed"}
+
+func (c ConnState) String() string {
+	if c < 0 || int(c) >= len(connStateNames) {
+		return "unknown"
+	}
+	return connStateNames[c]
+}
+
+// 允许的状态转换
+var connTransitions = map[ConnState][]ConnState{
+	StateConnecting: {StateOpen, StateResuming, StateClosing, StateClosed},
+	StateOpen:       {StateDraining, StateResuming, StateClosing, StateClosed},
+	StateDraining:   {StateResuming, StateClosing, StateClosed},
+	StateResuming:   {StateOpen, StateClosing, StateClosed},
+	StateClosing:    {StateClosed},
+}
+
+type handleStateChangeFunc func(s *Session, from, to ConnState)
+
+// HandleStateChange 会话状态转换时的处理方法，同一会话按转换顺序调用.
+func (p *Pigeon) HandleStateChange(fn func(s *Session, from, to ConnState)) {
+	p.stateChangeHandler = fn
+}
+
+// ConnState 获取会话连接的状态.
+func (s *Session) ConnState() ConnState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// 转换状态，不允许的转换被忽略并返回false
+func (s *Session) transition(to ConnState) bool {
+	s.mu.Lock()
+	from, ok := s.setState(to)
+	s.mu.Unlock()
+	if ok {
+		s.stateChanged(from, to)
+	}
+	return ok
+}
+
+// 在持有锁时转换状态，调用方须在释放锁后调用stateChanged
+func (s *Session) setState(to ConnState) (ConnState, bool) {
+	from := s.state
+	for _, next := range connTransitions[from] {
+		if next == to {
+			s.state = to
+			return from, true
+		}
+	}
+	return from, false
+}
+
+// 调用状态转换处理方法
+func (s *Session) stateChanged(from, to ConnState) {
+	if fn := s.pigeon.stateChangeHandler; fn != nil {
+		s.pigeon.call(s, func() { fn(s, from, to) })
+	}
+}
+
+// 判断当前状态是否允许写入该类型的信息
+func (s *Session) writable(t int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.open {
+		return errors.New("tried to write to closed a session")
+	}
+	if s.state == StateClosing && t != websocket.CloseMessage {
+		return errors.New("session is closing")
+	}
+	return nil
+}
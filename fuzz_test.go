@@ -0,0 +1,149 @@
+package pigeon
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 不带连接的会话，写入只进入缓冲区
+func newFuzzSession(p *Pigeon) *Session {
+	return &Session{
+		id:       newID(),
+		Request:  httptest.NewRequest("GET", "/", nil),
+		output:   make(chan *envelope, 16),
+		pigeon:   p,
+		open:     true,
+		mu:       newProfiledMutex(p.locks.stat(LockSession)),
+		reliable: newReliableState(),
+		paced:    newPacedQueue(),
+		closedCh: make(chan struct{}),
+		codec:    JSONCodec,
+	}
+}
+
+func newFuzzPigeon(t testing.TB) *Pigeon {
+	conf := DefaultConfig()
+	conf.EventProtocol = true
+	conf.SyncHandlers = true
+	p := New(conf)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func FuzzParseControl(f *testing.F) {
+	f.Add([]byte(`{"op":"join","room":"a"}`))
+	f.Add([]byte(`{"op":"ack","seq":1}`))
+	f.Add([]byte(`{"op":"subscribe","topic":"a.*"}`))
+	f.Add([]byte(`{"op":"nack","seq":-1}`))
+	f.Add([]byte(`{`))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		c, ok := parseControl(msg)
+		if !ok {
+			return
+		}
+		switch c.Op {
+		case ControlJoin, ControlLeave:
+			if c.Room == "" {
+				t.Fatalf("%s without room", c.Op)
+			}
+		case ControlAck, ControlNack:
+			if c.Seq <= 0 {
+				t.Fatalf("%s with seq %d", c.Op, c.Seq)
+			}
+		case ControlSubscribe, ControlUnsubscribe:
+			if c.Topic == "" {
+				t.Fatalf("%s without topic", c.Op)
+			}
+		default:
+			t.Fatalf("accepted unknown op %q", c.Op)
+		}
+	})
+}
+
+func FuzzHandleEvent(f *testing.F) {
+	f.Add([]byte(`{"event":"chat","data":{"text":"hi"}}`))
+	f.Add([]byte(`{"event":"chat","v":2,"id":"1","data":null}`))
+	f.Add([]byte(`{"event":"reply","id":"x","data":1}`))
+	f.Add([]byte(`{"event":""}`))
+	f.Add([]byte(`[]`))
+	p := newFuzzPigeon(f)
+	p.On("chat", func(s *Session, ev *Event) error { return nil })
+	p.Validate("chat", func(s *Session, ev *Event) error {
+		if len(ev.Data) == 0 {
+			return errors.New("empty chat")
+		}
+		return nil
+	})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		s := newFuzzSession(p)
+		handled := p.handleEvent(s, websocket.TextMessage, msg)
+		if handled && len(msg) == 0 {
+			t.Fatal("empty message handled as an event")
+		}
+	})
+}
+
+func FuzzRouteBinary(f *testing.F) {
+	f.Add([]byte{0, 1, 'x'})
+	f.Add([]byte{0, 2})
+	f.Add([]byte{0})
+	f.Add([]byte{})
+	p := newFuzzPigeon(f)
+	var routed, fell int
+	p.HandleBinaryOpcode(1, func(s *Session, msg []byte) { routed++ })
+	p.HandleBinaryFallthrough(func(s *Session, msg []byte) { fell++ })
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		r, ft := routed, fell
+		p.routeBinary(newFuzzSession(p), msg)
+		opcode := len(msg) >= 2 && msg[0] == 0 && msg[1] == 1
+		if opcode && routed != r+1 || !opcode && fell != ft+1 {
+			t.Fatalf("message %x routed=%v", msg, routed != r)
+		}
+	})
+}
+
+func FuzzCBORUnmarshal(f *testing.F) {
+	c := NewCBORCodec()
+	c.RegisterEvent(1, "chat")
+	text, _ := c.Marshal(map[string]string{"text": "hi"})
+	list, _ := c.Marshal([]interface{}{1, 2.5, "x", nil, true})
+	for _, ev := range []*Event{
+		{Name: "chat", Data: text},
+		{Name: "other", ID: "7", V: 2, Data: list},
+	} {
+		data, err := c.Marshal(ev)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{0x9f, 0x9f, 0x9f})
+	f.Add([]byte{0xbf, 0x61, 'a', 0xff})
+	f.Add([]byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b []byte
+		c.Unmarshal(data, &b)
+		var v interface{}
+		c.Unmarshal(data, &v)
+
+		ev := &Event{}
+		if err := c.Unmarshal(data, ev); err != nil {
+			return
+		}
+		// 能解码的事件重新编码后须解码为相同的事件
+		again, err := c.Marshal(ev)
+		if err != nil {
+			t.Fatalf("re-marshal %+v: %v", ev, err)
+		}
+		ev2 := &Event{}
+		if err := c.Unmarshal(again, ev2); err != nil {
+			t.Fatalf("decode re-marshaled %+v: %v", ev, err)
+		}
+		if ev2.Name != ev.Name || ev2.ID != ev.ID || ev2.V != ev.V {
+			t.Fatalf("round trip %+v != %+v", ev2, ev)
+		}
+	})
+}
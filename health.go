@@ -0,0 +1,78 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// Health 健康检查结果.
+type Health struct {
+	Status   string `json:"status"`   // ok、draining或closed.
+	Open     bool   `json:"open"`     // 信鸽实例是否开启.
+	Draining bool   `json:"draining"` // 是否正在排空连接.
+	Sessions int    `json:"sessions"` // 会话数量.
+
+	// 集群消息代理状态，未使用代理时为空，正常时为ok，异常时为error并在BrokerError中给出原因.
+	// 代理异常不影响Status：所有节点共用代理，据此摘除节点只会让全部节点同时下线.
+	Broker      string `json:"broker,omitempty"`
+	BrokerError string `json:"broker_error,omitempty"`
+}
+
+// Drain 开始排空：拒绝新的连接，已有会话不受影响.
+func (p *Pigeon) Drain() {
+	atomic.StoreInt32(&p.draining, 1)
+}
+
+// IsDraining 判断信鸽实例是否正在排空.
+func (p *Pigeon) IsDraining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
+
+// 检查是否可以接受新的连接
+func (p *Pigeon) accepting() error {
+	if p.hub.closed() {
+		return errors.New("pigeon instance is closed")
+	}
+	if p.IsDraining() {
+		return errors.New("pigeon instance is draining")
+	}
+	return nil
+}
+
+// Health 获取健康状态.
+func (p *Pigeon) Health() Health {
+	h := Health{
+		Status:   "ok",
+		Open:     !p.hub.closed(),
+		Draining: p.IsDraining(),
+		Sessions: p.hub.len(),
+	}
+	if used, err := p.brokers.check(); used {
+		h.Broker = "ok"
+		if err != nil {
+			h.Broker, h.BrokerError = "error", err.Error()
+		}
+	}
+	if h.Draining {
+		h.Status = "draining"
+	}
+	if !h.Open {
+		h.Status = "closed"
+	}
+	return h
+}
+
+// HealthHandler 供负载均衡使用的健康检查接口，排空或关闭时返回503.
+func (p *Pigeon) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := p.Health()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if h.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	})
+}
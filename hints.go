@@ -0,0 +1,84 @@
+package pigeon
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端能力声明使用的查询参数.
+const (
+	HintCompression    = "compression" // 0表示不希望压缩，CompressForceOn的信息仍会压缩.
+	HintBatch          = "batch"       // 1表示可以接收以换行分隔的合并文本信息.
+	HintMaxMessageSize = "max_msg"     // 客户端发送的最大信息容量.
+)
+
+// 默认合并信息的最大容量
+const defaultMaxBatchSize = 64 * 1024
+
+// Capabilities 客户端声明并经服务端配置约束后的连接能力.
+type Capabilities struct {
+	Compression        bool   // 是否压缩发送的信息，需启用Config.EnableCompression.
+	Batch              bool   // 是否将缓冲区中连续的文本信息以换行分隔合并发送，需启用Config.AllowBatch.
+	MaxMessageSize     int64  // 接收信息的最大容量，不超过Config.MaxMessageSize.
+	PayloadCompression string // 协商的应用层压缩算法，为空时不压缩，见HintPayloadCompression.
+}
+
+// 解析客户端声明的能力并按服务端配置约束
+func (p *Pigeon) negotiateCapabilities(r *http.Request) Capabilities {
+	q := r.URL.Query()
+	caps := Capabilities{
+		Compression:        p.Config.EnableCompression && q.Get(HintCompression) != "0",
+		Batch:              p.Config.AllowBatch && q.Get(HintBatch) == "1",
+		MaxMessageSize:     p.Config.MaxMessageSize,
+		PayloadCompression: p.negotiatePayload(q.Get(HintPayloadCompression)),
+	}
+	if n, err := strconv.ParseInt(q.Get(HintMaxMessageSize), 10, 64); err == nil && n > 0 && n < caps.MaxMessageSize {
+		caps.MaxMessageSize = n
+	}
+	return caps
+}
+
+// Capabilities 获取会话协商的连接能力.
+func (s *Session) Capabilities() Capabilities {
+	return s.caps
+}
+
+// 从msg开始收集可以合并发送的文本信息，返回合并的信息和第一条不能合并的信息
+func (s *Session) collect(msg *envelope) ([]*envelope, *envelope) {
+	batch := []*envelope{msg}
+	if !s.caps.Batch || msg.t != websocket.TextMessage || msg.opts != nil || msg.reliable {
+		return batch, nil
+	}
+	size := len(msg.message)
+	for {
+		select {
+		case next, ok := <-s.output:
+			if !ok {
+				return batch, nil
+			}
+			if next.t != websocket.TextMessage || next.opts != nil || next.reliable || size+len(next.message)+1 > defaultMaxBatchSize {
+				return batch, next
+			}
+			batch = append(batch, next)
+			size += len(next.message) + 1
+		default:
+			return batch, nil
+		}
+	}
+}
+
+// 以换行分隔合并信息
+func joinBatch(batch []*envelope) *envelope {
+	parts := make([][]byte, len(batch))
+	var opts *SendOptions
+	for i, m := range batch {
+		parts[i] = m.message
+		if m.forceCompress() {
+			opts = m.opts
+		}
+	}
+	return &envelope{t: websocket.TextMessage, message: bytes.Join(parts, []byte{'\n'}), opts: opts}
+}
@@ -0,0 +1,54 @@
+package pigeon
+
+// 暂存的收到的信息
+type heldMessage struct {
+	t    int
+	data []byte
+}
+
+// 暂存Ready之前收到的信息，返回false表示无需暂存
+func (s *Session) hold(t int, message []byte) bool {
+	s.mu.Lock()
+	if !s.holding {
+		s.mu.Unlock()
+		return false
+	}
+	if len(s.held) >= s.pigeon.Config.MessageBufferSize {
+		s.mu.Unlock()
+		s.abort(CloseBufferOverflow)
+		return true
+	}
+	s.held = append(s.held, heldMessage{t: t, data: message})
+	s.mu.Unlock()
+	return true
+}
+
+// Ready 开启Config.HoldInbound时，标记会话已完成准备，按到达顺序将暂存的信息交给处理方法，之后收到的信息不再暂存.
+// 可在异步完成鉴权等准备工作后从任意协程调用，重复调用无效.
+func (s *Session) Ready() {
+	for {
+		s.mu.Lock()
+		held := s.held
+		s.held = nil
+		if len(held) == 0 {
+			s.holding = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		for _, m := range held {
+			if s.closed() {
+				continue
+			}
+			s.dispatchInbound(m.t, m.data)
+		}
+	}
+}
+
+// IsReady 判断会话是否已不再暂存收到的信息.
+func (s *Session) IsReady() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.holding
+}
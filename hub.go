@@ -1,70 +1,334 @@
 package pigeon
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 )
 
+// HubImplementation hub的实现方式.
+type HubImplementation int
+
+const (
+	// ChannelHub 基于channel的单协程事件循环，默认实现.
+	ChannelHub HubImplementation = iota
+	// LockFree 基于分段锁和原子计数，注册、注销和广播都在调用方协程中直接完成.
+	LockFree
+	// ManualHub 用于测试的手动模式，注册和注销直接完成，广播进入队列，调用Pigeon.Tick时才在调用方协程中投递.
+	ManualHub
+)
+
+// 连续投递高优先级信封的上限，达到后与普通广播公平竞争一次，避免普通广播饥饿
+const maxUrgentStreak = 16
+
 type hub struct {
-	sessions   map[*Session]bool
+	dropped    uint64 // 首字段，保证32位平台上原子操作对齐
+	sessions   sessionSet
 	broadcast  chan *envelope
+	urgent     chan *envelope
 	register   chan *Session
 	unregister chan *Session
 	exit       chan *envelope
+	stopped    chan struct{} // 关闭时关闭，避免注册和注销阻塞在已退出的事件循环上.
+	done       chan struct{} // 关闭流程结束时关闭，reopen等待上一次关闭完成.
+	rooms      *roomTable
+	tags       *tagTable
+	pacing     pacing
+	metrics    *hubMetrics
+	onError    func(*Session, error)
+	overflow   HubOverflow
+	spill      []*envelope
+	spilled    chan struct{}
+	spillMu    *sync.Mutex
+	pending    []*envelope // 手动模式下待投递的广播.
+	direct     bool
+	manual     bool
 	open       bool
-	mu         *sync.RWMutex
+	generation int
+	mu         *profiledMutex
 }
 
-func newHub() *hub {
-	return &hub{
-		sessions:   make(map[*Session]bool),
-		broadcast:  make(chan *envelope),
+func newHub(conf *Config, locks *lockProfiler) *hub {
+	h := &hub{
+		broadcast:  make(chan *envelope, conf.HubQueueSize),
+		urgent:     make(chan *envelope),
 		register:   make(chan *Session),
 		unregister: make(chan *Session),
 		exit:       make(chan *envelope),
+		stopped:    make(chan struct{}),
+		rooms:      newRoomTable(locks.stat(LockRooms)),
+		tags:       newTagTable(),
+		pacing:     newPacing(conf),
+		metrics:    newHubMetrics(conf),
+		overflow:   conf.HubOverflow,
+		spilled:    make(chan struct{}, 1),
+		spillMu:    &sync.Mutex{},
 		open:       true,
-		mu:         &sync.RWMutex{},
+		mu:         newProfiledMutex(locks.stat(LockHub)),
+	}
+	switch conf.HubImplementation {
+	case LockFree:
+		h.sessions = newStripedSet(locks.stat(LockSessions))
+		h.direct = true
+	case ManualHub:
+		h.sessions = newMapSet(locks.stat(LockSessions))
+		h.manual = true
+	default:
+		h.sessions = newMapSet(locks.stat(LockSessions))
 	}
+	return h
 }
 
 func (h *hub) run() {
+	streak := 0
 loop:
 	for {
+		if streak < maxUrgentStreak {
+			select {
+			case m := <-h.urgent: // 优先投递高优先级广播
+				h.handle(hubOpUrgent, m)
+				streak++
+				continue
+			default:
+			}
+		}
+		streak = 0
+
 		select {
 		case s := <-h.register: // 注册会话
-			h.mu.Lock()
-			h.sessions[s] = true
-			h.mu.Unlock()
+			start := h.metrics.begin()
+			h.sessions.add(s)
+			h.metrics.done(hubOpRegister, start)
 		case s := <-h.unregister: // 注销会话
-			if _, ok := h.sessions[s]; ok {
-				h.mu.Lock()
-				delete(h.sessions, s)
-				h.mu.Unlock()
-			}
+			start := h.metrics.begin()
+			h.sessions.remove(s)
+			h.metrics.done(hubOpUnregister, start)
+		case m := <-h.urgent: // 高优先级广播
+			h.handle(hubOpUrgent, m)
 		case m := <-h.broadcast: // 广播消息
-			h.mu.RLock()
-			for s := range h.sessions {
-				if m.filter != nil {
-					if m.filter(s) {
-						s.writeMessage(m)
-					}
-				} else {
-					s.writeMessage(m)
-				}
-			}
-			h.mu.RUnlock()
+			h.handle(hubOpBroadcast, m)
+		case <-h.spilled: // 溢出队列
+			start := h.metrics.begin()
+			h.flushSpill()
+			h.metrics.done(hubOpSpill, start)
 		case m := <-h.exit: // 退出
-			h.mu.Lock()
-			for s := range h.sessions {
-				s.CloseWithMsg(m.message)
-				delete(h.sessions, s)
-			}
-			h.open = false
-			h.mu.Unlock()
+			h.shutdown(m)
 			break loop
 		}
 	}
 }
 
+// 在事件循环中投递广播并统计
+func (h *hub) handle(op int, m *envelope) {
+	h.metrics.waited(op, m.queuedAt)
+	start := h.metrics.begin()
+	h.deliver(m)
+	h.metrics.done(op, start)
+}
+
+// 注册会话
+func (h *hub) add(s *Session) {
+	if h.direct || h.manual {
+		h.sessions.add(s)
+		return
+	}
+	since := h.metrics.stamp()
+	select {
+	case h.register <- s:
+	case <-h.stop():
+	}
+	h.metrics.waited(hubOpRegister, since)
+}
+
+// 注销会话
+func (h *hub) remove(s *Session) {
+	if h.direct || h.manual {
+		h.sessions.remove(s)
+		return
+	}
+	since := h.metrics.stamp()
+	select {
+	case h.unregister <- s:
+	case <-h.stop():
+	}
+	h.metrics.waited(hubOpUnregister, since)
+}
+
+// 提交广播
+func (h *hub) send(ctx context.Context, m *envelope) error {
+	if h.direct {
+		h.deliver(m)
+		return nil
+	}
+	if h.manual {
+		h.queue(m)
+		return nil
+	}
+	m.queuedAt = h.metrics.stamp()
+	if m.urgent() {
+		select {
+		case h.urgent <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return h.submit(ctx, m)
+}
+
+// 关闭hub，所有会话关闭后返回. 已关闭时返回false，并发调用时只有一个调用方执行关闭
+func (h *hub) close(m *envelope) bool {
+	h.mu.Lock()
+	if !h.open {
+		h.mu.Unlock()
+		return false
+	}
+	h.open = false
+	close(h.stopped)
+	done := make(chan struct{})
+	h.done = done
+	h.mu.Unlock()
+
+	if h.looping() {
+		// 事件循环只在收到退出信封后结束，此处是唯一的发送方
+		h.exit <- m
+	} else {
+		h.shutdown(m)
+	}
+	<-done
+	return true
+}
+
+// 向目标会话投递信封
+func (h *hub) deliver(m *envelope) {
+	targets := h.targets(m)
+	m.resolvePayloads()
+	if h.pacing.enabled(m, len(targets)) {
+		h.pacing.deliver(h, m, targets)
+		m.endTrace(nil)
+		return
+	}
+	for _, s := range targets {
+		h.enqueue(s, m)
+	}
+	m.endTrace(nil)
+}
+
+// 投递到会话，错误异步交给处理方法，不阻塞hub
+func (h *hub) enqueue(s *Session, m *envelope) {
+	h.settle(s, m, h.put(s, m, s.enqueue))
+}
+
+// 用push放入会话缓冲区，事务信封放入其全部信息
+func (h *hub) put(s *Session, m *envelope, push func(*envelope) error) error {
+	if len(m.parts) > 0 {
+		return h.enqueueParts(s, m, push)
+	}
+	return push(m.variantFor(s))
+}
+
+// 记录投递结果，错误交给处理方法
+func (h *hub) settle(s *Session, m *envelope, err error) {
+	if m.room != nil {
+		if err != nil {
+			atomic.AddUint64(&m.room.dropped, 1)
+		} else {
+			atomic.AddUint64(&m.room.delivered, 1)
+		}
+	}
+	if err != nil && h.onError != nil {
+		h.onError(s, err)
+	}
+}
+
+// 解析信封的目标会话
+func (h *hub) targets(m *envelope) []*Session {
+	if len(m.rooms) > 0 || len(m.tags) > 0 {
+		members := h.members(m)
+		if m.filter == nil && m.exclude == "" && m.payloads == nil {
+			return members
+		}
+		targets := members[:0]
+		for _, s := range members {
+			if m.accept(s) {
+				targets = append(targets, s)
+			}
+		}
+		return targets
+	}
+	targets := make([]*Session, 0, h.sessions.len())
+	h.sessions.each(func(s *Session) bool {
+		if m.accept(s) {
+			targets = append(targets, s)
+		}
+		return true
+	})
+	return targets
+}
+
+// 获取目标房间成员与目标标签会话的并集
+func (h *hub) members(m *envelope) []*Session {
+	if len(m.tags) == 0 {
+		return h.rooms.members(m.rooms...)
+	}
+	var list []*Session
+	if len(m.rooms) > 0 {
+		list = h.rooms.members(m.rooms...)
+	}
+	seen := make(map[*Session]struct{}, len(list))
+	for _, s := range list {
+		seen[s] = struct{}{}
+	}
+	return h.tags.union(m.tags, seen, list)
+}
+
+// 判断会话是否为信封的目标
+func (m *envelope) accept(s *Session) bool {
+	if m.exclude != "" && s.id == m.exclude {
+		return false
+	}
+	if m.payloads != nil && m.payloads[s.codec.Name()] == nil {
+		return false
+	}
+	return m.filter == nil || m.filter(s)
+}
+
+// 丢弃排队的广播并关闭所有会话，完成后通知close
+func (h *hub) shutdown(m *envelope) {
+	h.discardQueue()
+	h.pacing.stop()
+	for _, s := range h.sessions.drain() {
+		s.CloseWithMsg(m.message)
+	}
+	h.mu.RLock()
+	done := h.done
+	h.mu.RUnlock()
+	close(done)
+}
+
+// 重新开启已关闭的hub，关闭流程尚未结束时等待其结束
+func (h *hub) reopen() error {
+	h.mu.RLock()
+	done := h.done
+	h.mu.RUnlock()
+	if done != nil {
+		<-done
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.open {
+		return errors.New("pigeon instance is not closed")
+	}
+	h.open = true
+	h.stopped = make(chan struct{})
+	h.generation++
+	if h.looping() {
+		go h.run()
+	}
+	return nil
+}
+
 // 关闭HUB
 func (h *hub) closed() bool {
 	h.mu.RLock()
@@ -72,20 +336,26 @@ func (h *hub) closed() bool {
 	return !h.open
 }
 
-// 获取会话数量
-func (h *hub) len() int {
+// 获取本次开启的关闭通知
+func (h *hub) stop() <-chan struct{} {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.sessions)
+	return h.stopped
 }
 
-// session 迭代器
-func (h *hub) iterator(fn func(*Session) bool) {
+// 获取开启次数，每次reopen后递增
+func (h *hub) gen() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	for s := range h.sessions {
-		if !fn(s) {
-			break
-		}
-	}
+	return h.generation
+}
+
+// 获取会话数量
+func (h *hub) len() int {
+	return h.sessions.len()
+}
+
+// session 迭代器
+func (h *hub) iterator(fn func(*Session) bool) {
+	h.sessions.each(fn)
 }
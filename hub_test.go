@@ -0,0 +1,173 @@
+package pigeon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 并发关闭时只有一次成功且都不阻塞，关闭返回后会话已注销并且可以立即重启
+func TestCloseConcurrentRestart(t *testing.T) {
+	for _, impl := range []HubImplementation{ChannelHub, LockFree, ManualHub} {
+		conf := DefaultConfig()
+		conf.HubImplementation = impl
+		p := New(conf)
+		connected := make(chan struct{}, 1)
+		p.HandleConnect(func(*Session) { connected <- struct{}{} })
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.HandleRequest(w, r)
+		}))
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		for round := 0; round < 20; round++ {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 客户端读取时才会回应关闭帧，会话流程随之结束
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+			<-connected
+
+			var wg sync.WaitGroup
+			var succeeded int32
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if p.Close() == nil {
+						atomic.AddInt32(&succeeded, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			if succeeded != 1 {
+				t.Fatalf("hub %d: %d closes succeeded, want 1", impl, succeeded)
+			}
+			if p.Len() != 0 {
+				t.Fatalf("hub %d: %d sessions left after close returned", impl, p.Len())
+			}
+			if err := p.Restart(); err != nil {
+				t.Fatalf("hub %d: restart after close: %v", impl, err)
+			}
+			conn.Close()
+		}
+		p.Close()
+		srv.Close()
+	}
+}
+
+// 基准测试比较的hub实现
+var benchHubs = []struct {
+	name string
+	impl HubImplementation
+}{
+	{"channel", ChannelHub},
+	{"lockfree", LockFree},
+}
+
+// 广播扇出，每次迭代广播一条信息并等待所有会话的缓冲区收到
+func BenchmarkBroadcastFanout(b *testing.B) {
+	msg := []byte(`{"event":"tick","data":{"seq":1}}`)
+	for _, hub := range benchHubs {
+		for _, n := range []int{10, 100, 1000} {
+			b.Run(hub.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				conf := DefaultConfig()
+				conf.HubImplementation = hub.impl
+				p := New(conf)
+				sessions := make([]*Session, n)
+				for i := range sessions {
+					sessions[i] = newFuzzSession(p)
+					p.hub.add(sessions[i])
+				}
+				// 会话没有连接，关闭实例前先注销
+				defer func() {
+					for _, s := range sessions {
+						p.hub.remove(s)
+					}
+					p.Close()
+				}()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := p.Broadcast(msg); err != nil {
+						b.Fatal(err)
+					}
+					for _, s := range sessions {
+						<-s.output
+					}
+				}
+			})
+		}
+	}
+}
+
+// 注册和注销吞吐，每次迭代在已有n个会话的hub中注册并注销一个会话
+func BenchmarkRegisterUnregister(b *testing.B) {
+	for _, hub := range benchHubs {
+		for _, n := range []int{10, 100, 1000} {
+			b.Run(hub.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				conf := DefaultConfig()
+				conf.HubImplementation = hub.impl
+				p := New(conf)
+				sessions := make([]*Session, n)
+				for i := range sessions {
+					sessions[i] = newFuzzSession(p)
+					p.hub.add(sessions[i])
+				}
+				// 会话没有连接，关闭实例前先注销
+				defer func() {
+					for _, s := range sessions {
+						p.hub.remove(s)
+					}
+					p.Close()
+				}()
+
+				churn := make([]*Session, 64)
+				for i := range churn {
+					churn[i] = newFuzzSession(p)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					s := churn[i%len(churn)]
+					p.hub.add(s)
+					p.hub.remove(s)
+				}
+			})
+		}
+	}
+}
+
+// 并发注册和注销吞吐，多个协程同时注册并注销各自的会话
+func BenchmarkRegisterUnregisterParallel(b *testing.B) {
+	for _, hub := range benchHubs {
+		b.Run(hub.name, func(b *testing.B) {
+			conf := DefaultConfig()
+			conf.HubImplementation = hub.impl
+			p := New(conf)
+			defer p.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				s := newFuzzSession(p)
+				for pb.Next() {
+					p.hub.add(s)
+					p.hub.remove(s)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package pigeon
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// hub事件循环处理的操作
+const (
+	hubOpRegister = iota
+	hubOpUnregister
+	hubOpBroadcast
+	hubOpUrgent
+	hubOpSpill
+	hubOps
+)
+
+var hubOpNames = [hubOps]string{"register", "unregister", "broadcast", "urgent", "spill"}
+
+// HubOpStats hub事件循环中一种操作的统计.
+type HubOpStats struct {
+	Op      string        `json:"op"`
+	Count   uint64        `json:"count"`
+	Busy    time.Duration `json:"busy"`     // 处理该操作的累计耗时.
+	Wait    time.Duration `json:"wait"`     // 提交后等待事件循环接收的累计耗时.
+	AvgBusy time.Duration `json:"avg_busy"` // 平均处理耗时.
+	AvgWait time.Duration `json:"avg_wait"` // 平均等待耗时.
+}
+
+// HubStats hub事件循环的统计，需启用Config.HubMetrics，仅对ChannelHub生效.
+type HubStats struct {
+	Iterations       uint64       `json:"iterations"`         // 事件循环处理的操作总数.
+	IterationsPerSec float64      `json:"iterations_per_sec"` // 最近一个完整秒内的循环次数.
+	Utilization      float64      `json:"utilization"`        // 最近一个完整秒内处理操作的时间占比，接近1表示事件循环已饱和.
+	Ops              []HubOpStats `json:"ops"`
+}
+
+type hubOpCounters struct {
+	count uint64
+	busy  uint64
+	wait  uint64
+}
+
+// hub事件循环的统计，均为原子操作，为nil时不统计
+type hubMetrics struct {
+	iterations uint64
+	rate       uint64 // float64的位表示.
+	util       uint64 // float64的位表示.
+	ops        [hubOps]hubOpCounters
+
+	// 以下只在事件循环中访问
+	windowStart time.Time
+	windowIter  uint64
+	windowBusy  time.Duration
+}
+
+func newHubMetrics(conf *Config) *hubMetrics {
+	if !conf.HubMetrics || conf.HubImplementation == LockFree {
+		return nil
+	}
+	return &hubMetrics{windowStart: time.Now()}
+}
+
+// 记录提交后等待事件循环接收的时间
+func (m *hubMetrics) waited(op int, since time.Time) {
+	if m == nil || since.IsZero() {
+		return
+	}
+	atomic.AddUint64(&m.ops[op].wait, uint64(time.Since(since)))
+}
+
+// 开始处理操作
+func (m *hubMetrics) begin() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// 结束处理操作，在事件循环中调用
+func (m *hubMetrics) done(op int, start time.Time) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	busy := now.Sub(start)
+	atomic.AddUint64(&m.ops[op].count, 1)
+	atomic.AddUint64(&m.ops[op].busy, uint64(busy))
+	atomic.AddUint64(&m.iterations, 1)
+
+	m.windowIter++
+	m.windowBusy += busy
+	if elapsed := now.Sub(m.windowStart); elapsed >= time.Second {
+		atomic.StoreUint64(&m.rate, math.Float64bits(float64(m.windowIter)/elapsed.Seconds()))
+		atomic.StoreUint64(&m.util, math.Float64bits(float64(m.windowBusy)/float64(elapsed)))
+		m.windowStart, m.windowIter, m.windowBusy = now, 0, 0
+	}
+}
+
+// 提交时间，用于计算等待时间
+func (m *hubMetrics) stamp() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// HubStats 获取hub事件循环的统计，未启用Config.HubMetrics时返回零值.
+func (p *Pigeon) HubStats() HubStats {
+	m := p.hub.metrics
+	if m == nil {
+		return HubStats{}
+	}
+	st := HubStats{
+		Iterations:       atomic.LoadUint64(&m.iterations),
+		IterationsPerSec: math.Float64frombits(atomic.LoadUint64(&m.rate)),
+		Utilization:      math.Float64frombits(atomic.LoadUint64(&m.util)),
+		Ops:              make([]HubOpStats, hubOps),
+	}
+	for i := range m.ops {
+		op := HubOpStats{
+			Op:    hubOpNames[i],
+			Count: atomic.LoadUint64(&m.ops[i].count),
+			Busy:  time.Duration(atomic.LoadUint64(&m.ops[i].busy)),
+			Wait:  time.Duration(atomic.LoadUint64(&m.ops[i].wait)),
+		}
+		if op.Count > 0 {
+			op.AvgBusy = op.Busy / time.Duration(op.Count)
+			op.AvgWait = op.Wait / time.Duration(op.Count)
+		}
+		st.Ops[i] = op
+	}
+	return st
+}
@@ -0,0 +1,111 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// HubOverflow hub广播队列已满时的处理策略.
+type HubOverflow int
+
+const (
+	OverflowBlock HubOverflow = iota // 阻塞到队列有空位或ctx结束，默认策略.
+	OverflowDrop                     // 丢弃广播并返回ErrHubQueueFull.
+	OverflowSpill                    // 转入无界的溢出队列，仍按提交顺序投递.
+)
+
+// ErrHubQueueFull hub广播队列已满，广播被丢弃.
+var ErrHubQueueFull = errors.New("hub queue is full")
+
+// BroadcastContext 与Broadcast功能相同，在OverflowBlock策略下最多阻塞到ctx结束.
+func (p *Pigeon) BroadcastContext(ctx context.Context, msg []byte) error {
+	return p.dispatchContext(ctx, &envelope{t: websocket.TextMessage, message: copyBytes(msg)})
+}
+
+// BroadcastBinaryContext 与BroadcastBinary功能相同，在OverflowBlock策略下最多阻塞到ctx结束.
+func (p *Pigeon) BroadcastBinaryContext(ctx context.Context, msg []byte) error {
+	return p.dispatchContext(ctx, &envelope{t: websocket.BinaryMessage, message: copyBytes(msg)})
+}
+
+// 按溢出策略提交到广播队列
+func (h *hub) submit(ctx context.Context, m *envelope) error {
+	switch h.overflow {
+	case OverflowDrop:
+		select {
+		case h.broadcast <- m:
+			return nil
+		default:
+			atomic.AddUint64(&h.dropped, 1)
+			return ErrHubQueueFull
+		}
+	case OverflowSpill:
+		h.spillMu.Lock()
+		if len(h.spill) == 0 {
+			select {
+			case h.broadcast <- m:
+				h.spillMu.Unlock()
+				return nil
+			default:
+			}
+		}
+		h.spill = append(h.spill, m)
+		h.spillMu.Unlock()
+		select {
+		case h.spilled <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	select {
+	case h.broadcast <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 投递溢出队列. 溢出队列非空时广播不会进入主队列，因此先投递主队列中的广播即可保持顺序
+func (h *hub) flushSpill() {
+	for n := cap(h.broadcast); n > 0; n-- {
+		select {
+		case m := <-h.broadcast:
+			h.deliver(m)
+			continue
+		default:
+		}
+		break
+	}
+
+	h.spillMu.Lock()
+	spill := h.spill
+	h.spill = nil
+	h.spillMu.Unlock()
+	for _, m := range spill {
+		h.deliver(m)
+	}
+}
+
+// 丢弃队列中尚未投递的广播，hub关闭时调用
+func (h *hub) discardQueue() {
+	h.spillMu.Lock()
+	h.spill = nil
+	h.pending = nil
+	h.spillMu.Unlock()
+	for {
+		select {
+		case <-h.broadcast:
+		default:
+			return
+		}
+	}
+}
+
+// 获取队列中待投递的广播数量
+func (h *hub) queueDepth() int {
+	h.spillMu.Lock()
+	defer h.spillMu.Unlock()
+	return len(h.broadcast) + len(h.spill) + len(h.pending)
+}
@@ -0,0 +1,269 @@
+package pigeon
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalOp 日志记录的操作.
+type JournalOp string
+
+const (
+	JournalEpoch  JournalOp = "epoch"  // 实例启用日志，记录实例纪元.
+	JournalJoin   JournalOp = "join"   // 会话加入房间.
+	JournalLeave  JournalOp = "leave"  // 会话离开房间.
+	JournalSend   JournalOp = "send"   // 可靠房间向会话发送了信息，等待确认.
+	JournalAck    JournalOp = "ack"    // 会话累计确认到Seq.
+	JournalClose  JournalOp = "close"  // 会话结束.
+	JournalSecret JournalOp = "secret" // 会话签发了未加密的恢复令牌，Data为恢复密钥.
+)
+
+// JournalEntry 日志记录.
+type JournalEntry struct {
+	Op      JournalOp       `json:"op"`
+	Session string          `json:"sid,omitempty"`
+	Room    string          `json:"room,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"` // JournalSend时为ReliableFrame，JournalEpoch时为纪元，JournalSecret时为恢复密钥.
+	Time    time.Time       `json:"time"`
+}
+
+// Journal 只追加的日志，用于崩溃后恢复房间成员和可靠投递状态.
+type Journal interface {
+	// Append 追加记录，在读写流程中同步调用.
+	Append(e *JournalEntry) error
+	// Replay 按追加顺序读取全部记录.
+	Replay(fn func(*JournalEntry) error) error
+	// Rewrite 以entries原子地替换全部记录，用于恢复后压缩日志.
+	Rewrite(entries []*JournalEntry) error
+}
+
+// 基于文件的日志，每行一条JSON记录
+type fileJournal struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+	mu   *sync.Mutex
+}
+
+// NewFileJournal 新建基于文件的日志，文件不存在时创建.
+func NewFileJournal(path string) (Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileJournal{path: path, file: f, w: bufio.NewWriter(f), mu: &sync.Mutex{}}, nil
+}
+
+func (j *fileJournal) Append(e *JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(data)
+	j.w.WriteByte('\n')
+	return j.w.Flush()
+}
+
+func (j *fileJournal) Replay(fn func(*JournalEntry) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.Open(j.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		e := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// 崩溃时可能只写入了最后一行的一部分
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *fileJournal) Rewrite(entries []*JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file, j.w = f, bufio.NewWriter(f)
+	return nil
+}
+
+// UseJournal 启用日志，记录房间成员变化和可靠投递状态. 需在接入会话前调用，通常先调用RecoverJournal.
+func (p *Pigeon) UseJournal(j Journal) error {
+	data, _ := json.Marshal(p.epoch)
+	if err := j.Append(&JournalEntry{Op: JournalEpoch, Data: data, Time: p.now()}); err != nil {
+		return err
+	}
+	p.journal = j
+	return nil
+}
+
+// RecoverJournal 从日志恢复崩溃前的会话状态，客户端在Config.ResumeWindow内携带ResumeQueryParam重新连接时
+// 恢复房间并重发未确认的信息. 恢复后日志被压缩为仅包含恢复的状态.
+func (p *Pigeon) RecoverJournal(j Journal) error {
+	states := make(map[string]*SessionState)
+	var order []string
+	rooms := make(map[string]map[string]struct{})
+	var epoch string
+
+	err := j.Replay(func(e *JournalEntry) error {
+		if e.Op == JournalEpoch {
+			json.Unmarshal(e.Data, &epoch)
+			return nil
+		}
+		state, ok := states[e.Session]
+		if !ok {
+			if e.Op == JournalClose {
+				return nil
+			}
+			state = &SessionState{ID: e.Session}
+			states[e.Session] = state
+			rooms[e.Session] = make(map[string]struct{})
+			order = append(order, e.Session)
+		}
+		state.Epoch = epoch
+		switch e.Op {
+		case JournalJoin:
+			rooms[e.Session][e.Room] = struct{}{}
+		case JournalLeave:
+			delete(rooms[e.Session], e.Room)
+		case JournalSend:
+			if e.Seq > state.Seq {
+				state.Seq = e.Seq
+			}
+			state.Unacked = append(state.Unacked, e.Data)
+		case JournalAck:
+			state.Unacked = ackFrames(state.Unacked, e.Seq)
+		case JournalSecret:
+			json.Unmarshal(e.Data, &state.Secret)
+		case JournalClose:
+			delete(states, e.Session)
+			delete(rooms, e.Session)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	window := p.Config.ResumeWindow
+	if window <= 0 {
+		window = defaultResumeWindow
+	}
+	until := p.now().Add(window)
+	var compacted []*JournalEntry
+	now := p.now()
+	if epoch != "" {
+		data, _ := json.Marshal(epoch)
+		compacted = append(compacted, &JournalEntry{Op: JournalEpoch, Data: data, Time: now})
+	}
+	for _, id := range order {
+		state, ok := states[id]
+		if !ok {
+			continue
+		}
+		if state.Secret != "" {
+			data, _ := json.Marshal(state.Secret)
+			compacted = append(compacted, &JournalEntry{Op: JournalSecret, Session: id, Data: data, Time: now})
+		}
+		for room := range rooms[id] {
+			state.Rooms = append(state.Rooms, room)
+			compacted = append(compacted, &JournalEntry{Op: JournalJoin, Session: id, Room: room, Time: now})
+		}
+		for _, frame := range state.Unacked {
+			compacted = append(compacted, &JournalEntry{Op: JournalSend, Session: id, Seq: frameSeq(frame), Data: frame, Time: now})
+		}
+		p.resumes.put(state, until)
+	}
+	return j.Rewrite(compacted)
+}
+
+// 移除序号小于等于seq的信息
+func ackFrames(frames []json.RawMessage, seq uint64) []json.RawMessage {
+	i := 0
+	for i < len(frames) && frameSeq(frames[i]) <= seq {
+		i++
+	}
+	return frames[i:]
+}
+
+// 读取信息的序号
+func frameSeq(frame json.RawMessage) uint64 {
+	var f struct {
+		Seq uint64 `json:"seq"`
+	}
+	json.Unmarshal(frame, &f)
+	return f.Seq
+}
+
+// 读取信息的过期时间，未设置时返回0
+func frameExpiry(frame json.RawMessage) int64 {
+	var f struct {
+		Expiry int64 `json:"expiry"`
+	}
+	json.Unmarshal(frame, &f)
+	return f.Expiry
+}
+
+// 追加日志记录，失败时交给错误处理方法
+func (s *Session) record(op JournalOp, room string, seq uint64, data []byte) {
+	j := s.pigeon.journal
+	if j == nil {
+		return
+	}
+	e := &JournalEntry{Op: op, Session: s.id, Room: room, Seq: seq, Data: data, Time: s.pigeon.now()}
+	if err := j.Append(e); err != nil {
+		s.pigeon.reportError(s, err)
+	}
+}
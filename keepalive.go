@@ -0,0 +1,65 @@
+package pigeon
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 按配置调整底层TCP连接的保活探测，找不到TCP连接时忽略并计入Stats.KeepAliveSkipped
+func (p *Pigeon) tuneKeepAlive(conn *websocket.Conn) error {
+	c := p.Config
+	if c.TCPKeepAlive == 0 && c.TCPKeepAliveInterval == 0 && c.TCPKeepAliveCount == 0 {
+		return nil
+	}
+	tcp, ok := tcpConn(conn.UnderlyingConn())
+	if !ok {
+		atomic.AddUint64(&p.counters.keepAliveSkipped, 1)
+		return nil
+	}
+	if c.TCPKeepAlive < 0 {
+		return tcp.SetKeepAlive(false)
+	}
+	return setKeepAlive(tcp, c.TCPKeepAlive, c.TCPKeepAliveInterval, c.TCPKeepAliveCount)
+}
+
+// 逐层解开TLS和写入重试等包装，获取最底层的TCP连接
+func tcpConn(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch v := c.(type) {
+		case *net.TCPConn:
+			return v, true
+		case *tls.Conn:
+			c = v.NetConn()
+		case *retryConn:
+			c = v.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// 记录收到数据的时间
+func (s *Session) touch() {
+	atomic.StoreInt64(&s.lastRead, s.pigeon.now().UnixNano())
+}
+
+// 快速探活：超过Config.ProbeInterval未收到任何数据时立即发送ping，并将读取期限缩短为Config.ProbeTimeout，
+// 收到pong后恢复为PongWait，每次空闲只探活一次，在写入流程中调用
+func (s *Session) probe() {
+	last := atomic.LoadInt64(&s.lastRead)
+	if last == s.probed || s.pigeon.now().Sub(time.Unix(0, last)) < s.pigeon.Config.ProbeInterval {
+		return
+	}
+	s.probed = last
+	timeout := s.pigeon.Config.ProbeTimeout
+	if timeout <= 0 || timeout > s.pigeon.Config.PongWait {
+		timeout = s.pigeon.Config.ProbeInterval
+	}
+	conn := s.connection()
+	s.setReadTimeout(conn, timeout)
+	s.ping()
+}
@@ -0,0 +1,18 @@
+//go:build go1.23
+
+package pigeon
+
+import (
+	"net"
+	"time"
+)
+
+// 设置保活探测的空闲时间、间隔和次数，为0的项使用系统默认
+func setKeepAlive(tcp *net.TCPConn, idle, interval time.Duration, count int) error {
+	return tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     idle,
+		Interval: interval,
+		Count:    count,
+	})
+}
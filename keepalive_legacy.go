@@ -0,0 +1,23 @@
+//go:build !go1.23
+
+package pigeon
+
+import (
+	"net"
+	"time"
+)
+
+// 设置保活探测，Go 1.23之前的标准库只能设置空闲时间和间隔为同一值，忽略次数
+func setKeepAlive(tcp *net.TCPConn, idle, interval time.Duration, count int) error {
+	if err := tcp.SetKeepAlive(true); err != nil {
+		return err
+	}
+	period := idle
+	if period == 0 {
+		period = interval
+	}
+	if period == 0 {
+		return nil
+	}
+	return tcp.SetKeepAlivePeriod(period)
+}
@@ -0,0 +1,46 @@
+package pigeon
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestTCPConnUnwrap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	tcp := raw.(*net.TCPConn)
+
+	wrapped := map[string]net.Conn{
+		"tcp":       tcp,
+		"retry":     &retryConn{Conn: tcp},
+		"tls":       tls.Server(tcp, &tls.Config{}),
+		"tls+retry": tls.Server(&retryConn{Conn: tcp}, &tls.Config{}),
+		"retry+tls": &retryConn{Conn: tls.Server(tcp, &tls.Config{})},
+	}
+	for name, c := range wrapped {
+		if got, ok := tcpConn(c); !ok || got != tcp {
+			t.Errorf("%s: tcpConn did not find the TCP connection", name)
+		}
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, ok := tcpConn(&retryConn{Conn: a}); ok {
+		t.Error("pipe reported as TCP")
+	}
+}
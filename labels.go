@@ -0,0 +1,165 @@
+package pigeon
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 默认每个标签允许的取值数量
+const defaultMaxLabelValues = 50
+
+// LabelOverflow 标签取值数量超出上限后使用的取值.
+const LabelOverflow = "other"
+
+// 标签表，记录每个标签取值对应的会话数量
+type labelTable struct {
+	allowed map[string]bool
+	values  map[string]map[string]int
+	max     int
+	mu      *sync.Mutex
+}
+
+func newLabelTable(names []string, max int) *labelTable {
+	if max <= 0 {
+		max = defaultMaxLabelValues
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return &labelTable{
+		allowed: allowed,
+		values:  make(map[string]map[string]int),
+		max:     max,
+		mu:      &sync.Mutex{},
+	}
+}
+
+// 记录取值，超出上限的新取值记为LabelOverflow
+func (t *labelTable) add(name, value string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	values, ok := t.values[name]
+	if !ok {
+		values = make(map[string]int)
+		t.values[name] = values
+	}
+	if _, ok := values[value]; !ok && len(values) >= t.max {
+		value = LabelOverflow
+	}
+	values[value]++
+	return value
+}
+
+// 移除取值，取值保留在表中以维持基数上限
+func (t *labelTable) remove(name, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if values, ok := t.values[name]; ok && values[value] > 0 {
+		values[value]--
+	}
+}
+
+// 获取各标签取值的会话数量快照
+func (t *labelTable) snapshot() map[string]map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snap := make(map[string]map[string]int, len(t.values))
+	for name, values := range t.values {
+		m := make(map[string]int, len(values))
+		for value, n := range values {
+			m[value] = n
+		}
+		snap[name] = m
+	}
+	return snap
+}
+
+// SetLabel 设置会话标签，标签名须在Config.SessionLabels中.
+// 每个标签的取值数量受Config.MaxLabelValues限制，超出后新取值记为LabelOverflow.
+func (s *Session) SetLabel(name, value string) error {
+	t := s.pigeon.labels
+	if !t.allowed[name] {
+		return errors.New("label " + name + " is not allowed")
+	}
+	value = t.add(name, value)
+
+	s.mu.Lock()
+	if s.labels == nil {
+		s.labels = make(map[string]string)
+	}
+	old, ok := s.labels[name]
+	s.labels[name] = value
+	s.mu.Unlock()
+
+	if ok {
+		t.remove(name, old)
+	}
+	return nil
+}
+
+// Labels 获取会话标签.
+func (s *Session) Labels() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	labels := make(map[string]string, len(s.labels))
+	for name, value := range s.labels {
+		labels[name] = value
+	}
+	return labels
+}
+
+// 会话关闭后移除标签计数
+func (s *Session) clearLabels() {
+	s.mu.Lock()
+	labels := s.labels
+	s.labels = nil
+	s.mu.Unlock()
+
+	for name, value := range labels {
+		s.pigeon.labels.remove(name, value)
+	}
+}
+
+// 一组标签取值的会话数量
+type labeledCount struct {
+	values   []string // 按Config.SessionLabels的顺序，未设置的标签为空.
+	sessions int
+}
+
+// 按允许的标签的取值组合统计会话数量，按取值排序
+func (p *Pigeon) labeledSessions() []labeledCount {
+	names := p.Config.SessionLabels
+	if len(names) == 0 {
+		return nil
+	}
+	counts := make(map[string]*labeledCount)
+	p.hub.iterator(func(s *Session) bool {
+		values := make([]string, len(names))
+		s.mu.RLock()
+		for i, name := range names {
+			values[i] = s.labels[name]
+		}
+		s.mu.RUnlock()
+		key := strings.Join(values, "\x00")
+		c, ok := counts[key]
+		if !ok {
+			c = &labeledCount{values: values}
+			counts[key] = c
+		}
+		c.sessions++
+		return true
+	})
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]labeledCount, len(keys))
+	for i, key := range keys {
+		list[i] = *counts[key]
+	}
+	return list
+}
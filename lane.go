@@ -0,0 +1,122 @@
+package pigeon
+
+import "sync"
+
+// 默认处理通道缓冲容量
+const defaultLaneBufferSize = 256
+
+// 处理通道，通道内的任务在同一个协程中按顺序执行
+type lane struct {
+	queue   chan func()
+	refs    int
+	stopped bool
+	mu      *sync.RWMutex
+}
+
+// 处理通道表
+type laneTable struct {
+	lanes map[string]*lane
+	size  int
+	mu    *sync.Mutex
+}
+
+func newLaneTable(size int) *laneTable {
+	if size <= 0 {
+		size = defaultLaneBufferSize
+	}
+	return &laneTable{
+		lanes: make(map[string]*lane),
+		size:  size,
+		mu:    &sync.Mutex{},
+	}
+}
+
+// 获取处理通道，不存在时创建并启动
+func (t *laneTable) acquire(name string) *lane {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.lanes[name]
+	if !ok {
+		l = &lane{queue: make(chan func(), t.size), mu: &sync.RWMutex{}}
+		t.lanes[name] = l
+		go l.run()
+	}
+	l.refs++
+	return l
+}
+
+// 释放处理通道，不再被引用时停止
+func (t *laneTable) release(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.lanes[name]
+	if !ok {
+		return
+	}
+	l.refs--
+	if l.refs <= 0 {
+		delete(t.lanes, name)
+		// 通道内的任务可能正在释放自身所在的通道，异步停止以免死锁
+		go l.stop()
+	}
+}
+
+func (l *lane) run() {
+	for fn := range l.queue {
+		fn()
+	}
+}
+
+// 提交任务，通道已停止时返回false
+func (l *lane) submit(fn func()) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.stopped {
+		return false
+	}
+	l.queue <- fn
+	return true
+}
+
+// 停止通道，已提交的任务执行完后协程退出
+func (l *lane) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+	close(l.queue)
+}
+
+// SetLane 将会话分配到指定名称的处理通道，同一通道内所有会话收到的信息在同一个协程中按到达顺序处理.
+// name为空时取消分配. 切换通道时，已进入旧通道的信息仍在旧通道中处理.
+func (s *Session) SetLane(name string) {
+	s.mu.Lock()
+	old := s.laneName
+	if old == name {
+		s.mu.Unlock()
+		return
+	}
+	s.laneName = name
+	s.lane = nil
+	if name != "" {
+		s.lane = s.pigeon.lanes.acquire(name)
+	}
+	s.mu.Unlock()
+
+	if old != "" {
+		s.pigeon.lanes.release(old)
+	}
+}
+
+// Lane 获取会话所在的处理通道名称.
+func (s *Session) Lane() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.laneName
+}
+
+// 获取会话所在的处理通道
+func (s *Session) currentLane() *lane {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lane
+}
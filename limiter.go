@@ -0,0 +1,135 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrRoomRateLimited 超出房间的发布频率.
+var ErrRoomRateLimited = errors.New("room rate limit exceeded")
+
+// 按键限流器中令牌桶数量超过该值时回收已满的令牌桶
+const limiterSweepSize = 4096
+
+// Limiter 按键限流，键为会话身份或房间名等. 多节点部署时使用NewClusterLimiter在集群内共享额度.
+type Limiter interface {
+	// Allow 为键取出一个令牌，超出频率时返回false.
+	Allow(key string) bool
+}
+
+// 本节点的按键限流器，每个键一个令牌桶
+type keyedLimiter struct {
+	rate    float64
+	burst   int
+	buckets map[string]*TokenBucket
+	clock   Clock
+	mu      *sync.Mutex
+}
+
+// 由信鸽实例设置时钟的限流器，信鸽包内的限流器在UseIdentityLimiter等安装时改用实例的Config.Clock
+type clockUser interface {
+	useClock(Clock)
+}
+
+// NewLimiter 新建只在本节点生效的按键限流器，每个键每秒补充rate个令牌，容量为burst，安装到信鸽实例后按其时钟补充.
+func NewLimiter(rate float64, burst int) Limiter {
+	return &keyedLimiter{rate: rate, burst: burst, buckets: make(map[string]*TokenBucket), clock: realClock{}, mu: &sync.Mutex{}}
+}
+
+func (l *keyedLimiter) Allow(key string) bool {
+	return l.bucket(key).Allow()
+}
+
+func (l *keyedLimiter) bucket(key string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if ok {
+		return b
+	}
+	if len(l.buckets) >= limiterSweepSize {
+		for k, old := range l.buckets {
+			if old.Full() {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b = newTokenBucket(l.rate, l.burst, l.clock)
+	l.buckets[key] = b
+	return b
+}
+
+// 改用clock并清空已有的令牌桶
+func (l *keyedLimiter) useClock(clock Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = clock
+	l.buckets = make(map[string]*TokenBucket)
+}
+
+// UseIdentityLimiter 按会话身份限制收到信息的频率，控制指令不计入. 键为Session.Identity，
+// 身份为空时为会话ID，同一用户的多个连接共享额度. 超出频率的会话以CloseRateLimited关闭，为nil时不限制.
+func (p *Pigeon) UseIdentityLimiter(l Limiter) {
+	p.adoptClock(l)
+	p.identityLimiter = l
+}
+
+// UseRoomLimiter 按房间限制Session.Publish的发布频率，房间的全部成员共享额度，
+// 超出时返回ErrRoomRateLimited，服务端的广播不受限制. 为nil时不限制.
+func (p *Pigeon) UseRoomLimiter(l Limiter) {
+	p.adoptClock(l)
+	p.roomLimiter = l
+}
+
+// UseIPLimiter 按客户端IP限制升级请求的频率，由AllowIP检查，pigeonhttp.RateLimitByIP在升级前使用同一个限流器.
+// 为nil时不限制.
+func (p *Pigeon) UseIPLimiter(l Limiter) {
+	p.adoptClock(l)
+	p.ipLimiter = l
+}
+
+// AllowIP 为IP取出UseIPLimiter设置的限流器中的一个令牌，超出频率时返回false.
+func (p *Pigeon) AllowIP(ip string) bool {
+	l := p.ipLimiter
+	if l == nil || l.Allow(ip) {
+		return true
+	}
+	atomic.AddUint64(&p.counters.rateLimited, 1)
+	return false
+}
+
+// 使信鸽包内的限流器使用实例的时钟
+func (p *Pigeon) adoptClock(l Limiter) {
+	if c, ok := l.(clockUser); ok {
+		c.useClock(p.clock)
+	}
+}
+
+// 检查会话身份的频率，超出时关闭会话并返回false
+func (p *Pigeon) allowInbound(s *Session) bool {
+	l := p.identityLimiter
+	if l == nil {
+		return true
+	}
+	key := s.Identity()
+	if key == "" {
+		key = s.id
+	}
+	if l.Allow(key) {
+		return true
+	}
+	atomic.AddUint64(&p.counters.rateLimited, 1)
+	s.CloseWithReason(CloseRateLimited)
+	return false
+}
+
+// 检查房间的发布频率
+func (p *Pigeon) allowPublish(room string) bool {
+	l := p.roomLimiter
+	if l == nil || l.Allow(room) {
+		return true
+	}
+	atomic.AddUint64(&p.counters.rateLimited, 1)
+	return false
+}
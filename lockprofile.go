@@ -0,0 +1,143 @@
+package pigeon
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 记录争用的锁的名称.
+const (
+	LockHub      = "hub"      // hub的开关状态.
+	LockSessions = "sessions" // 会话集合，LockFree实现时为全部分段的合计.
+	LockRooms    = "rooms"    // 房间表.
+	LockSession  = "session"  // 全部会话各自的状态锁的合计.
+	LockExecutor = "executor" // 公平调度执行器的队列.
+)
+
+var lockNames = []string{LockHub, LockSessions, LockRooms, LockSession, LockExecutor}
+
+// LockStats 锁的争用统计，需开启Config.LockProfiling.
+type LockStats struct {
+	Lock      string        `json:"lock"`
+	Acquired  uint64        `json:"acquired"`  // 加锁次数，含读锁.
+	Contended uint64        `json:"contended"` // 未能立即获得锁的次数.
+	Wait      time.Duration `json:"wait"`      // 累计等待时间.
+	MaxWait   time.Duration `json:"max_wait"`  // 单次最长等待时间.
+}
+
+// 一类锁的计数器，均为原子操作
+type lockStat struct {
+	acquired  uint64
+	contended uint64
+	waitNanos uint64
+	maxNanos  uint64
+}
+
+func (st *lockStat) record(wait time.Duration) {
+	atomic.AddUint64(&st.contended, 1)
+	atomic.AddUint64(&st.waitNanos, uint64(wait))
+	for {
+		max := atomic.LoadUint64(&st.maxNanos)
+		if uint64(wait) <= max || atomic.CompareAndSwapUint64(&st.maxNanos, max, uint64(wait)) {
+			return
+		}
+	}
+}
+
+// 各类锁的争用统计，未开启时为nil
+type lockProfiler struct {
+	stats map[string]*lockStat
+}
+
+func newLockProfiler(conf *Config) *lockProfiler {
+	if !conf.LockProfiling {
+		return nil
+	}
+	lp := &lockProfiler{stats: make(map[string]*lockStat, len(lockNames))}
+	for _, name := range lockNames {
+		lp.stats[name] = &lockStat{}
+	}
+	return lp
+}
+
+// 获取一类锁的计数器，未开启时返回nil
+func (lp *lockProfiler) stat(name string) *lockStat {
+	if lp == nil {
+		return nil
+	}
+	return lp.stats[name]
+}
+
+// 可记录争用的读写锁，stat为nil时与sync.RWMutex相同. 先尝试加锁，失败时才计时，未争用时几乎没有额外开销
+type profiledMutex struct {
+	sync.RWMutex
+	stat *lockStat
+}
+
+func newProfiledMutex(stat *lockStat) *profiledMutex {
+	return &profiledMutex{stat: stat}
+}
+
+func (m *profiledMutex) Lock() {
+	if m.stat == nil {
+		m.RWMutex.Lock()
+		return
+	}
+	atomic.AddUint64(&m.stat.acquired, 1)
+	if m.RWMutex.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.stat.record(time.Since(start))
+}
+
+func (m *profiledMutex) RLock() {
+	if m.stat == nil {
+		m.RWMutex.RLock()
+		return
+	}
+	atomic.AddUint64(&m.stat.acquired, 1)
+	if m.RWMutex.TryRLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.stat.record(time.Since(start))
+}
+
+// LockProfile 获取各类锁的争用统计，按累计等待时间从多到少排列，未开启Config.LockProfiling时返回nil.
+func (p *Pigeon) LockProfile() []LockStats {
+	lp := p.locks
+	if lp == nil {
+		return nil
+	}
+	stats := make([]LockStats, 0, len(lockNames))
+	for _, name := range lockNames {
+		st := lp.stats[name]
+		stats = append(stats, LockStats{
+			Lock:      name,
+			Acquired:  atomic.LoadUint64(&st.acquired),
+			Contended: atomic.LoadUint64(&st.contended),
+			Wait:      time.Duration(atomic.LoadUint64(&st.waitNanos)),
+			MaxWait:   time.Duration(atomic.LoadUint64(&st.maxNanos)),
+		})
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Wait > stats[j].Wait })
+	return stats
+}
+
+// ResetLockProfile 清零锁的争用统计，用于调整分段数量或执行器协程数后重新观察.
+func (p *Pigeon) ResetLockProfile() {
+	if p.locks == nil {
+		return
+	}
+	for _, st := range p.locks.stats {
+		atomic.StoreUint64(&st.acquired, 0)
+		atomic.StoreUint64(&st.contended, 0)
+		atomic.StoreUint64(&st.waitNanos, 0)
+		atomic.StoreUint64(&st.maxNanos, 0)
+	}
+}
@@ -0,0 +1,43 @@
+package pigeon
+
+// Tick 投递hub队列中的全部广播，返回投递的广播数. 仅在Config.HubImplementation为ManualHub时有效，
+// 广播在调用方协程中按提交顺序投递，高优先级广播先于普通广播. 投递中新提交的广播留到下一次调用.
+func (p *Pigeon) Tick() int {
+	if !p.hub.manual {
+		return 0
+	}
+	return p.hub.tick()
+}
+
+// 是否需要运行事件循环
+func (h *hub) looping() bool {
+	return !h.direct && !h.manual
+}
+
+// 手动模式下将广播放入待投递队列
+func (h *hub) queue(m *envelope) {
+	m.queuedAt = h.metrics.stamp()
+	h.spillMu.Lock()
+	h.pending = append(h.pending, m)
+	h.spillMu.Unlock()
+}
+
+// 投递待投递队列中的广播
+func (h *hub) tick() int {
+	h.spillMu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.spillMu.Unlock()
+
+	for _, m := range pending {
+		if m.urgent() {
+			h.handle(hubOpUrgent, m)
+		}
+	}
+	for _, m := range pending {
+		if !m.urgent() {
+			h.handle(hubOpBroadcast, m)
+		}
+	}
+	return len(pending)
+}
@@ -0,0 +1,34 @@
+package pigeon
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultMaxConnectionAgeJitter = 0.1
+
+// 计算会话到期的计时器，未限制存活时间时返回nil.
+// 按会话的cohort缩短存活时间，同一会话在重新绑定连接后到期时间不变
+func (s *Session) ageTimer() ClockTimer {
+	age := s.pigeon.Config.MaxConnectionAge
+	if age <= 0 {
+		return nil
+	}
+	jitter := s.pigeon.Config.MaxConnectionAgeJitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = defaultMaxConnectionAgeJitter
+	}
+	age -= time.Duration(float64(age) * jitter * s.cohort)
+	return s.pigeon.clock.NewTimer(s.connectedAt.Add(age).Sub(s.pigeon.now()))
+}
+
+// 建议客户端重连，在写入流程中调用
+func (s *Session) adviseReconnect() error {
+	msg, err := s.encodeAdvice(AdviceMaxAge)
+	if err != nil {
+		return err
+	}
+	s.transition(StateDraining)
+	return s.writeRaw(&envelope{t: websocket.TextMessage, message: msg})
+}
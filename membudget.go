@@ -0,0 +1,158 @@
+package pigeon
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrMemoryBudget 全部会话缓冲区中的字节数超出Config.QueuedBytesLimit，信息被丢弃.
+var ErrMemoryBudget = errors.New("queued bytes exceed the memory budget")
+
+// 回收内存时关闭会话直到低于预算的该比例，留出余量避免反复触发
+const memoryBudgetTarget = 0.9
+
+// 两次回收之间的最短间隔
+const memoryReclaimInterval = 100 * time.Millisecond
+
+// 全局缓冲字节数的记录
+type memoryBudget struct {
+	queued      int64
+	reclaiming  int32
+	lastReclaim int64
+}
+
+// 是否开启了内存预算
+func (p *Pigeon) budgeted() bool {
+	return p.Config.QueuedBytesLimit > 0
+}
+
+// 是否超出内存预算
+func (p *Pigeon) overBudget() bool {
+	return p.budgeted() && atomic.LoadInt64(&p.memory.queued) > p.Config.QueuedBytesLimit
+}
+
+// 信息进入缓冲区前计入字节数，超出预算时只接受高优先级信息和控制帧，返回false表示拒绝
+func (s *Session) reserveQueued(m *envelope) bool {
+	p := s.pigeon
+	if !p.budgeted() {
+		return true
+	}
+	n := int64(len(m.message))
+	if atomic.AddInt64(&p.memory.queued, n) > p.Config.QueuedBytesLimit && m.evictable(p.now()) {
+		atomic.AddInt64(&p.memory.queued, -n)
+		atomic.AddUint64(&p.counters.budgetDropped, 1)
+		p.reclaimMemory()
+		return false
+	}
+	atomic.AddInt64(&s.queuedBytes, n)
+	return true
+}
+
+// 信息离开缓冲区后释放字节数，n超过会话记录的字节数时按后者释放，会话结束时释放全部
+func (s *Session) releaseQueued(n int64) {
+	p := s.pigeon
+	if !p.budgeted() {
+		return
+	}
+	for {
+		cur := atomic.LoadInt64(&s.queuedBytes)
+		if n > cur {
+			n = cur
+		}
+		if atomic.CompareAndSwapInt64(&s.queuedBytes, cur, cur-n) {
+			break
+		}
+	}
+	atomic.AddInt64(&p.memory.queued, -n)
+}
+
+// 释放一批信息的字节数
+func (s *Session) releaseBatch(batch []*envelope) {
+	var n int64
+	for _, m := range batch {
+		n += int64(len(m.message))
+	}
+	s.releaseQueued(n)
+}
+
+// 超出预算时，缓冲字节数超过平均值的会话跳过可淘汰的信息，使其缓冲区尽快排空
+func (s *Session) evict(batch []*envelope) []*envelope {
+	p := s.pigeon
+	if !p.overBudget() {
+		return batch
+	}
+	sessions := int64(p.hub.len())
+	if sessions == 0 || atomic.LoadInt64(&s.queuedBytes) <= p.Config.QueuedBytesLimit/sessions {
+		return batch
+	}
+	now := p.now()
+	kept := batch[:0:0]
+	for _, m := range batch {
+		if !m.evictable(now) {
+			kept = append(kept, m)
+			continue
+		}
+		atomic.AddUint64(&p.counters.budgetEvicted, 1)
+		notifyWritten([]*envelope{m}, ErrMemoryBudget)
+		s.dropped(m, ErrMemoryBudget)
+	}
+	return kept
+}
+
+// 可被丢弃的信息：非可靠投递、非高优先级的普通信息，已过期的信息优先丢弃
+func (m *envelope) evictable(now time.Time) bool {
+	if m.t != websocket.TextMessage && m.t != websocket.BinaryMessage {
+		return false
+	}
+	if m.expired(now) {
+		return true
+	}
+	return !m.reliable && !m.urgent()
+}
+
+// 最后手段：按缓冲字节数从多到少关闭会话，直到低于预算的90%. 同一时间只有一次回收，两次回收至少间隔100毫秒
+func (p *Pigeon) reclaimMemory() {
+	now := p.now().UnixNano()
+	if now-atomic.LoadInt64(&p.memory.lastReclaim) < int64(memoryReclaimInterval) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&p.memory.reclaiming, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&p.memory.lastReclaim, now)
+	go func() {
+		defer atomic.StoreInt32(&p.memory.reclaiming, 0)
+		target := int64(float64(p.Config.QueuedBytesLimit) * memoryBudgetTarget)
+		excess := atomic.LoadInt64(&p.memory.queued) - target
+		if excess <= 0 {
+			return
+		}
+		sessions := p.collect(func(s *Session) bool { return atomic.LoadInt64(&s.queuedBytes) > 0 })
+		sort.Slice(sessions, func(i, j int) bool {
+			return atomic.LoadInt64(&sessions[i].queuedBytes) > atomic.LoadInt64(&sessions[j].queuedBytes)
+		})
+		for _, s := range sessions {
+			if excess <= 0 {
+				return
+			}
+			excess -= atomic.LoadInt64(&s.queuedBytes)
+			atomic.AddUint64(&p.counters.budgetClosed, 1)
+			s.abort(CloseMemoryBudget)
+		}
+	}()
+}
+
+// 会话结束时释放缓冲区中剩余信息的字节数
+func (s *Session) releaseAllQueued() {
+	s.releaseQueued(math.MaxInt64)
+}
+
+// QueuedBytes 获取会话缓冲区中待发送的字节数，需设置Config.QueuedBytesLimit.
+func (s *Session) QueuedBytes() int64 {
+	return atomic.LoadInt64(&s.queuedBytes)
+}
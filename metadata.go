@@ -0,0 +1,42 @@
+package pigeon
+
+type handleSentMetadataFunc func(s *Session, t int, msg []byte, metadata interface{})
+type handleDroppedFunc func(s *Session, t int, msg []byte, metadata interface{}, err error)
+
+// HandleSentMetadata 每条信息写入连接后的处理方法，metadata为发送时SendOptions.Metadata，未设置时为nil.
+// 可用于将一次广播与各会话的实际发送关联，例如按活动ID计费.
+func (p *Pigeon) HandleSentMetadata(fn func(s *Session, t int, msg []byte, metadata interface{})) {
+	p.sentMetadataHandler = fn
+}
+
+// HandleDropped 信息因缓冲区已满、会话已关闭或写入失败未能发送时的处理方法，在执行器中异步调用.
+func (p *Pigeon) HandleDropped(fn func(s *Session, t int, msg []byte, metadata interface{}, err error)) {
+	p.droppedHandler = fn
+}
+
+// 获取信封携带的元数据
+func (m *envelope) metadata() interface{} {
+	if m.opts == nil {
+		return nil
+	}
+	return m.opts.Metadata
+}
+
+// 调用发送处理方法
+func (s *Session) sentMetadata(m *envelope) {
+	fn := s.pigeon.sentMetadataHandler
+	if fn == nil {
+		return
+	}
+	s.pigeon.call(s, func() { fn(s, m.t, m.message, m.metadata()) })
+}
+
+// 调用丢弃处理方法
+func (s *Session) dropped(m *envelope, err error) {
+	s.droppedCallback(m, err)
+	fn := s.pigeon.droppedHandler
+	if fn == nil {
+		return
+	}
+	s.pigeon.asyncExecutor.submit(s, func() { fn(s, m.t, m.message, m.metadata(), err) })
+}
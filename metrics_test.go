@@ -0,0 +1,69 @@
+package pigeon_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+)
+
+func scrape(p *pigeon.Pigeon) string {
+	rec := httptest.NewRecorder()
+	p.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func expectMetric(t *testing.T, body, line string) {
+	t.Helper()
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Fatalf("metrics missing %q:\n%s", line, body)
+}
+
+// 允许的会话标签作为Prometheus标签输出在会话数量上
+func TestMetricsSessionLabels(t *testing.T) {
+	conf := pigeon.DefaultConfig()
+	conf.SessionLabels = []string{"plan", "app-region"}
+	srv := pigeontest.NewServer(conf)
+	defer srv.Close()
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		q := s.Request.URL.Query()
+		for _, name := range conf.SessionLabels {
+			if v := q.Get(name); v != "" {
+				s.SetLabel(name, v)
+			}
+		}
+	})
+	srv.Dial(t, "/?plan=pro&app-region=eu")
+	srv.Dial(t, "/?plan=pro&app-region=eu")
+	srv.Dial(t, "/?plan=free")
+
+	body := scrape(srv.Pigeon)
+	expectMetric(t, body, `pigeon_labeled_sessions{plan="free",app_region=""} 1`)
+	expectMetric(t, body, `pigeon_labeled_sessions{plan="pro",app_region="eu"} 2`)
+}
+
+// 采样的投递延迟以直方图输出
+func TestMetricsDeliveryLatency(t *testing.T) {
+	conf := pigeon.DefaultConfig()
+	conf.DeliverySampleRate = 1
+	srv := pigeontest.NewServer(conf)
+	defer srv.Close()
+	c := srv.Dial(t, "/")
+	srv.Pigeon.Broadcast([]byte("hello"))
+	srv.Flush()
+	c.Next(t)
+
+	waitUntil(t, "sampled delivery", func() bool {
+		return strings.Contains(scrape(srv.Pigeon), "pigeon_delivery_latency_seconds_count 1\n")
+	})
+	body := scrape(srv.Pigeon)
+	expectMetric(t, body, "# TYPE pigeon_delivery_latency_seconds histogram")
+	expectMetric(t, body, `pigeon_delivery_latency_seconds_bucket{le="10"} 1`)
+	expectMetric(t, body, `pigeon_delivery_latency_seconds_bucket{le="+Inf"} 1`)
+}
@@ -0,0 +1,215 @@
+package pigeon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ResumeQueryParam 客户端恢复会话时携带Session.ResumeToken签发的令牌的查询参数，只有会话ID时拒绝恢复.
+const ResumeQueryParam = "resume"
+
+// 导入的会话状态默认保留时间
+const defaultResumeWindow = 5 * time.Minute
+
+// SessionState 可迁移的会话元数据.
+type SessionState struct {
+	ID         string                 `json:"id"`
+	Secret     string                 `json:"secret,omitempty"` // 服务端生成的恢复密钥，导出的数据须按凭证保管.
+	Rooms      []string               `json:"rooms,omitempty"`
+	Keys       map[string]interface{} `json:"keys,omitempty"`
+	Labels     map[string]string      `json:"labels,omitempty"`
+	Lane       string                 `json:"lane,omitempty"`
+	Epoch      string                 `json:"epoch,omitempty"`      // 导出实例的纪元，恢复令牌须由同一纪元签发.
+	Seq        uint64                 `json:"seq,omitempty"`        // 可靠投递最后分配的序号.
+	Unacked    []json.RawMessage      `json:"unacked,omitempty"`    // 可靠投递中未确认的ReliableFrame.
+	Persistent []string               `json:"persistent,omitempty"` // 标记为持久的Keys.
+}
+
+// 等待恢复的会话状态
+type resumeTable struct {
+	states map[string]*SessionState
+	expiry map[string]time.Time
+	clock  Clock
+	mu     *sync.Mutex
+}
+
+func newResumeTable(clock Clock) *resumeTable {
+	return &resumeTable{
+		states: make(map[string]*SessionState),
+		expiry: make(map[string]time.Time),
+		clock:  clock,
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (t *resumeTable) put(state *SessionState, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge()
+	t.states[state.ID] = state
+	t.expiry[state.ID] = until
+}
+
+// 取出valid校验通过的会话状态，只能取出一次. 校验失败时保留状态，他人无法凭会话ID使其失效
+func (t *resumeTable) take(id string, valid func(*SessionState) bool) (*SessionState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge()
+	state, ok := t.states[id]
+	if !ok || !valid(state) {
+		return nil, false
+	}
+	delete(t.states, id)
+	delete(t.expiry, id)
+	return state, ok
+}
+
+// 清除过期的会话状态
+func (t *resumeTable) purge() int {
+	now := t.clock.Now()
+	n := 0
+	for id, until := range t.expiry {
+		if now.After(until) {
+			delete(t.states, id)
+			delete(t.expiry, id)
+			n++
+		}
+	}
+	return n
+}
+
+// 压缩时清除过期的会话状态，返回清除的数量
+func (t *resumeTable) compact() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.purge()
+}
+
+// State 获取会话的可迁移元数据.
+func (s *Session) State() *SessionState {
+	state := &SessionState{
+		ID:     s.ID(),
+		Secret: s.secret,
+		Rooms:  s.Rooms(),
+		Labels: s.Labels(),
+		Lane:   s.Lane(),
+		Epoch:  s.pigeon.epoch,
+	}
+	state.Seq, state.Unacked = s.reliable.snapshot()
+	s.mu.RLock()
+	if len(s.Keys) > 0 {
+		state.Keys = make(map[string]interface{}, len(s.Keys))
+		for k, v := range s.Keys {
+			state.Keys[k] = v
+		}
+	}
+	for k := range s.persistent {
+		state.Persistent = append(state.Persistent, k)
+	}
+	s.mu.RUnlock()
+	return state
+}
+
+// ExportSessions 以JSON导出全部会话的元数据，用于进程交接. Keys中的值须可被JSON编码.
+// 导出的数据包含各会话的恢复密钥，持有者可以恢复任意会话.
+func (p *Pigeon) ExportSessions() ([]byte, error) {
+	states := make([]*SessionState, 0, p.Len())
+	p.Range(func(s *Session) bool {
+		states = append(states, s.State())
+		return true
+	})
+	return json.Marshal(states)
+}
+
+// ImportSessions 导入ExportSessions导出的会话元数据.
+// 客户端在Config.ResumeWindow内携带ResumeQueryParam重新连接时恢复会话ID、房间、Keys、标签、处理通道和未确认的可靠信息.
+// 客户端须携带导出实例签发的恢复令牌，启用UseResumeKeys时两个实例须配置相同的密钥.
+// 导入的Keys经过JSON编解码，数值为float64，对象为map[string]interface{}.
+func (p *Pigeon) ImportSessions(data []byte) error {
+	var states []*SessionState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	window := p.Config.ResumeWindow
+	if window <= 0 {
+		window = defaultResumeWindow
+	}
+	until := p.now().Add(window)
+	for _, state := range states {
+		if state.ID != "" {
+			p.resumes.put(state, until)
+		}
+	}
+	return nil
+}
+
+// 判断令牌能否恢复会话状态：加密令牌须由同一纪元签发，未加密的令牌须携带会话的恢复密钥
+func (c *resumeClaims) match(state *SessionState) bool {
+	if c.secret == "" {
+		return c.Epoch == state.Epoch
+	}
+	return state.Secret != "" && subtle.ConstantTimeCompare([]byte(c.secret), []byte(state.Secret)) == 1
+}
+
+// Resumed 判断会话是否由导入的会话状态恢复.
+func (s *Session) Resumed() bool {
+	return s.resumed
+}
+
+// 根据请求中的恢复令牌取出待恢复的会话状态
+func (p *Pigeon) resumeState(s *Session) *SessionState {
+	param := s.Request.URL.Query().Get(ResumeQueryParam)
+	if param == "" {
+		return nil
+	}
+	claims, err := p.resumeID(s.Request, param)
+	if err != nil {
+		p.reportError(s, err)
+		return nil
+	}
+	matched := true
+	state, ok := p.resumes.take(claims.ID, func(state *SessionState) bool {
+		matched = claims.match(state)
+		return matched
+	})
+	if !matched {
+		p.reportError(s, ErrInvalidResumeToken)
+	}
+	if !ok {
+		return nil
+	}
+	s.id = state.ID
+	s.resumed = true
+	if len(state.Keys) > 0 {
+		keys := make(map[string]interface{}, len(state.Keys)+len(s.Keys))
+		for k, v := range state.Keys {
+			if v, ok := p.restoreKey(s, k, v); ok {
+				keys[k] = v
+			}
+		}
+		for k, v := range s.Keys {
+			keys[k] = v
+		}
+		s.Keys = keys
+	}
+	if len(state.Persistent) > 0 {
+		s.Persist(state.Persistent...)
+	}
+	return state
+}
+
+// 注册后恢复房间、标签、处理通道和可靠投递状态
+func (s *Session) restore(state *SessionState) {
+	s.reliable.restore(state.Seq, state.Unacked, s.pigeon.now())
+	for _, room := range state.Rooms {
+		s.Join(room)
+	}
+	for name, value := range state.Labels {
+		s.SetLabel(name, value)
+	}
+	if state.Lane != "" {
+		s.SetLane(state.Lane)
+	}
+}
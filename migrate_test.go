@@ -0,0 +1,86 @@
+package pigeon_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+)
+
+func TestResumeRequiresSecret(t *testing.T) {
+	old := pigeontest.NewServer(nil)
+	defer old.Close()
+	var token, id string
+	old.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		s.Join("lobby")
+		id = s.ID()
+		token, _ = s.ResumeToken()
+	})
+	old.Dial(t, "/").Close()
+	data, err := old.Pigeon.ExportSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := pigeontest.NewServer(nil)
+	defer srv.Close()
+	if err := srv.Pigeon.ImportSessions(data); err != nil {
+		t.Fatal(err)
+	}
+	var resumed *pigeon.Session
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		if s.Resumed() {
+			resumed = s
+		}
+	})
+
+	for _, param := range []string{id, id + ".", id + ".guess"} {
+		srv.Dial(t, "/?resume="+url.QueryEscape(param)).Close()
+		if resumed != nil {
+			t.Fatalf("resumed with %q", param)
+		}
+	}
+
+	srv.Dial(t, "/?resume="+url.QueryEscape(token))
+	if resumed == nil || resumed.ID() != id || !resumed.InRoom("lobby") {
+		t.Fatalf("token did not resume session %s", id)
+	}
+
+	resumed = nil
+	srv.Dial(t, "/?resume="+url.QueryEscape(token))
+	if resumed != nil {
+		t.Fatal("token resumed twice")
+	}
+}
+
+func TestPersistentKeysRequireToken(t *testing.T) {
+	srv := pigeontest.NewServer(nil)
+	defer srv.Close()
+	var token, id string
+	var restored interface{}
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		if s.Resumed() {
+			restored, _ = s.Get("user")
+		}
+		if id != "" {
+			return
+		}
+		s.SetPersistent("user", "alice")
+		id = s.ID()
+		token, _ = s.ResumeToken()
+	})
+	gone := make(chan struct{}, 4)
+	srv.Pigeon.HandleDisconnect(func(*pigeon.Session) { gone <- struct{}{} })
+	srv.Dial(t, "/").Close()
+	<-gone
+
+	srv.Dial(t, "/?resume="+url.QueryEscape(id))
+	if restored != nil {
+		t.Fatal("persistent keys restored from a bare session ID")
+	}
+	srv.Dial(t, "/?resume="+url.QueryEscape(token))
+	if restored != "alice" {
+		t.Fatalf("restored %v, want alice", restored)
+	}
+}
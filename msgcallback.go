@@ -0,0 +1,54 @@
+package pigeon
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// 信息放入缓冲区后调用SendOptions.OnQueued
+func (s *Session) queued(m *envelope) {
+	if m.opts == nil || m.opts.OnQueued == nil {
+		return
+	}
+	fn := m.opts.OnQueued
+	s.pigeon.asyncExecutor.submit(s, func() { fn(s) })
+}
+
+// 信息写入连接后调用SendOptions.OnWritten
+func (s *Session) written(m *envelope) {
+	if m.opts == nil || m.opts.OnWritten == nil {
+		return
+	}
+	fn := m.opts.OnWritten
+	s.pigeon.asyncExecutor.submit(s, func() { fn(s) })
+}
+
+// 信息未能发送时调用SendOptions.OnDropped
+func (s *Session) droppedCallback(m *envelope, err error) {
+	if m.opts == nil || m.opts.OnDropped == nil {
+		return
+	}
+	fn := m.opts.OnDropped
+	s.pigeon.asyncExecutor.submit(s, func() { fn(s, err) })
+}
+
+// 写入流程退出时丢弃缓冲区中剩余的信息，使每条信息最终都得到写入或丢弃的通知
+func (s *Session) discardQueued() {
+	err := errors.New("session closed before the message was written")
+	for {
+		select {
+		case m, ok := <-s.output:
+			if !ok {
+				return
+			}
+			if m.t != websocket.TextMessage && m.t != websocket.BinaryMessage {
+				continue
+			}
+			notifyWritten([]*envelope{m}, err)
+			s.dropped(m, err)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package pigeon
+
+import "net/http"
+
+// Handler 返回接入会话的http.HandlerFunc，可直接注册到http.ServeMux等路由器.
+// fn根据请求返回会话的Keys和连接后自动加入的房间，为nil时不设置.
+func Handler(p *Pigeon, fn func(r *http.Request) (map[string]interface{}, []string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var keys map[string]interface{}
+		var rooms []string
+		if fn != nil {
+			keys, rooms = fn(r)
+		}
+		if err := p.handleRequest(w, r, keys, rooms); err != nil {
+			p.reportError(nil, err)
+		}
+	}
+}
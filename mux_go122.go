@@ -0,0 +1,24 @@
+//go:build go1.22
+
+package pigeon
+
+import "net/http"
+
+// PathValues 配合Handler使用，将http.ServeMux匹配的路径参数names写入Keys，
+// room不为空时加入该房间，room可引用路径参数，如 {name}.
+//
+//	mux.HandleFunc("GET /channel/{name}/ws", pigeon.Handler(p, pigeon.PathValues("{name}", "name")))
+func PathValues(room string, names ...string) func(r *http.Request) (map[string]interface{}, []string) {
+	return func(r *http.Request) (map[string]interface{}, []string) {
+		params := make(map[string]string, len(names))
+		keys := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			params[name] = r.PathValue(name)
+			keys[name] = params[name]
+		}
+		if room == "" {
+			return keys, nil
+		}
+		return keys, []string{expandParams(room, params)}
+	}
+}
@@ -0,0 +1,36 @@
+package pigeon
+
+import "encoding/binary"
+
+// HandleBinaryOpcode 按二进制信息的前两个字节（大端序操作码）路由到处理方法，处理方法收到的信息不含操作码.
+func (p *Pigeon) HandleBinaryOpcode(op uint16, fn func(*Session, []byte)) {
+	if p.binaryRoutes == nil {
+		p.binaryRoutes = make(map[uint16]handleMessageFunc)
+	}
+	p.binaryRoutes[op] = fn
+}
+
+// HandleBinaryFallthrough 没有匹配的操作码时的处理方法，收到完整的二进制信息.
+// 未设置时交给HandleMessageBinary的处理方法，均未设置时按Config.UnexpectedBinary处理.
+func (p *Pigeon) HandleBinaryFallthrough(fn func(*Session, []byte)) {
+	p.binaryFallthrough = fn
+}
+
+// 分发二进制信息
+func (p *Pigeon) routeBinary(s *Session, msg []byte) {
+	if len(p.binaryRoutes) > 0 && len(msg) >= 2 {
+		if fn, ok := p.binaryRoutes[binary.BigEndian.Uint16(msg)]; ok {
+			fn(s, msg[2:])
+			return
+		}
+	}
+	if p.binaryFallthrough != nil {
+		p.binaryFallthrough(s, msg)
+		return
+	}
+	if p.messageHandlerBinary == nil {
+		p.unexpectedBinary(s, msg)
+		return
+	}
+	p.messageHandlerBinary(s, msg)
+}
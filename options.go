@@ -0,0 +1,80 @@
+package pigeon
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// Compression 单条信息的压缩策略.
+type Compression int
+
+const (
+	CompressAuto     Compression = iota // 使用连接的默认设置.
+	CompressForceOn                     // 强制压缩，忽略客户端的压缩提示和应用层压缩的大小阈值.
+	CompressForceOff                    // 不压缩，适用于图片等已压缩的内容.
+)
+
+// Priority 广播在hub中的优先级.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota // 按提交顺序投递.
+	PriorityHigh                   // 优先于排队中的普通广播投递，并跳过分批投递，适用于停机通知、配置推送等系统广播.
+)
+
+// SendOptions 发送信息时的可选项.
+type SendOptions struct {
+	Compress  Compression // 压缩策略，仅在协商了permessage-deflate时生效.
+	Immediate bool        // 广播时跳过分批投递.
+	Priority  Priority    // 广播优先级.
+	Metadata  interface{} // 透传给HandleSentMetadata和HandleDropped的不透明数据，不发送给客户端.
+
+	// 单条信息的生命周期回调，广播时对每个会话分别调用. 与HandleDropped等处理方法一样在执行器中异步调用，同一会话保持顺序.
+	OnQueued  func(s *Session)            // 信息放入会话缓冲区后调用.
+	OnWritten func(s *Session)            // 信息写入连接后调用.
+	OnDropped func(s *Session, err error) // 信息因缓冲区已满、超出内存预算、过期或写入失败等未能发送时调用，err为原因.
+}
+
+// WriteWithOptions 按可选项向会话写入普通文本信息.
+func (s *Session) WriteWithOptions(msg []byte, opts *SendOptions) error {
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	s.writeMessage(&envelope{t: websocket.TextMessage, message: msg, opts: opts})
+	return nil
+}
+
+// WriteBinaryWithOptions 按可选项向会话写入二进制信息.
+func (s *Session) WriteBinaryWithOptions(msg []byte, opts *SendOptions) error {
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	s.writeMessage(&envelope{t: websocket.BinaryMessage, message: msg, opts: opts})
+	return nil
+}
+
+// BroadcastWithOptions 按可选项广播消息.
+func (p *Pigeon) BroadcastWithOptions(msg []byte, opts *SendOptions) error {
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), opts: opts})
+}
+
+// BroadcastBinaryWithOptions 按可选项广播二进制消息.
+func (p *Pigeon) BroadcastBinaryWithOptions(msg []byte, opts *SendOptions) error {
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), opts: opts})
+}
+
+// 判断信封是否为高优先级
+func (m *envelope) urgent() bool {
+	return m.opts != nil && m.opts.Priority == PriorityHigh
+}
+
+// 判断信封是否需要压缩，启用压缩后默认压缩所有信息
+func (m *envelope) compress() bool {
+	return m.opts == nil || m.opts.Compress != CompressForceOff
+}
+
+// 判断信封是否强制压缩
+func (m *envelope) forceCompress() bool {
+	return m.opts != nil && m.opts.Compress == CompressForceOn
+}
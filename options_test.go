@@ -0,0 +1,39 @@
+package pigeon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressForceOn(t *testing.T) {
+	p := newFuzzPigeon(t)
+	p.Config.PayloadCompressThreshold = 1 << 20
+	s := newFuzzSession(p)
+	s.caps.PayloadCompression = PayloadGzip
+	msg := []byte("short")
+
+	m := s.compressPayload(&envelope{t: websocket.TextMessage, message: msg})
+	if m.t != websocket.TextMessage || !bytes.Equal(m.message, msg) {
+		t.Fatalf("message under the threshold was compressed: %x", m.message)
+	}
+	m = s.compressPayload(&envelope{t: websocket.TextMessage, message: msg, opts: &SendOptions{Compress: CompressForceOn}})
+	if m.t != websocket.BinaryMessage || m.message[0] != PayloadText|PayloadGzipFlag {
+		t.Fatalf("forced message was not compressed: %x", m.message)
+	}
+
+	batch := []*envelope{
+		{t: websocket.TextMessage, message: []byte("a")},
+		{t: websocket.TextMessage, message: []byte("b"), opts: &SendOptions{Compress: CompressForceOn}},
+	}
+	if !joinBatch(batch).forceCompress() {
+		t.Fatal("batch lost the forced compression")
+	}
+	if joinBatch(batch[:1]).forceCompress() || !joinBatch(batch[:1]).compress() {
+		t.Fatal("plain batch should use the connection default")
+	}
+	if (&envelope{opts: &SendOptions{Compress: CompressForceOff}}).compress() {
+		t.Fatal("forced off message should not be compressed")
+	}
+}
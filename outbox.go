@@ -0,0 +1,135 @@
+package pigeon
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxRecord 发件箱中的一条待广播记录，通常由业务事务在同一事务内写入.
+type OutboxRecord struct {
+	Offset  uint64 // 单调递增的位置.
+	Room    string // 目标房间，为空时广播给所有会话.
+	Binary  bool   // 是否为二进制信息.
+	Payload []byte // 信息内容.
+}
+
+// OutboxSource 发件箱数据源，通常由数据库表实现.
+type OutboxSource interface {
+	// Offset 获取已投递的位置.
+	Offset(ctx context.Context) (uint64, error)
+	// Fetch 按位置顺序获取位置大于after的记录，最多limit条.
+	Fetch(ctx context.Context, after uint64, limit int) ([]OutboxRecord, error)
+	// Commit 保存已投递的位置.
+	Commit(ctx context.Context, offset uint64) error
+}
+
+// OutboxNotifier 可选接口，数据源有新记录时通知消费者立即拉取，例如基于LISTEN/NOTIFY实现.
+type OutboxNotifier interface {
+	Notify() <-chan struct{}
+}
+
+// OutboxOptions 发件箱消费设置.
+type OutboxOptions struct {
+	PollInterval time.Duration // 轮询间隔，默认1秒.
+	BatchSize    int           // 每次拉取的记录数，默认100.
+	MaxRetries   int           // 单个操作的最大重试次数，为0时一直重试.
+	RetryBackoff time.Duration // 重试间隔，默认1秒.
+}
+
+// ConsumeOutbox 消费发件箱，将记录按顺序转换为广播并保存位置，阻塞到ctx结束或重试次数用尽.
+// 进程内每条记录只广播一次；进程在广播后、保存位置前退出时，重启后会再次广播这部分记录.
+func (p *Pigeon) ConsumeOutbox(ctx context.Context, src OutboxSource, opts OutboxOptions) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+
+	var offset uint64
+	if err := p.retryOutbox(ctx, opts, func() (err error) {
+		offset, err = src.Offset(ctx)
+		return
+	}); err != nil {
+		return err
+	}
+
+	var notify <-chan struct{}
+	if n, ok := src.(OutboxNotifier); ok {
+		notify = n.Notify()
+	}
+	ticker := p.clock.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		var records []OutboxRecord
+		if err := p.retryOutbox(ctx, opts, func() (err error) {
+			records, err = src.Fetch(ctx, offset, opts.BatchSize)
+			return
+		}); err != nil {
+			return err
+		}
+
+		for _, rec := range records {
+			if rec.Offset <= offset {
+				continue
+			}
+			rec := rec
+			if err := p.retryOutbox(ctx, opts, func() error { return p.broadcastRecord(&rec) }); err != nil {
+				return err
+			}
+			offset = rec.Offset
+			if err := p.retryOutbox(ctx, opts, func() error { return src.Commit(ctx, offset) }); err != nil {
+				return err
+			}
+		}
+		if len(records) == opts.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		case <-notify:
+		}
+	}
+}
+
+// 将发件箱记录转换为广播
+func (p *Pigeon) broadcastRecord(rec *OutboxRecord) error {
+	switch {
+	case rec.Room != "" && rec.Binary:
+		return p.BroadcastRoomBinary(rec.Room, rec.Payload)
+	case rec.Room != "":
+		return p.BroadcastRoom(rec.Room, rec.Payload)
+	case rec.Binary:
+		return p.BroadcastBinary(rec.Payload)
+	default:
+		return p.Broadcast(rec.Payload)
+	}
+}
+
+// 按设置重试，每次失败都交给错误处理方法
+func (p *Pigeon) retryOutbox(ctx context.Context, opts OutboxOptions, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		p.reportError(nil, err)
+		if opts.MaxRetries > 0 && attempt > opts.MaxRetries {
+			return err
+		}
+		t := p.clock.NewTimer(opts.RetryBackoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
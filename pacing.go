@@ -0,0 +1,218 @@
+package pigeon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 默认分批数量
+const defaultPacingCohorts = 10
+
+// 广播分批配置
+type pacing struct {
+	threshold int
+	window    time.Duration
+	cohorts   int
+	clock     Clock
+	pending   *pendingCohorts
+}
+
+func newPacing(conf *Config) pacing {
+	cohorts := conf.PacingCohorts
+	if cohorts <= 0 {
+		cohorts = defaultPacingCohorts
+	}
+	return pacing{
+		threshold: conf.PacingThreshold,
+		window:    conf.PacingWindow,
+		cohorts:   cohorts,
+		clock:     newClock(conf),
+		pending:   &pendingCohorts{batches: make(map[*pacedBatch]struct{}), mu: &sync.Mutex{}},
+	}
+}
+
+// 判断广播是否需要分批投递
+func (p pacing) enabled(m *envelope, n int) bool {
+	if p.window <= 0 || p.threshold <= 0 || n <= p.threshold {
+		return false
+	}
+	return m.opts == nil || !m.opts.Immediate && !m.urgent()
+}
+
+// 按会话随机分配的批次在窗口内分批投递.
+// 延迟批次中的会话在到期前收到的其他信息（包括不分批的广播和Session.Write）排在分批广播之后，
+// 因此同一会话收到的信息保持发送顺序.
+func (p pacing) deliver(h *hub, m *envelope, targets []*Session) {
+	cohorts := make([][]*Session, p.cohorts)
+	for _, s := range targets {
+		i := int(s.cohort * float64(p.cohorts))
+		cohorts[i] = append(cohorts[i], s)
+	}
+	step := p.window / time.Duration(p.cohorts)
+	for i, cohort := range cohorts {
+		if len(cohort) == 0 {
+			continue
+		}
+		if i == 0 {
+			for _, s := range cohort {
+				h.enqueue(s, m)
+			}
+			continue
+		}
+		b := &pacedBatch{sessions: cohort, entries: make([]*pacedEntry, len(cohort))}
+		for j, s := range cohort {
+			s := s
+			b.entries[j] = s.paced.wait(
+				func() error { return h.put(s, m, s.push) },
+				func(err error) { h.settle(s, m, err) },
+			)
+		}
+		p.pending.schedule(p.clock, step*time.Duration(i), b)
+	}
+}
+
+// 停止尚未到期的批次，排在其后的信息不再等待，在hub关闭时调用
+func (p pacing) stop() {
+	for _, b := range p.pending.drain() {
+		b.cancel()
+	}
+}
+
+// 尚未到期的批次
+type pendingCohorts struct {
+	batches map[*pacedBatch]struct{}
+	mu      *sync.Mutex
+}
+
+// 一个批次的定时投递
+type pacedBatch struct {
+	timer    *funcTimer
+	sessions []*Session
+	entries  []*pacedEntry
+}
+
+// 在d之后投递批次，持有锁创建定时器，到期时一定能找到已登记的批次
+func (c *pendingCohorts) schedule(clock Clock, d time.Duration, b *pacedBatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.timer = afterFunc(clock, d, func() {
+		c.mu.Lock()
+		_, ok := c.batches[b]
+		delete(c.batches, b)
+		c.mu.Unlock()
+		if ok {
+			b.release()
+		}
+	})
+	c.batches[b] = struct{}{}
+}
+
+// 取出全部批次并停止其定时器
+func (c *pendingCohorts) drain() []*pacedBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	batches := make([]*pacedBatch, 0, len(c.batches))
+	for b := range c.batches {
+		b.timer.Stop()
+		batches = append(batches, b)
+	}
+	c.batches = make(map[*pacedBatch]struct{})
+	return batches
+}
+
+func (b *pacedBatch) release() {
+	for i, s := range b.sessions {
+		s.paced.release(b.entries[i])
+	}
+}
+
+func (b *pacedBatch) cancel() {
+	for i, s := range b.sessions {
+		s.paced.cancel(b.entries[i])
+	}
+}
+
+// 会话的分批等待队列. 会话有尚未到期的分批广播时，之后写入的信息排在其后，到期后按顺序放入缓冲区
+type pacedQueue struct {
+	entries []*pacedEntry
+	mu      *sync.Mutex
+}
+
+// 等待放入缓冲区的信息
+type pacedEntry struct {
+	push  func() error // 放入会话缓冲区，持有队列锁调用.
+	done  func(error)  // 放入后在锁外调用.
+	ready bool
+}
+
+func newPacedQueue() *pacedQueue {
+	return &pacedQueue{mu: &sync.Mutex{}}
+}
+
+// 有尚未到期的分批广播时将信息排在其后，返回是否已排队. 控制帧不排队
+func (q *pacedQueue) hold(s *Session, m *envelope) bool {
+	switch m.t {
+	case websocket.CloseMessage, websocket.PingMessage, websocket.PongMessage:
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return false
+	}
+	q.entries = append(q.entries, &pacedEntry{
+		push: func() error { return s.push(m) },
+		done: func(err error) {
+			if err != nil {
+				s.pigeon.reportErrorAsync(s, err)
+			}
+		},
+		ready: true,
+	})
+	return true
+}
+
+// 登记尚未到期的分批广播
+func (q *pacedQueue) wait(push func() error, done func(error)) *pacedEntry {
+	e := &pacedEntry{push: push, done: done}
+	q.mu.Lock()
+	q.entries = append(q.entries, e)
+	q.mu.Unlock()
+	return e
+}
+
+// 分批广播到期，按顺序放入队首已就绪的信息
+func (q *pacedQueue) release(e *pacedEntry) {
+	q.mu.Lock()
+	e.ready = true
+	q.flush()
+}
+
+// 取消分批广播，排在其后的信息不再等待
+func (q *pacedQueue) cancel(e *pacedEntry) {
+	q.mu.Lock()
+	for i, entry := range q.entries {
+		if entry == e {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	q.flush()
+}
+
+// 放入队首已就绪的信息，须持有锁，返回前释放锁后再调用完成回调
+func (q *pacedQueue) flush() {
+	n := 0
+	errs := make([]error, 0, len(q.entries))
+	for ; n < len(q.entries) && q.entries[n].ready; n++ {
+		errs = append(errs, q.entries[n].push())
+	}
+	flushed := append([]*pacedEntry(nil), q.entries[:n]...)
+	q.entries = append(q.entries[:0], q.entries[n:]...)
+	q.mu.Unlock()
+	for i, e := range flushed {
+		e.done(errs[i])
+	}
+}
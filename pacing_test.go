@@ -0,0 +1,83 @@
+package pigeon
+
+import (
+	"testing"
+	"time"
+)
+
+func newPacedSessions(t *testing.T, n int) (*Pigeon, []*Session) {
+	conf := DefaultConfig()
+	conf.HubImplementation = LockFree
+	conf.PacingThreshold = 1
+	conf.PacingWindow = 100 * time.Millisecond
+	conf.PacingCohorts = 2
+	p := New(conf)
+	sessions := make([]*Session, n)
+	for i := range sessions {
+		sessions[i] = newFuzzSession(p)
+		sessions[i].cohort = 0.9 // 第二批，延迟半个窗口
+		p.hub.add(sessions[i])
+	}
+	// 会话没有连接，关闭实例前先注销
+	t.Cleanup(func() {
+		for _, s := range sessions {
+			p.hub.remove(s)
+		}
+		p.Close()
+	})
+	return p, sessions
+}
+
+func expectOutput(t *testing.T, s *Session, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case m := <-s.output:
+			if string(m.message) != w {
+				t.Fatalf("got %q, want %q", m.message, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+	select {
+	case m := <-s.output:
+		t.Fatalf("unexpected message %q", m.message)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+// 分批广播到期前，不分批的广播和Session.Write排在其后
+func TestPacingKeepsOrder(t *testing.T) {
+	p, sessions := newPacedSessions(t, 2)
+	if err := p.Broadcast([]byte("paced")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.BroadcastWithOptions([]byte("immediate"), &SendOptions{Immediate: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions[0].Write([]byte("direct")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-sessions[0].output:
+		t.Fatalf("%q delivered before the paced cohort", m.message)
+	default:
+	}
+	expectOutput(t, sessions[0], "paced", "immediate", "direct")
+	expectOutput(t, sessions[1], "paced", "immediate")
+}
+
+// 停止分批后未到期的广播被丢弃，排在其后的信息立即投递
+func TestPacingStop(t *testing.T) {
+	p, sessions := newPacedSessions(t, 2)
+	if err := p.Broadcast([]byte("paced")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions[0].Write([]byte("direct")); err != nil {
+		t.Fatal(err)
+	}
+	p.hub.pacing.stop()
+	expectOutput(t, sessions[0], "direct")
+	expectOutput(t, sessions[1])
+}
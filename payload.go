@@ -0,0 +1,124 @@
+package pigeon
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// HintPayloadCompression 客户端声明支持的应用层压缩算法，多个以逗号分隔，按客户端偏好排序，如 gzip,deflate.
+const HintPayloadCompression = "payload"
+
+// PayloadHeader 握手应答中服务端返回协商的应用层压缩算法的头，未协商时不返回.
+const PayloadHeader = "X-Pigeon-Payload"
+
+// 应用层压缩算法.
+const (
+	PayloadGzip    = "gzip"
+	PayloadDeflate = "deflate"
+)
+
+// 协商应用层压缩后，二进制信息的第一个字节为头，低位表示压缩算法，PayloadText表示原信息为文本.
+// 超过Config.PayloadCompressThreshold的文本信息压缩后以二进制信息发送.
+const (
+	PayloadRaw         byte = 0x00 // 未压缩.
+	PayloadGzipFlag    byte = 0x01 // gzip压缩.
+	PayloadDeflateFlag byte = 0x02 // deflate压缩.
+	PayloadText        byte = 0x80 // 原信息为文本.
+)
+
+var (
+	gzipWriters  = &sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	flateWriters = &sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// 按客户端偏好选择服务端启用时支持的压缩算法
+func (p *Pigeon) negotiatePayload(hint string) string {
+	if p.Config.PayloadCompressThreshold <= 0 {
+		return ""
+	}
+	for _, algo := range strings.Split(hint, ",") {
+		switch algo = strings.TrimSpace(algo); algo {
+		case PayloadGzip, PayloadDeflate:
+			return algo
+		}
+	}
+	return ""
+}
+
+// 握手应答头，告知客户端协商的应用层压缩算法
+func (p *Pigeon) payloadHeader(r *http.Request) http.Header {
+	algo := p.negotiatePayload(r.URL.Query().Get(HintPayloadCompression))
+	if algo == "" {
+		return nil
+	}
+	return http.Header{PayloadHeader: []string{algo}}
+}
+
+// 对协商了应用层压缩的会话，压缩超过阈值的信息并为二进制信息添加头
+func (s *Session) compressPayload(msg *envelope) *envelope {
+	algo := s.caps.PayloadCompression
+	if algo == "" || (msg.t != websocket.TextMessage && msg.t != websocket.BinaryMessage) {
+		return msg
+	}
+	var flag byte
+	if msg.t == websocket.TextMessage {
+		flag = PayloadText
+	}
+	if len(msg.message) < s.pigeon.Config.PayloadCompressThreshold && !msg.forceCompress() {
+		if msg.t == websocket.TextMessage {
+			return msg
+		}
+		return s.framePayload(msg, flag, msg.message)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(0)
+	var err error
+	switch algo {
+	case PayloadGzip:
+		flag |= PayloadGzipFlag
+		w := gzipWriters.Get().(*gzip.Writer)
+		w.Reset(buf)
+		_, err = w.Write(msg.message)
+		if err == nil {
+			err = w.Close()
+		}
+		gzipWriters.Put(w)
+	case PayloadDeflate:
+		flag |= PayloadDeflateFlag
+		w := flateWriters.Get().(*flate.Writer)
+		w.Reset(buf)
+		_, err = w.Write(msg.message)
+		if err == nil {
+			err = w.Close()
+		}
+		flateWriters.Put(w)
+	}
+	if err != nil {
+		s.pigeon.reportError(s, err)
+		return s.framePayload(msg, flag&PayloadText, msg.message)
+	}
+	data := buf.Bytes()
+	data[0] = flag
+	s.recordAlloc(len(data))
+	return &envelope{t: websocket.BinaryMessage, message: data, opts: msg.opts}
+}
+
+// 为信息添加头
+func (s *Session) framePayload(msg *envelope, flag byte, payload []byte) *envelope {
+	data := make([]byte, len(payload)+1)
+	data[0] = flag
+	copy(data[1:], payload)
+	s.recordAlloc(len(data))
+	return &envelope{t: websocket.BinaryMessage, message: data, opts: msg.opts}
+}
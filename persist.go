@@ -0,0 +1,74 @@
+package pigeon
+
+type handleKeyRestoreFunc func(s *Session, key string, value interface{}) (interface{}, bool)
+
+// SetPersistent 设置key/value并标记为持久，会话断开后持久的Keys随会话ID保留Config.ResumeWindow，
+// 客户端通过ResumeQueryParam携带Session.ResumeToken签发的令牌重新连接时自动恢复到新会话上，只有会话ID时不恢复.
+func (s *Session) SetPersistent(key string, value interface{}) {
+	s.Set(key, value)
+	s.Persist(key)
+}
+
+// Persist 将已有或之后设置的keys标记为持久.
+func (s *Session) Persist(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.persistent == nil {
+		s.persistent = make(map[string]struct{}, len(keys))
+	}
+	for _, key := range keys {
+		s.persistent[key] = struct{}{}
+	}
+}
+
+// Unpersist 取消keys的持久标记，不删除其值.
+func (s *Session) Unpersist(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.persistent, key)
+	}
+}
+
+// HandleKeyRestore 恢复会话时校验每个恢复的key，可返回替换后的值，返回false时丢弃该key.
+// 对持久的Keys和ImportSessions导入的Keys均生效.
+func (p *Pigeon) HandleKeyRestore(fn func(s *Session, key string, value interface{}) (interface{}, bool)) {
+	p.keyRestoreHandler = fn
+}
+
+// 会话结束后保留持久的Keys等待恢复，没有持久的Keys时不保留
+func (s *Session) persist() {
+	s.mu.RLock()
+	var keys map[string]interface{}
+	var names []string
+	for key := range s.persistent {
+		value, ok := s.Keys[key]
+		if !ok {
+			continue
+		}
+		if keys == nil {
+			keys = make(map[string]interface{}, len(s.persistent))
+		}
+		keys[key] = value
+		names = append(names, key)
+	}
+	s.mu.RUnlock()
+	if keys == nil {
+		return
+	}
+
+	window := s.pigeon.Config.ResumeWindow
+	if window <= 0 {
+		window = defaultResumeWindow
+	}
+	state := &SessionState{ID: s.id, Secret: s.secret, Keys: keys, Persistent: names, Epoch: s.pigeon.epoch}
+	s.pigeon.resumes.put(state, s.pigeon.now().Add(window))
+}
+
+// 校验恢复的key
+func (p *Pigeon) restoreKey(s *Session, key string, value interface{}) (interface{}, bool) {
+	if p.keyRestoreHandler == nil {
+		return value, true
+	}
+	return p.keyRestoreHandler(s, key, value)
+}
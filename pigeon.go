@@ -1,11 +1,16 @@
 package pigeon
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 type handleMessageFunc func(*Session, []byte)
@@ -13,21 +18,106 @@ type handleErrorFunc func(*Session, error)
 type handleCloseFunc func(*Session, int, string) error
 type handleSessionFunc func(*Session)
 type filterFunc func(*Session) bool
+type handleMessageTimingFunc func(*Session, int, time.Duration)
 
 // Pigeon websocket 管理器.
 type Pigeon struct {
-	Config                   *Config
-	UpGrader                 *websocket.Upgrader
-	messageHandler           handleMessageFunc
-	messageHandlerBinary     handleMessageFunc
-	messageSentHandler       handleMessageFunc
-	messageSentHandlerBinary handleMessageFunc
-	errorHandler             handleErrorFunc
-	closeHandler             handleCloseFunc
-	connectHandler           handleSessionFunc
-	disconnectHandler        handleSessionFunc
-	pongHandler              handleSessionFunc
-	hub                      *hub
+	Config                     *Config
+	UpGrader                   *websocket.Upgrader
+	messageHandler             handleMessageFunc
+	messageHandlerBinary       handleMessageFunc
+	messageSentHandler         handleMessageFunc
+	messageSentHandlerBinary   handleMessageFunc
+	errorHandler               handleErrorFunc
+	closeHandler               handleCloseFunc
+	connectHandler             handleSessionFunc
+	disconnectHandler          handleSessionFunc
+	pongHandler                handleSessionFunc
+	controlParser              controlParseFunc
+	controlAuthHandler         controlAuthFunc
+	codecs                     map[string]Codec
+	protocols                  map[string]*Protocol
+	binaryRoutes               map[uint16]handleMessageFunc
+	binaryFallthrough          handleMessageFunc
+	quotaHandler               quotaFunc
+	quotaExceededHandler       quotaExceededFunc
+	quotaStore                 QuotaStore
+	draining                   int32
+	lanes                      *laneTable
+	closeCodes                 map[CloseReason]CloseCode
+	disconnectReasonHandler    handleDisconnectReasonFunc
+	asyncExecutor              *executor
+	serving                    *serving
+	digests                    map[string]*digest
+	digestMu                   *sync.RWMutex
+	bans                       map[string]time.Time
+	banMu                      *sync.Mutex
+	eventHandlers              map[string]handleEventFunc
+	eventErrorHandler          handleEventErrorFunc
+	validators                 map[string][]validateEventFunc
+	invalidMessageHandler      handleInvalidMessageFunc
+	labels                     *labelTable
+	calls                      *callTable
+	resumes                    *resumeTable
+	roomFullHandler            handleRoomFullFunc
+	roomPromotedHandler        handleRoomFunc
+	delivery                   *deliveryTracker
+	creditPolicy               CreditPolicy
+	messageTimingHandler       handleMessageTimingFunc
+	acceptLimiter              *TokenBucket
+	identityLimiter            Limiter
+	tracer                     Tracer
+	roomLimiter                Limiter
+	ipLimiter                  Limiter
+	webhooks                   map[string][]*webhookSink
+	webhookMu                  *sync.RWMutex
+	taps                       *tapTable
+	experiments                *experimentTable
+	webhookDeadLetterHandler   webhookDeadLetterFunc
+	bridges                    []*bridgeDirection
+	bridgeMu                   *sync.RWMutex
+	resumeKeys                 *resumeKeys
+	resumeIdentity             resumeIdentityFunc
+	epoch                      string
+	roomMetrics                *roomMetrics
+	journal                    Journal
+	topics                     map[string]*Topic
+	topicMu                    *sync.RWMutex
+	fairIdentity               fairIdentityFunc
+	sessionIdentity            sessionIdentityFunc
+	fairWeight                 fairWeightFunc
+	pressure                   *pressureTable
+	keyRestoreHandler          handleKeyRestoreFunc
+	sentMetadataHandler        handleSentMetadataFunc
+	droppedHandler             handleDroppedFunc
+	reconnectAdvice            reconnectAdviceFunc
+	stateChangeHandler         handleStateChangeFunc
+	compactors                 *compactorTable
+	compactHandler             handleCompactFunc
+	sendLimiter                *rate.Limiter
+	sendBudgetExempt           sendBudgetExemptFunc
+	transportHealth            *transportHealthCounts
+	transportHealthHandler     handleTransportHealthFunc
+	roomModerator              roomModeratorFunc
+	moderationHandler          handleModerationFunc
+	moderation                 *moderationQueue
+	upgradeErrors              upgradeErrorCounts
+	upgradeErrorHandler        handleUpgradeErrorFunc
+	scopes                     *scopeTree
+	clientConfig               *clientConfigState
+	clientConfigHandler        handleClientConfigFunc
+	eventTTLs                  *eventTTLTable
+	locks                      *lockProfiler
+	brokers                    *brokerState
+	nodeID                     string
+	backpressureOnHandler      handleSessionFunc
+	backpressureOffHandler     handleSessionFunc
+	roomBackpressureOnHandler  handleRoomPressureFunc
+	roomBackpressureOffHandler handleRoomPressureFunc
+	clock                      Clock
+	counters                   counters
+	memory                     memoryBudget
+	hub                        *hub
 }
 
 // New 新建信鸽实例.
@@ -38,17 +128,25 @@ func New(conf *Config) *Pigeon {
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
 
-	hub := newHub()
-
-	go hub.run()
 	if conf == nil {
-		conf = defaultConfig()
+		conf = DefaultConfig()
+	}
+	upGrader.EnableCompression = conf.EnableCompression
+	if !conf.DisableWriteBufferPool {
+		// 写缓冲区只在写入一帧期间从池中取出，空闲连接不持有写缓冲区
+		upGrader.WriteBufferPool = &sync.Pool{}
+	}
+
+	locks := newLockProfiler(conf)
+	hub := newHub(conf, locks)
+	clock := newClock(conf)
+	if hub.looping() {
+		go hub.run()
 	}
-	return &Pigeon{
+	p := &Pigeon{
 		Config:                   conf,
 		UpGrader:                 upGrader,
 		messageHandler:           func(*Session, []byte) {},
-		messageHandlerBinary:     func(*Session, []byte) {},
 		messageSentHandler:       func(*Session, []byte) {},
 		messageSentHandlerBinary: func(*Session, []byte) {},
 		errorHandler:             func(*Session, error) {},
@@ -56,8 +154,73 @@ func New(conf *Config) *Pigeon {
 		connectHandler:           func(*Session) {},
 		disconnectHandler:        func(*Session) {},
 		pongHandler:              func(*Session) {},
+		controlParser:            parseControl,
+		codecs:                   make(map[string]Codec),
+		protocols:                make(map[string]*Protocol),
+		quotaStore:               NewMemoryQuotaStore(),
+		lanes:                    newLaneTable(conf.LaneBufferSize),
+		closeCodes:               defaultCloseCodes(),
+		digests:                  make(map[string]*digest),
+		digestMu:                 &sync.RWMutex{},
+		bans:                     make(map[string]time.Time),
+		banMu:                    &sync.Mutex{},
+		eventHandlers:            make(map[string]handleEventFunc),
+		validators:               make(map[string][]validateEventFunc),
+		labels:                   newLabelTable(conf.SessionLabels, conf.MaxLabelValues),
+		calls:                    newCallTable(),
+		resumes:                  newResumeTable(clock),
+		delivery:                 newDeliveryTracker(),
+		acceptLimiter:            newAcceptLimiter(conf),
+		webhooks:                 make(map[string][]*webhookSink),
+		webhookMu:                &sync.RWMutex{},
+		taps:                     newTapTable(),
+		experiments:              newExperimentTable(),
+		bridgeMu:                 &sync.RWMutex{},
+		resumeKeys:               newResumeKeys(),
+		epoch:                    newID(),
+		roomMetrics:              newRoomMetrics(conf),
+		topics:                   make(map[string]*Topic),
+		topicMu:                  &sync.RWMutex{},
+		pressure:                 newPressureTable(),
+		compactors:               newCompactorTable(),
+		sendLimiter:              newSendLimiter(conf),
+		transportHealth:          newTransportHealthCounts(),
+		moderation:               newModerationQueue(),
+		eventTTLs:                newEventTTLTable(),
+		locks:                    locks,
+		brokers:                  newBrokerState(),
+		clock:                    clock,
+		nodeID:                   conf.NodeID,
+		clientConfig:             newClientConfigState(),
 		hub:                      hub,
+		serving:                  newServing(),
+	}
+	if conf.FairScheduling {
+		p.asyncExecutor = newFairExecutor(p, conf.AsyncQueueSize, conf.FairQueueLimit, conf.AsyncWorkers, &p.counters.asyncDropped)
+	} else {
+		p.asyncExecutor = newExecutor(conf.AsyncQueueSize, conf.AsyncWorkers, &p.counters.asyncDropped)
+	}
+	hub.onError = p.reportErrorAsync
+	p.RegisterCodec(JSONCodec)
+	p.scopes = newScopeTree(p)
+	for _, def := range conf.Rooms {
+		p.DeclareRoom(def)
+	}
+	if p.nodeID == "" {
+		p.nodeID = newID()
 	}
+	if conf.StatsInterval > 0 {
+		go p.statsFeed(conf.StatsInterval)
+	}
+	if conf.CompactInterval > 0 {
+		go p.compactLoop(conf.CompactInterval)
+	}
+	if conf.ExpvarName != "" {
+		if err := p.publishExpvar(conf.ExpvarName); err != nil {
+			p.reportError(nil, err)
+		}
+	}
+	return p
 }
 
 // HandleConnect 会话连接时的处理方法.
@@ -75,27 +238,36 @@ func (p *Pigeon) HandlePong(fn func(*Session)) {
 	p.pongHandler = fn
 }
 
+// HandleMessageTiming 每条收到的信息处理完成后调用，传入信息大小和处理耗时，用于定位慢处理方法.
+// 耗时包含控制指令、事件和信息处理方法，在通道中处理时不包含排队时间.
+func (p *Pigeon) HandleMessageTiming(fn func(s *Session, size int, handlerDuration time.Duration)) {
+	p.messageTimingHandler = fn
+}
+
 // HandleMessage 收到信息时的处理方法.
 func (p *Pigeon) HandleMessage(fn func(*Session, []byte)) {
 	p.messageHandler = fn
 }
 
-// HandleMessageBinary 收到二进制信息的处理方法.
+// HandleMessageBinary 收到二进制信息的处理方法，未设置时按Config.UnexpectedBinary处理.
 func (p *Pigeon) HandleMessageBinary(fn func(*Session, []byte)) {
 	p.messageHandlerBinary = fn
 }
 
 // HandleSentMessage 发送信息时的处理方法.
+// 广播的消息由多个会话共享，处理方法中不得修改.
 func (p *Pigeon) HandleSentMessage(fn func(*Session, []byte)) {
 	p.messageSentHandler = fn
 }
 
 // HandleSentMessageBinary 发送二进制信息的处理方法.
+// 广播的消息由多个会话共享，处理方法中不得修改.
 func (p *Pigeon) HandleSentMessageBinary(fn func(*Session, []byte)) {
 	p.messageSentHandlerBinary = fn
 }
 
 // HandleError 发生错误时的处理方法.
+// 默认在执行器中异步调用，同一会话的错误保持顺序，见Config.SyncHandlers.
 func (p *Pigeon) HandleError(fn func(*Session, error)) {
 	p.errorHandler = fn
 }
@@ -108,79 +280,195 @@ func (p *Pigeon) HandleClose(fn func(*Session, int, string) error) {
 }
 
 // HandleRequest 将http请求升级成websocket连接，并将其注册到信鸽实例进行管理.
+// 也接受HTTP/2（含h2c）上RFC 8441的扩展CONNECT请求，会话建立在单个HTTP/2流上，其余用法不变.
 func (p *Pigeon) HandleRequest(w http.ResponseWriter, r *http.Request) error {
 	return p.HandleRequestWithKeys(w, r, nil)
 }
 
 // HandleRequestWithKeys 与HandleRequest功能相同，增加keys.
 func (p *Pigeon) HandleRequestWithKeys(w http.ResponseWriter, r *http.Request, keys map[string]interface{}) error {
-	if p.hub.closed() {
-		return errors.New("pigeon instance is closed")
+	return p.handleRequest(w, r, keys, nil)
+}
+
+// 接入会话，并在调用连接处理方法前加入rooms
+func (p *Pigeon) handleRequest(w http.ResponseWriter, r *http.Request, keys map[string]interface{}, rooms []string) error {
+	if isExtendedConnect(r) {
+		return p.serveExtendedConnect(w, r, keys, rooms)
+	}
+
+	if err := p.accepting(); err != nil {
+		if p.IsDraining() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+		return err
+	}
+
+	if p.IsBanned(RemoteIP(r)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return errors.New("remote ip is banned")
+	}
+
+	if err := p.throttleAccept(w); err != nil {
+		return err
+	}
+
+	quota, err := p.acquireQuota(w, r)
+	if err != nil {
+		return err
 	}
+	defer p.releaseQuota(quota)
 
-	conn, err := p.UpGrader.Upgrade(w, r, nil)
+	conn, err := p.UpGrader.Upgrade(p.retryWriter(w), r, p.payloadHeader(r))
 
 	if err != nil {
+		p.upgradeFailed(r, err)
+		return err
+	}
+
+	if err := p.tuneCompression(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := p.tuneKeepAlive(conn); err != nil {
+		conn.Close()
 		return err
 	}
 
 	session := &Session{
+		id:      newID(),
+		secret:  newID(),
 		Request: r,
 		Keys:    keys,
 		conn:    conn,
 		output:  make(chan *envelope, p.Config.MessageBufferSize),
 		pigeon:  p,
 		open:    true,
-		mu:      &sync.RWMutex{},
+		mu:      newProfiledMutex(p.locks.stat(LockSession)),
+		cohort:  rand.Float64(),
+
+		reliable: newReliableState(),
+		paced:    newPacedQueue(),
+		closedCh: make(chan struct{}),
+		holding:  p.Config.HoldInbound,
+
+		connectedAt: p.now(),
+	}
+	session.codec = p.negotiateCodec(session)
+	session.protocol = p.protocols[conn.Subprotocol()]
+	session.caps = p.negotiateCapabilities(r)
+	session.startDiagnostics()
+	state := p.resumeState(session)
+
+	p.serving.add()
+	defer p.serving.done()
+	p.hub.add(session)
+
+	if state != nil {
+		session.transition(StateResuming)
+		session.restore(state)
+	}
+	for _, room := range rooms {
+		if err := session.Join(room); err != nil && err != ErrRoomWaiting {
+			p.reportError(session, err)
+		}
 	}
 
-	p.hub.register <- session
+	session.transition(StateOpen)
 
+	p.assignExperiments(session)
+	p.pushClientConfigOnConnect(session)
 	p.connectHandler(session)
 
-	if p.closeHandler != nil {
-		session.conn.SetCloseHandler(func(code int, text string) error {
-			return p.closeHandler(session, code, text)
-		})
+	p.serve(session)
+
+	return nil
+}
+
+// 在会话当前的连接上运行读写流程，连接断开后注销并关闭会话.
+// 若连接已被rebind替换，则由新连接的serve负责后续流程.
+func (p *Pigeon) serve(session *Session) {
+	session.mu.Lock()
+	if session.detached {
+		session.mu.Unlock()
+		return
+	}
+	conn := session.conn
+	stop, done := make(chan struct{}), make(chan struct{})
+	session.writeStop, session.writeDone = stop, done
+	from, changed := session.setState(StateOpen)
+	session.mu.Unlock()
+	if changed {
+		session.stateChanged(from, StateOpen)
 	}
 
-	go session.writePump()
+	defaultCloseHandler := conn.CloseHandler()
+	conn.SetCloseHandler(func(code int, text string) error {
+		session.markClientClose()
+		if p.closeHandler != nil {
+			return p.closeHandler(session, code, text)
+		}
+		return defaultCloseHandler(code, text)
+	})
+
+	// 写入流程的计时器就绪后再开始读取，虚拟时钟推进时不会错过尚未创建的计时器
+	armed := make(chan struct{})
+	go session.writePump(stop, done, armed)
+	<-armed
 
-	session.readPump()
+	err := session.readPump(conn)
+
+	if session.replaced(conn) || session.isDetached() {
+		return
+	}
 
 	if !p.hub.closed() {
-		p.hub.unregister <- session
+		p.hub.remove(session)
 	}
 
 	session.close()
+	session.releaseAllQueued()
+
+	held := session.holdForResume()
+
+	session.leaveAll()
+
+	session.relievePressure(true)
+
+	if !held {
+		session.persist()
+	}
+
+	session.SetLane("")
+
+	session.clearLabels()
+
+	session.clearTags()
+
+	session.stopDiagnostics()
 
 	p.disconnectHandler(session)
 
-	return nil
+	if p.disconnectReasonHandler != nil {
+		p.disconnectReasonHandler(session, session.disconnectReason(err))
+	}
 }
 
 // Broadcast 广播消息.
+// 消息在广播前复制一次，所有会话共享该只读副本，调用方可在返回后继续修改msg.
 func (p *Pigeon) Broadcast(msg []byte) error {
-	if p.hub.closed() {
-		return errors.New("pigeon instance is closed")
-	}
-
-	message := &envelope{t: websocket.TextMessage, message: msg}
-	p.hub.broadcast <- message
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg)})
+}
 
-	return nil
+// BroadcastNoCopy 与Broadcast功能相同，但不复制消息.
+// 调用方须保证广播后不再修改msg，处理方法也不得修改收到的消息.
+func (p *Pigeon) BroadcastNoCopy(msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: msg})
 }
 
 // BroadcastFilter 向符合过滤器结果的会话广播消息.
+// 过滤器在hub中执行，应避免耗时操作.
 func (p *Pigeon) BroadcastFilter(msg []byte, fn func(*Session) bool) error {
-	if p.hub.closed() {
-		return errors.New("pigeon instance is closed")
-	}
-
-	message := &envelope{t: websocket.TextMessage, message: msg, filter: fn}
-	p.hub.broadcast <- message
-
-	return nil
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), filter: fn})
 }
 
 // BroadcastOthers 向某个会话之外的所有会话广播消息.
@@ -192,6 +480,7 @@ func (p *Pigeon) BroadcastOthers(msg []byte, s *Session) error {
 
 // BroadcastMultiple 向多个会话广播消息.
 func (p *Pigeon) BroadcastMultiple(msg []byte, sessions []*Session) error {
+	msg = copyBytes(msg)
 	for _, sess := range sessions {
 		if writeErr := sess.Write(msg); writeErr != nil {
 			return writeErr
@@ -202,24 +491,17 @@ func (p *Pigeon) BroadcastMultiple(msg []byte, sessions []*Session) error {
 
 // BroadcastBinary 广播二进制消息.
 func (p *Pigeon) BroadcastBinary(msg []byte) error {
-	if p.hub.closed() {
-		return errors.New("pigeon instance is closed")
-	}
-	message := &envelope{t: websocket.BinaryMessage, message: msg}
-	p.hub.broadcast <- message
-	return nil
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg)})
+}
+
+// BroadcastBinaryNoCopy 与BroadcastBinary功能相同，但不复制消息.
+func (p *Pigeon) BroadcastBinaryNoCopy(msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: msg})
 }
 
 // BroadcastBinaryFilter 向符合过滤器结果的会话广播二进制消息.
 func (p *Pigeon) BroadcastBinaryFilter(msg []byte, fn func(*Session) bool) error {
-	if p.hub.closed() {
-		return errors.New("pigeon instance is closed")
-	}
-
-	message := &envelope{t: websocket.BinaryMessage, message: msg, filter: fn}
-	p.hub.broadcast <- message
-
-	return nil
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), filter: fn})
 }
 
 // BroadcastBinaryOthers 向某个会话之外的所有会话广播二进制消息.
@@ -229,6 +511,36 @@ func (p *Pigeon) BroadcastBinaryOthers(msg []byte, s *Session) error {
 	})
 }
 
+// 将信封交给hub分发
+func (p *Pigeon) dispatch(message *envelope) error {
+	return p.dispatchContext(context.Background(), message)
+}
+
+// 提交广播，在hub队列阻塞时最多等待到ctx结束
+func (p *Pigeon) dispatchContext(ctx context.Context, message *envelope) error {
+	if p.hub.closed() {
+		return errors.New("pigeon instance is closed")
+	}
+	if p.digested(message) {
+		return nil
+	}
+	atomic.AddUint64(&p.counters.broadcasts, 1)
+	message.reliable = p.reliable(message.rooms)
+	p.applyEventTTL(message, message.event)
+	message.room = p.roomMetrics.publish(message.rooms)
+	p.mirror(message)
+	p.tap(message)
+	p.forward(message)
+	p.startTrace(ctx, message)
+	p.publishBroker(message)
+	p.sample(message)
+	if err := p.hub.send(ctx, message); err != nil {
+		message.endTrace(err)
+		return err
+	}
+	return nil
+}
+
 // Range 遍历所有session
 func (p *Pigeon) Range(fn func(*Session) bool) {
 	if fn == nil {
@@ -244,10 +556,29 @@ func (p *Pigeon) Close() error {
 
 // CloseWithMsg 关闭信鸽以及所有会话的连接，并向客户端发送消息
 func (p *Pigeon) CloseWithMsg(msg []byte) error {
-	if p.hub.closed() {
+	if !p.hub.close(&envelope{t: websocket.CloseMessage, message: msg}) {
 		return errors.New("pigeon instance is already closed")
 	}
-	p.hub.exit <- &envelope{t: websocket.CloseMessage, message: msg}
+	// 会话的断开流程仍会提交回调，等待其结束后再停止执行器，超时未结束的会话此后在自身协程中执行回调
+	p.serving.wait(p.Config.WriteWait)
+	p.asyncExecutor.stop()
+	return nil
+}
+
+// Restart 重新开启已关闭的信鸽实例，处理方法和配置保持不变，排空状态被清除.
+func (p *Pigeon) Restart() error {
+	if err := p.hub.reopen(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&p.draining, 0)
+	p.asyncExecutor.start()
+	p.restartDigests()
+	if p.Config.StatsInterval > 0 {
+		go p.statsFeed(p.Config.StatsInterval)
+	}
+	if p.Config.CompactInterval > 0 {
+		go p.compactLoop(p.Config.CompactInterval)
+	}
 	return nil
 }
 
@@ -0,0 +1,426 @@
+package pigeonclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed 客户端已关闭.
+var ErrClosed = errors.New("client is closed")
+
+// 按建议重连失败后，未设置ReconnectWait时的重试间隔
+const defaultAdvisedRetryWait = time.Second
+
+// Options 客户端设置.
+type Options struct {
+	Codec           pigeon.Codec                               // 编解码器，需在服务端注册同名编解码器，默认JSON.
+	Dialer          *websocket.Dialer                          // 默认websocket.DefaultDialer.
+	Header          http.Header                                // 握手时附加的请求头.
+	ReconnectWait   time.Duration                              // 断线后重连的间隔，为0时不重连，但仍按服务端的重连建议重连.
+	HandleMessage   func(c *Client, t int, msg []byte)         // 收到非事件信息时的处理方法.
+	HandleError     func(c *Client, err error)                 // 读取、重连或事件处理出错时的处理方法.
+	HandleReconnect func(c *Client)                            // 重连并重新加入房间后的处理方法.
+	HandleAdvice    func(c *Client, advice pigeon.Advice) bool // 收到服务端重连建议时的处理方法，返回false时不按建议重连.
+	HandleConfig    func(c *Client, cfg pigeon.ClientConfig)   // 收到服务端下发的配置时的处理方法，版本低于已收到的配置时不调用.
+}
+
+// Client 与服务端事件层对称的客户端，事件处理方法在读取协程中按顺序调用.
+type Client struct {
+	url      string
+	opts     Options
+	codec    pigeon.Codec
+	conn     *websocket.Conn
+	handlers map[string]func(*Client, *pigeon.Event) error
+	rooms    map[string]struct{}
+	calls    map[string]chan json.RawMessage
+	nextID   uint64
+	advice   *pigeon.Advice       // 待执行的重连建议.
+	payload  bool                 // 当前连接协商了应用层压缩.
+	config   *pigeon.ClientConfig // 最近收到的服务端配置.
+	closed   bool
+	done     chan struct{}
+	mu       *sync.Mutex // 保护conn、payload、config、rooms、calls和closed.
+	writeMu  *sync.Mutex
+	handleMu *sync.RWMutex
+}
+
+// Dial 连接服务端，opts为nil时使用默认设置.
+func Dial(ctx context.Context, rawURL string, opts *Options) (*Client, error) {
+	c := &Client{
+		handlers: make(map[string]func(*Client, *pigeon.Event) error),
+		rooms:    make(map[string]struct{}),
+		calls:    make(map[string]chan json.RawMessage),
+		done:     make(chan struct{}),
+		mu:       &sync.Mutex{},
+		writeMu:  &sync.Mutex{},
+		handleMu: &sync.RWMutex{},
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	c.codec = c.opts.Codec
+	if c.codec == nil {
+		c.codec = pigeon.JSONCodec
+	}
+	if c.opts.Dialer == nil {
+		c.opts.Dialer = websocket.DefaultDialer
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set(pigeon.CodecQueryParam, c.codec.Name())
+	u.RawQuery = q.Encode()
+	c.url = u.String()
+
+	conn, resp, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.payload = resp.Header.Get(pigeon.PayloadHeader) != ""
+	go c.readLoop(conn)
+	return c, nil
+}
+
+// On 注册事件的处理方法，返回的错误交给HandleError.
+func (c *Client) On(event string, fn func(*Client, *pigeon.Event) error) {
+	c.handleMu.Lock()
+	defer c.handleMu.Unlock()
+	c.handlers[event] = fn
+}
+
+// Emit 向服务端发送事件，data使用客户端的编解码器编码.
+func (c *Client) Emit(event string, data interface{}) error {
+	return c.send(&pigeon.Event{Name: event}, data)
+}
+
+// EmitV 向服务端发送指定版本的事件，由服务端为该版本注册的处理方法处理.
+func (c *Client) EmitV(event string, v int, data interface{}) error {
+	return c.send(&pigeon.Event{Name: event, V: v}, data)
+}
+
+// Reply 应答服务端通过Session.Call发出的请求.
+func (c *Client) Reply(ev *pigeon.Event, data interface{}) error {
+	return c.send(&pigeon.Event{Name: pigeon.EventReply, ID: ev.ID}, data)
+}
+
+// Call 向服务端发送带ID的事件并等待服务端以Session.Reply应答，ctx结束时返回其错误.
+func (c *Client) Call(ctx context.Context, event string, data interface{}) (json.RawMessage, error) {
+	id := strconv.FormatUint(atomic.AddUint64(&c.nextID, 1), 10)
+	reply := make(chan json.RawMessage, 1)
+	c.mu.Lock()
+	c.calls[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(&pigeon.Event{Name: event, ID: id}, data); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-reply:
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+// Decode 使用客户端的编解码器解码事件数据.
+func (c *Client) Decode(data []byte, v interface{}) error {
+	return c.codec.Unmarshal(data, v)
+}
+
+// Join 通过控制协议加入房间，重连后自动重新加入，需服务端启用Config.ControlProtocol.
+func (c *Client) Join(room string) error {
+	c.mu.Lock()
+	c.rooms[room] = struct{}{}
+	c.mu.Unlock()
+	return c.control(pigeon.ControlJoin, room)
+}
+
+// Leave 通过控制协议离开房间.
+func (c *Client) Leave(room string) error {
+	c.mu.Lock()
+	delete(c.rooms, room)
+	c.mu.Unlock()
+	return c.control(pigeon.ControlLeave, room)
+}
+
+// Close 关闭客户端，不再重连.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	conn := c.conn
+	close(c.done)
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return conn.Close()
+}
+
+// Done 客户端关闭时关闭的通道.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// 编码并发送事件
+func (c *Client) send(ev *pigeon.Event, data interface{}) error {
+	if data != nil {
+		raw, err := c.codec.Marshal(data)
+		if err != nil {
+			return err
+		}
+		ev.Data = raw
+	}
+	msg, err := c.codec.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return c.write(c.codec.MessageType(), msg)
+}
+
+// 发送控制指令
+func (c *Client) control(op, room string) error {
+	msg, err := json.Marshal(&pigeon.Control{Op: op, Room: room})
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, msg)
+}
+
+func (c *Client) write(t int, msg []byte) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteMessage(t, msg)
+}
+
+// 读取信息直到连接断开，按设置重连
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		t, msg, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			if c.isClosed() {
+				return
+			}
+			if !c.advised() {
+				c.reportError(err)
+			}
+			if conn = c.reconnect(); conn == nil {
+				return
+			}
+			continue
+		}
+		c.mu.Lock()
+		payload := c.payload
+		c.mu.Unlock()
+		if payload {
+			if t, msg, err = DecodePayload(t, msg); err != nil {
+				c.reportError(err)
+				continue
+			}
+		}
+		c.dispatch(t, msg)
+	}
+}
+
+// 分发收到的信息
+func (c *Client) dispatch(t int, msg []byte) {
+	if t == websocket.TextMessage {
+		if advice, ok := pigeon.ParseAdvice(msg); ok {
+			c.follow(advice)
+			return
+		}
+		if cfg, ok := pigeon.ParseClientConfig(msg); ok {
+			c.applyConfig(cfg)
+			return
+		}
+	}
+	if t == c.codec.MessageType() {
+		ev := &pigeon.Event{}
+		if err := c.codec.Unmarshal(msg, ev); err == nil && ev.Name != "" {
+			if ev.Name == pigeon.EventReply && ev.ID != "" && c.resolve(ev) {
+				return
+			}
+			c.handleMu.RLock()
+			fn, ok := c.handlers[ev.Name]
+			c.handleMu.RUnlock()
+			if ok {
+				if err := fn(c, ev); err != nil {
+					c.reportError(err)
+				}
+				return
+			}
+		}
+	}
+	if c.opts.HandleMessage != nil {
+		c.opts.HandleMessage(c, t, msg)
+	}
+}
+
+// 记录服务端下发的配置，忽略过期的版本
+func (c *Client) applyConfig(cfg pigeon.ClientConfig) {
+	c.mu.Lock()
+	stale := c.config != nil && cfg.Version < c.config.Version
+	if !stale {
+		c.config = &cfg
+	}
+	c.mu.Unlock()
+	if !stale && c.opts.HandleConfig != nil {
+		c.opts.HandleConfig(c, cfg)
+	}
+}
+
+// Config 获取最近收到的服务端配置，尚未收到时返回false.
+func (c *Client) Config() (pigeon.ClientConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config == nil {
+		return pigeon.ClientConfig{}, false
+	}
+	return *c.config, true
+}
+
+// 交付应答
+func (c *Client) resolve(ev *pigeon.Event) bool {
+	c.mu.Lock()
+	reply, ok := c.calls[ev.ID]
+	delete(c.calls, ev.ID)
+	c.mu.Unlock()
+	if ok {
+		reply <- ev.Data
+	}
+	return ok
+}
+
+// 按服务端建议断开当前连接，由读取协程按建议重连
+func (c *Client) follow(advice pigeon.Advice) {
+	if c.opts.HandleAdvice != nil && !c.opts.HandleAdvice(c, advice) {
+		return
+	}
+	c.mu.Lock()
+	c.advice = &advice
+	conn := c.conn
+	c.mu.Unlock()
+	conn.Close()
+}
+
+func (c *Client) advised() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.advice != nil
+}
+
+// 按间隔或服务端建议重连直到成功或客户端关闭，成功后重新加入房间
+func (c *Client) reconnect() *websocket.Conn {
+	c.mu.Lock()
+	advice := c.advice
+	c.advice = nil
+	c.mu.Unlock()
+
+	wait, target := c.opts.ReconnectWait, c.url
+	if advice != nil {
+		wait = advice.Delay
+		if advice.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(advice.Jitter)))
+		}
+		if advice.Host != "" {
+			if u, err := url.Parse(c.url); err == nil {
+				u.Host = advice.Host
+				target = u.String()
+			}
+		}
+	} else if wait <= 0 {
+		c.Close()
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			wait = c.opts.ReconnectWait
+			if wait <= 0 {
+				wait = defaultAdvisedRetryWait
+			}
+		}
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(wait):
+		}
+		conn, resp, err := c.opts.Dialer.Dial(target, c.opts.Header)
+		if err != nil {
+			c.reportError(err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		c.payload = resp.Header.Get(pigeon.PayloadHeader) != ""
+		c.url = target
+		rooms := make([]string, 0, len(c.rooms))
+		for room := range c.rooms {
+			rooms = append(rooms, room)
+		}
+		c.mu.Unlock()
+
+		for _, room := range rooms {
+			if err := c.control(pigeon.ControlJoin, room); err != nil {
+				c.reportError(err)
+			}
+		}
+		if c.opts.HandleReconnect != nil {
+			c.opts.HandleReconnect(c)
+		}
+		return conn
+	}
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Client) reportError(err error) {
+	if c.opts.HandleError != nil {
+		c.opts.HandleError(c, err)
+	}
+}
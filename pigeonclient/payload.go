@@ -0,0 +1,77 @@
+// Package pigeonclient 提供连接信鸽服务端的Go客户端，事件、请求应答和编解码器与服务端对称.
+package pigeonclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/gorilla/websocket"
+)
+
+// WithPayloadCompression 在连接地址上声明支持的应用层压缩算法，默认声明gzip和deflate.
+// Client根据握手应答中的pigeon.PayloadHeader判断服务端是否启用，启用时自动还原收到的信息.
+func WithPayloadCompression(rawURL string, algos ...string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if len(algos) == 0 {
+		algos = []string{pigeon.PayloadGzip, pigeon.PayloadDeflate}
+	}
+	q := u.Query()
+	q.Set(pigeon.HintPayloadCompression, strings.Join(algos, ","))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// DecodePayload 还原协商了应用层压缩的连接收到的信息，返回原信息类型和内容. 文本信息原样返回.
+func DecodePayload(messageType int, data []byte) (int, []byte, error) {
+	if messageType != websocket.BinaryMessage {
+		return messageType, data, nil
+	}
+	if len(data) == 0 {
+		return 0, nil, errors.New("missing payload header")
+	}
+	flag, payload := data[0], data[1:]
+	t := websocket.BinaryMessage
+	if flag&pigeon.PayloadText != 0 {
+		t = websocket.TextMessage
+	}
+
+	var r io.ReadCloser
+	switch flag &^ pigeon.PayloadText {
+	case pigeon.PayloadRaw:
+		return t, payload, nil
+	case pigeon.PayloadGzipFlag:
+		gr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return 0, nil, err
+		}
+		r = gr
+	case pigeon.PayloadDeflateFlag:
+		r = flate.NewReader(bytes.NewReader(payload))
+	default:
+		return 0, nil, errors.New("unknown payload compression")
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return t, out, nil
+}
+
+// ReadMessage 从协商了应用层压缩的连接读取信息并还原，见pigeon.PayloadHeader.
+func ReadMessage(conn *websocket.Conn) (int, []byte, error) {
+	t, data, err := conn.ReadMessage()
+	if err != nil {
+		return t, data, err
+	}
+	return DecodePayload(t, data)
+}
@@ -0,0 +1,132 @@
+// Package pigeonhttp 提供升级前使用的http中间件，与信鸽实例共享封禁和限流状态.
+package pigeonhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+// 签名URL使用的查询参数.
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+// Middleware http中间件.
+type Middleware func(http.Handler) http.Handler
+
+// Chain 依次使用中间件包装handler，第一个中间件最先执行.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequireHeader 要求请求带有指定的头，给出values时头的值必须是其中之一，否则返回400.
+func RequireHeader(name string, values ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v := r.Header.Get(name)
+			if v == "" || (len(values) > 0 && !contains(values, v)) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectBanned 拒绝被信鸽实例封禁的IP，返回403.
+func RejectBanned(p *pigeon.Pigeon) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p.IsBanned(pigeon.RemoteIP(r)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitByIP 使用信鸽实例的UseIPLimiter限制请求频率，超出时返回429，与实例共享各IP的额度.
+// 被信鸽实例封禁的IP直接返回403；banFor大于0时，超出频率的IP会在信鸽实例中被封禁，其已有会话同时被关闭.
+func RateLimitByIP(p *pigeon.Pigeon, banFor time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := pigeon.RemoteIP(r)
+			if p.IsBanned(ip) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if !p.AllowIP(ip) {
+				if banFor > 0 {
+					p.Ban(ip, banFor)
+				}
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidateSignedURL 校验由SignURL签名的URL，签名错误或已过期时返回403.
+func ValidateSignedURL(secret []byte) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !validSignature(secret, r.URL) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SignURL 为URL签名，签名在expires之后失效.
+func SignURL(secret []byte, u *url.URL, expires time.Time) *url.URL {
+	signed := *u
+	q := signed.Query()
+	q.Del(SignatureParam)
+	q.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	signed.RawQuery = q.Encode()
+	q.Set(SignatureParam, sign(secret, &signed))
+	signed.RawQuery = q.Encode()
+	return &signed
+}
+
+// 计算签名，覆盖路径及除签名外的全部查询参数
+func sign(secret []byte, u *url.URL) string {
+	q := u.Query()
+	q.Del(SignatureParam)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(u.EscapedPath() + "?" + q.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSignature(secret []byte, u *url.URL) bool {
+	q := u.Query()
+	expires, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get(SignatureParam)), []byte(sign(secret, u)))
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package pigeontest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+// Clock 只在调用Advance时前进的虚拟时钟，实现pigeon.Clock. 到期的计时器按到期时间依次触发，
+// 与time.Ticker一样，接收方未取走上一次的时间时丢弃本次触发.
+type Clock struct {
+	now     time.Time
+	waiters []*waiter
+	mu      *sync.Mutex
+}
+
+// 等待到期的计时器或定时器
+type waiter struct {
+	clock  *Clock
+	when   time.Time
+	period time.Duration // 周期，定时器为0.
+	active bool
+	c      chan time.Time
+}
+
+// NewClock 新建从start开始的虚拟时钟.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, mu: &sync.Mutex{}}
+}
+
+// Now 获取虚拟时钟的当前时间.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker 新建周期为d的计时器.
+func (c *Clock) NewTicker(d time.Duration) pigeon.ClockTicker {
+	if d <= 0 {
+		panic("pigeontest: non-positive interval for NewTicker")
+	}
+	return ticker{c.add(d, d)}
+}
+
+// NewTimer 新建d后触发一次的定时器.
+func (c *Clock) NewTimer(d time.Duration) pigeon.ClockTimer {
+	return c.add(d, 0)
+}
+
+func (c *Clock) add(d, period time.Duration) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{clock: c, when: c.now.Add(d), period: period, active: true, c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// Advance 将时钟推进d，期间到期的计时器和定时器按到期时间依次触发. 触发后才重新计时的定时器，
+// 如Session.Every，重新计时时时钟已推进到终点，因此每次Advance最多触发一次，需按间隔分步推进.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		w := c.next(end)
+		if w == nil {
+			break
+		}
+		c.now = w.when
+		select {
+		case w.c <- w.when:
+		default:
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+	}
+	c.now = end
+}
+
+// Waiters 获取尚未到期或停止的计时器和定时器数量.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// 获取end之前最早到期的计时器
+func (c *Clock) next(end time.Time) *waiter {
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+	if len(c.waiters) == 0 || c.waiters[0].when.After(end) {
+		return nil
+	}
+	return c.waiters[0]
+}
+
+func (c *Clock) remove(w *waiter) {
+	w.active = false
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// 计时器的Stop没有返回值
+type ticker struct{ *waiter }
+
+func (t ticker) Stop() { t.waiter.Stop() }
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *waiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.active
+	w.clock.remove(w)
+	return active
+}
+
+// Reset 重新计时并丢弃未取走的触发时间.
+func (w *waiter) Reset(d time.Duration) bool {
+	c := w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := w.active
+	select {
+	case <-w.c:
+	default:
+	}
+	w.when = c.now.Add(d)
+	if !active {
+		w.active = true
+		c.waiters = append(c.waiters, w)
+	}
+	return active
+}
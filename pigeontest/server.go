@@ -0,0 +1,256 @@
+// Package pigeontest 提供确定性的测试工具：广播只在调用Tick时投递的服务器、只在调用Advance时前进的虚拟时钟，
+// 以及在服务端处理完毕后才返回的测试客户端，测试中无需用sleep等待.
+package pigeontest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/gorilla/websocket"
+)
+
+// Timeout 测试客户端等待服务端的最长真实时间，超过时测试失败，仅用于避免测试挂起.
+var Timeout = 5 * time.Second
+
+// Server 测试服务器.
+type Server struct {
+	Pigeon *pigeon.Pigeon
+	Clock  *Clock
+	URL    string // websocket地址，如ws://127.0.0.1:1234.
+	http   *httptest.Server
+}
+
+// NewServer 新建测试服务器，conf为nil时使用与pigeon.New相同的默认配置. 配置被复制后修改：
+// HubImplementation为pigeon.ManualHub，处理方法同步执行，Clock未设置时为从当前时间开始的虚拟时钟.
+func NewServer(conf *pigeon.Config) *Server {
+	c := *pigeon.DefaultConfig()
+	if conf != nil {
+		c = *conf
+	}
+	c.HubImplementation = pigeon.ManualHub
+	c.SyncHandlers = true
+	clock, _ := c.Clock.(*Clock)
+	if c.Clock == nil {
+		clock = NewClock(time.Now())
+		c.Clock = clock
+	}
+	p := pigeon.New(&c)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.HandleRequest(w, r)
+	}))
+	return &Server{Pigeon: p, Clock: clock, URL: "ws" + strings.TrimPrefix(srv.URL, "http"), http: srv}
+}
+
+// Tick 投递已提交的广播，返回投递的广播数，见pigeon.Pigeon.Tick.
+func (s *Server) Tick() int {
+	return s.Pigeon.Tick()
+}
+
+// Flush 反复投递直到没有待投递的广播，返回投递的广播总数.
+func (s *Server) Flush() int {
+	total := 0
+	for {
+		n := s.Pigeon.Tick()
+		if n == 0 {
+			return total
+		}
+		total += n
+	}
+}
+
+// Advance 将虚拟时钟推进d，使用自定义时钟时忽略.
+func (s *Server) Advance(d time.Duration) {
+	if s.Clock != nil {
+		s.Clock.Advance(d)
+	}
+}
+
+// Close 关闭信鸽实例和http服务器.
+func (s *Server) Close() {
+	s.Pigeon.Close()
+	s.http.Close()
+}
+
+// Dial 连接到path，连接处理方法执行完毕、会话开始读写后才返回，失败时测试失败.
+func (s *Server) Dial(t testing.TB, path string) *Client {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.URL+path, nil)
+	if err != nil {
+		t.Fatalf("pigeontest: dial %s: %v", path, err)
+	}
+	c := newClient(conn)
+	c.Sync(t)
+	return c
+}
+
+// Message 客户端收到的信息.
+type Message struct {
+	Type int
+	Data []byte
+}
+
+// 客户端缓冲的信息数量
+const clientBuffer = 1024
+
+// Client 测试客户端，在后台协程中读取信息. 最多缓冲1024条未取出的信息，超出的信息被丢弃并记录，
+// 取完缓冲的信息后Next使测试失败，读取协程不会因测试未取出信息而阻塞.
+type Client struct {
+	Conn     *websocket.Conn
+	messages chan Message
+	pings    chan string
+	pongs    chan struct{}
+	done     chan struct{}
+	err      error
+	dropped  int64
+}
+
+func newClient(conn *websocket.Conn) *Client {
+	c := &Client{
+		Conn:     conn,
+		messages: make(chan Message, clientBuffer),
+		pings:    make(chan string, 64),
+		pongs:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case c.pings <- data:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(Timeout))
+	})
+	conn.SetPongHandler(func(string) error {
+		select {
+		case c.pongs <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	go c.read()
+	return c
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		t, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		select {
+		case c.messages <- Message{Type: t, Data: data}:
+		default:
+			atomic.AddInt64(&c.dropped, 1)
+		}
+	}
+}
+
+// Dropped 获取因缓冲已满而丢弃的信息数量.
+func (c *Client) Dropped() int {
+	return int(atomic.LoadInt64(&c.dropped))
+}
+
+// Sync 发送ping并等待pong. 服务端按顺序读取，收到pong时之前发送的信息均已被处理方法处理完毕.
+func (c *Client) Sync(t testing.TB) {
+	t.Helper()
+	if err := c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(Timeout)); err != nil {
+		t.Fatalf("pigeontest: ping: %v", err)
+	}
+	select {
+	case <-c.pongs:
+	case <-c.done:
+		t.Fatalf("pigeontest: connection closed before pong: %v", c.err)
+	case <-time.After(Timeout):
+		t.Fatal("pigeontest: timed out waiting for pong")
+	}
+}
+
+// Send 发送文本信息并等待服务端处理完毕，之后调用Server.Tick即可投递处理方法中提交的广播.
+func (c *Client) Send(t testing.TB, msg string) {
+	t.Helper()
+	if err := c.Conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("pigeontest: send: %v", err)
+	}
+	c.Sync(t)
+}
+
+// Next 获取下一条信息，超过Timeout、连接关闭或下一条信息已被丢弃时测试失败.
+func (c *Client) Next(t testing.TB) Message {
+	t.Helper()
+	select {
+	case m := <-c.messages:
+		return m
+	default:
+	}
+	if n := c.Dropped(); n > 0 {
+		t.Fatalf("pigeontest: client buffer overflowed, %d messages dropped", n)
+	}
+	select {
+	case m := <-c.messages:
+		return m
+	case <-c.done:
+		// 关闭前收到的信息仍可取出
+		select {
+		case m := <-c.messages:
+			return m
+		default:
+		}
+		t.Fatalf("pigeontest: connection closed: %v", c.err)
+	case <-time.After(Timeout):
+		t.Fatal("pigeontest: timed out waiting for a message")
+	}
+	return Message{}
+}
+
+// Expect 获取下一条信息并与want比较，不同时测试失败.
+func (c *Client) Expect(t testing.TB, want string) {
+	t.Helper()
+	if m := c.Next(t); string(m.Data) != want {
+		t.Fatalf("pigeontest: got message %q, want %q", m.Data, want)
+	}
+}
+
+// ExpectPing 等待服务端的ping，返回其内容.
+func (c *Client) ExpectPing(t testing.TB) string {
+	t.Helper()
+	select {
+	case data := <-c.pings:
+		return data
+	case <-time.After(Timeout):
+		t.Fatal("pigeontest: timed out waiting for a ping")
+	}
+	return ""
+}
+
+// ExpectClose 等待连接关闭，返回关闭码，连接未收到关闭帧而断开时为websocket.CloseAbnormalClosure.
+// 关闭前收到的信息被丢弃.
+func (c *Client) ExpectClose(t testing.TB) int {
+	t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case <-c.messages:
+		case <-c.done:
+			var ce *websocket.CloseError
+			if errors.As(c.err, &ce) {
+				return ce.Code
+			}
+			return websocket.CloseAbnormalClosure
+		case <-timeout:
+			t.Fatal("pigeontest: timed out waiting for the connection to close")
+			return 0
+		}
+	}
+}
+
+// Close 关闭客户端连接.
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
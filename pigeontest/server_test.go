@@ -0,0 +1,34 @@
+package pigeontest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+func TestClientOverflow(t *testing.T) {
+	conf := pigeon.DefaultConfig()
+	conf.MessageBufferSize = 2 * clientBuffer
+	srv := NewServer(conf)
+	defer srv.Close()
+	c := srv.Dial(t, "/")
+	for i := 0; i < clientBuffer+100; i++ {
+		srv.Pigeon.Broadcast([]byte(strconv.Itoa(i)))
+	}
+	srv.Flush()
+	deadline := time.Now().Add(Timeout)
+	for len(c.messages)+c.Dropped() < clientBuffer+100 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the messages")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Expect(t, "0")
+	srv.Pigeon.Close()
+	c.ExpectClose(t)
+	if n := c.Dropped(); n != 100 {
+		t.Fatalf("dropped %d messages, want 100", n)
+	}
+}
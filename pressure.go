@@ -0,0 +1,174 @@
+package pigeon
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 默认低水位占高水位的比例
+const defaultLowWatermarkRatio = 0.5
+
+type handleRoomPressureFunc func(room string)
+
+// Pressure 实例的背压概况.
+type Pressure struct {
+	Sessions   int     `json:"sessions"`    // 缓冲区处于高水位的会话数.
+	Rooms      int     `json:"rooms"`       // 存在高水位会话的房间数.
+	BufferFill float64 `json:"buffer_fill"` // 会话缓冲区的平均占用比例.
+	HubFill    float64 `json:"hub_fill"`    // hub广播队列的占用比例，未设置Config.HubQueueSize时为0.
+	Level      float64 `json:"level"`       // 综合压力，取高水位会话占比、BufferFill和HubFill中的最大值.
+}
+
+// 处于高水位的会话及其所在房间的计数
+type pressureTable struct {
+	sessions int64
+	rooms    map[string]int
+	mu       *sync.Mutex
+}
+
+func newPressureTable() *pressureTable {
+	return &pressureTable{rooms: make(map[string]int), mu: &sync.Mutex{}}
+}
+
+// HandleBackpressureOn 会话缓冲区达到Config.HighWatermark时的处理方法，在执行器中异步调用.
+func (p *Pigeon) HandleBackpressureOn(fn func(*Session)) {
+	p.backpressureOnHandler = fn
+}
+
+// HandleBackpressureOff 会话缓冲区回落到Config.LowWatermark或会话结束时的处理方法，在执行器中异步调用.
+func (p *Pigeon) HandleBackpressureOff(fn func(*Session)) {
+	p.backpressureOffHandler = fn
+}
+
+// HandleRoomBackpressureOn 房间中出现第一个高水位会话时的处理方法，在执行器中异步调用.
+func (p *Pigeon) HandleRoomBackpressureOn(fn func(room string)) {
+	p.roomBackpressureOnHandler = fn
+}
+
+// HandleRoomBackpressureOff 房间中不再有高水位会话时的处理方法，在执行器中异步调用.
+func (p *Pigeon) HandleRoomBackpressureOff(fn func(room string)) {
+	p.roomBackpressureOffHandler = fn
+}
+
+// Pressure 获取实例的背压概况，可供上游生产者限流.
+func (p *Pigeon) Pressure() Pressure {
+	pr := Pressure{Sessions: int(atomic.LoadInt64(&p.pressure.sessions))}
+	p.pressure.mu.Lock()
+	pr.Rooms = len(p.pressure.rooms)
+	p.pressure.mu.Unlock()
+
+	sessions, buffered := 0, 0
+	p.hub.iterator(func(s *Session) bool {
+		sessions++
+		buffered += len(s.output)
+		return true
+	})
+	if size := p.Config.MessageBufferSize; sessions > 0 && size > 0 {
+		pr.BufferFill = float64(buffered) / float64(sessions*size)
+	}
+	if size := p.Config.HubQueueSize; size > 0 {
+		pr.HubFill = float64(p.hub.queueDepth()) / float64(size)
+		if pr.HubFill > 1 {
+			pr.HubFill = 1
+		}
+	}
+	pr.Level = pr.BufferFill
+	if pr.HubFill > pr.Level {
+		pr.Level = pr.HubFill
+	}
+	if sessions > 0 {
+		if ratio := float64(pr.Sessions) / float64(sessions); ratio > pr.Level {
+			pr.Level = ratio
+		}
+	}
+	return pr
+}
+
+// 获取高低水位对应的信息数量，未启用时返回0
+func (p *Pigeon) watermarks() (int, int) {
+	high := p.Config.HighWatermark
+	if high <= 0 {
+		return 0, 0
+	}
+	low := p.Config.LowWatermark
+	if low <= 0 || low >= high {
+		low = high * defaultLowWatermarkRatio
+	}
+	size := float64(p.Config.MessageBufferSize)
+	return int(high * size), int(low * size)
+}
+
+// 信息放入缓冲区后检查是否达到高水位
+func (s *Session) raisePressure() {
+	high, _ := s.pigeon.watermarks()
+	if high <= 0 || len(s.output) < high || !atomic.CompareAndSwapInt32(&s.pressured, 0, 1) {
+		return
+	}
+	p := s.pigeon
+	atomic.AddInt64(&p.pressure.sessions, 1)
+	rooms := s.Rooms()
+	var raised []string
+	p.pressure.mu.Lock()
+	s.pressureRooms = rooms
+	for _, room := range rooms {
+		p.pressure.rooms[room]++
+		if p.pressure.rooms[room] == 1 {
+			raised = append(raised, room)
+		}
+	}
+	p.pressure.mu.Unlock()
+
+	p.asyncExecutor.submit(s, func() {
+		if p.backpressureOnHandler != nil {
+			p.backpressureOnHandler(s)
+		}
+		if p.roomBackpressureOnHandler != nil {
+			for _, room := range raised {
+				p.roomBackpressureOnHandler(room)
+			}
+		}
+	})
+}
+
+// 写入后检查是否回落到低水位，force为true时直接解除，用于会话结束
+func (s *Session) relievePressure(force bool) {
+	if atomic.LoadInt32(&s.pressured) == 0 {
+		return
+	}
+	_, low := s.pigeon.watermarks()
+	if !force && len(s.output) > low {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.pressured, 1, 0) {
+		return
+	}
+	p := s.pigeon
+	atomic.AddInt64(&p.pressure.sessions, -1)
+	var relieved []string
+	p.pressure.mu.Lock()
+	for _, room := range s.pressureRooms {
+		p.pressure.rooms[room]--
+		if p.pressure.rooms[room] <= 0 {
+			delete(p.pressure.rooms, room)
+			relieved = append(relieved, room)
+		}
+	}
+	s.pressureRooms = nil
+	p.pressure.mu.Unlock()
+
+	p.asyncExecutor.submit(s, func() {
+		if p.backpressureOffHandler != nil {
+			p.backpressureOffHandler(s)
+		}
+		if p.roomBackpressureOffHandler != nil {
+			for _, room := range relieved {
+				p.roomBackpressureOffHandler(room)
+			}
+		}
+	})
+}
+
+// Pressured 判断会话缓冲区是否处于高水位.
+func (s *Session) Pressured() bool {
+	return atomic.LoadInt32(&s.pressured) == 1
+}
@@ -0,0 +1,207 @@
+package pigeon_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/gorilla/websocket"
+)
+
+var propertyRooms = []string{"a", "b", "c"}
+
+// 随机交错的连接、断开、加入、离开与广播，运行结束后检查hub的不变量，配合-race使用
+func TestHubProperties(t *testing.T) {
+	impls := []struct {
+		name string
+		impl pigeon.HubImplementation
+	}{{"channel", pigeon.ChannelHub}, {"lockfree", pigeon.LockFree}, {"manual", pigeon.ManualHub}}
+	for _, impl := range impls {
+		for seed := int64(1); seed <= 3; seed++ {
+			impl, seed := impl, seed
+			t.Run(fmt.Sprintf("%s/%d", impl.name, seed), func(t *testing.T) {
+				runHubProperty(t, impl.impl, seed)
+			})
+		}
+	}
+}
+
+func runHubProperty(t *testing.T, impl pigeon.HubImplementation, seed int64) {
+	p := pigeon.New(&pigeon.Config{
+		WriteWait:         time.Second,
+		PongWait:          time.Minute,
+		PingPeriod:        time.Second * 50,
+		MaxMessageSize:    512,
+		MessageBufferSize: 64,
+		HubImplementation: impl,
+		QueuedBytesLimit:  1 << 30,
+	})
+	var mu sync.Mutex
+	sessions := make(map[*pigeon.Session]struct{})
+	p.HandleConnect(func(s *pigeon.Session) {
+		mu.Lock()
+		sessions[s] = struct{}{}
+		mu.Unlock()
+	})
+	p.HandleDisconnect(func(s *pigeon.Session) {
+		mu.Lock()
+		delete(sessions, s)
+		mu.Unlock()
+	})
+	pick := func(r *rand.Rand) *pigeon.Session {
+		mu.Lock()
+		defer mu.Unlock()
+		n := r.Intn(len(sessions) + 1)
+		for s := range sessions {
+			if n--; n < 0 {
+				return s
+			}
+		}
+		return nil
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { p.HandleRequest(w, r) }))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var readers sync.WaitGroup
+	var clientsMu sync.Mutex
+	var clients []*websocket.Conn
+	dial := func() {
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			return
+		}
+		clientsMu.Lock()
+		clients = append(clients, c)
+		clientsMu.Unlock()
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			readOrdered(t, c)
+		}()
+	}
+
+	// 广播只在一个协程中发出，每个会话收到的同一目标的序号须递增
+	stop := make(chan struct{})
+	broadcasting := make(chan struct{})
+	go func() {
+		defer close(broadcasting)
+		seq := 0
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			seq++
+			target := "*"
+			if seq%4 != 0 {
+				target = propertyRooms[seq%len(propertyRooms)]
+				p.BroadcastRoom(target, []byte(target+":"+strconv.Itoa(seq)))
+			} else {
+				p.Broadcast([]byte(target + ":" + strconv.Itoa(seq)))
+			}
+			p.Tick()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		r := rand.New(rand.NewSource(seed*10 + int64(w)))
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := 0; i < 300; i++ {
+				time.Sleep(time.Duration(r.Intn(200)) * time.Microsecond)
+				switch op := r.Intn(10); {
+				case op < 3:
+					mu.Lock()
+					live := len(sessions)
+					mu.Unlock()
+					if live < 32 {
+						dial()
+					}
+				case op < 6:
+					if s := pick(r); s != nil {
+						s.Join(propertyRooms[r.Intn(len(propertyRooms))])
+					}
+				case op < 8:
+					if s := pick(r); s != nil {
+						s.Leave(propertyRooms[r.Intn(len(propertyRooms))])
+					}
+				case op < 9:
+					if s := pick(r); s != nil {
+						s.Close()
+					}
+				default:
+					clientsMu.Lock()
+					if len(clients) > 0 {
+						clients[r.Intn(len(clients))].Close()
+					}
+					clientsMu.Unlock()
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	close(stop)
+	<-broadcasting
+
+	// 关闭一半客户端，其余由Close断开
+	clientsMu.Lock()
+	for i, c := range clients {
+		if i%2 == 0 {
+			c.Close()
+		}
+	}
+	clientsMu.Unlock()
+	p.Close()
+	readers.Wait()
+
+	// 会话的清理在服务端连接断开后异步完成
+	members := func() (n int) {
+		for _, room := range propertyRooms {
+			n += p.RoomLen(room)
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Len() != 0 || p.Stats().QueuedBytes != 0 || members() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("after close: %d sessions, %d queued bytes, %d room members", p.Len(), p.Stats().QueuedBytes, members())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.IsClosed() {
+		t.Error("pigeon not closed")
+	}
+}
+
+// 读取到连接断开，检查同一目标的序号递增
+func readOrdered(t *testing.T, c *websocket.Conn) {
+	last := make(map[string]int)
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		target, n, ok := strings.Cut(string(msg), ":")
+		if !ok {
+			t.Errorf("unexpected message %q", msg)
+			continue
+		}
+		seq, _ := strconv.Atoi(n)
+		if seq <= last[target] {
+			t.Errorf("target %s: seq %d after %d", target, seq, last[target])
+		}
+		last[target] = seq
+	}
+}
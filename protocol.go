@@ -0,0 +1,83 @@
+package pigeon
+
+// Protocol 按协商的子协议区分的处理方法集合，同一端点可同时服务多种协议. 未设置的处理方法使用信鸽实例的处理方法.
+type Protocol struct {
+	name                 string
+	messageHandler       handleMessageFunc
+	messageHandlerBinary handleMessageFunc
+	errorHandler         handleErrorFunc
+	eventHandlers        map[string]handleEventFunc
+}
+
+// Protocol 获取子协议对应的处理方法集合，不存在时创建并加入升级时可协商的子协议.
+func (p *Pigeon) Protocol(name string) *Protocol {
+	if pr, ok := p.protocols[name]; ok {
+		return pr
+	}
+	pr := &Protocol{name: name, eventHandlers: make(map[string]handleEventFunc)}
+	p.protocols[name] = pr
+	if _, ok := p.codecs[name]; !ok {
+		p.UpGrader.Subprotocols = append(p.UpGrader.Subprotocols, name)
+	}
+	return pr
+}
+
+// Name 获取子协议名.
+func (pr *Protocol) Name() string {
+	return pr.name
+}
+
+// HandleMessage 该协议的会话收到文本信息时的处理方法.
+func (pr *Protocol) HandleMessage(fn func(*Session, []byte)) {
+	pr.messageHandler = fn
+}
+
+// HandleMessageBinary 该协议的会话收到二进制信息时的处理方法，设置后不再按操作码路由.
+func (pr *Protocol) HandleMessageBinary(fn func(*Session, []byte)) {
+	pr.messageHandlerBinary = fn
+}
+
+// HandleError 该协议的会话出现错误时的处理方法.
+func (pr *Protocol) HandleError(fn func(*Session, error)) {
+	pr.errorHandler = fn
+}
+
+// On 注册该协议的事件处理方法，优先于信鸽实例注册的同名事件，需启用Config.EventProtocol.
+// 版本规则同Pigeon.On.
+func (pr *Protocol) On(event string, fn func(*Session, *Event) error) *EventRoute {
+	r := newEventRoute(fn)
+	pr.eventHandlers[event] = r.handle
+	return r
+}
+
+// Protocol 获取会话协商的协议，未协商到已注册的协议时返回nil.
+func (s *Session) Protocol() *Protocol {
+	return s.protocol
+}
+
+// 获取会话使用的文本信息处理方法
+func (p *Pigeon) messageHandlerOf(s *Session) handleMessageFunc {
+	if s.protocol != nil && s.protocol.messageHandler != nil {
+		return s.protocol.messageHandler
+	}
+	return p.messageHandler
+}
+
+// 获取会话使用的错误处理方法
+func (p *Pigeon) errorHandlerOf(s *Session) handleErrorFunc {
+	if s != nil && s.protocol != nil && s.protocol.errorHandler != nil {
+		return s.protocol.errorHandler
+	}
+	return p.errorHandler
+}
+
+// 获取会话使用的事件处理方法
+func (p *Pigeon) eventHandlerOf(s *Session, event string) (handleEventFunc, bool) {
+	if s.protocol != nil {
+		if fn, ok := s.protocol.eventHandlers[event]; ok {
+			return fn, true
+		}
+	}
+	fn, ok := p.eventHandlers[event]
+	return fn, ok
+}
@@ -0,0 +1,200 @@
+package pigeon
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认配额租约有效期
+const defaultQuotaLeaseTTL = 30 * time.Second
+
+// ErrQuotaExceeded 连接数超出配额.
+var ErrQuotaExceeded = errors.New("connection quota exceeded")
+
+// QuotaStore 连接配额存储，集群部署时可使用Redis等共享存储实现.
+type QuotaStore interface {
+	Acquire(key string, limit int) (bool, error) // 占用一个连接配额，已达到limit时返回false.
+	Release(key string) error                    // 释放一个连接配额.
+}
+
+// QuotaLeaseStore 按租约占用配额的存储. 每个连接占用一份带有效期的租约，信鸽在连接期间每隔有效期的三分之一续期，
+// 节点崩溃后其租约不再续期，过期后配额自动释放. 配额存储实现该接口时使用租约，否则使用QuotaStore的计数.
+// 基于Redis的实现可使用RedisQuotaAcquireScript和RedisQuotaRenewScript，释放时执行ZREM key lease.
+type QuotaLeaseStore interface {
+	QuotaStore
+	AcquireLease(key, lease string, limit int, ttl time.Duration) (bool, error) // 占用配额，未过期的租约已达到limit时返回false.
+	RenewLease(key, lease string, ttl time.Duration) error                      // 将租约的有效期延长到ttl之后，租约已过期时重新占用.
+	ReleaseLease(key, lease string) error                                       // 释放租约.
+}
+
+// RedisQuotaAcquireScript 实现QuotaLeaseStore.AcquireLease的Redis Lua脚本，以EVALSHA执行:
+// KEYS[1]为配额的键，ARGV依次为租约ID、limit和以毫秒计的ttl，返回1表示占用成功，0表示已达到上限.
+// 租约保存在有序集合中，分数为过期时间，每次占用先清除过期的租约. 时间取自Redis服务器，最后一份租约过期后键自动删除.
+const RedisQuotaAcquireScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`
+
+// RedisQuotaRenewScript 实现QuotaLeaseStore.RenewLease的Redis Lua脚本，以EVALSHA执行:
+// KEYS[1]为配额的键，ARGV依次为租约ID和以毫秒计的ttl. 连接仍在时即使租约已过期也重新写入，不检查上限.
+const RedisQuotaRenewScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`
+
+// 连接占用的配额
+type quotaHold struct {
+	key   string
+	lease string // 使用QuotaLeaseStore时的租约ID.
+	store QuotaLeaseStore
+	stop  chan struct{}
+}
+
+type quotaFunc func(*http.Request) (string, int)
+type quotaExceededFunc func(*http.Request, string)
+
+// 进程内的配额存储
+type memoryQuotaStore struct {
+	counts map[string]int
+	mu     *sync.Mutex
+}
+
+// NewMemoryQuotaStore 新建进程内的配额存储.
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{
+		counts: make(map[string]int),
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (m *memoryQuotaStore) Acquire(key string, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[key] >= limit {
+		return false, nil
+	}
+	m.counts[key]++
+	return true, nil
+}
+
+func (m *memoryQuotaStore) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[key] <= 1 {
+		delete(m.counts, key)
+		return nil
+	}
+	m.counts[key]--
+	return nil
+}
+
+// HandleQuota 从请求中提取配额key及其并发连接上限，key为空或上限不大于0时不限制.
+func (p *Pigeon) HandleQuota(fn func(*http.Request) (key string, limit int)) {
+	p.quotaHandler = fn
+}
+
+// HandleQuotaExceeded 连接因超出配额被拒绝时的处理方法.
+func (p *Pigeon) HandleQuotaExceeded(fn func(*http.Request, string)) {
+	p.quotaExceededHandler = fn
+}
+
+// UseQuotaStore 设置配额存储，默认使用进程内存储. 集群部署时应使用实现了QuotaLeaseStore的共享存储，
+// 节点崩溃后其占用的配额随租约过期释放.
+func (p *Pigeon) UseQuotaStore(store QuotaStore) {
+	if store != nil {
+		p.quotaStore = store
+	}
+}
+
+// 占用配额，超出配额时返回429，不限制时返回nil. 使用租约时在释放前定时续期
+func (p *Pigeon) acquireQuota(w http.ResponseWriter, r *http.Request) (*quotaHold, error) {
+	if p.quotaHandler == nil {
+		return nil, nil
+	}
+	key, limit := p.quotaHandler(r)
+	if key == "" || limit <= 0 {
+		return nil, nil
+	}
+	hold := &quotaHold{key: key}
+	ttl := p.quotaLeaseTTL()
+	var ok bool
+	var err error
+	if store, leased := p.quotaStore.(QuotaLeaseStore); leased {
+		hold.lease, hold.store = newID(), store
+		ok, err = store.AcquireLease(key, hold.lease, limit, ttl)
+	} else {
+		ok, err = p.quotaStore.Acquire(key, limit)
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, err
+	}
+	if !ok {
+		atomic.AddUint64(&p.counters.quotaRejected, 1)
+		if p.quotaExceededHandler != nil {
+			p.quotaExceededHandler(r, key)
+		}
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return nil, ErrQuotaExceeded
+	}
+	if hold.store != nil {
+		// 先创建计时器再返回，虚拟时钟推进时不会错过续期
+		hold.stop = make(chan struct{})
+		go p.renewQuota(hold, p.clock.NewTicker(ttl/3), ttl)
+	}
+	return hold, nil
+}
+
+// 释放配额
+func (p *Pigeon) releaseQuota(hold *quotaHold) {
+	if hold == nil {
+		return
+	}
+	var err error
+	if hold.store != nil {
+		close(hold.stop)
+		err = hold.store.ReleaseLease(hold.key, hold.lease)
+	} else {
+		err = p.quotaStore.Release(hold.key)
+	}
+	if err != nil {
+		p.reportError(nil, err)
+	}
+}
+
+// 每隔有效期的三分之一续期租约，释放时退出
+func (p *Pigeon) renewQuota(hold *quotaHold, ticker ClockTicker, ttl time.Duration) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := hold.store.RenewLease(hold.key, hold.lease, ttl); err != nil {
+				p.reportError(nil, err)
+			}
+		case <-hold.stop:
+			return
+		}
+	}
+}
+
+func (p *Pigeon) quotaLeaseTTL() time.Duration {
+	if p.Config.QuotaLeaseTTL > 0 {
+		return p.Config.QuotaLeaseTTL
+	}
+	return defaultQuotaLeaseTTL
+}
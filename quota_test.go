@@ -0,0 +1,110 @@
+package pigeon_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+	"github.com/gorilla/websocket"
+)
+
+// 按租约占用配额的测试存储，租约按服务器的虚拟时钟过期
+type leaseStore struct {
+	clock    *pigeontest.Clock
+	leases   map[string]map[string]time.Time
+	renewals int
+	mu       sync.Mutex
+}
+
+func (s *leaseStore) Acquire(string, int) (bool, error) { panic("counting quota used") }
+func (s *leaseStore) Release(string) error              { panic("counting quota used") }
+
+func (s *leaseStore) AcquireLease(key, lease string, limit int, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for id, expires := range s.leases[key] {
+		if !expires.After(now) {
+			delete(s.leases[key], id)
+		}
+	}
+	if len(s.leases[key]) >= limit {
+		return false, nil
+	}
+	if s.leases[key] == nil {
+		s.leases[key] = make(map[string]time.Time)
+	}
+	s.leases[key][lease] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *leaseStore) RenewLease(key, lease string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewals++
+	s.leases[key][lease] = s.clock.Now().Add(ttl)
+	return nil
+}
+
+func (s *leaseStore) ReleaseLease(key, lease string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases[key], lease)
+	return nil
+}
+
+func (s *leaseStore) count(key string) (leases, renewals int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.leases[key]), s.renewals
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(pigeontest.Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 连接期间定时续期租约，断开后释放，其他节点崩溃后留下的租约过期后配额可再次占用
+func TestQuotaLease(t *testing.T) {
+	conf := pigeon.DefaultConfig()
+	conf.QuotaLeaseTTL = 30 * time.Second
+	srv := pigeontest.NewServer(conf)
+	defer srv.Close()
+	store := &leaseStore{clock: srv.Clock, leases: make(map[string]map[string]time.Time)}
+	srv.Pigeon.UseQuotaStore(store)
+	srv.Pigeon.HandleQuota(func(*http.Request) (string, int) { return "user", 1 })
+
+	c := srv.Dial(t, "/")
+	if _, resp, err := websocket.DefaultDialer.Dial(srv.URL, nil); err == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second connection was not rejected: %v", err)
+	}
+
+	srv.Advance(10 * time.Second)
+	waitUntil(t, "the lease renewal", func() bool {
+		_, renewals := store.count("user")
+		return renewals > 0
+	})
+
+	c.Close()
+	waitUntil(t, "the lease release", func() bool {
+		leases, _ := store.count("user")
+		return leases == 0
+	})
+
+	// 崩溃的节点不再续期，租约过期后释放
+	store.AcquireLease("user", "crashed", 1, conf.QuotaLeaseTTL)
+	if _, _, err := websocket.DefaultDialer.Dial(srv.URL, nil); err == nil {
+		t.Fatal("connection accepted while the crashed node holds the quota")
+	}
+	srv.Advance(conf.QuotaLeaseTTL)
+	srv.Dial(t, "/")
+}
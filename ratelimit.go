@@ -0,0 +1,71 @@
+package pigeon
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶限流器.
+type TokenBucket struct {
+	rate   float64 // 每秒补充的令牌数.
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  Clock
+	mu     *sync.Mutex
+}
+
+// NewTokenBucket 新建令牌桶，初始为满.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return newTokenBucket(rate, burst, realClock{})
+}
+
+// 新建按clock补充令牌的令牌桶
+func newTokenBucket(rate float64, burst int, clock Clock) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+		clock:  clock,
+		mu:     &sync.Mutex{},
+	}
+}
+
+// 按经过的时间补充令牌
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Allow 取出一个令牌，令牌不足时返回false.
+func (b *TokenBucket) Allow() bool {
+	ok, _ := b.Reserve()
+	return ok
+}
+
+// Reserve 取出一个令牌，令牌不足时返回false及下一个令牌可用前需等待的时间.
+func (b *TokenBucket) Reserve() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(b.clock.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Duration(1<<63 - 1)
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Full 判断令牌桶是否已满，已满的令牌桶可被回收.
+func (b *TokenBucket) Full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(b.clock.Now())
+	return b.tokens >= b.burst
+}
@@ -0,0 +1,209 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 可靠投递的控制指令.
+const (
+	ControlAck  = "ack"  // 累计确认，序号小于等于seq的信息均已收到.
+	ControlNack = "nack" // 请求重发序号大于等于seq的信息.
+)
+
+const (
+	defaultAckTimeout = 5 * time.Second
+	defaultMaxUnacked = 1024
+)
+
+// ReliableFrame 可靠房间的信息在发送时的封装，以文本信息发送.
+// 投递语义为至少一次，重发的信息序号不变，客户端按序号去重即可做到实际上的恰好一次.
+type ReliableFrame struct {
+	Seq    uint64 `json:"seq"`              // 会话内递增的序号，从1开始.
+	Room   string `json:"room,omitempty"`   // 广播的房间，多个房间时为空.
+	Text   string `json:"text,omitempty"`   // 文本信息.
+	Binary []byte `json:"binary,omitempty"` // 二进制信息，以base64编码.
+	Expiry int64  `json:"expiry,omitempty"` // 过期时间，Unix毫秒，之后服务端不再重发，见SetEventTTL.
+}
+
+// 等待确认的信息
+type unackedFrame struct {
+	seq     uint64
+	data    []byte
+	sentAt  time.Time
+	expires time.Time
+}
+
+// 会话的可靠投递状态
+type reliableState struct {
+	next    uint64
+	unacked []*unackedFrame
+	resend  uint64        // nack请求重发的起始序号，为0时没有请求.
+	nacked  chan struct{} // 通知写入流程重发.
+	mu      *sync.Mutex
+}
+
+func newReliableState() *reliableState {
+	return &reliableState{nacked: make(chan struct{}, 1), mu: &sync.Mutex{}}
+}
+
+// 判断是否需要可靠投递，任一目标房间开启了可靠投递即可
+func (p *Pigeon) reliable(rooms []string) bool {
+	for _, room := range rooms {
+		if p.hub.rooms.option(room).Reliable {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取重发间隔
+func (p *Pigeon) ackTimeout() time.Duration {
+	if p.Config.AckTimeout > 0 {
+		return p.Config.AckTimeout
+	}
+	return defaultAckTimeout
+}
+
+// 为信息分配序号并封装，超过未确认上限时返回false
+func (s *Session) track(m *envelope) (*envelope, bool) {
+	frame := &ReliableFrame{}
+	if len(m.rooms) == 1 {
+		frame.Room = m.rooms[0]
+	}
+	if m.t == websocket.BinaryMessage {
+		frame.Binary = m.message
+	} else {
+		frame.Text = string(m.message)
+	}
+	if !m.expires.IsZero() {
+		frame.Expiry = m.expires.UnixMilli()
+	}
+
+	limit := s.pigeon.Config.MaxUnacked
+	if limit <= 0 {
+		limit = defaultMaxUnacked
+	}
+
+	r := s.reliable
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.unacked) >= limit {
+		return nil, false
+	}
+	r.next++
+	frame.Seq = r.next
+	data, err := json.Marshal(frame)
+	if err != nil {
+		r.next--
+		return nil, false
+	}
+	r.unacked = append(r.unacked, &unackedFrame{seq: frame.Seq, data: data, sentAt: s.pigeon.now(), expires: m.expires})
+	s.recordAlloc(len(data))
+	s.record(JournalSend, frame.Room, frame.Seq, data)
+	return &envelope{t: websocket.TextMessage, message: data, sampledAt: m.sampledAt}, true
+}
+
+// 累计确认，释放序号小于等于seq的信息
+func (s *Session) ack(seq uint64) {
+	r := s.reliable
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := 0
+	for i < len(r.unacked) && r.unacked[i].seq <= seq {
+		i++
+	}
+	r.unacked = r.unacked[i:]
+	s.record(JournalAck, "", seq, nil)
+}
+
+// 请求重发序号大于等于seq的信息
+func (s *Session) nack(seq uint64) {
+	r := s.reliable
+	r.mu.Lock()
+	if r.resend == 0 || seq < r.resend {
+		r.resend = seq
+	}
+	r.mu.Unlock()
+	select {
+	case r.nacked <- struct{}{}:
+	default:
+	}
+}
+
+// 重发被nack请求或超时未确认的信息，在写入流程中调用
+func (s *Session) retransmit() error {
+	r := s.reliable
+	timeout := s.pigeon.ackTimeout()
+	now := s.pigeon.now()
+
+	r.mu.Lock()
+	resend := r.resend
+	r.resend = 0
+	var frames [][]byte
+	r.unacked = unexpired(r.unacked, now)
+	for _, f := range r.unacked {
+		if (resend > 0 && f.seq >= resend) || now.Sub(f.sentAt) >= timeout {
+			f.sentAt = now
+			frames = append(frames, f.data)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, data := range frames {
+		if err := s.writeRaw(&envelope{t: websocket.TextMessage, message: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 导出序号和未确认的信息
+func (r *reliableState) snapshot() (uint64, []json.RawMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var frames []json.RawMessage
+	for _, f := range r.unacked {
+		frames = append(frames, f.data)
+	}
+	return r.next, frames
+}
+
+// 恢复序号和未确认的信息，恢复的信息在下一个重发周期重发
+func (r *reliableState) restore(next uint64, frames []json.RawMessage, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if next > r.next {
+		r.next = next
+	}
+	for _, data := range frames {
+		f := &unackedFrame{seq: frameSeq(data), data: data}
+		if expiry := frameExpiry(data); expiry > 0 {
+			f.expires = time.UnixMilli(expiry)
+		}
+		if f.expires.IsZero() || now.Before(f.expires) {
+			r.unacked = append(r.unacked, f)
+		}
+	}
+}
+
+// 移除已过期的信息，之后的确认仍按序号累计
+func unexpired(frames []*unackedFrame, now time.Time) []*unackedFrame {
+	kept := frames[:0]
+	for _, f := range frames {
+		if f.expires.IsZero() || now.Before(f.expires) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Unacked 获取会话中等待客户端确认的信息数量.
+func (s *Session) Unacked() int {
+	s.reliable.mu.Lock()
+	defer s.reliable.mu.Unlock()
+	return len(s.reliable.unacked)
+}
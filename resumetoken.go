@@ -0,0 +1,214 @@
+package pigeon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	resumeSecretSep       = "." // 未加密令牌中会话ID与恢复密钥的分隔符.
+	defaultResumeTokenTTL = time.Hour
+	maxResumeKeys         = 4     // 轮换时保留的密钥数量，包含当前密钥.
+	maxReplayCache        = 65536 // 重放缓存的容量.
+)
+
+// ErrInvalidResumeToken 恢复令牌无效、过期或已被使用.
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
+type resumeIdentityFunc func(*http.Request) string
+
+// 令牌内容
+type resumeClaims struct {
+	ID       string `json:"sid"`
+	Identity string `json:"idt,omitempty"`
+	Epoch    string `json:"ep"`
+	Expires  int64  `json:"exp"`
+	secret   string // 未启用密钥时令牌中的恢复密钥.
+}
+
+// 恢复令牌的密钥和重放缓存
+type resumeKeys struct {
+	aeads  []cipher.AEAD // 第一个为当前密钥.
+	replay map[string]time.Time
+	mu     *sync.Mutex
+}
+
+func newResumeKeys() *resumeKeys {
+	return &resumeKeys{replay: make(map[string]time.Time), mu: &sync.Mutex{}}
+}
+
+// UseResumeKeys 启用加密的恢复令牌，keys为16、24或32字节的AES密钥，第一个用于签发，其余仅用于验证.
+// 未启用时令牌由会话ID和服务端随机生成的恢复密钥组成，不绑定身份和有效期.
+func (p *Pigeon) UseResumeKeys(keys ...[]byte) error {
+	aeads := make([]cipher.AEAD, 0, len(keys))
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		aeads = append(aeads, aead)
+	}
+	p.resumeKeys.mu.Lock()
+	p.resumeKeys.aeads = aeads
+	p.resumeKeys.mu.Unlock()
+	return nil
+}
+
+// RotateResumeKey 使用新密钥签发令牌，之前的密钥仍可验证已签发的令牌，最多保留4个密钥.
+func (p *Pigeon) RotateResumeKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k := p.resumeKeys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.aeads = append([]cipher.AEAD{aead}, k.aeads...)
+	if len(k.aeads) > maxResumeKeys {
+		k.aeads = k.aeads[:maxResumeKeys]
+	}
+	return nil
+}
+
+// HandleResumeIdentity 获取请求身份的方法，令牌绑定签发时的身份，恢复时身份不一致则拒绝.
+func (p *Pigeon) HandleResumeIdentity(fn func(*http.Request) string) {
+	p.resumeIdentity = fn
+}
+
+// Epoch 获取实例的纪元，每个实例启动时随机生成，令牌只能恢复由同一纪元导出的会话.
+func (p *Pigeon) Epoch() string {
+	return p.epoch
+}
+
+// ResumeToken 为会话签发恢复令牌，客户端重新连接时通过ResumeQueryParam携带，只能使用一次.
+// 启用UseResumeKeys时令牌经AEAD加密，绑定会话ID、身份和实例纪元；未启用时为会话ID和恢复密钥.
+func (s *Session) ResumeToken() (string, error) {
+	p := s.pigeon
+	if !p.resumeKeys.enabled() {
+		data, _ := json.Marshal(s.secret)
+		s.record(JournalSecret, "", 0, data)
+		return s.id + resumeSecretSep + s.secret, nil
+	}
+	ttl := p.Config.ResumeTokenTTL
+	if ttl <= 0 {
+		ttl = defaultResumeTokenTTL
+	}
+	claims := &resumeClaims{
+		ID:      s.ID(),
+		Epoch:   p.epoch,
+		Expires: p.now().Add(ttl).Unix(),
+	}
+	if p.resumeIdentity != nil {
+		claims.Identity = p.resumeIdentity(s.Request)
+	}
+	plain, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	p.resumeKeys.mu.Lock()
+	if len(p.resumeKeys.aeads) == 0 {
+		p.resumeKeys.mu.Unlock()
+		return "", errors.New("resume keys are not configured")
+	}
+	aead := p.resumeKeys.aeads[0]
+	p.resumeKeys.mu.Unlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// 判断是否启用了恢复令牌
+func (k *resumeKeys) enabled() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.aeads) > 0
+}
+
+// 解密并校验令牌是否在now时有效，通过后记入重放缓存
+func (k *resumeKeys) open(token string, now time.Time) (*resumeClaims, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, aead := range k.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			continue
+		}
+		claims := &resumeClaims{}
+		if err := json.Unmarshal(plain, claims); err != nil {
+			return nil, ErrInvalidResumeToken
+		}
+		expires := time.Unix(claims.Expires, 0)
+		if now.After(expires) || !k.remember(string(nonce), expires, now) {
+			return nil, ErrInvalidResumeToken
+		}
+		return claims, nil
+	}
+	return nil, ErrInvalidResumeToken
+}
+
+// 记录已使用的令牌，已使用过或缓存已满时返回false
+func (k *resumeKeys) remember(nonce string, expires, now time.Time) bool {
+	if _, ok := k.replay[nonce]; ok {
+		return false
+	}
+	if len(k.replay) >= maxReplayCache {
+		for n, exp := range k.replay {
+			if now.After(exp) {
+				delete(k.replay, n)
+			}
+		}
+		if len(k.replay) >= maxReplayCache {
+			return false
+		}
+	}
+	k.replay[nonce] = expires
+	return true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 解析请求中的恢复令牌，只有会话ID时返回ErrInvalidResumeToken
+func (p *Pigeon) resumeID(r *http.Request, param string) (*resumeClaims, error) {
+	if !p.resumeKeys.enabled() {
+		i := strings.LastIndex(param, resumeSecretSep)
+		if i <= 0 || i == len(param)-1 {
+			return nil, ErrInvalidResumeToken
+		}
+		return &resumeClaims{ID: param[:i], secret: param[i+1:]}, nil
+	}
+	claims, err := p.resumeKeys.open(param, p.now())
+	if err != nil {
+		return nil, err
+	}
+	if p.resumeIdentity != nil && p.resumeIdentity(r) != claims.Identity {
+		return nil, ErrInvalidResumeToken
+	}
+	return claims, nil
+}
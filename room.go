@@ -0,0 +1,427 @@
+package pigeon
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// 加入房间的结果
+const (
+	roomJoined = iota
+	roomWaiting
+	roomFull
+)
+
+// ErrRoomFull 房间已满.
+var ErrRoomFull = errors.New("room is full")
+
+// ErrRoomWaiting 房间已满，会话已进入等待队列.
+var ErrRoomWaiting = errors.New("room is full, session is waiting")
+
+// RoomOptions 房间设置.
+type RoomOptions struct {
+	MaxMembers int         // 最大成员数量，为0时不限制.
+	WaitList   bool        // 满员时进入先进先出的等待队列，有空位时自动加入.
+	Codec      Codec       // 房间的编解码器，为nil时使用JSONCodec，见BroadcastRoomValue和DecodeRoom.
+	Transforms []Transform // 编码后依次执行的变换，如压缩，解码时逆序还原.
+	Reliable   bool        // 可靠投递，信息以ReliableFrame发送并保留到客户端确认，需启用控制协议.
+	Mode       RoomMode    // 房间模式，限制会话以自身名义发布，见Session.Publish.
+	MaxPending int         // 审核模式下审核队列的容量，默认1024.
+}
+
+type handleRoomFullFunc func(*Session, string, bool)
+type handleRoomFunc func(*Session, string)
+
+// 房间表
+type roomTable struct {
+	rooms     map[string]map[*Session]struct{}
+	options   map[string]RoomOptions
+	waiting   map[string][]*Session
+	permanent map[string]struct{} // 声明的常驻房间，没有成员时也不删除
+	mu        *profiledMutex
+}
+
+func newRoomTable(stat *lockStat) *roomTable {
+	return &roomTable{
+		rooms:     make(map[string]map[*Session]struct{}),
+		options:   make(map[string]RoomOptions),
+		waiting:   make(map[string][]*Session),
+		permanent: make(map[string]struct{}),
+		mu:        newProfiledMutex(stat),
+	}
+}
+
+// 加入房间，满员时按房间设置进入等待队列或拒绝
+func (t *roomTable) add(room string, s *Session) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	members, ok := t.rooms[room]
+	if !ok {
+		members = make(map[*Session]struct{})
+		t.rooms[room] = members
+	}
+	if _, ok := members[s]; ok {
+		return roomJoined
+	}
+	opts := t.options[room]
+	if opts.MaxMembers > 0 && len(members) >= opts.MaxMembers {
+		if len(members) == 0 && !t.retained(room) {
+			delete(t.rooms, room)
+		}
+		if !opts.WaitList {
+			return roomFull
+		}
+		for _, w := range t.waiting[room] {
+			if w == s {
+				return roomWaiting
+			}
+		}
+		t.waiting[room] = append(t.waiting[room], s)
+		return roomWaiting
+	}
+	members[s] = struct{}{}
+	return roomJoined
+}
+
+// 离开房间或等待队列，房间为空且不是常驻房间时将其删除. 腾出空位时返回从等待队列中加入房间的会话
+func (t *roomTable) remove(room string, s *Session) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	members, ok := t.rooms[room]
+	if !ok {
+		t.unwait(room, s)
+		return nil
+	}
+	if _, ok := members[s]; !ok {
+		t.unwait(room, s)
+		return nil
+	}
+	delete(members, s)
+
+	var promoted *Session
+	if queue := t.waiting[room]; len(queue) > 0 {
+		promoted = queue[0]
+		if len(queue) == 1 {
+			delete(t.waiting, room)
+		} else {
+			t.waiting[room] = queue[1:]
+		}
+		members[promoted] = struct{}{}
+	}
+	if len(members) == 0 && !t.retained(room) {
+		delete(t.rooms, room)
+	}
+	return promoted
+}
+
+// 从等待队列中移除
+func (t *roomTable) unwait(room string, s *Session) {
+	queue := t.waiting[room]
+	for i, w := range queue {
+		if w == s {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(t.waiting, room)
+	} else {
+		t.waiting[room] = queue
+	}
+}
+
+// 设置房间
+func (t *roomTable) setOptions(room string, opts RoomOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.options[room] = opts
+}
+
+// 获取房间设置
+func (t *roomTable) option(room string) RoomOptions {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.options[room]
+}
+
+// 获取多个房间成员的并集快照，同时位于多个房间的会话只出现一次
+func (t *roomTable) members(rooms ...string) []*Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(rooms) == 1 {
+		members := t.rooms[rooms[0]]
+		list := make([]*Session, 0, len(members))
+		for s := range members {
+			list = append(list, s)
+		}
+		return list
+	}
+	seen := make(map[*Session]struct{})
+	var list []*Session
+	for _, room := range rooms {
+		for s := range t.rooms[room] {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// 获取房间成员数量
+func (t *roomTable) len(room string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.rooms[room])
+}
+
+// 获取房间数量
+func (t *roomTable) count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.rooms)
+}
+
+// SetRoomOptions 设置房间，已在房间中的会话不受新的成员上限影响.
+func (p *Pigeon) SetRoomOptions(room string, opts RoomOptions) {
+	p.hub.rooms.setOptions(room, opts)
+}
+
+// HandleRoomFull 加入已满的房间时的处理方法，waiting表示会话已进入等待队列.
+func (p *Pigeon) HandleRoomFull(fn func(s *Session, room string, waiting bool)) {
+	p.roomFullHandler = fn
+}
+
+// HandleRoomPromoted 会话从等待队列中加入房间时的处理方法.
+func (p *Pigeon) HandleRoomPromoted(fn func(*Session, string)) {
+	p.roomPromotedHandler = fn
+}
+
+// Join 加入房间. 房间已满时返回ErrRoomFull，或在开启等待队列时返回ErrRoomWaiting.
+func (s *Session) Join(room string) error {
+	s.mu.Lock()
+	if !s.open {
+		s.mu.Unlock()
+		return errors.New("session is closed")
+	}
+	if s.state == StateClosing {
+		s.mu.Unlock()
+		return errors.New("session is closing")
+	}
+	if _, ok := s.rooms[room]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]struct{})
+	}
+	s.pending[room] = struct{}{}
+	s.mu.Unlock()
+
+	result := s.pigeon.hub.rooms.add(room, s)
+
+	s.mu.Lock()
+	if _, ok := s.rooms[room]; ok {
+		// 已从等待队列中加入
+		s.mu.Unlock()
+		return nil
+	}
+	if result == roomJoined {
+		if !s.open {
+			s.mu.Unlock()
+			s.pigeon.leaveRoom(room, s)
+			return errors.New("session is closed")
+		}
+		delete(s.pending, room)
+		if s.rooms == nil {
+			s.rooms = make(map[string]struct{})
+		}
+		s.rooms[room] = struct{}{}
+		s.mu.Unlock()
+		s.record(JournalJoin, room, 0, nil)
+		return nil
+	}
+	if result == roomFull {
+		delete(s.pending, room)
+	}
+	s.mu.Unlock()
+
+	if s.pigeon.roomFullHandler != nil {
+		s.pigeon.roomFullHandler(s, room, result == roomWaiting)
+	}
+	if result == roomWaiting {
+		return ErrRoomWaiting
+	}
+	return ErrRoomFull
+}
+
+// Leave 离开房间或其等待队列.
+func (s *Session) Leave(room string) error {
+	s.mu.Lock()
+	_, joined := s.rooms[room]
+	_, waiting := s.pending[room]
+	if !joined && !waiting {
+		s.mu.Unlock()
+		return errors.New("session is not in room " + room)
+	}
+	delete(s.rooms, room)
+	delete(s.pending, room)
+	s.mu.Unlock()
+
+	s.pigeon.leaveRoom(room, s)
+	if joined {
+		s.record(JournalLeave, room, 0, nil)
+	}
+	return nil
+}
+
+// 从房间表中移除会话，并将等待队列中补位的会话加入房间
+func (p *Pigeon) leaveRoom(room string, s *Session) {
+	promoted := p.hub.rooms.remove(room, s)
+	for promoted != nil {
+		if promoted.promote(room) {
+			promoted.record(JournalJoin, room, 0, nil)
+			if p.roomPromotedHandler != nil {
+				p.roomPromotedHandler(promoted, room)
+			}
+			return
+		}
+		promoted = p.hub.rooms.remove(room, promoted)
+	}
+}
+
+// 从等待状态转为已加入，会话已关闭或已取消等待时返回false
+func (s *Session) promote(room string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[room]; !ok || !s.open {
+		return false
+	}
+	delete(s.pending, room)
+	if s.rooms == nil {
+		s.rooms = make(map[string]struct{})
+	}
+	s.rooms[room] = struct{}{}
+	return true
+}
+
+// InRoom 判断会话是否在某个房间中.
+func (s *Session) InRoom(room string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.rooms[room]
+	return ok
+}
+
+// Rooms 获取会话所在的全部房间.
+func (s *Session) Rooms() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// 离开全部房间及等待队列并取消主题订阅，会话关闭后调用
+func (s *Session) leaveAll() {
+	s.mu.Lock()
+	rooms, pending := s.rooms, s.pending
+	s.rooms, s.pending = nil, nil
+	s.mu.Unlock()
+	s.record(JournalClose, "", 0, nil)
+	s.unsubscribeAll()
+
+	for room := range rooms {
+		s.pigeon.leaveRoom(room, s)
+	}
+	for room := range pending {
+		s.pigeon.leaveRoom(room, s)
+	}
+}
+
+// BroadcastRoom 向房间内的所有会话广播消息.
+func (p *Pigeon) BroadcastRoom(room string, msg []byte) error {
+	return p.BroadcastRoomNoCopy(room, copyBytes(msg))
+}
+
+// BroadcastRoomNoCopy 与BroadcastRoom功能相同，但不复制消息.
+func (p *Pigeon) BroadcastRoomNoCopy(room string, msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: msg, rooms: []string{room}})
+}
+
+// BroadcastRoomBinary 向房间内的所有会话广播二进制消息.
+func (p *Pigeon) BroadcastRoomBinary(room string, msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), rooms: []string{room}})
+}
+
+// BroadcastRooms 向多个房间广播消息，同时位于多个房间的会话只收到一次.
+func (p *Pigeon) BroadcastRooms(rooms []string, msg []byte) error {
+	return p.BroadcastRoomsFilter(rooms, msg, nil)
+}
+
+// BroadcastRoomsFilter 向多个房间中符合过滤器结果的会话广播消息，每个会话只收到一次.
+func (p *Pigeon) BroadcastRoomsFilter(rooms []string, msg []byte, fn func(*Session) bool) error {
+	if len(rooms) == 0 {
+		return errors.New("no rooms to broadcast")
+	}
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), rooms: rooms, filter: fn})
+}
+
+// BroadcastRoomOthers 服务端向房间内除sender之外的会话广播消息，sender为nil时发给全部成员.
+// 与其他服务端广播一样不受房间模式限制，以客户端名义发布并按房间模式执行时使用Session.Publish.
+func (p *Pigeon) BroadcastRoomOthers(room string, msg []byte, sender *Session) error {
+	return p.broadcastRoomOthers(room, websocket.TextMessage, msg, sender)
+}
+
+// BroadcastRoomBinaryOthers 服务端向房间内除sender之外的会话广播二进制消息，见BroadcastRoomOthers.
+func (p *Pigeon) BroadcastRoomBinaryOthers(room string, msg []byte, sender *Session) error {
+	return p.broadcastRoomOthers(room, websocket.BinaryMessage, msg, sender)
+}
+
+func (p *Pigeon) broadcastRoomOthers(room string, t int, msg []byte, sender *Session) error {
+	m := &envelope{t: t, message: copyBytes(msg), rooms: []string{room}}
+	if sender != nil {
+		m.exclude = sender.id
+	}
+	return p.dispatch(m)
+}
+
+// BroadcastToMyRooms 向会话所在的全部房间广播消息，同时位于多个房间的会话只收到一次，excludeSelf为true时不发给自己.
+// 按各房间的模式执行，只读房间被跳过并返回ErrRoomReadOnly，审核房间的信息进入各自的审核队列.
+func (s *Session) BroadcastToMyRooms(msg []byte, excludeSelf bool) error {
+	return s.broadcastToMyRooms(websocket.TextMessage, msg, excludeSelf)
+}
+
+// BroadcastBinaryToMyRooms 向会话所在的全部房间广播二进制消息，excludeSelf为true时不发给自己.
+func (s *Session) BroadcastBinaryToMyRooms(msg []byte, excludeSelf bool) error {
+	return s.broadcastToMyRooms(websocket.BinaryMessage, msg, excludeSelf)
+}
+
+func (s *Session) broadcastToMyRooms(t int, msg []byte, excludeSelf bool) error {
+	rooms := s.Rooms()
+	if len(rooms) == 0 {
+		return errors.New("session is not in any room")
+	}
+	exclude := ""
+	if excludeSelf {
+		exclude = s.id
+	}
+	return s.pigeon.publish(s, rooms, t, copyBytes(msg), exclude)
+}
+
+// BroadcastRoomsBinary 向多个房间广播二进制消息，同时位于多个房间的会话只收到一次.
+func (p *Pigeon) BroadcastRoomsBinary(rooms []string, msg []byte) error {
+	if len(rooms) == 0 {
+		return errors.New("no rooms to broadcast")
+	}
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), rooms: rooms})
+}
+
+// RoomLen 获取房间内的会话数量.
+func (p *Pigeon) RoomLen(room string) int {
+	return p.hub.rooms.len(room)
+}
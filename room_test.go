@@ -0,0 +1,40 @@
+package pigeon_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+func TestBroadcastRoomOthers(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	var sender *pigeon.Session
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		s.Join("news")
+		if sender == nil {
+			sender = s
+		}
+	})
+	srv.Pigeon.SetRoomMode("news", pigeon.RoomReadOnly)
+	a := srv.Dial(t, "/")
+	b := srv.Dial(t, "/")
+
+	// 服务端广播不受只读模式限制，sender为nil时不会panic
+	if err := srv.Pigeon.BroadcastRoomOthers("news", []byte("others"), sender); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Pigeon.BroadcastRoomBinaryOthers("news", []byte("all"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// 客户端发布仍按房间模式执行
+	if err := sender.Publish("news", []byte("publish")); err != pigeon.ErrRoomReadOnly {
+		t.Fatalf("Publish err = %v, want ErrRoomReadOnly", err)
+	}
+
+	got := received(t, srv, a, b)
+	want := [][]string{{"all"}, {"all", "others"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+}
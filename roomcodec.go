@@ -0,0 +1,43 @@
+package pigeon
+
+// Transform 编码后的信息变换，如压缩、加密.
+type Transform interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// 获取房间的编解码器
+func (o RoomOptions) codec() Codec {
+	if o.Codec != nil {
+		return o.Codec
+	}
+	return JSONCodec
+}
+
+// BroadcastRoomValue 使用房间的编解码器编码并执行变换后向房间广播.
+func (p *Pigeon) BroadcastRoomValue(room string, v interface{}) error {
+	opts := p.hub.rooms.option(room)
+	c := opts.codec()
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	for _, t := range opts.Transforms {
+		if data, err = t.Encode(data); err != nil {
+			return err
+		}
+	}
+	return p.dispatch(&envelope{t: c.MessageType(), message: data, rooms: []string{room}})
+}
+
+// DecodeRoom 按房间的变换逆序还原后，使用房间的编解码器解码发往该房间的信息.
+func (p *Pigeon) DecodeRoom(room string, data []byte, v interface{}) error {
+	opts := p.hub.rooms.option(room)
+	var err error
+	for i := len(opts.Transforms) - 1; i >= 0; i-- {
+		if data, err = opts.Transforms[i].Decode(data); err != nil {
+			return err
+		}
+	}
+	return opts.codec().Unmarshal(data, v)
+}
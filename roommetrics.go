@@ -0,0 +1,281 @@
+package pigeon
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultRoomMetricsLimit = 1000
+
+// RoomStats 房间的运行统计，计数均为累计值，速率由采集方按时间差计算.
+// 向多个房间的广播只计入各房间的Published，投递和丢弃只统计单个房间的广播.
+type RoomStats struct {
+	Room      string `json:"room"`      // 房间名，开启Config.RoomMetricsHash时为哈希.
+	Members   int    `json:"members"`   // 成员数量.
+	Published uint64 `json:"published"` // 向房间提交的广播数.
+	Delivered uint64 `json:"delivered"` // 投递到成员缓冲区的信息数.
+	Dropped   uint64 `json:"dropped"`   // 因成员缓冲区已满等原因丢弃的信息数.
+}
+
+// 房间计数器，均为原子操作
+type roomCounters struct {
+	published uint64
+	delivered uint64
+	dropped   uint64
+}
+
+// 房间统计表
+type roomMetrics struct {
+	enabled  bool
+	hash     bool
+	limit    int
+	rooms    map[string]*roomCounters
+	overflow *roomCounters
+	mu       *sync.RWMutex
+}
+
+func newRoomMetrics(conf *Config) *roomMetrics {
+	limit := conf.RoomMetricsLimit
+	if limit <= 0 {
+		limit = defaultRoomMetricsLimit
+	}
+	return &roomMetrics{
+		enabled:  conf.RoomMetrics,
+		hash:     conf.RoomMetricsHash,
+		limit:    limit,
+		rooms:    make(map[string]*roomCounters),
+		overflow: &roomCounters{},
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// 获取房间的计数器，超出数量上限的房间共用LabelOverflow的计数器
+func (t *roomMetrics) lookup(room string) *roomCounters {
+	t.mu.RLock()
+	c, ok := t.rooms[room]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.rooms[room]; ok {
+		return c
+	}
+	if len(t.rooms) >= t.limit {
+		return t.overflow
+	}
+	c = &roomCounters{}
+	t.rooms[room] = c
+	return c
+}
+
+// 记录广播，返回单个房间广播的计数器用于统计投递
+func (t *roomMetrics) publish(rooms []string) *roomCounters {
+	if !t.enabled || len(rooms) == 0 {
+		return nil
+	}
+	var c *roomCounters
+	for _, room := range rooms {
+		c = t.lookup(room)
+		atomic.AddUint64(&c.published, 1)
+	}
+	if len(rooms) > 1 {
+		return nil
+	}
+	return c
+}
+
+// 统计中使用的房间名
+func (t *roomMetrics) name(room string) string {
+	if !t.hash {
+		return room
+	}
+	h := fnv.New64a()
+	h.Write([]byte(room))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RoomStats 获取各房间的运行统计，需开启Config.RoomMetrics.
+func (p *Pigeon) RoomStats() []RoomStats {
+	t := p.roomMetrics
+	t.mu.RLock()
+	stats := make([]RoomStats, 0, len(t.rooms)+1)
+	for room, c := range t.rooms {
+		stats = append(stats, RoomStats{
+			Room:      t.name(room),
+			Members:   p.hub.rooms.len(room),
+			Published: atomic.LoadUint64(&c.published),
+			Delivered: atomic.LoadUint64(&c.delivered),
+			Dropped:   atomic.LoadUint64(&c.dropped),
+		})
+	}
+	t.mu.RUnlock()
+
+	if published := atomic.LoadUint64(&t.overflow.published); published > 0 {
+		stats = append(stats, RoomStats{
+			Room:      LabelOverflow,
+			Published: published,
+			Delivered: atomic.LoadUint64(&t.overflow.delivered),
+			Dropped:   atomic.LoadUint64(&t.overflow.dropped),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Room < stats[j].Room })
+	return stats
+}
+
+// MetricsHandler 以Prometheus文本格式输出运行统计及各房间统计.
+func (p *Pigeon) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		st := p.Stats()
+		b := &strings.Builder{}
+		writeMetric(b, "pigeon_sessions", "gauge", "Number of sessions.", float64(st.Sessions))
+		if labeled := p.labeledSessions(); len(labeled) > 0 {
+			names := make([]string, len(p.Config.SessionLabels))
+			for i, name := range p.Config.SessionLabels {
+				names[i] = metricLabelName(name)
+			}
+			fmt.Fprintf(b, "# HELP pigeon_labeled_sessions Sessions per combination of session labels.\n# TYPE pigeon_labeled_sessions gauge\n")
+			for _, c := range labeled {
+				pairs := make([]string, len(names))
+				for i, name := range names {
+					pairs[i] = name + "=\"" + labelEscaper.Replace(c.values[i]) + "\""
+				}
+				fmt.Fprintf(b, "pigeon_labeled_sessions{%s} %d\n", strings.Join(pairs, ","), c.sessions)
+			}
+		}
+		writeMetric(b, "pigeon_rooms", "gauge", "Number of rooms.", float64(st.Rooms))
+		writeMetric(b, "pigeon_messages_in_total", "counter", "Messages received.", float64(st.MessagesIn))
+		writeMetric(b, "pigeon_messages_out_total", "counter", "Messages sent.", float64(st.MessagesOut))
+		writeMetric(b, "pigeon_broadcasts_total", "counter", "Broadcasts submitted.", float64(st.Broadcasts))
+		writeMetric(b, "pigeon_dropped_total", "counter", "Messages dropped because a session buffer was full.", float64(st.Dropped))
+		writeMetric(b, "pigeon_errors_total", "counter", "Errors reported.", float64(st.Errors))
+		writeMetric(b, "pigeon_queued_bytes", "gauge", "Bytes queued for writing across all sessions.", float64(st.QueuedBytes))
+		writeMetric(b, "pigeon_budget_dropped_total", "counter", "Messages refused because the memory budget was exceeded.", float64(st.BudgetDropped))
+		writeMetric(b, "pigeon_budget_evicted_total", "counter", "Queued messages evicted because the memory budget was exceeded.", float64(st.BudgetEvicted))
+		writeMetric(b, "pigeon_budget_closed_total", "counter", "Sessions closed to bring queued bytes back under the memory budget.", float64(st.BudgetClosed))
+		writeMetric(b, "pigeon_tap_dropped_total", "counter", "Room broadcasts dropped because a tap fell behind.", float64(st.TapDropped))
+		writeMetric(b, "pigeon_write_retries_total", "counter", "Writes retried after a transient network error.", float64(st.WriteRetries))
+		writeMetric(b, "pigeon_keepalive_skipped_total", "counter", "Connections whose TCP keepalive could not be tuned because no TCP connection was found.", float64(st.KeepAliveSkipped))
+		writeMetric(b, "pigeon_rate_limited_total", "counter", "Messages rejected by the identity or room limiter.", float64(st.RateLimited))
+		writeMetric(b, "pigeon_unexpected_binary_total", "counter", "Binary messages received with no binary handler set.", float64(st.UnexpectedBinary))
+		if p.sendLimiter != nil {
+			writeMetric(b, "pigeon_send_throttled_total", "counter", "Writes delayed by the global send budget.", float64(st.SendThrottled))
+			writeMetric(b, "pigeon_send_throttled_seconds_total", "counter", "Time writes waited for the global send budget.", st.SendThrottledTime.Seconds())
+		}
+
+		if p.Config.DeliverySampleRate > 0 {
+			h := p.delivery.histogram()
+			fmt.Fprintf(b, "# HELP pigeon_delivery_latency_seconds Latency of sampled broadcasts from submission to the connection write.\n# TYPE pigeon_delivery_latency_seconds histogram\n")
+			for i, le := range deliveryBuckets {
+				fmt.Fprintf(b, "pigeon_delivery_latency_seconds_bucket{le=\"%g\"} %d\n", le, h.buckets[i])
+			}
+			fmt.Fprintf(b, "pigeon_delivery_latency_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+			fmt.Fprintf(b, "pigeon_delivery_latency_seconds_sum %g\npigeon_delivery_latency_seconds_count %d\n", h.sum.Seconds(), h.count)
+		}
+
+		if len(st.TransportHealth) > 0 {
+			fmt.Fprintf(b, "# HELP pigeon_transport_health_sessions Sessions per transport health status.\n# TYPE pigeon_transport_health_sessions gauge\n")
+			for status, n := range st.TransportHealth {
+				fmt.Fprintf(b, "pigeon_transport_health_sessions{status=\"%s\"} %d\n", status, n)
+			}
+		}
+
+		if len(st.UpgradeErrors) > 0 {
+			fmt.Fprintf(b, "# HELP pigeon_upgrade_errors_total Failed websocket upgrades per failure class.\n# TYPE pigeon_upgrade_errors_total counter\n")
+			for _, class := range upgradeErrorClasses {
+				if n, ok := st.UpgradeErrors[class]; ok {
+					fmt.Fprintf(b, "pigeon_upgrade_errors_total{class=\"%s\"} %d\n", class, n)
+				}
+			}
+		}
+
+		if locks := p.LockProfile(); locks != nil {
+			lockFamilies := []struct {
+				name, help string
+				value      func(LockStats) float64
+			}{
+				{"pigeon_lock_acquired_total", "Lock acquisitions per lock.", func(s LockStats) float64 { return float64(s.Acquired) }},
+				{"pigeon_lock_contended_total", "Lock acquisitions that had to wait per lock.", func(s LockStats) float64 { return float64(s.Contended) }},
+				{"pigeon_lock_wait_seconds_total", "Time spent waiting for each lock.", func(s LockStats) float64 { return s.Wait.Seconds() }},
+			}
+			for _, f := range lockFamilies {
+				fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", f.name, f.help, f.name)
+				for _, s := range locks {
+					fmt.Fprintf(b, "%s{lock=\"%s\"} %g\n", f.name, s.Lock, f.value(s))
+				}
+			}
+		}
+
+		if hub := p.HubStats(); hub.Ops != nil {
+			writeMetric(b, "pigeon_hub_iterations_total", "counter", "Operations handled by the hub loop.", float64(hub.Iterations))
+			writeMetric(b, "pigeon_hub_iterations_per_second", "gauge", "Hub loop iterations in the last full second.", hub.IterationsPerSec)
+			writeMetric(b, "pigeon_hub_utilization", "gauge", "Fraction of the last full second the hub loop spent handling operations.", hub.Utilization)
+			opFamilies := []struct {
+				name, kind, help string
+				value            func(HubOpStats) float64
+			}{
+				{"pigeon_hub_ops_total", "counter", "Operations handled per kind.", func(s HubOpStats) float64 { return float64(s.Count) }},
+				{"pigeon_hub_busy_seconds_total", "counter", "Time spent handling operations per kind.", func(s HubOpStats) float64 { return s.Busy.Seconds() }},
+				{"pigeon_hub_wait_seconds_total", "counter", "Time operations waited for the hub loop per kind.", func(s HubOpStats) float64 { return s.Wait.Seconds() }},
+			}
+			for _, f := range opFamilies {
+				fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+				for _, s := range hub.Ops {
+					fmt.Fprintf(b, "%s{op=\"%s\"} %g\n", f.name, s.Op, f.value(s))
+				}
+			}
+		}
+
+		rooms := p.RoomStats()
+		families := []struct {
+			name, kind, help string
+			value            func(RoomStats) float64
+		}{
+			{"pigeon_room_members", "gauge", "Members per room.", func(s RoomStats) float64 { return float64(s.Members) }},
+			{"pigeon_room_published_total", "counter", "Broadcasts submitted per room.", func(s RoomStats) float64 { return float64(s.Published) }},
+			{"pigeon_room_delivered_total", "counter", "Messages delivered per room.", func(s RoomStats) float64 { return float64(s.Delivered) }},
+			{"pigeon_room_dropped_total", "counter", "Messages dropped per room.", func(s RoomStats) float64 { return float64(s.Dropped) }},
+		}
+		for _, f := range families {
+			if len(rooms) == 0 {
+				break
+			}
+			fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+			for _, s := range rooms {
+				fmt.Fprintf(b, "%s{room=\"%s\"} %g\n", f.name, labelEscaper.Replace(s.Room), f.value(s))
+			}
+		}
+		w.Write([]byte(b.String()))
+	})
+}
+
+// Prometheus标签值的转义
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 将会话标签名转换为合法的Prometheus标签名，非法字符替换为下划线
+func metricLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || len(b) > 1 && b[0] == '_' && b[1] == '_' {
+		return "label_" + string(b)
+	}
+	return string(b)
+}
+
+// 写入一个无标签的指标
+func writeMetric(b *strings.Builder, name, kind, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,249 @@
+package pigeon
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RoomMode 房间模式，限制会话以自身名义向房间发布信息，服务端的广播不受限制.
+type RoomMode int
+
+// 房间模式.
+const (
+	RoomOpen      RoomMode = iota // 成员可自由发布.
+	RoomReadOnly                  // 只读，仅主持人可发布，用于公告.
+	RoomModerated                 // 审核，发布的信息进入审核队列，批准后才广播.
+)
+
+var roomModeNames = map[RoomMode]string{
+	RoomOpen:      "open",
+	RoomReadOnly:  "read_only",
+	RoomModerated: "moderated",
+}
+
+func (m RoomMode) String() string {
+	if name, ok := roomModeNames[m]; ok {
+		return name
+	}
+	return "mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// ParseRoomMode 按名称解析房间模式.
+func ParseRoomMode(name string) (RoomMode, bool) {
+	for m, n := range roomModeNames {
+		if n == name {
+			return m, true
+		}
+	}
+	return RoomOpen, false
+}
+
+// 默认每个房间审核队列的容量
+const defaultMaxPending = 1024
+
+var (
+	// ErrRoomReadOnly 房间为只读模式.
+	ErrRoomReadOnly = errors.New("room is read-only")
+	// ErrModerationPending 信息已进入审核队列，批准后广播.
+	ErrModerationPending = errors.New("message is pending moderation")
+	// ErrModerationRejected 信息被审核处理方法拒绝.
+	ErrModerationRejected = errors.New("message was rejected by moderation")
+	// ErrModerationQueueFull 审核队列已满.
+	ErrModerationQueueFull = errors.New("moderation queue is full")
+	// ErrModerationNotFound 审核队列中没有该信息.
+	ErrModerationNotFound = errors.New("moderation item not found")
+)
+
+// ModerationDecision 审核决定.
+type ModerationDecision int
+
+// 审核决定.
+const (
+	ModerationHold    ModerationDecision = iota // 留在审核队列中，等待ApproveMessage或RejectMessage.
+	ModerationApprove                           // 立即广播.
+	ModerationReject                            // 丢弃.
+)
+
+// ModerationItem 审核队列中的信息.
+type ModerationItem struct {
+	ID      string    `json:"id"`
+	Room    string    `json:"room"`
+	Session string    `json:"session"`          // 发布者的会话ID.
+	Text    string    `json:"text,omitempty"`   // 文本信息.
+	Binary  []byte    `json:"binary,omitempty"` // 二进制信息.
+	Queued  time.Time `json:"queued"`
+	t       int
+	exclude string // 批准后广播时排除的会话ID.
+}
+
+type roomModeratorFunc func(*Session, string) bool
+type handleModerationFunc func(*Session, *ModerationItem) ModerationDecision
+
+// 各房间的审核队列
+type moderationQueue struct {
+	items map[string][]*ModerationItem
+	mu    *sync.Mutex
+}
+
+func newModerationQueue() *moderationQueue {
+	return &moderationQueue{items: make(map[string][]*ModerationItem), mu: &sync.Mutex{}}
+}
+
+// SetRoomMode 设置房间模式，其他设置不变. 从审核模式切换后，队列中的信息仍可批准或拒绝.
+func (p *Pigeon) SetRoomMode(room string, mode RoomMode) {
+	t := p.hub.rooms
+	t.mu.Lock()
+	opts := t.options[room]
+	opts.Mode = mode
+	t.options[room] = opts
+	t.mu.Unlock()
+}
+
+// RoomMode 获取房间模式.
+func (p *Pigeon) RoomMode(room string) RoomMode {
+	return p.hub.rooms.option(room).Mode
+}
+
+// HandleRoomModerator 判断会话是否为房间的主持人，主持人的发布不受房间模式限制.
+func (p *Pigeon) HandleRoomModerator(fn func(s *Session, room string) bool) {
+	p.roomModerator = fn
+}
+
+// HandleModeration 审核模式的房间收到发布时的处理方法，返回审核决定，未设置时信息留在审核队列中.
+func (p *Pigeon) HandleModeration(fn func(s *Session, item *ModerationItem) ModerationDecision) {
+	p.moderationHandler = fn
+}
+
+// Publish 以会话的名义向房间发布信息，不发给自己，按房间模式执行:
+// 只读房间返回ErrRoomReadOnly，超出UseRoomLimiter的频率时返回ErrRoomRateLimited，审核房间在批准前返回ErrModerationPending，被审核处理方法拒绝时返回ErrModerationRejected.
+func (s *Session) Publish(room string, msg []byte) error {
+	return s.pigeon.publish(s, []string{room}, websocket.TextMessage, copyBytes(msg), s.id)
+}
+
+// PublishBinary 以会话的名义向房间发布二进制信息，规则同Publish.
+func (s *Session) PublishBinary(room string, msg []byte) error {
+	return s.pigeon.publish(s, []string{room}, websocket.BinaryMessage, copyBytes(msg), s.id)
+}
+
+// 按房间模式发布，开放的房间合并为一次广播，审核的房间分别进入队列. exclude为空时发布者也会收到
+func (p *Pigeon) publish(s *Session, rooms []string, t int, msg []byte, exclude string) error {
+	var open, moderated []string
+	var err error
+	for _, room := range rooms {
+		if !p.allowPublish(room) {
+			err = ErrRoomRateLimited
+			continue
+		}
+		mode := p.RoomMode(room)
+		if mode != RoomOpen && p.roomModerator != nil && p.roomModerator(s, room) {
+			mode = RoomOpen
+		}
+		switch mode {
+		case RoomOpen:
+			open = append(open, room)
+		case RoomModerated:
+			moderated = append(moderated, room)
+		default:
+			err = ErrRoomReadOnly
+		}
+	}
+	for _, room := range moderated {
+		if e := p.moderate(s, room, t, msg, exclude); e != nil {
+			err = e
+		}
+	}
+	if len(open) > 0 {
+		if e := p.dispatch(&envelope{t: t, message: msg, rooms: open, exclude: exclude}); e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+// 将信息加入审核队列并交给审核处理方法
+func (p *Pigeon) moderate(s *Session, room string, t int, msg []byte, exclude string) error {
+	item := &ModerationItem{ID: newID(), Room: room, Session: s.id, Queued: p.now(), t: t, exclude: exclude}
+	if t == websocket.BinaryMessage {
+		item.Binary = msg
+	} else {
+		item.Text = string(msg)
+	}
+	decision := ModerationHold
+	if p.moderationHandler != nil {
+		decision = p.moderationHandler(s, item)
+	}
+	switch decision {
+	case ModerationApprove:
+		return p.dispatch(&envelope{t: t, message: msg, rooms: []string{room}, exclude: exclude})
+	case ModerationReject:
+		return ErrModerationRejected
+	}
+
+	limit := p.hub.rooms.option(room).MaxPending
+	if limit <= 0 {
+		limit = defaultMaxPending
+	}
+	q := p.moderation
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items[room]) >= limit {
+		return ErrModerationQueueFull
+	}
+	q.items[room] = append(q.items[room], item)
+	return ErrModerationPending
+}
+
+// ModerationQueue 获取房间审核队列中的信息，按进入队列的顺序排列.
+func (p *Pigeon) ModerationQueue(room string) []ModerationItem {
+	q := p.moderation
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]ModerationItem, 0, len(q.items[room]))
+	for _, item := range q.items[room] {
+		items = append(items, *item)
+	}
+	return items
+}
+
+// ApproveMessage 批准审核队列中的信息并向房间广播.
+func (p *Pigeon) ApproveMessage(room, id string) error {
+	item, ok := p.moderation.take(room, id)
+	if !ok {
+		return ErrModerationNotFound
+	}
+	m := &envelope{t: item.t, message: []byte(item.Text), rooms: []string{room}, exclude: item.exclude}
+	if item.t == websocket.BinaryMessage {
+		m.message = item.Binary
+	}
+	return p.dispatch(m)
+}
+
+// RejectMessage 从审核队列中丢弃信息.
+func (p *Pigeon) RejectMessage(room, id string) error {
+	if _, ok := p.moderation.take(room, id); !ok {
+		return ErrModerationNotFound
+	}
+	return nil
+}
+
+func (q *moderationQueue) take(room, id string) (*ModerationItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items[room]
+	for i, item := range items {
+		if item.ID == id {
+			items = append(items[:i:i], items[i+1:]...)
+			if len(items) == 0 {
+				delete(q.items, room)
+			} else {
+				q.items[room] = items
+			}
+			return item, true
+		}
+	}
+	return nil, false
+}
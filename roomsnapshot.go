@@ -0,0 +1,74 @@
+package pigeon
+
+import (
+	"sort"
+	"time"
+)
+
+type sessionIdentityFunc func(*Session) string
+
+// SessionInfo 会话的可序列化快照，不持有会话，可直接编码为JSON返回给REST接口.
+type SessionInfo struct {
+	ID          string            `json:"id"`
+	Identity    string            `json:"identity,omitempty"` // 会话所属的用户等身份，见HandleIdentity.
+	RemoteIP    string            `json:"remote_ip"`
+	ConnectedAt time.Time         `json:"connected_at"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// HandleIdentity 获取会话所属的用户等身份，用于SessionInfo和RoomsOf，通常取自鉴权后设置的Keys.
+func (p *Pigeon) HandleIdentity(fn func(*Session) string) {
+	p.sessionIdentity = fn
+}
+
+// Identity 获取会话的身份，未设置HandleIdentity时返回空字符串.
+func (s *Session) Identity() string {
+	if fn := s.pigeon.sessionIdentity; fn != nil {
+		return fn(s)
+	}
+	return ""
+}
+
+// Info 获取会话的可序列化快照.
+func (s *Session) Info() SessionInfo {
+	return SessionInfo{
+		ID:          s.id,
+		Identity:    s.Identity(),
+		RemoteIP:    s.RemoteIP(),
+		ConnectedAt: s.connectedAt,
+		State:       s.ConnState().String(),
+		Labels:      s.Labels(),
+	}
+}
+
+// RoomMembers 获取房间内会话的快照，按连接时间排列.
+func (p *Pigeon) RoomMembers(room string) []SessionInfo {
+	members := p.hub.rooms.members(room)
+	infos := make([]SessionInfo, 0, len(members))
+	for _, s := range members {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ConnectedAt.Before(infos[j].ConnectedAt) })
+	return infos
+}
+
+// RoomsOf 获取会话ID或身份为key的会话所在的房间，同一身份有多个会话时取并集，按名称排列.
+func (p *Pigeon) RoomsOf(key string) []string {
+	seen := make(map[string]struct{})
+	p.hub.iterator(func(s *Session) bool {
+		if s.id != key && (p.sessionIdentity == nil || s.Identity() != key) {
+			return true
+		}
+		for _, room := range s.Rooms() {
+			seen[room] = struct{}{}
+		}
+		return true
+	})
+	rooms := make([]string, 0, len(seen))
+	for room := range seen {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
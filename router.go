@@ -0,0 +1,133 @@
+package pigeon
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Route 路由设置.
+type Route struct {
+	Pattern        string                                                // 路径模式，如 /channel/{name}/ws，路径参数写入会话的Keys.
+	Room           string                                                // 连接后自动加入的房间，可引用路径参数，如 {name}，为空时不加入.
+	Auth           func(r *http.Request, params map[string]string) error // 升级前的鉴权方法，返回错误时响应403.
+	MaxConnections int                                                   // 该路由的连接上限，超过时响应503，为0时不限制.
+}
+
+type route struct {
+	Route
+	segments []string
+	conns    int64
+}
+
+// Router 按路径模式接入会话的路由器.
+type Router struct {
+	pigeon *Pigeon
+	routes []*route
+}
+
+// Router 新建路由器，路由器实现了http.Handler.
+func (p *Pigeon) Router() *Router {
+	return &Router{pigeon: p}
+}
+
+// Mount 添加路由，按添加顺序匹配.
+func (rt *Router) Mount(r Route) error {
+	if !strings.HasPrefix(r.Pattern, "/") {
+		return errors.New("route pattern must start with /")
+	}
+	segments := strings.Split(strings.Trim(r.Pattern, "/"), "/")
+	names := make(map[string]struct{})
+	for _, seg := range segments {
+		if name, ok := paramName(seg); ok {
+			if _, dup := names[name]; dup || name == "" {
+				return errors.New("invalid route pattern " + r.Pattern)
+			}
+			names[name] = struct{}{}
+		}
+	}
+	rt.routes = append(rt.routes, &route{Route: r, segments: segments})
+	return nil
+}
+
+// ServeHTTP 匹配路由并接入会话，未匹配时响应404.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rte := range rt.routes {
+		if params, ok := rte.match(r.URL.Path); ok {
+			rt.serve(rte, params, w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// 按路由接入会话
+func (rt *Router) serve(rte *route, params map[string]string, w http.ResponseWriter, r *http.Request) {
+	if rte.Auth != nil {
+		if err := rte.Auth(r, params); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			rt.pigeon.reportError(nil, err)
+			return
+		}
+	}
+
+	if rte.MaxConnections > 0 {
+		if atomic.AddInt64(&rte.conns, 1) > int64(rte.MaxConnections) {
+			atomic.AddInt64(&rte.conns, -1)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer atomic.AddInt64(&rte.conns, -1)
+	}
+
+	keys := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		keys[k] = v
+	}
+	var rooms []string
+	if rte.Room != "" {
+		rooms = append(rooms, expandParams(rte.Room, params))
+	}
+	if err := rt.pigeon.handleRequest(w, r, keys, rooms); err != nil {
+		rt.pigeon.reportError(nil, err)
+	}
+}
+
+// 匹配路径并提取路径参数
+func (rte *route) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(rte.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range rte.segments {
+		if name, ok := paramName(seg); ok {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[name] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// 解析 {name} 形式的路径参数
+func paramName(seg string) (string, bool) {
+	if len(seg) >= 2 && seg[0] == '{' && seg[len(seg)-1] == '}' {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
+
+// 将 {name} 替换为路径参数的值
+func expandParams(s string, params map[string]string) string {
+	for k, v := range params {
+		s = strings.ReplaceAll(s, "{"+k+"}", v)
+	}
+	return s
+}
@@ -0,0 +1,170 @@
+package pigeon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// 请求应答使用的事件名.
+const (
+	EventReply  = "reply"  // 应答，ID与请求相同.
+	EventGather = "gather" // Gather发出的请求.
+)
+
+// Selector 会话选择器.
+type Selector struct {
+	Rooms  []string            // 选择这些房间的会话，与Tags取并集，均为空时选择全部会话.
+	Tags   []string            // 选择有这些标签的会话.
+	Filter func(*Session) bool // 过滤器，为nil时不过滤.
+}
+
+// 等待应答的请求
+type call struct {
+	session *Session
+	reply   chan json.RawMessage
+}
+
+// 等待应答的请求表
+type callTable struct {
+	calls map[string]*call
+	mu    *sync.Mutex
+}
+
+func newCallTable() *callTable {
+	return &callTable{calls: make(map[string]*call), mu: &sync.Mutex{}}
+}
+
+func (t *callTable) add(id string, c *call) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls[id] = c
+}
+
+func (t *callTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.calls, id)
+}
+
+// 交付应答，只接受发出请求的会话的应答
+func (t *callTable) resolve(id string, s *Session, data json.RawMessage) bool {
+	t.mu.Lock()
+	c, ok := t.calls[id]
+	if ok && c.session == s {
+		delete(t.calls, id)
+	}
+	t.mu.Unlock()
+	if !ok || c.session != s {
+		return false
+	}
+	c.reply <- data
+	return true
+}
+
+// Call 向会话发送带ID的事件并等待客户端以EventReply事件应答，需启用Config.EventProtocol.
+// 请求未能放入缓冲区时立即返回其错误，应答前会话关闭时返回ErrSessionClosed.
+func (s *Session) Call(ctx context.Context, event string, data interface{}) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if data != nil {
+		b, err := s.codec.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+	return s.call(ctx, event, raw)
+}
+
+func (s *Session) call(ctx context.Context, event string, data json.RawMessage) (json.RawMessage, error) {
+	if s.closed() {
+		return nil, ErrSessionClosed
+	}
+	id := newID()
+	msg, err := s.codec.Marshal(&Event{Name: event, ID: id, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &call{session: s, reply: make(chan json.RawMessage, 1)}
+	calls := s.pigeon.calls
+	calls.add(id, c)
+	defer calls.remove(id)
+
+	// 请求未能放入缓冲区时客户端不会应答，立即返回
+	if err := s.enqueue(&envelope{t: s.codec.MessageType(), message: msg}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-c.reply:
+		return reply, nil
+	case <-s.closedCh:
+		return nil, ErrSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Reply 应答客户端发出的带ID的事件，客户端以ID关联请求.
+func (s *Session) Reply(ev *Event, data interface{}) error {
+	if ev.ID == "" {
+		return errors.New("event has no id")
+	}
+	msg, err := s.encodeReply(ev.ID, data)
+	if err != nil {
+		return err
+	}
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	s.recordAlloc(len(msg))
+	s.writeMessage(&envelope{t: s.codec.MessageType(), message: msg})
+	return nil
+}
+
+// 编码应答事件
+func (s *Session) encodeReply(id string, data interface{}) ([]byte, error) {
+	ev := &Event{Name: EventReply, ID: id}
+	if data != nil {
+		raw, err := s.codec.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		ev.Data = raw
+	}
+	return s.codec.Marshal(ev)
+}
+
+// Gather 向选中的会话发送EventGather事件并收集应答，返回以会话ID为key的应答.
+// payload为已编码的数据. ctx结束时仍未应答的会话不在结果中，此时同时返回ctx的错误.
+func (p *Pigeon) Gather(ctx context.Context, targets Selector, payload []byte) (map[string][]byte, error) {
+	if p.hub.closed() {
+		return nil, errors.New("pigeon instance is closed")
+	}
+	sessions := p.hub.targets(&envelope{rooms: targets.Rooms, tags: targets.Tags, filter: targets.Filter})
+
+	results := make(map[string][]byte, len(sessions))
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			reply, err := s.call(ctx, EventGather, payload)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			results[s.ID()] = reply
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+
+	if len(results) < len(sessions) && ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	return results, nil
+}
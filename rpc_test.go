@@ -0,0 +1,52 @@
+package pigeon
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCallSessionClosed(t *testing.T) {
+	p := newFuzzPigeon(t)
+	s := newFuzzSession(p)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.Call(context.Background(), "ask", nil)
+		errc <- err
+	}()
+	for len(s.output) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 会话没有连接，只模拟close对等待方可见的部分
+	s.mu.Lock()
+	s.open = false
+	close(s.closedCh)
+	s.mu.Unlock()
+	select {
+	case err := <-errc:
+		if err != ErrSessionClosed {
+			t.Fatalf("err = %v, want ErrSessionClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not return after the session closed")
+	}
+	if n := len(p.calls.calls); n != 0 {
+		t.Fatalf("%d pending calls after close", n)
+	}
+}
+
+func TestCallBufferFull(t *testing.T) {
+	p := newFuzzPigeon(t)
+	s := newFuzzSession(p)
+	for len(s.output) < cap(s.output) {
+		s.output <- &envelope{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.Call(ctx, "ask", nil); err == nil || ctx.Err() != nil {
+		t.Fatalf("err = %v, want an immediate enqueue error", err)
+	}
+	if n := len(p.calls.calls); n != 0 {
+		t.Fatalf("%d pending calls after a dropped request", n)
+	}
+}
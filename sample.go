@@ -0,0 +1,63 @@
+package pigeon
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrInvalidFraction 抽样比例不在[0,1]之间.
+var ErrInvalidFraction = errors.New("sample fraction must be within [0, 1]")
+
+// BroadcastRoomSample 向房间内随机抽取的fraction比例的会话广播消息，用于实时功能的灰度发布.
+// seedKey不为空时按seedKey和会话ID稳定分配，同一seedKey下的会话每次抽样结果相同，比例增大时原有会话仍被选中；
+// seedKey为空时每次广播独立随机抽样.
+func (p *Pigeon) BroadcastRoomSample(room string, msg []byte, fraction float64, seedKey string) error {
+	return p.broadcastRoomSample(websocket.TextMessage, room, msg, fraction, seedKey)
+}
+
+// BroadcastRoomBinarySample 向房间内随机抽取的fraction比例的会话广播二进制消息，抽样方式同BroadcastRoomSample.
+func (p *Pigeon) BroadcastRoomBinarySample(room string, msg []byte, fraction float64, seedKey string) error {
+	return p.broadcastRoomSample(websocket.BinaryMessage, room, msg, fraction, seedKey)
+}
+
+func (p *Pigeon) broadcastRoomSample(t int, room string, msg []byte, fraction float64, seedKey string) error {
+	if !(fraction >= 0 && fraction <= 1) {
+		return ErrInvalidFraction
+	}
+	if fraction == 0 {
+		return nil
+	}
+	m := &envelope{t: t, message: copyBytes(msg), rooms: []string{room}}
+	if fraction < 1 {
+		m.filter = func(s *Session) bool {
+			if seedKey == "" {
+				return rand.Float64() < fraction
+			}
+			return s.InSample(fraction, seedKey)
+		}
+	}
+	return p.dispatch(m)
+}
+
+// InSample 判断会话是否位于seedKey下fraction比例的抽样中，结果只由seedKey和会话ID决定.
+// 可用于在处理方法中与BroadcastRoomSample保持一致的分组.
+func (s *Session) InSample(fraction float64, seedKey string) bool {
+	return sampleBucket(seedKey, s.ID()) < fraction
+}
+
+// 将seedKey和会话ID均匀映射到[0,1)
+func sampleBucket(seedKey, id string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(seedKey))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	// fnv的高位对末尾字节的变化不够敏感，混合后再取高53位
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return float64(x>>11) / (1 << 53)
+}
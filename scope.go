@@ -0,0 +1,286 @@
+package pigeon
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ScopeSeparator 作用域路径的分隔符，如"org/team/channel".
+const ScopeSeparator = "/"
+
+// 作用域对应房间名的前缀
+const scopeRoomPrefix = "$scope/"
+
+// ErrInvalidScope 作用域路径为空或含有空的层级.
+var ErrInvalidScope = errors.New("invalid scope path")
+
+type handleScopeJoinFunc func(*Session, *Scope) error
+type handleScopeLeaveFunc func(*Session, *Scope)
+
+// Scope 层级作用域，如组织、团队、频道，每个作用域对应一个房间.
+// 向作用域广播时到达其自身及全部子孙作用域的成员，各层级的房间合并为一次投递，同时位于多个层级的会话只收到一次.
+// 未设置房间设置和处理方法的作用域继承最近的祖先作用域的设置.
+type Scope struct {
+	name     string
+	path     string
+	parent   *Scope
+	children map[string]*Scope
+	pigeon   *Pigeon
+
+	options      *RoomOptions
+	joinHandler  handleScopeJoinFunc
+	leaveHandler handleScopeLeaveFunc
+}
+
+// 作用域树，根节点不对应房间
+type scopeTree struct {
+	root *Scope
+	mu   *sync.RWMutex
+}
+
+func newScopeTree(p *Pigeon) *scopeTree {
+	return &scopeTree{root: &Scope{children: make(map[string]*Scope), pigeon: p}, mu: &sync.RWMutex{}}
+}
+
+// Scope 获取路径对应的作用域，不存在时依次创建各层级，新建的作用域继承祖先的房间设置.
+func (p *Pigeon) Scope(path string) (*Scope, error) {
+	names, err := splitScope(path)
+	if err != nil {
+		return nil, err
+	}
+	t := p.scopes
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sc := t.root
+	for _, name := range names {
+		child, ok := sc.children[name]
+		if !ok {
+			child = &Scope{
+				name:     name,
+				path:     strings.TrimPrefix(sc.path+ScopeSeparator+name, ScopeSeparator),
+				parent:   sc,
+				children: make(map[string]*Scope),
+				pigeon:   p,
+			}
+			sc.children[name] = child
+			if opts, ok := child.inheritedOptions(); ok {
+				p.hub.rooms.setOptions(child.Room(), opts)
+			}
+		}
+		sc = child
+	}
+	return sc, nil
+}
+
+// LookupScope 获取已存在的作用域.
+func (p *Pigeon) LookupScope(path string) (*Scope, bool) {
+	names, err := splitScope(path)
+	if err != nil {
+		return nil, false
+	}
+	t := p.scopes
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	sc := t.root
+	for _, name := range names {
+		child, ok := sc.children[name]
+		if !ok {
+			return nil, false
+		}
+		sc = child
+	}
+	return sc, true
+}
+
+func splitScope(path string) ([]string, error) {
+	if path == "" {
+		return nil, ErrInvalidScope
+	}
+	names := strings.Split(path, ScopeSeparator)
+	for _, name := range names {
+		if name == "" {
+			return nil, ErrInvalidScope
+		}
+	}
+	return names, nil
+}
+
+// Name 获取作用域在父作用域中的名称.
+func (sc *Scope) Name() string {
+	return sc.name
+}
+
+// Path 获取作用域的完整路径.
+func (sc *Scope) Path() string {
+	return sc.path
+}
+
+// Room 获取作用域对应的房间名，可用于房间相关的接口，如RoomLen、SetRoomMode.
+func (sc *Scope) Room() string {
+	return scopeRoomPrefix + sc.path
+}
+
+// Parent 获取父作用域，顶层作用域返回nil.
+func (sc *Scope) Parent() *Scope {
+	if sc.parent == nil || sc.parent.parent == nil {
+		return nil
+	}
+	return sc.parent
+}
+
+// Children 获取子作用域，按名称排列.
+func (sc *Scope) Children() []*Scope {
+	t := sc.pigeon.scopes
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	children := make([]*Scope, 0, len(sc.children))
+	for _, child := range sc.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// Child 获取子作用域，不存在时创建.
+func (sc *Scope) Child(name string) (*Scope, error) {
+	if name == "" || strings.Contains(name, ScopeSeparator) {
+		return nil, ErrInvalidScope
+	}
+	return sc.pigeon.Scope(sc.path + ScopeSeparator + name)
+}
+
+// SetOptions 设置作用域的房间设置，未单独设置的子孙作用域随之更新.
+// MaxMembers等限制对每个作用域的房间分别生效，不是整个子树的总量.
+func (sc *Scope) SetOptions(opts RoomOptions) {
+	t := sc.pigeon.scopes
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sc.options = &opts
+	sc.propagate(opts)
+}
+
+// 将设置应用到作用域及未单独设置的子孙作用域，调用时须持有作用域树的锁
+func (sc *Scope) propagate(opts RoomOptions) {
+	sc.pigeon.hub.rooms.setOptions(sc.Room(), opts)
+	for _, child := range sc.children {
+		if child.options == nil {
+			child.propagate(opts)
+		}
+	}
+}
+
+// Options 获取作用域生效的房间设置，包括继承的设置.
+func (sc *Scope) Options() RoomOptions {
+	return sc.pigeon.hub.rooms.option(sc.Room())
+}
+
+// 获取最近的祖先作用域的设置，调用时须持有作用域树的锁
+func (sc *Scope) inheritedOptions() (RoomOptions, bool) {
+	for a := sc.parent; a != nil; a = a.parent {
+		if a.options != nil {
+			return *a.options, true
+		}
+	}
+	return RoomOptions{}, false
+}
+
+// HandleJoin 会话加入作用域前的处理方法，返回错误时拒绝加入，未设置时使用祖先作用域的处理方法.
+func (sc *Scope) HandleJoin(fn func(*Session, *Scope) error) {
+	t := sc.pigeon.scopes
+	t.mu.Lock()
+	sc.joinHandler = fn
+	t.mu.Unlock()
+}
+
+// HandleLeave 会话通过Leave离开作用域后的处理方法，未设置时使用祖先作用域的处理方法. 连接断开时不调用.
+func (sc *Scope) HandleLeave(fn func(*Session, *Scope)) {
+	t := sc.pigeon.scopes
+	t.mu.Lock()
+	sc.leaveHandler = fn
+	t.mu.Unlock()
+}
+
+// 获取作用域或最近的祖先作用域的处理方法
+func (sc *Scope) handlers() (handleScopeJoinFunc, handleScopeLeaveFunc) {
+	t := sc.pigeon.scopes
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var join handleScopeJoinFunc
+	var leave handleScopeLeaveFunc
+	for a := sc; a != nil && (join == nil || leave == nil); a = a.parent {
+		if join == nil {
+			join = a.joinHandler
+		}
+		if leave == nil {
+			leave = a.leaveHandler
+		}
+	}
+	return join, leave
+}
+
+// Join 会话加入作用域，只接收该作用域及其祖先作用域的广播.
+func (sc *Scope) Join(s *Session) error {
+	if join, _ := sc.handlers(); join != nil {
+		if err := join(s, sc); err != nil {
+			return err
+		}
+	}
+	return s.Join(sc.Room())
+}
+
+// Leave 会话离开作用域.
+func (sc *Scope) Leave(s *Session) error {
+	if err := s.Leave(sc.Room()); err != nil {
+		return err
+	}
+	if _, leave := sc.handlers(); leave != nil {
+		leave(s, sc)
+	}
+	return nil
+}
+
+// Len 获取直接加入该作用域的会话数量，不含子孙作用域.
+func (sc *Scope) Len() int {
+	return sc.pigeon.hub.rooms.len(sc.Room())
+}
+
+// Members 获取作用域及全部子孙作用域的会话，每个会话只出现一次.
+func (sc *Scope) Members() []*Session {
+	return sc.pigeon.hub.rooms.members(sc.rooms()...)
+}
+
+// Broadcast 向作用域及全部子孙作用域的会话广播消息，每个会话只收到一次.
+func (sc *Scope) Broadcast(msg []byte) error {
+	return sc.pigeon.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), rooms: sc.rooms()})
+}
+
+// BroadcastBinary 向作用域及全部子孙作用域的会话广播二进制消息.
+func (sc *Scope) BroadcastBinary(msg []byte) error {
+	return sc.pigeon.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), rooms: sc.rooms()})
+}
+
+// BroadcastFilter 向作用域及全部子孙作用域中符合过滤器结果的会话广播消息.
+func (sc *Scope) BroadcastFilter(msg []byte, fn func(*Session) bool) error {
+	return sc.pigeon.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), rooms: sc.rooms(), filter: fn})
+}
+
+// 作用域及全部子孙作用域对应的房间
+func (sc *Scope) rooms() []string {
+	t := sc.pigeon.scopes
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var rooms []string
+	var walk func(*Scope)
+	walk = func(n *Scope) {
+		rooms = append(rooms, n.Room())
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(sc)
+	return rooms
+}
@@ -0,0 +1,88 @@
+package pigeon
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type sendBudgetExemptFunc func(*Session) bool
+
+// 按Config.SendBytesPerSecond新建全局发送限流器
+func newSendLimiter(conf *Config) *rate.Limiter {
+	if conf.SendBytesPerSecond <= 0 {
+		return nil
+	}
+	burst := conf.SendBurst
+	if burst <= 0 {
+		burst = conf.SendBytesPerSecond
+	}
+	return rate.NewLimiter(rate.Limit(conf.SendBytesPerSecond), burst)
+}
+
+// SetSendLimiter 设置全局发送限流器，令牌单位为字节，替换Config.SendBytesPerSecond创建的限流器，为nil时不限制.
+// 可在多个信鸽实例间共享同一个限流器，运行中可通过rate.Limiter.SetLimit调整预算. 须在接入连接前调用.
+func (p *Pigeon) SetSendLimiter(l *rate.Limiter) {
+	p.sendLimiter = l
+}
+
+// SendLimiter 获取全局发送限流器，未设置时返回nil.
+func (p *Pigeon) SendLimiter() *rate.Limiter {
+	return p.sendLimiter
+}
+
+// HandleSendBudgetExempt 判断会话是否免于全局发送限流，如运维或付费用户的会话.
+// 高优先级的信息（SendOptions.Priority为PriorityHigh）、ping及重发的可靠信息始终不受限流.
+func (p *Pigeon) HandleSendBudgetExempt(fn func(*Session) bool) {
+	p.sendBudgetExempt = fn
+}
+
+// 写入前等待全局发送预算，等待期间会话关闭时提前返回
+func (s *Session) waitSendBudget(batch []*envelope, size int) {
+	p := s.pigeon
+	l := p.sendLimiter
+	if l == nil || l.Limit() == rate.Inf || size == 0 {
+		return
+	}
+	exempt := true
+	for _, m := range batch {
+		if !m.urgent() {
+			exempt = false
+			break
+		}
+	}
+	if exempt || (p.sendBudgetExempt != nil && p.sendBudgetExempt(s)) {
+		return
+	}
+
+	// 超过突发容量的信息分段预留
+	var delay time.Duration
+	now := p.now()
+	for size > 0 {
+		n := size
+		if burst := l.Burst(); burst > 0 && n > burst {
+			n = burst
+		}
+		r := l.ReserveN(now, n)
+		if !r.OK() {
+			return
+		}
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+		size -= n
+	}
+	if delay <= 0 {
+		return
+	}
+
+	atomic.AddUint64(&p.counters.sendThrottled, 1)
+	atomic.AddUint64(&p.counters.sendWaitNanos, uint64(delay))
+	t := p.clock.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-s.closedCh:
+	}
+}
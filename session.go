@@ -1,9 +1,11 @@
 package pigeon
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,26 +13,133 @@ import (
 
 // Session 会话包装器.
 type Session struct {
-	Request *http.Request
-	Keys    map[string]interface{}
-	conn    *websocket.Conn
-	output  chan *envelope
-	pigeon  *Pigeon
-	open    bool
-	mu      *sync.RWMutex
+	Request       *http.Request
+	Keys          map[string]interface{}
+	conn          *websocket.Conn
+	output        chan *envelope
+	pigeon        *Pigeon
+	id            string
+	rooms         map[string]struct{}
+	pending       map[string]struct{}
+	codec         Codec
+	protocol      *Protocol
+	cohort        float64
+	lane          *lane
+	laneName      string
+	aborted       int32
+	pressured     int32
+	lastRead      int64          // 最后收到数据的时间，UnixNano.
+	probed        int64          // 最近一次探活时的lastRead.
+	queuedBytes   int64          // 缓冲区中待发送的字节数，未开启内存预算时为0.
+	pressureRooms []string       // 进入高水位时所在的房间.
+	diag          *transportDiag // 传输诊断，未开启时为nil.
+	labels        map[string]string
+	tags          map[string]struct{}
+	persistent    map[string]struct{} // 标记为持久的Keys.
+	timers        map[*Timer]struct{} // 未停止的定时器.
+	caps          Capabilities
+	resumed       bool
+	secret        string // 服务端生成的恢复密钥，未启用恢复令牌密钥时与会话ID组成恢复令牌.
+	credit        creditState
+	reliable      *reliableState
+	paced         *pacedQueue
+	usage         usageCounters
+
+	connectedAt time.Time
+	serverClose *websocket.CloseError
+	writeErr    *WriteError // 首次写入失败的错误，见Config.WriteRetryGrace.
+	clientClose bool
+	open        bool
+	reading     bool // 读取流程正阻塞在读取上.
+	detached    bool
+	state       ConnState
+	holding     bool // 暂存收到的信息直到Ready.
+	held        []heldMessage
+	mu          *profiledMutex
+
+	writeStop chan struct{}
+	writeDone chan struct{}
+	closedCh  chan struct{} // 会话关闭或被Detach时关闭.
+	readTimer ClockTimer    // 使用虚拟时钟时的读取期限定时器.
+}
+
+// 生成随机ID
+// ErrSessionClosed 会话已关闭.
+var ErrSessionClosed = errors.New("session is closed")
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID 获取会话ID.
+func (s *Session) ID() string {
+	return s.id
 }
 
 // 写入信息
 func (s *Session) writeMessage(message *envelope) {
-	if s.closed() {
-		s.pigeon.errorHandler(s, errors.New("tried to write to closed a session"))
-		return
+	if err := s.enqueue(message); err != nil {
+		s.pigeon.reportError(s, err)
 	}
+}
 
+// 将信息放入缓冲区，不调用任何处理方法. 有尚未到期的分批广播时排在其后
+func (s *Session) enqueue(message *envelope) error {
+	if s.paced.hold(s, message) {
+		return nil
+	}
+	return s.push(message)
+}
+
+// 直接放入缓冲区
+func (s *Session) push(message *envelope) error {
+	if err := s.writable(message.t); err != nil {
+		s.dropped(message, err)
+		return err
+	}
+
+	if !s.reserveQueued(message) {
+		s.dropped(message, ErrMemoryBudget)
+		return ErrMemoryBudget
+	}
+
+	sent, open := s.offer(message)
+	if !open {
+		s.releaseQueued(int64(len(message.message)))
+		err := errors.New("tried to write to closed a session")
+		s.dropped(message, err)
+		return err
+	}
+	if sent {
+		s.raisePressure()
+		s.queued(message)
+		return nil
+	}
+
+	s.releaseQueued(int64(len(message.message)))
+	atomic.AddUint64(&s.pigeon.counters.dropped, 1)
+	if s.pigeon.Config.CloseOnOverflow {
+		go s.abort(CloseBufferOverflow)
+	}
+	err := errors.New("session message buffer is full")
+	s.dropped(message, err)
+	return err
+}
+
+// 持有读锁放入缓冲区，与close关闭缓冲区互斥. 返回是否放入及会话是否仍开启
+func (s *Session) offer(message *envelope) (sent, open bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.open {
+		return false, false
+	}
 	select {
 	case s.output <- message:
+		return true, true
 	default:
-		s.pigeon.errorHandler(s, errors.New("session message buffer is full"))
+		return false, true
 	}
 }
 
@@ -39,6 +148,9 @@ func (s *Session) writeRaw(message *envelope) error {
 		return errors.New("tried to write to a closed session")
 	}
 	s.conn.SetWriteDeadline(time.Now().Add(s.pigeon.Config.WriteWait))
+	if s.pigeon.Config.EnableCompression {
+		s.conn.EnableWriteCompression(message.forceCompress() || (s.caps.Compression && message.compress()))
+	}
 	return s.conn.WriteMessage(message.t, message.message)
 }
 
@@ -55,10 +167,59 @@ func (s *Session) close() {
 	if !s.closed() {
 		s.mu.Lock()
 		s.open = false
+		from, changed := s.setState(StateClosed)
 		s.conn.Close()
 		close(s.output)
+		close(s.closedCh)
 		s.mu.Unlock()
+		s.stopTimers()
+		if changed {
+			s.stateChanged(from, StateClosed)
+		}
+	}
+}
+
+// 获取当前的底层连接
+func (s *Session) connection() *websocket.Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// 判断conn是否已被rebind替换
+func (s *Session) replaced(conn *websocket.Conn) bool {
+	return s.connection() != conn
+}
+
+// 替换底层连接，保留缓冲区中待发送的信息和已加入的房间，供会话恢复、传输降级等流程使用.
+// 旧连接的写入流程停止后才会替换，旧连接随后被关闭，其读取流程结束时不会注销会话.
+// 调用方需随后在新连接上调用Pigeon.serve.
+func (s *Session) rebind(conn *websocket.Conn) error {
+	s.mu.Lock()
+	if !s.open {
+		s.mu.Unlock()
+		return errors.New("session is closed")
+	}
+	stop, done := s.writeStop, s.writeDone
+	s.writeStop, s.writeDone = nil, nil
+	from, changed := s.setState(StateResuming)
+	s.mu.Unlock()
+	if changed {
+		s.stateChanged(from, StateResuming)
 	}
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.mu.Lock()
+	old := s.conn
+	s.conn = conn
+	s.mu.Unlock()
+
+	old.Close()
+	return nil
 }
 
 // 向客户端发送ping信息
@@ -66,10 +227,61 @@ func (s *Session) ping() {
 	s.writeRaw(&envelope{t: websocket.PingMessage, message: []byte("Ping")})
 }
 
-// 写入信息流
-func (s *Session) writePump() {
-	ticker := time.NewTicker(s.pigeon.Config.PingPeriod)
+// 写入信息流，计时器创建后关闭armed，stop关闭时退出并保留缓冲区
+func (s *Session) writePump(stop <-chan struct{}, done chan<- struct{}, armed chan<- struct{}) {
+	ticker := s.pigeon.clock.NewTicker(s.pigeon.Config.PingPeriod)
 	defer ticker.Stop()
+	defer close(done)
+
+	// 被rebind或Detach停止时缓冲区中的信息留给新连接或由调用方处理
+	stopped := false
+	defer func() {
+		if !stopped {
+			s.discardQueued()
+		}
+	}()
+
+	// 可靠投递依赖控制协议接收确认
+	var retransmit <-chan time.Time
+	var nacked <-chan struct{}
+	if s.pigeon.Config.ControlProtocol {
+		retransmitTicker := s.pigeon.clock.NewTicker(s.pigeon.ackTimeout())
+		defer retransmitTicker.Stop()
+		retransmit = retransmitTicker.C()
+		nacked = s.reliable.nacked
+	}
+
+	var probe <-chan time.Time
+	if interval := s.pigeon.Config.ProbeInterval; interval > 0 {
+		probeTicker := s.pigeon.clock.NewTicker(interval / 2)
+		defer probeTicker.Stop()
+		probe = probeTicker.C()
+	}
+
+	var expire <-chan time.Time
+	advised := false
+	if t := s.ageTimer(); t != nil {
+		defer t.Stop()
+		expire = t.C()
+	}
+
+	var diagnose <-chan time.Time
+	if s.diag != nil {
+		diagTicker := s.pigeon.clock.NewTicker(s.pigeon.Config.DiagnosticInterval)
+		defer diagTicker.Stop()
+		diagnose = diagTicker.C()
+	}
+
+	var credit <-chan time.Time
+	if s.pigeon.flowControl() {
+		creditTicker := s.pigeon.clock.NewTicker(s.pigeon.Config.CreditInterval)
+		defer creditTicker.Stop()
+		credit = creditTicker.C()
+		if err := s.grantCredit(); err != nil {
+			s.pigeon.reportError(s, err)
+		}
+	}
+	close(armed)
 
 loop:
 	for {
@@ -79,57 +291,220 @@ loop:
 				break loop
 			}
 
-			if msg.t == websocket.CloseMessage {
+			for msg != nil {
+				if msg.t == websocket.CloseMessage {
+					break loop
+				}
+
+				var batch []*envelope
+				batch, msg = s.collect(msg)
+				s.releaseBatch(batch)
+				if batch = s.evict(batch); len(batch) == 0 {
+					continue
+				}
+				if !s.write(batch) {
+					break loop
+				}
+			}
+			s.relievePressure(false)
+		case <-ticker.C():
+			s.ping()
+		case <-probe:
+			s.probe()
+		case <-diagnose:
+			s.diagnose()
+		case <-credit:
+			if err := s.grantCredit(); err != nil {
+				s.pigeon.reportError(s, err)
+			}
+		case <-retransmit:
+			if !s.resend() {
 				break loop
 			}
-
-			if err := s.writeRaw(msg); err != nil {
-				s.pigeon.errorHandler(s, err)
+		case <-nacked:
+			if !s.resend() {
 				break loop
 			}
-
-			if msg.t == websocket.TextMessage {
-				s.pigeon.messageSentHandler(s, msg.message)
+		case <-expire:
+			if grace := s.pigeon.Config.MaxConnectionAgeGrace; grace > 0 && !advised {
+				advised = true
+				if err := s.adviseReconnect(); err != nil {
+					s.pigeon.reportError(s, err)
+				}
+				t := s.pigeon.clock.NewTimer(grace)
+				defer t.Stop()
+				expire = t.C()
+				continue
 			}
+			s.abort(CloseMaxAge)
+			break loop
+		case <-stop:
+			stopped = true
+			break loop
+		}
+	}
+}
 
-			if msg.t == websocket.BinaryMessage {
-				s.pigeon.messageSentHandlerBinary(s, msg.message)
-			}
-		case <-ticker.C:
-			s.ping()
+// 写入一批信息并调用发送处理方法，写入失败时返回false
+func (s *Session) write(batch []*envelope) bool {
+	msg := batch[0]
+	if len(batch) > 1 {
+		msg = joinBatch(batch)
+		s.recordAlloc(len(msg.message))
+	} else if msg.reliable {
+		if msg.expired(s.pigeon.now()) {
+			notifyWritten(batch, ErrMessageExpired)
+			s.dropped(msg, ErrMessageExpired)
+			return true
+		}
+		framed, ok := s.track(msg)
+		if !ok {
+			err := errors.New("too many unacked messages")
+			notifyWritten(batch, err)
+			s.dropped(msg, err)
+			s.abort(CloseBufferOverflow)
+			return false
+		}
+		msg = framed
+	}
+	msg = s.compressPayload(msg)
+	s.waitSendBudget(batch, len(msg.message))
+
+	endTrace := s.traceWrite(batch)
+	err := s.writeRaw(msg)
+	endTrace(err)
+	if err != nil {
+		we := s.writeFailed(err)
+		notifyWritten(batch, we)
+		for _, m := range batch {
+			s.dropped(m, we)
+		}
+		s.pigeon.reportError(s, we)
+		if isTimeout(err) {
+			s.abort(CloseWriteTimeout)
+		}
+		return false
+	}
+	notifyWritten(batch, nil)
+	atomic.AddUint64(&s.pigeon.counters.messagesOut, uint64(len(batch)))
+	s.recordOut(len(batch), len(msg.message))
+
+	for _, m := range batch {
+		m := m
+		s.pigeon.delivered(m)
+		s.sentMetadata(m)
+		s.written(m)
+		if m.t == websocket.TextMessage {
+			s.pigeon.call(s, func() { s.pigeon.messageSentHandler(s, m.message) })
+		}
+
+		if m.t == websocket.BinaryMessage {
+			s.pigeon.call(s, func() { s.pigeon.messageSentHandlerBinary(s, m.message) })
 		}
 	}
+	return true
 }
 
-// 读取信息流
-func (s *Session) readPump() {
-	s.conn.SetReadLimit(s.pigeon.Config.MaxMessageSize)
-	s.conn.SetReadDeadline(time.Now().Add(s.pigeon.Config.PongWait))
+// 重发未确认的信息，写入失败时返回false
+func (s *Session) resend() bool {
+	if err := s.retransmit(); err != nil {
+		s.pigeon.reportError(s, err)
+		if isTimeout(err) {
+			s.abort(CloseWriteTimeout)
+		}
+		return false
+	}
+	return true
+}
 
-	s.conn.SetPongHandler(func(string) error {
-		s.conn.SetReadDeadline(time.Now().Add(s.pigeon.Config.PongWait))
-		s.pigeon.pongHandler(s)
+// 读取信息流，返回导致结束的错误
+func (s *Session) readPump(conn *websocket.Conn) error {
+	conn.SetReadLimit(s.caps.MaxMessageSize)
+	s.setReadTimeout(conn, s.pigeon.Config.PongWait)
+
+	s.touch()
+	conn.SetPongHandler(func(data string) error {
+		s.touch()
+		s.setReadTimeout(conn, s.pigeon.Config.PongWait)
+		if s.observePong(data) {
+			return nil
+		}
+		s.pigeon.call(s, func() { s.pigeon.pongHandler(s) })
 		return nil
 	})
 
 	for {
-		t, message, err := s.conn.ReadMessage()
+		if !s.beginRead() {
+			return errDetached
+		}
+		t, message, err := conn.ReadMessage()
+		s.endRead()
 		if err != nil {
+			if s.replaced(conn) {
+				return err
+			}
 			if websocket.IsUnexpectedCloseError(err,
 				websocket.CloseNormalClosure,
 				websocket.CloseGoingAway,
 				websocket.CloseAbnormalClosure,
 				websocket.CloseServiceRestart) {
-				s.pigeon.errorHandler(s, err)
+				s.pigeon.reportError(s, err)
 			}
-			break
+			return err
 		}
-		if t == websocket.TextMessage {
-			s.pigeon.messageHandler(s, message)
+		s.touch()
+		atomic.AddUint64(&s.pigeon.counters.messagesIn, 1)
+		s.recordIn(len(message))
+		if !s.consumeCredit(len(message)) {
+			s.abort(CloseCreditExceeded)
+			continue
+		}
+		if s.hold(t, message) {
+			continue
+		}
+		s.dispatchInbound(t, message)
+	}
+}
+
+// 将收到的信息交给处理通道或直接处理
+func (s *Session) dispatchInbound(t int, message []byte) {
+	if l := s.currentLane(); l != nil && l.submit(func() { s.handleInbound(t, message) }) {
+		return
+	}
+	if s.pigeon.scheduleInbound(s, func() { s.handleInbound(t, message) }) {
+		return
+	}
+	s.handleInbound(t, message)
+}
+
+// 处理收到的信息
+func (s *Session) handleInbound(t int, message []byte) {
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		atomic.AddInt64(&s.usage.handlerNanos, int64(elapsed))
+		if fn := s.pigeon.messageTimingHandler; fn != nil {
+			fn(s, len(message), elapsed)
 		}
-		if t == websocket.BinaryMessage {
-			s.pigeon.messageHandlerBinary(s, message)
+	}()
+	if t == websocket.TextMessage && s.pigeon.handleControl(s, message) {
+		return
+	}
+	if !s.pigeon.allowInbound(s) {
+		return
+	}
+	if s.pigeon.handleEvent(s, t, message) {
+		return
+	}
+	if t == websocket.TextMessage {
+		s.pigeon.messageHandlerOf(s)(s, message)
+	}
+	if t == websocket.BinaryMessage {
+		if s.protocol != nil && s.protocol.messageHandlerBinary != nil {
+			s.protocol.messageHandlerBinary(s, message)
+			return
 		}
+		s.pigeon.routeBinary(s, message)
 	}
 }
 
@@ -151,6 +526,18 @@ func (s *Session) WriteBinary(msg []byte) error {
 	return nil
 }
 
+// WriteControl 直接向会话写入控制帧（CloseMessage、PingMessage或PongMessage）.
+// 控制帧不经过缓冲区，websocket允许其与普通写入并发执行.
+func (s *Session) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType != websocket.CloseMessage && messageType != websocket.PingMessage && messageType != websocket.PongMessage {
+		return errors.New("invalid control message type")
+	}
+	if s.closed() {
+		return errors.New("session is closed")
+	}
+	return s.connection().WriteControl(messageType, data, deadline)
+}
+
 // Close 关闭会话.
 func (s *Session) Close() error {
 	return s.CloseWithMsg([]byte{})
@@ -161,8 +548,9 @@ func (s *Session) CloseWithMsg(msg []byte) error {
 	if s.closed() {
 		return errors.New("session is already closed")
 	}
+	s.markServerClose(msg)
 	s.writeMessage(&envelope{t: websocket.CloseMessage, message: msg})
-	return s.conn.WriteControl(websocket.CloseMessage, msg, time.Now())
+	return s.connection().WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.pigeon.Config.WriteWait))
 }
 
 // Set key/value
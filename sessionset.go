@@ -0,0 +1,142 @@
+package pigeon
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// 会话集合
+type sessionSet interface {
+	add(*Session)
+	remove(*Session) bool
+	len() int
+	each(func(*Session) bool)
+	drain() []*Session
+}
+
+// 单锁map实现，配合ChannelHub使用
+type mapSet struct {
+	sessions map[*Session]bool
+	mu       *profiledMutex
+}
+
+func newMapSet(stat *lockStat) *mapSet {
+	return &mapSet{
+		sessions: make(map[*Session]bool),
+		mu:       newProfiledMutex(stat),
+	}
+}
+
+func (m *mapSet) add(s *Session) {
+	m.mu.Lock()
+	m.sessions[s] = true
+	m.mu.Unlock()
+}
+
+func (m *mapSet) remove(s *Session) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[s]; !ok {
+		return false
+	}
+	delete(m.sessions, s)
+	return true
+}
+
+func (m *mapSet) len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions)
+}
+
+func (m *mapSet) each(fn func(*Session) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for s := range m.sessions {
+		if !fn(s) {
+			break
+		}
+	}
+}
+
+func (m *mapSet) drain() []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Session, 0, len(m.sessions))
+	for s := range m.sessions {
+		list = append(list, s)
+		delete(m.sessions, s)
+	}
+	return list
+}
+
+// 分段数量
+const stripeCount = 32
+
+// 分段锁实现，配合LockFree使用，数量由原子计数维护
+type stripedSet struct {
+	stripes [stripeCount]mapSet
+	count   int64
+}
+
+func newStripedSet(stat *lockStat) *stripedSet {
+	set := &stripedSet{}
+	for i := range set.stripes {
+		set.stripes[i] = *newMapSet(stat)
+	}
+	return set
+}
+
+// 按会话地址选择分段
+func (m *stripedSet) stripe(s *Session) *mapSet {
+	h := uintptr(unsafe.Pointer(s))
+	h ^= h >> 17
+	return &m.stripes[(h>>4)%stripeCount]
+}
+
+func (m *stripedSet) add(s *Session) {
+	stripe := m.stripe(s)
+	stripe.mu.Lock()
+	if _, ok := stripe.sessions[s]; !ok {
+		stripe.sessions[s] = true
+		atomic.AddInt64(&m.count, 1)
+	}
+	stripe.mu.Unlock()
+}
+
+func (m *stripedSet) remove(s *Session) bool {
+	if !m.stripe(s).remove(s) {
+		return false
+	}
+	atomic.AddInt64(&m.count, -1)
+	return true
+}
+
+func (m *stripedSet) len() int {
+	return int(atomic.LoadInt64(&m.count))
+}
+
+func (m *stripedSet) each(fn func(*Session) bool) {
+	for i := range m.stripes {
+		stop := false
+		m.stripes[i].each(func(s *Session) bool {
+			if !fn(s) {
+				stop = true
+			}
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+func (m *stripedSet) drain() []*Session {
+	var list []*Session
+	for i := range m.stripes {
+		removed := m.stripes[i].drain()
+		atomic.AddInt64(&m.count, -int64(len(removed)))
+		list = append(list, removed...)
+	}
+	return list
+}
@@ -0,0 +1,191 @@
+package pigeon
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SystemRoom 信鸽推送运行统计的内置房间.
+const SystemRoom = "$system"
+
+// 运行计数器，均为原子操作
+type counters struct {
+	messagesIn       uint64
+	messagesOut      uint64
+	broadcasts       uint64
+	dropped          uint64
+	errors           uint64
+	quotaRejected    uint64
+	asyncDropped     uint64
+	acceptDeferred   uint64
+	sendThrottled    uint64
+	sendWaitNanos    uint64
+	unexpectedBinary uint64
+	rateLimited      uint64
+	writeRetries     uint64
+	keepAliveSkipped uint64
+	tapDropped       uint64
+	budgetDropped    uint64
+	budgetEvicted    uint64
+	budgetClosed     uint64
+}
+
+// Stats 信鸽运行统计.
+type Stats struct {
+	Sessions          int                       `json:"sessions"`                   // 会话数量.
+	Rooms             int                       `json:"rooms"`                      // 房间数量.
+	MessagesIn        uint64                    `json:"messages_in"`                // 累计收到的信息数.
+	MessagesOut       uint64                    `json:"messages_out"`               // 累计发送的信息数.
+	Broadcasts        uint64                    `json:"broadcasts"`                 // 累计广播次数.
+	Dropped           uint64                    `json:"dropped"`                    // 因缓冲区已满丢弃的信息数.
+	Errors            uint64                    `json:"errors"`                     // 累计错误数.
+	QuotaRejected     uint64                    `json:"quota_rejected"`             // 因超出配额被拒绝的连接数.
+	AsyncDropped      uint64                    `json:"async_dropped"`              // 因执行队列已满未执行的异步处理方法数.
+	QueueDepth        int                       `json:"queue_depth"`                // 所有会话缓冲区中待发送的信息数.
+	MaxQueue          int                       `json:"max_queue"`                  // 单个会话缓冲区中待发送的最大信息数.
+	InRate            float64                   `json:"in_rate"`                    // 每秒收到的信息数，仅在推送中计算.
+	OutRate           float64                   `json:"out_rate"`                   // 每秒发送的信息数，仅在推送中计算.
+	Labels            map[string]map[string]int `json:"labels,omitempty"`           // 各标签取值的会话数量.
+	Delivery          Latency                   `json:"delivery"`                   // 采样广播从提交到写入连接的延迟.
+	HubQueue          int                       `json:"hub_queue"`                  // hub广播队列（含溢出队列）中待投递的广播数.
+	HubDropped        uint64                    `json:"hub_dropped"`                // 因hub广播队列已满丢弃的广播数.
+	AcceptDeferred    uint64                    `json:"accept_deferred"`            // 因超出接入速率被推迟的连接数.
+	SendThrottled     uint64                    `json:"send_throttled"`             // 因全局发送预算不足而等待的写入次数.
+	SendThrottledTime time.Duration             `json:"send_throttled_time"`        // 因全局发送预算累计等待的时间.
+	TransportHealth   map[string]int            `json:"transport_health,omitempty"` // 各传输健康状态的会话数量，需开启Config.DiagnosticInterval.
+	UpgradeErrors     map[string]uint64         `json:"upgrade_errors,omitempty"`   // 各分类的升级失败次数，分类见UpgradeNotWebSocket等.
+	TapDropped        uint64                    `json:"tap_dropped"`                // 因房间监听跟不上被丢弃的信息数，见TapRoom.
+	QueuedBytes       int64                     `json:"queued_bytes"`               // 所有会话缓冲区中待发送的字节数，需设置Config.QueuedBytesLimit.
+	BudgetDropped     uint64                    `json:"budget_dropped"`             // 因超出内存预算未进入缓冲区的信息数.
+	BudgetEvicted     uint64                    `json:"budget_evicted"`             // 因超出内存预算从缓冲区中丢弃的信息数.
+	BudgetClosed      uint64                    `json:"budget_closed"`              // 为回收内存以CloseMemoryBudget关闭的会话数.
+	WriteRetries      uint64                    `json:"write_retries"`              // 暂时性写入错误的重试次数，见Config.WriteRetries.
+	KeepAliveSkipped  uint64                    `json:"keepalive_skipped"`          // 设置了TCP保活但找不到底层TCP连接而未调整的连接数.
+	RateLimited       uint64                    `json:"rate_limited"`               // 因超出身份、房间或IP的频率被拒绝的信息及请求数，见UseIdentityLimiter、UseRoomLimiter和UseIPLimiter.
+	UnexpectedBinary  uint64                    `json:"unexpected_binary"`          // 没有处理方法接收、按Config.UnexpectedBinary处理的二进制信息数.
+}
+
+// Stats 获取运行统计快照.
+func (p *Pigeon) Stats() Stats {
+	st := Stats{
+		Sessions:          p.hub.len(),
+		Rooms:             p.hub.rooms.count(),
+		MessagesIn:        atomic.LoadUint64(&p.counters.messagesIn),
+		MessagesOut:       atomic.LoadUint64(&p.counters.messagesOut),
+		Broadcasts:        atomic.LoadUint64(&p.counters.broadcasts),
+		Dropped:           atomic.LoadUint64(&p.counters.dropped),
+		Errors:            atomic.LoadUint64(&p.counters.errors),
+		QuotaRejected:     atomic.LoadUint64(&p.counters.quotaRejected),
+		AsyncDropped:      atomic.LoadUint64(&p.counters.asyncDropped),
+		Labels:            p.labels.snapshot(),
+		Delivery:          p.delivery.latency(),
+		HubQueue:          p.hub.queueDepth(),
+		HubDropped:        atomic.LoadUint64(&p.hub.dropped),
+		AcceptDeferred:    atomic.LoadUint64(&p.counters.acceptDeferred),
+		SendThrottled:     atomic.LoadUint64(&p.counters.sendThrottled),
+		SendThrottledTime: time.Duration(atomic.LoadUint64(&p.counters.sendWaitNanos)),
+		TransportHealth:   p.transportHealth.snapshot(),
+		UpgradeErrors:     p.upgradeErrors.snapshot(),
+		TapDropped:        atomic.LoadUint64(&p.counters.tapDropped),
+		QueuedBytes:       atomic.LoadInt64(&p.memory.queued),
+		BudgetDropped:     atomic.LoadUint64(&p.counters.budgetDropped),
+		BudgetEvicted:     atomic.LoadUint64(&p.counters.budgetEvicted),
+		BudgetClosed:      atomic.LoadUint64(&p.counters.budgetClosed),
+		WriteRetries:      atomic.LoadUint64(&p.counters.writeRetries),
+		KeepAliveSkipped:  atomic.LoadUint64(&p.counters.keepAliveSkipped),
+		RateLimited:       atomic.LoadUint64(&p.counters.rateLimited),
+		UnexpectedBinary:  atomic.LoadUint64(&p.counters.unexpectedBinary),
+	}
+	p.hub.iterator(func(s *Session) bool {
+		n := len(s.output)
+		st.QueueDepth += n
+		if n > st.MaxQueue {
+			st.MaxQueue = n
+		}
+		return true
+	})
+	return st
+}
+
+// 记录错误并交给错误处理方法
+func (p *Pigeon) reportError(s *Session, err error) {
+	atomic.AddUint64(&p.counters.errors, 1)
+	p.call(s, func() {
+		p.errorHandlerOf(s)(s, err)
+	})
+}
+
+// 记录错误并在执行器中异步调用错误处理方法，用于hub等不能被阻塞的流程
+func (p *Pigeon) reportErrorAsync(s *Session, err error) {
+	atomic.AddUint64(&p.counters.errors, 1)
+	p.asyncExecutor.submit(s, func() {
+		p.errorHandlerOf(s)(s, err)
+	})
+}
+
+// 调用错误、pong及发送处理方法，默认在执行器中异步执行，同一会话保持顺序
+func (p *Pigeon) call(s *Session, fn func()) {
+	if p.Config.SyncHandlers {
+		fn()
+		return
+	}
+	p.asyncExecutor.submit(s, fn)
+}
+
+// 定时向SystemRoom推送运行统计，信鸽关闭或重启后退出
+func (p *Pigeon) statsFeed(interval time.Duration) {
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	gen := p.hub.gen()
+	last := p.Stats()
+	for range ticker.C() {
+		if p.hub.closed() || p.hub.gen() != gen {
+			return
+		}
+		st := p.Stats()
+		seconds := interval.Seconds()
+		st.InRate = float64(st.MessagesIn-last.MessagesIn) / seconds
+		st.OutRate = float64(st.MessagesOut-last.MessagesOut) / seconds
+		last = st
+
+		if p.hub.rooms.len(SystemRoom) == 0 {
+			continue
+		}
+		data, err := json.Marshal(st)
+		if err != nil {
+			continue
+		}
+		p.BroadcastRoomNoCopy(SystemRoom, data)
+	}
+}
+
+// 通过expvar发布的运行统计，expvar不能注销，同名的新实例替换变量指向的实例
+var (
+	expvarPigeons   = make(map[string]*atomic.Value)
+	expvarPigeonsMu = &sync.Mutex{}
+)
+
+// 以name为名通过expvar发布运行统计，名称已由本包发布时改为发布p，已被其他变量占用时返回错误
+func (p *Pigeon) publishExpvar(name string) error {
+	expvarPigeonsMu.Lock()
+	defer expvarPigeonsMu.Unlock()
+	if current, ok := expvarPigeons[name]; ok {
+		current.Store(p)
+		return nil
+	}
+	if expvar.Get(name) != nil {
+		return errors.New("expvar " + name + " is already published")
+	}
+	current := &atomic.Value{}
+	current.Store(p)
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return current.Load().(*Pigeon).Stats()
+	}))
+	expvarPigeons[name] = current
+	return nil
+}
@@ -0,0 +1,36 @@
+package pigeon_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+)
+
+// 同名的新实例替换expvar变量指向的实例，名称被其他变量占用时不panic而是记为错误
+func TestExpvarName(t *testing.T) {
+	conf := pigeon.DefaultConfig()
+	conf.ExpvarName = "pigeon_test_stats"
+	first := pigeon.New(conf)
+	defer first.Close()
+	first.Broadcast([]byte("x"))
+	second := pigeon.New(conf)
+	defer second.Close()
+
+	st := &pigeon.Stats{}
+	if err := json.Unmarshal([]byte(expvar.Get(conf.ExpvarName).String()), st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Broadcasts != 0 {
+		t.Fatalf("expvar still reports the first instance: %+v", st)
+	}
+
+	expvar.NewInt("pigeon_test_taken")
+	conf.ExpvarName = "pigeon_test_taken"
+	p := pigeon.New(conf)
+	defer p.Close()
+	if st := p.Stats(); st.Errors != 1 {
+		t.Fatalf("errors = %d, want 1", st.Errors)
+	}
+}
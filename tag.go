@@ -0,0 +1,161 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// 标签表，记录每个标签下的会话
+type tagTable struct {
+	tags map[string]map[*Session]struct{}
+	mu   *sync.RWMutex
+}
+
+func newTagTable() *tagTable {
+	return &tagTable{tags: make(map[string]map[*Session]struct{}), mu: &sync.RWMutex{}}
+}
+
+func (t *tagTable) add(tag string, s *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	members, ok := t.tags[tag]
+	if !ok {
+		members = make(map[*Session]struct{})
+		t.tags[tag] = members
+	}
+	members[s] = struct{}{}
+}
+
+// 移除会话，标签下没有会话时将其删除
+func (t *tagTable) remove(tag string, s *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	members, ok := t.tags[tag]
+	if !ok {
+		return
+	}
+	delete(members, s)
+	if len(members) == 0 {
+		delete(t.tags, tag)
+	}
+}
+
+// 将多个标签下不在seen中的会话追加到list
+func (t *tagTable) union(tags []string, seen map[*Session]struct{}, list []*Session) []*Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, tag := range tags {
+		for s := range t.tags[tag] {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func (t *tagTable) len(tag string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.tags[tag])
+}
+
+// Tag 为会话添加标签，广播可按标签选择会话，见BroadcastTags和BroadcastSelect.
+// 与房间不同，标签没有容量、模式和可靠投递等设置，会话关闭时自动移除.
+func (s *Session) Tag(tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.open {
+		return ErrSessionClosed
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]struct{}, len(tags))
+	}
+	for _, tag := range tags {
+		if _, ok := s.tags[tag]; ok {
+			continue
+		}
+		s.tags[tag] = struct{}{}
+		s.pigeon.hub.tags.add(tag, s)
+	}
+	return nil
+}
+
+// Untag 移除会话的标签.
+func (s *Session) Untag(tags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		if _, ok := s.tags[tag]; !ok {
+			continue
+		}
+		delete(s.tags, tag)
+		s.pigeon.hub.tags.remove(tag, s)
+	}
+}
+
+// HasTag 判断会话是否有某个标签.
+func (s *Session) HasTag(tag string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.tags[tag]
+	return ok
+}
+
+// Tags 获取会话的全部标签.
+func (s *Session) Tags() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tags := make([]string, 0, len(s.tags))
+	for tag := range s.tags {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// 会话关闭后移除全部标签
+func (s *Session) clearTags() {
+	s.mu.Lock()
+	tags := s.tags
+	s.tags = nil
+	s.mu.Unlock()
+
+	for tag := range tags {
+		s.pigeon.hub.tags.remove(tag, s)
+	}
+}
+
+// TagLen 获取有某个标签的会话数量.
+func (p *Pigeon) TagLen(tag string) int {
+	return p.hub.tags.len(tag)
+}
+
+// BroadcastTags 向有任一标签的会话广播消息，有多个标签的会话只收到一次.
+func (p *Pigeon) BroadcastTags(tags []string, msg []byte) error {
+	if len(tags) == 0 {
+		return errors.New("no tags to broadcast")
+	}
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), tags: tags})
+}
+
+// BroadcastTagsBinary 向有任一标签的会话广播二进制消息，有多个标签的会话只收到一次.
+func (p *Pigeon) BroadcastTagsBinary(tags []string, msg []byte) error {
+	if len(tags) == 0 {
+		return errors.New("no tags to broadcast")
+	}
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), tags: tags})
+}
+
+// BroadcastSelect 向选择器选中的会话广播消息：取房间成员与标签会话的并集再按过滤器过滤，每个会话只收到一次.
+// 房间和标签均为空时选择全部会话.
+func (p *Pigeon) BroadcastSelect(sel Selector, msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.TextMessage, message: copyBytes(msg), rooms: sel.Rooms, tags: sel.Tags, filter: sel.Filter})
+}
+
+// BroadcastSelectBinary 向选择器选中的会话广播二进制消息，见BroadcastSelect.
+func (p *Pigeon) BroadcastSelectBinary(sel Selector, msg []byte) error {
+	return p.dispatch(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg), rooms: sel.Rooms, tags: sel.Tags, filter: sel.Filter})
+}
@@ -0,0 +1,123 @@
+package pigeon_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/crow-hugin/pigeon"
+	"github.com/crow-hugin/pigeon/pigeontest"
+)
+
+// 按路径查询参数加入房间、添加标签的测试服务器，如/?rooms=a,b&tags=x
+func newTargetServer(t *testing.T, conf *pigeon.Config) *pigeontest.Server {
+	srv := pigeontest.NewServer(conf)
+	t.Cleanup(srv.Close)
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		q := s.Request.URL.Query()
+		for _, room := range split(q.Get("rooms")) {
+			s.Join(room)
+		}
+		s.Tag(split(q.Get("tags"))...)
+		if name := q.Get("name"); name != "" {
+			s.Set("name", name)
+		}
+	})
+	return srv
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// 投递全部广播后发送结束标记，返回客户端在标记前收到的信息
+func received(t *testing.T, srv *pigeontest.Server, clients ...*pigeontest.Client) [][]string {
+	t.Helper()
+	srv.Pigeon.Broadcast([]byte("end"))
+	srv.Flush()
+	all := make([][]string, len(clients))
+	for i, c := range clients {
+		for {
+			m := string(c.Next(t).Data)
+			if m == "end" {
+				break
+			}
+			all[i] = append(all[i], m)
+		}
+		sort.Strings(all[i])
+	}
+	return all
+}
+
+func TestBroadcastTargets(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	ab := srv.Dial(t, "/?rooms=a,b&name=ab")
+	ax := srv.Dial(t, "/?rooms=a&tags=x,y&name=ax")
+	xy := srv.Dial(t, "/?tags=x,y&name=xy")
+	none := srv.Dial(t, "/?name=none")
+
+	notAX := func(s *pigeon.Session) bool {
+		name, _ := s.Get("name")
+		return name != "ax"
+	}
+	srv.Pigeon.BroadcastRooms([]string{"a", "b"}, []byte("rooms"))
+	srv.Pigeon.BroadcastTags([]string{"x", "y"}, []byte("tags"))
+	srv.Pigeon.BroadcastSelect(pigeon.Selector{Rooms: []string{"a", "b"}, Tags: []string{"x"}}, []byte("select"))
+	srv.Pigeon.BroadcastSelect(pigeon.Selector{Rooms: []string{"a"}, Tags: []string{"y"}, Filter: notAX}, []byte("filtered"))
+	srv.Pigeon.BroadcastRoomsFilter([]string{"a", "b"}, []byte("roomsfilter"), notAX)
+	srv.Pigeon.BroadcastSelect(pigeon.Selector{}, []byte("everyone"))
+
+	got := received(t, srv, ab, ax, xy, none)
+	want := [][]string{
+		{"everyone", "filtered", "rooms", "roomsfilter", "select"},
+		{"everyone", "rooms", "select", "tags"},
+		{"everyone", "filtered", "select", "tags"},
+		{"everyone"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+}
+
+func TestTagsRemovedOnClose(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	gone := make(chan struct{}, 1)
+	srv.Pigeon.HandleDisconnect(func(s *pigeon.Session) {
+		if len(s.Tags()) != 0 {
+			t.Error("tags kept after close")
+		}
+		gone <- struct{}{}
+	})
+	c := srv.Dial(t, "/?tags=x,y")
+	if n := srv.Pigeon.TagLen("x"); n != 1 {
+		t.Fatalf("TagLen(x) = %d, want 1", n)
+	}
+	c.Close()
+	<-gone
+	if n := srv.Pigeon.TagLen("x") + srv.Pigeon.TagLen("y"); n != 0 {
+		t.Fatalf("%d tagged sessions after close", n)
+	}
+}
+
+func TestUntag(t *testing.T) {
+	srv := newTargetServer(t, nil)
+	var session *pigeon.Session
+	srv.Pigeon.HandleConnect(func(s *pigeon.Session) {
+		s.Tag("x", "y")
+		session = s
+	})
+	c := srv.Dial(t, "/")
+	session.Untag("x")
+	if session.HasTag("x") || !session.HasTag("y") {
+		t.Fatalf("tags = %v, want [y]", session.Tags())
+	}
+	srv.Pigeon.BroadcastTags([]string{"x"}, []byte("x"))
+	srv.Pigeon.BroadcastTags([]string{"y"}, []byte("y"))
+	if got := received(t, srv, c); !reflect.DeepEqual(got, [][]string{{"y"}}) {
+		t.Fatalf("received %v, want [[y]]", got)
+	}
+}
@@ -0,0 +1,109 @@
+package pigeon
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 每个房间监听的缓冲区容量
+const tapQueueSize = 1024
+
+// 房间的一个监听
+type roomTap struct {
+	sink  func(msg []byte)
+	queue chan []byte
+	stop  chan struct{}
+}
+
+// 各房间的监听
+type tapTable struct {
+	taps map[string][]*roomTap
+	mu   *sync.RWMutex
+}
+
+func newTapTable() *tapTable {
+	return &tapTable{taps: make(map[string][]*roomTap), mu: &sync.RWMutex{}}
+}
+
+// TapRoom 监听房间的广播，供不持有会话的后端服务消费房间的信息流，返回取消监听的方法.
+// 到达本节点的广播都会交给sink，包括经集群消息代理转发的广播. sink在独立的协程中按顺序调用，
+// 不阻塞广播，跟不上时丢弃的信息计入Stats.TapDropped. 收到的信息由所有会话共享，不得修改.
+func (p *Pigeon) TapRoom(room string, sink func(msg []byte)) func() {
+	tap := &roomTap{sink: sink, queue: make(chan []byte, tapQueueSize), stop: make(chan struct{})}
+	t := p.taps
+	t.mu.Lock()
+	t.taps[room] = append(t.taps[room], tap)
+	t.mu.Unlock()
+	go tap.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			taps := t.taps[room]
+			for i, other := range taps {
+				if other == tap {
+					taps = append(taps[:i:i], taps[i+1:]...)
+					break
+				}
+			}
+			if len(taps) == 0 {
+				delete(t.taps, room)
+			} else {
+				t.taps[room] = taps
+			}
+			t.mu.Unlock()
+			close(tap.stop)
+		})
+	}
+}
+
+func (tap *roomTap) run() {
+	for {
+		select {
+		case msg := <-tap.queue:
+			tap.sink(msg)
+		case <-tap.stop:
+			return
+		}
+	}
+}
+
+// 将房间广播交给监听
+func (p *Pigeon) tap(m *envelope) {
+	if len(m.rooms) == 0 {
+		return
+	}
+	t := p.taps
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.taps) == 0 {
+		return
+	}
+	for _, room := range m.rooms {
+		for _, tap := range t.taps[room] {
+			select {
+			case tap.queue <- m.message:
+			default:
+				atomic.AddUint64(&p.counters.tapDropped, 1)
+			}
+		}
+	}
+}
+
+// TapBroker 不运行信鸽实例，直接通过集群消息代理消费房间的广播，用于订阅代理的后端服务.
+// 全部节点发往该房间的广播交给sink，被代理重复投递的广播只交给一次. sink在代理的投递协程中调用.
+func TapBroker(b Broker, room string, sink func(msg *BrokerMessage)) (cancel func(), err error) {
+	st := newBrokerState()
+	st.resetDedup()
+	return b.Subscribe(func(msg *BrokerMessage) {
+		for _, r := range msg.Rooms {
+			if r == room {
+				if st.claim(msg.ID) {
+					sink(msg)
+				}
+				return
+			}
+		}
+	})
+}
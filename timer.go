@@ -0,0 +1,110 @@
+package pigeon
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer 会话定时器，会话关闭或被Detach时自动停止.
+type Timer struct {
+	session *Session
+	fn      func()
+	period  time.Duration // 重复执行的间隔，为0时只执行一次.
+	timer   *funcTimer
+	stopped bool // 已停止或只执行一次的定时器已触发.
+	mu      *sync.Mutex
+}
+
+// After 在d之后执行fn，会话在此之前关闭时不执行. fn与错误、pong处理方法一样在执行器中执行，同一会话保持顺序.
+func (s *Session) After(d time.Duration, fn func()) *Timer {
+	return s.startTimer(d, 0, fn)
+}
+
+// Every 每隔d执行一次fn，直到调用Timer.Stop或会话关闭，d须大于0. 间隔从上次触发开始计算.
+func (s *Session) Every(d time.Duration, fn func()) *Timer {
+	if d <= 0 {
+		panic("non-positive interval for Session.Every")
+	}
+	return s.startTimer(d, d, fn)
+}
+
+func (s *Session) startTimer(d, period time.Duration, fn func()) *Timer {
+	t := &Timer{session: s, fn: fn, period: period, mu: &sync.Mutex{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.open {
+		t.stopped = true
+		return t
+	}
+	if s.timers == nil {
+		s.timers = make(map[*Timer]struct{})
+	}
+	s.timers[t] = struct{}{}
+	t.mu.Lock()
+	t.timer = afterFunc(s.pigeon.clock, d, t.fire)
+	t.mu.Unlock()
+	return t
+}
+
+// Stop 停止定时器，返回false表示定时器已停止或只执行一次的定时器已触发. 已进入执行器的fn仍会执行.
+func (t *Timer) Stop() bool {
+	if !t.stop() {
+		return false
+	}
+	s := t.session
+	s.mu.Lock()
+	delete(s.timers, t)
+	s.mu.Unlock()
+	return true
+}
+
+func (t *Timer) stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	t.timer.Stop()
+	return true
+}
+
+// 到期时将fn提交到执行器，重复执行的定时器重新计时
+func (t *Timer) fire() {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	once := t.period == 0
+	if once {
+		t.stopped = true
+	} else {
+		t.timer.Reset(t.period)
+	}
+	t.mu.Unlock()
+
+	s := t.session
+	if once {
+		s.mu.Lock()
+		delete(s.timers, t)
+		s.mu.Unlock()
+	}
+	s.pigeon.call(s, func() {
+		if s.closed() {
+			return
+		}
+		t.fn()
+	})
+}
+
+// 停止会话的全部定时器，会话关闭或被Detach后调用
+func (s *Session) stopTimers() {
+	s.mu.Lock()
+	timers := s.timers
+	s.timers = nil
+	s.mu.Unlock()
+	for t := range timers {
+		t.stop()
+	}
+}
@@ -0,0 +1,135 @@
+package pigeon
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// 主题订阅的控制指令.
+const (
+	ControlSubscribe   = "subscribe"   // 订阅主题.
+	ControlUnsubscribe = "unsubscribe" // 取消订阅主题.
+)
+
+// ErrUnknownTopic 主题不存在.
+var ErrUnknownTopic = errors.New("unknown topic")
+
+// Topic 二进制主题流，订阅时先收到快照，随后收到快照之后发布的全部信息，两者之间没有遗漏和重复.
+// 同一会话订阅多个主题时，信息内容需自行携带主题标识.
+type Topic struct {
+	name        string
+	pigeon      *Pigeon
+	snapshot    func(topic string) ([]byte, error)
+	subscribers map[*Session]struct{}
+	mu          *sync.Mutex
+}
+
+// RegisterTopic 注册主题，snapshot返回订阅时发送的压缩快照，为nil或返回nil时不发送快照.
+// snapshot与Publish互斥执行，其中不能调用该主题的Publish.
+func (p *Pigeon) RegisterTopic(name string, snapshot func(topic string) ([]byte, error)) *Topic {
+	p.topicMu.Lock()
+	defer p.topicMu.Unlock()
+	if t, ok := p.topics[name]; ok {
+		t.mu.Lock()
+		t.snapshot = snapshot
+		t.mu.Unlock()
+		return t
+	}
+	t := &Topic{
+		name:        name,
+		pigeon:      p,
+		snapshot:    snapshot,
+		subscribers: make(map[*Session]struct{}),
+		mu:          &sync.Mutex{},
+	}
+	p.topics[name] = t
+	return t
+}
+
+// Topic 获取已注册的主题.
+func (p *Pigeon) Topic(name string) (*Topic, bool) {
+	p.topicMu.RLock()
+	defer p.topicMu.RUnlock()
+	t, ok := p.topics[name]
+	return t, ok
+}
+
+// Name 获取主题名.
+func (t *Topic) Name() string {
+	return t.name
+}
+
+// Subscribe 会话订阅主题，先写入快照再开始接收发布的信息，已订阅时不重复发送快照.
+func (t *Topic) Subscribe(s *Session) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subscribers[s]; ok {
+		return nil
+	}
+	if t.snapshot != nil {
+		data, err := t.snapshot(t.name)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			if err := s.enqueue(&envelope{t: websocket.BinaryMessage, message: data}); err != nil {
+				return err
+			}
+		}
+	}
+	t.subscribers[s] = struct{}{}
+	return nil
+}
+
+// Unsubscribe 会话取消订阅主题.
+func (t *Topic) Unsubscribe(s *Session) {
+	t.mu.Lock()
+	delete(t.subscribers, s)
+	t.mu.Unlock()
+}
+
+// Publish 向订阅者发布二进制信息. 写入缓冲区失败的会话无法保证连续，会被取消订阅.
+func (t *Topic) Publish(msg []byte) {
+	msg = copyBytes(msg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for s := range t.subscribers {
+		if err := s.enqueue(&envelope{t: websocket.BinaryMessage, message: msg}); err != nil {
+			delete(t.subscribers, s)
+			if !s.closed() {
+				t.pigeon.reportError(s, err)
+			}
+		}
+	}
+}
+
+// Subscribers 获取订阅者数量.
+func (t *Topic) Subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subscribers)
+}
+
+// 订阅或取消订阅指定名称的主题
+func (s *Session) subscribe(name string, on bool) error {
+	t, ok := s.pigeon.Topic(name)
+	if !ok {
+		return ErrUnknownTopic
+	}
+	if on {
+		return t.Subscribe(s)
+	}
+	t.Unsubscribe(s)
+	return nil
+}
+
+// 取消会话的全部订阅，会话关闭后调用
+func (s *Session) unsubscribeAll() {
+	s.pigeon.topicMu.RLock()
+	defer s.pigeon.topicMu.RUnlock()
+	for _, t := range s.pigeon.topics {
+		t.Unsubscribe(s)
+	}
+}
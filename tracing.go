@@ -0,0 +1,92 @@
+package pigeon
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// span名称.
+const (
+	SpanBroadcast = "pigeon.broadcast" // 广播从提交到hub投递到全部目标会话的缓冲区.
+	SpanWrite     = "pigeon.write"     // 广播写入单个会话的连接，为SpanBroadcast的子span.
+)
+
+// Tracer 广播追踪，可对接OpenTelemetry等追踪系统，按Config.TraceSampleRate采样.
+type Tracer interface {
+	// Start 开始名为name的span，ctx中已有span时作为其子span.
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, TraceSpan)
+	// Inject 将ctx中的追踪上下文编码为字符串，如W3C traceparent，随集群消息代理转发的广播传递.
+	Inject(ctx context.Context) string
+	// Extract 从Inject编码的字符串恢复追踪上下文.
+	Extract(ctx context.Context, header string) context.Context
+}
+
+// TraceSpan 追踪中的span.
+type TraceSpan interface {
+	// End 结束span，err不为nil时标记为失败.
+	End(err error)
+}
+
+// UseTracer 设置广播追踪. 采样的广播在提交时开始SpanBroadcast，写入各会话时开始SpanWrite，
+// 经集群消息代理转发的广播在其他节点继续同一追踪. 为nil时不追踪.
+func (p *Pigeon) UseTracer(t Tracer) {
+	p.tracer = t
+}
+
+// 按采样率为广播开始追踪，来自其他节点且已被追踪的广播总是继续追踪
+func (p *Pigeon) startTrace(ctx context.Context, m *envelope) {
+	t := p.tracer
+	if t == nil {
+		return
+	}
+	if m.remote && m.trace != "" {
+		ctx = t.Extract(ctx, m.trace)
+	} else if rate := p.Config.TraceSampleRate; rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return
+	}
+	attrs := map[string]string{
+		"pigeon.node":  p.nodeID,
+		"pigeon.bytes": strconv.Itoa(len(m.message)),
+	}
+	if len(m.rooms) > 0 {
+		attrs["pigeon.rooms"] = strings.Join(m.rooms, ",")
+	}
+	if m.remote {
+		attrs["pigeon.remote"] = "true"
+	}
+	m.traceCtx, m.span = t.Start(ctx, SpanBroadcast, attrs)
+	m.trace = t.Inject(m.traceCtx)
+}
+
+// 结束广播的span
+func (m *envelope) endTrace(err error) {
+	if m.span != nil {
+		m.span.End(err)
+	}
+}
+
+// 为批次中被追踪的信息开始写入的span，返回结束的方法
+func (s *Session) traceWrite(batch []*envelope) func(error) {
+	t := s.pigeon.tracer
+	if t == nil {
+		return func(error) {}
+	}
+	var spans []TraceSpan
+	for _, m := range batch {
+		if m.traceCtx == nil {
+			continue
+		}
+		_, span := t.Start(m.traceCtx, SpanWrite, map[string]string{
+			"pigeon.session": s.id,
+			"pigeon.bytes":   strconv.Itoa(len(m.message)),
+		})
+		spans = append(spans, span)
+	}
+	return func(err error) {
+		for _, span := range spans {
+			span.End(err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrTxDone 广播事务已提交或已放弃.
+var ErrTxDone = errors.New("broadcast transaction is done")
+
+// BroadcastTx 房间广播事务，暂存的信息在提交时作为一个整体投递：
+// 提交时在房间中的会话按顺序收到全部信息，之后加入的会话一条也收不到.
+type BroadcastTx struct {
+	pigeon *Pigeon
+	room   string
+	parts  []*envelope
+	done   bool
+}
+
+// BeginBroadcast 开始向房间广播的事务.
+func (p *Pigeon) BeginBroadcast(room string) *BroadcastTx {
+	return &BroadcastTx{pigeon: p, room: room}
+}
+
+// Add 暂存文本信息，信息被复制.
+func (tx *BroadcastTx) Add(msg []byte) error {
+	return tx.add(websocket.TextMessage, msg)
+}
+
+// AddBinary 暂存二进制信息，信息被复制.
+func (tx *BroadcastTx) AddBinary(msg []byte) error {
+	return tx.add(websocket.BinaryMessage, msg)
+}
+
+func (tx *BroadcastTx) add(t int, msg []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.parts = append(tx.parts, &envelope{t: t, message: copyBytes(msg)})
+	return nil
+}
+
+// Emit 暂存事件，按已注册的各编解码器分别编码，提交时每个会话只收到其编解码器编码的一份.
+func (tx *BroadcastTx) Emit(event string, data interface{}) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	m, err := tx.pigeon.encodeEvents(event, data)
+	if err != nil {
+		return err
+	}
+	tx.parts = append(tx.parts, m)
+	return nil
+}
+
+// Len 获取暂存的信息数量.
+func (tx *BroadcastTx) Len() int {
+	return len(tx.parts)
+}
+
+// Commit 提交事务. 会话缓冲区的剩余容量不足以容纳全部信息时，该会话一条也不会收到.
+// 事务中的信息不经过可靠投递、webhook和桥接.
+func (tx *BroadcastTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.parts) == 0 {
+		return nil
+	}
+	p := tx.pigeon
+	if p.hub.closed() {
+		return errors.New("pigeon instance is closed")
+	}
+	atomic.AddUint64(&p.counters.broadcasts, 1)
+	rooms := []string{tx.room}
+	return p.hub.send(context.Background(), &envelope{t: tx.parts[0].t, rooms: rooms, parts: tx.parts, room: p.roomMetrics.publish(rooms)})
+}
+
+// Rollback 放弃事务.
+func (tx *BroadcastTx) Rollback() {
+	tx.done = true
+	tx.parts = nil
+}
+
+// 投递事务中的全部信息，剩余容量不足时全部放弃
+func (h *hub) enqueueParts(s *Session, m *envelope, push func(*envelope) error) error {
+	var err error
+	parts := m.partsFor(s)
+	if cap(s.output)-len(s.output) < len(parts) {
+		atomic.AddUint64(&s.pigeon.counters.dropped, uint64(len(parts)))
+		err = errors.New("session message buffer has no room for the transaction")
+		for _, part := range parts {
+			s.dropped(part, err)
+		}
+	} else {
+		for _, part := range parts {
+			if err = push(part); err != nil {
+				break
+			}
+		}
+	}
+	return err
+}
+
+// 获取会话要收到的事务信息，跳过按其他编解码器编码的事件
+func (m *envelope) partsFor(s *Session) []*envelope {
+	for i, part := range m.parts {
+		if part.payloads != nil {
+			parts := append([]*envelope(nil), m.parts[:i]...)
+			for _, part := range m.parts[i:] {
+				if part.payloads == nil {
+					parts = append(parts, part)
+				} else if payload := part.payloads[s.codec.Name()]; payload != nil {
+					parts = append(parts, payload)
+				}
+			}
+			return parts
+		}
+	}
+	return m.parts
+}
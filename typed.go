@@ -0,0 +1,43 @@
+package pigeon
+
+// EventDecodeError 事件数据无法解码为处理方法要求的类型，交给HandleEventError的处理方法.
+type EventDecodeError struct {
+	Event string
+	Err   error
+}
+
+func (e *EventDecodeError) Error() string {
+	return "decode event " + e.Event + ": " + e.Err.Error()
+}
+
+func (e *EventDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// OnEvent 注册强类型的事件处理方法，事件数据使用会话的编解码器解码为T后传入.
+// 解码失败时不调用fn，以EventDecodeError交给事件错误处理方法.
+func OnEvent[T any](p *Pigeon, event string, fn func(s *Session, payload T) error) *EventRoute {
+	return p.On(event, typedEventHandler(fn))
+}
+
+// OnEventV 为事件的版本v注册强类型的处理方法，新版本的数据可使用不同的类型.
+func OnEventV[T any](r *EventRoute, v int, fn func(s *Session, payload T) error) *EventRoute {
+	return r.OnV(v, typedEventHandler(fn))
+}
+
+func typedEventHandler[T any](fn func(s *Session, payload T) error) handleEventFunc {
+	return func(s *Session, ev *Event) error {
+		var payload T
+		if len(ev.Data) > 0 {
+			if err := s.Decode(ev.Data, &payload); err != nil {
+				return &EventDecodeError{Event: ev.Name, Err: err}
+			}
+		}
+		return fn(s, payload)
+	}
+}
+
+// EmitTyped 向会话发送强类型的事件.
+func EmitTyped[T any](s *Session, event string, payload T) error {
+	return s.Emit(event, payload)
+}
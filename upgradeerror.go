@@ -0,0 +1,88 @@
+package pigeon
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 升级失败的分类，用于统计和HandleUpgradeError的处理方法区分扫描流量与配置错误的客户端.
+// TLS握手失败发生在http.Server中，不会到达信鸽，需通过http.Server.ErrorLog观察.
+const (
+	UpgradeNotWebSocket = "not_websocket" // 缺少Upgrade、Connection头或不是GET请求，多为扫描或普通HTTP请求.
+	UpgradeBadVersion   = "bad_version"   // 不支持的Sec-WebSocket-Version.
+	UpgradeBadOrigin    = "bad_origin"    // 未通过Upgrader.CheckOrigin.
+	UpgradeBadKey       = "bad_key"       // Sec-WebSocket-Key无效.
+	UpgradeBadRequest   = "bad_request"   // 其他不合规的握手，如握手完成前发送数据.
+	UpgradeHijack       = "hijack"        // 无法接管连接，多为中间件包装了ResponseWriter.
+	UpgradeNetwork      = "network"       // 写入握手应答时连接出错.
+)
+
+var upgradeErrorClasses = []string{
+	UpgradeNotWebSocket,
+	UpgradeBadVersion,
+	UpgradeBadOrigin,
+	UpgradeBadKey,
+	UpgradeBadRequest,
+	UpgradeHijack,
+	UpgradeNetwork,
+}
+
+type handleUpgradeErrorFunc func(*http.Request, error)
+
+// 各分类的升级失败次数，下标与upgradeErrorClasses对应
+type upgradeErrorCounts [7]uint64
+
+// HandleUpgradeError 升级为WebSocket连接失败时的处理方法，在请求的协程中调用，可通过UpgradeErrorClass获取分类.
+// 被封禁、排空或超出配额等在升级前拒绝的请求不会调用.
+func (p *Pigeon) HandleUpgradeError(fn func(*http.Request, error)) {
+	p.upgradeErrorHandler = fn
+}
+
+// UpgradeErrorClass 获取Upgrader.Upgrade返回的错误的分类，见UpgradeNotWebSocket等.
+func UpgradeErrorClass(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "not using the websocket protocol"):
+		return UpgradeNotWebSocket
+	case strings.Contains(msg, "Sec-Websocket-Version"):
+		return UpgradeBadVersion
+	case strings.Contains(msg, "CheckOrigin"):
+		return UpgradeBadOrigin
+	case strings.Contains(msg, "Sec-WebSocket-Key"):
+		return UpgradeBadKey
+	case strings.Contains(msg, "Hijack"), strings.Contains(msg, "hijack"):
+		return UpgradeHijack
+	case strings.HasPrefix(msg, "websocket: "):
+		return UpgradeBadRequest
+	}
+	return UpgradeNetwork
+}
+
+// 记录升级失败并交给处理方法
+func (p *Pigeon) upgradeFailed(r *http.Request, err error) {
+	class := UpgradeErrorClass(err)
+	for i, c := range upgradeErrorClasses {
+		if c == class {
+			atomic.AddUint64(&p.upgradeErrors[i], 1)
+			break
+		}
+	}
+	if p.upgradeErrorHandler != nil {
+		p.upgradeErrorHandler(r, err)
+	}
+}
+
+// 各分类的升级失败次数，没有失败时返回nil
+func (c *upgradeErrorCounts) snapshot() map[string]uint64 {
+	var counts map[string]uint64
+	for i, class := range upgradeErrorClasses {
+		if n := atomic.LoadUint64(&c[i]); n > 0 {
+			if counts == nil {
+				counts = make(map[string]uint64, len(upgradeErrorClasses))
+			}
+			counts[class] = n
+		}
+	}
+	return counts
+}
@@ -0,0 +1,120 @@
+package pigeon
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Metric 会话资源用量的指标.
+type Metric int
+
+const (
+	MetricHandlerTime Metric = iota // 处理方法累计耗时.
+	MetricBytesIn                   // 收到的字节数.
+	MetricBytesOut                  // 发送的字节数.
+	MetricMessagesIn                // 收到的信息数.
+	MetricMessagesOut               // 发送的信息数.
+	MetricAlloc                     // 估算的内存分配字节数.
+)
+
+// 指标名称，用于管理接口的查询参数
+var metricNames = map[string]Metric{
+	"handler_time": MetricHandlerTime,
+	"bytes_in":     MetricBytesIn,
+	"bytes_out":    MetricBytesOut,
+	"messages_in":  MetricMessagesIn,
+	"messages_out": MetricMessagesOut,
+	"alloc":        MetricAlloc,
+}
+
+// 会话资源用量计数器，均为原子操作
+type usageCounters struct {
+	handlerNanos int64
+	bytesIn      uint64
+	bytesOut     uint64
+	messagesIn   uint64
+	messagesOut  uint64
+	allocBytes   uint64
+}
+
+// SessionUsage 会话资源用量快照.
+type SessionUsage struct {
+	ID          string        `json:"id"`
+	RemoteIP    string        `json:"remote_ip"`
+	ConnectedAt time.Time     `json:"connected_at"`
+	HandlerTime time.Duration `json:"handler_time"` // 控制指令、事件和信息处理方法的累计耗时.
+	BytesIn     uint64        `json:"bytes_in"`
+	BytesOut    uint64        `json:"bytes_out"`
+	MessagesIn  uint64        `json:"messages_in"`
+	MessagesOut uint64        `json:"messages_out"`
+	AllocBytes  uint64        `json:"alloc_bytes"` // 估算值，包含收到信息的缓冲区和为该会话单独编码的信息.
+}
+
+// 按指标取值
+func (u *SessionUsage) value(by Metric) uint64 {
+	switch by {
+	case MetricHandlerTime:
+		return uint64(u.HandlerTime)
+	case MetricBytesIn:
+		return u.BytesIn
+	case MetricBytesOut:
+		return u.BytesOut
+	case MetricMessagesIn:
+		return u.MessagesIn
+	case MetricMessagesOut:
+		return u.MessagesOut
+	case MetricAlloc:
+		return u.AllocBytes
+	}
+	return 0
+}
+
+// Usage 获取会话资源用量.
+func (s *Session) Usage() SessionUsage {
+	return SessionUsage{
+		ID:          s.id,
+		RemoteIP:    s.RemoteIP(),
+		ConnectedAt: s.connectedAt,
+		HandlerTime: time.Duration(atomic.LoadInt64(&s.usage.handlerNanos)),
+		BytesIn:     atomic.LoadUint64(&s.usage.bytesIn),
+		BytesOut:    atomic.LoadUint64(&s.usage.bytesOut),
+		MessagesIn:  atomic.LoadUint64(&s.usage.messagesIn),
+		MessagesOut: atomic.LoadUint64(&s.usage.messagesOut),
+		AllocBytes:  atomic.LoadUint64(&s.usage.allocBytes),
+	}
+}
+
+// TopSessions 获取按指标排序的用量最大的n个会话.
+func (p *Pigeon) TopSessions(by Metric, n int) []SessionUsage {
+	var all []SessionUsage
+	p.hub.iterator(func(s *Session) bool {
+		all = append(all, s.Usage())
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].value(by) > all[j].value(by)
+	})
+	if n > 0 && len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// 记录收到的信息
+func (s *Session) recordIn(size int) {
+	atomic.AddUint64(&s.usage.messagesIn, 1)
+	atomic.AddUint64(&s.usage.bytesIn, uint64(size))
+	s.recordAlloc(size)
+}
+
+// 记录为该会话单独分配的内存
+func (s *Session) recordAlloc(size int) {
+	atomic.AddUint64(&s.usage.allocBytes, uint64(size))
+}
+
+// 记录发送的信息
+func (s *Session) recordOut(messages, size int) {
+	atomic.AddUint64(&s.usage.messagesOut, uint64(messages))
+	atomic.AddUint64(&s.usage.bytesOut, uint64(size))
+}
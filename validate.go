@@ -0,0 +1,43 @@
+package pigeon
+
+// EventError 校验失败时自动回复的事件名.
+const EventError = "error"
+
+// EventErrorReply 校验失败时自动回复的内容.
+type EventErrorReply struct {
+	Event string `json:"event"`
+	Error string `json:"error"`
+}
+
+type validateEventFunc func(*Session, *Event) error
+type handleInvalidMessageFunc func(*Session, string, error)
+
+// Validate 为事件注册校验方法，校验在处理方法之前按注册顺序执行，返回错误时不再调用处理方法.
+func (p *Pigeon) Validate(event string, fn func(*Session, *Event) error) {
+	p.validators[event] = append(p.validators[event], fn)
+}
+
+// HandleInvalidMessage 事件未通过校验时的处理方法.
+func (p *Pigeon) HandleInvalidMessage(fn func(*Session, string, error)) {
+	p.invalidMessageHandler = fn
+}
+
+// 执行事件的校验方法
+func (p *Pigeon) validateEvent(s *Session, ev *Event) error {
+	for _, fn := range p.validators[ev.Name] {
+		if err := fn(s, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 处理未通过校验的事件，按配置自动回复错误
+func (p *Pigeon) invalidEvent(s *Session, ev *Event, err error) {
+	if p.invalidMessageHandler != nil {
+		p.invalidMessageHandler(s, ev.Name, err)
+	}
+	if p.Config.EventErrorReply {
+		s.Emit(EventError, &EventErrorReply{Event: ev.Name, Error: err.Error()})
+	}
+}
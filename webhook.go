@@ -0,0 +1,301 @@
+package pigeon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 推送到webhook的请求头.
+const (
+	WebhookRoomHeader      = "X-Pigeon-Room"
+	WebhookTimestampHeader = "X-Pigeon-Timestamp"
+	WebhookSignatureHeader = "X-Pigeon-Signature" // sha256=十六进制HMAC，签名内容为 时间戳 + "." + 请求体.
+)
+
+// ErrWebhookQueueFull webhook推送队列已满，信息直接进入死信.
+var ErrWebhookQueueFull = errors.New("webhook queue is full")
+
+const (
+	defaultWebhookRetries   = 3
+	defaultWebhookBackoff   = time.Second
+	defaultWebhookTimeout   = 10 * time.Second
+	defaultWebhookQueueSize = 1024
+)
+
+// Webhook 房间广播的webhook镜像，广播内容以POST请求异步推送.
+type Webhook struct {
+	URL          string                             // 推送地址，建议使用HTTPS.
+	Secret       []byte                             // 签名密钥，为空时不签名.
+	Filter       func(room string, msg []byte) bool // 只推送返回true的广播，为nil时全部推送.
+	MaxRetries   int                                // 最大重试次数，默认3.
+	RetryBackoff time.Duration                      // 首次重试间隔，之后每次翻倍，默认1秒.
+	Timeout      time.Duration                      // 单次请求超时，默认10秒.
+	QueueSize    int                                // 推送队列容量，默认1024.
+	Client       *http.Client                       // 为nil时使用按Timeout设置的默认客户端.
+}
+
+type webhookDeadLetterFunc func(room string, msg []byte, err error)
+
+// 待推送的广播
+type webhookDelivery struct {
+	room   string
+	binary bool
+	body   []byte
+}
+
+// 单个webhook的推送协程
+type webhookSink struct {
+	Webhook
+	queue chan *webhookDelivery
+	stop  chan struct{}
+	clock Clock // 重试退避使用的时钟，签名时间戳始终为真实时间.
+}
+
+// AddWebhook 为房间添加webhook镜像，房间的每次广播都会推送到该地址.
+// 重试用尽、不可重试的响应或队列已满的信息交给HandleWebhookDeadLetter设置的处理方法.
+func (p *Pigeon) AddWebhook(room string, wh Webhook) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.New("webhook url must be http or https")
+	}
+	if wh.MaxRetries <= 0 {
+		wh.MaxRetries = defaultWebhookRetries
+	}
+	if wh.RetryBackoff <= 0 {
+		wh.RetryBackoff = defaultWebhookBackoff
+	}
+	if wh.Timeout <= 0 {
+		wh.Timeout = defaultWebhookTimeout
+	}
+	if wh.QueueSize <= 0 {
+		wh.QueueSize = defaultWebhookQueueSize
+	}
+	if wh.Client == nil {
+		wh.Client = &http.Client{Timeout: wh.Timeout}
+	}
+
+	sink := &webhookSink{
+		Webhook: wh,
+		queue:   make(chan *webhookDelivery, wh.QueueSize),
+		stop:    make(chan struct{}),
+		clock:   p.clock,
+	}
+	p.webhookMu.Lock()
+	p.webhooks[room] = append(p.webhooks[room], sink)
+	p.webhookMu.Unlock()
+
+	go p.runWebhook(sink)
+	return nil
+}
+
+// RemoveWebhooks 移除房间的全部webhook，队列中尚未推送的信息被丢弃.
+func (p *Pigeon) RemoveWebhooks(room string) {
+	p.webhookMu.Lock()
+	sinks := p.webhooks[room]
+	delete(p.webhooks, room)
+	p.webhookMu.Unlock()
+
+	for _, sink := range sinks {
+		close(sink.stop)
+	}
+}
+
+// HandleWebhookDeadLetter 推送失败的信息的处理方法.
+func (p *Pigeon) HandleWebhookDeadLetter(fn func(room string, msg []byte, err error)) {
+	p.webhookDeadLetterHandler = fn
+}
+
+// 将房间广播放入webhook推送队列
+func (p *Pigeon) mirror(m *envelope) {
+	if len(m.rooms) == 0 {
+		return
+	}
+	p.webhookMu.RLock()
+	defer p.webhookMu.RUnlock()
+	if len(p.webhooks) == 0 {
+		return
+	}
+	for _, room := range m.rooms {
+		for _, sink := range p.webhooks[room] {
+			if sink.Filter != nil && !sink.Filter(room, m.message) {
+				continue
+			}
+			d := &webhookDelivery{room: room, binary: m.t == websocket.BinaryMessage, body: m.message}
+			select {
+			case sink.queue <- d:
+			default:
+				p.deadLetter(d, ErrWebhookQueueFull)
+			}
+		}
+	}
+}
+
+func (p *Pigeon) runWebhook(sink *webhookSink) {
+	for {
+		select {
+		case d := <-sink.queue:
+			if err := sink.deliver(d); err != nil {
+				p.deadLetter(d, err)
+			}
+		case <-sink.stop:
+			return
+		}
+	}
+}
+
+// 推送并按退避间隔重试，webhook被移除时放弃
+func (sink *webhookSink) deliver(d *webhookDelivery) error {
+	backoff := sink.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= sink.MaxRetries; attempt++ {
+		if attempt > 0 {
+			t := sink.clock.NewTimer(backoff)
+			select {
+			case <-t.C():
+				backoff *= 2
+			case <-sink.stop:
+				t.Stop()
+				return err
+			}
+		}
+		var retry bool
+		if retry, err = sink.post(d); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// 发送一次请求，返回错误是否可以重试
+func (sink *webhookSink) post(d *webhookDelivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, sink.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	if d.binary {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	} else {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	req.Header.Set(WebhookRoomHeader, d.room)
+	req.Header.Set(WebhookTimestampHeader, ts)
+	if len(sink.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(sink.Secret, ts, d.body))
+	}
+
+	resp, err := sink.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = errors.New("webhook " + sink.URL + " responded " + resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// 计算webhook签名
+func signWebhook(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 交给死信处理方法，未设置时作为错误上报
+func (p *Pigeon) deadLetter(d *webhookDelivery, err error) {
+	if p.webhookDeadLetterHandler != nil {
+		p.asyncExecutor.submit(nil, func() { p.webhookDeadLetterHandler(d.room, d.body, err) })
+		return
+	}
+	p.reportErrorAsync(nil, err)
+}
+
+// WebhookRoomParam 推送到WebhookHandler时指定房间的查询参数.
+const WebhookRoomParam = "room"
+
+// 接收webhook时允许的时间戳偏差
+const webhookTolerance = 5 * time.Minute
+
+// WebhookHandler 接收签名的POST请求并将请求体广播到房间，后端服务无需引入信鸽即可向客户端推送.
+// 签名方式与AddWebhook相同，时间戳与当前时间相差超过5分钟的请求被拒绝.
+// 房间依次取自X-Pigeon-Room请求头、room查询参数和路径的最后一段，Content-Type为application/octet-stream时以二进制广播.
+func (p *Pigeon) WebhookHandler(secret []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, p.Config.MaxMessageSize+1))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > p.Config.MaxMessageSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !validWebhook(secret, r.Header, body) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		room := webhookRoom(r)
+		if room == "" {
+			http.Error(w, "missing room", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Content-Type") == "application/octet-stream" {
+			err = p.BroadcastRoomBinary(room, body)
+		} else {
+			err = p.BroadcastRoomNoCopy(room, body)
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// 校验webhook签名和时间戳
+func validWebhook(secret []byte, h http.Header, body []byte) bool {
+	ts := h.Get(WebhookTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > webhookTolerance || d < -webhookTolerance {
+		return false
+	}
+	sig := strings.TrimPrefix(h.Get(WebhookSignatureHeader), "sha256=")
+	return hmac.Equal([]byte(sig), []byte(signWebhook(secret, ts, body)))
+}
+
+// 解析推送的目标房间
+func webhookRoom(r *http.Request) string {
+	if room := r.Header.Get(WebhookRoomHeader); room != "" {
+		return room
+	}
+	if room := r.URL.Query().Get(WebhookRoomParam); room != "" {
+		return room
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,160 @@
+package pigeon
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+)
+
+// Op 谓词的比较方式.
+type Op string
+
+const (
+	Eq     Op = "eq"     // Keys[Key]等于Value.
+	Ne     Op = "ne"     // Keys[Key]不存在或不等于Value.
+	In     Op = "in"     // Keys[Key]等于Value（切片）中的任意一项.
+	Exists Op = "exists" // Keys[Key]存在.
+	Gt     Op = "gt"     // Keys[Key]为数值且大于Value.
+	Lt     Op = "lt"     // Keys[Key]为数值且小于Value.
+)
+
+// Where 基于会话Keys的广播谓词，可序列化，由hub直接求值，用于替代无法跨节点传递的过滤闭包.
+// Key非空时按Op比较，And、Or非空时分别要求全部、任一子谓词成立，同时设置时均需成立.
+// 数值统一按float64比较，因此经JSON传递后仍可匹配.
+type Where struct {
+	Key   string      `json:"key,omitempty"`
+	Op    Op          `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	And   []Where     `json:"and,omitempty"`
+	Or    []Where     `json:"or,omitempty"`
+}
+
+// Match 判断会话是否满足谓词.
+func (w *Where) Match(s *Session) bool {
+	if w.Key != "" {
+		v, ok := s.Get(w.Key)
+		if !w.compare(v, ok) {
+			return false
+		}
+	}
+	for i := range w.And {
+		if !w.And[i].Match(s) {
+			return false
+		}
+	}
+	if len(w.Or) > 0 {
+		for i := range w.Or {
+			if w.Or[i].Match(s) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// 按比较方式比较会话的取值
+func (w *Where) compare(v interface{}, exists bool) bool {
+	switch w.Op {
+	case Exists:
+		return exists
+	case Ne:
+		return !exists || !equalValue(v, w.Value)
+	case In:
+		if !exists {
+			return false
+		}
+		list := reflect.ValueOf(w.Value)
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			return false
+		}
+		for i := 0; i < list.Len(); i++ {
+			if equalValue(v, list.Index(i).Interface()) {
+				return true
+			}
+		}
+		return false
+	case Gt, Lt:
+		a, ok1 := toFloat(v)
+		b, ok2 := toFloat(w.Value)
+		if !exists || !ok1 || !ok2 {
+			return false
+		}
+		if w.Op == Gt {
+			return a > b
+		}
+		return a < b
+	default:
+		return exists && equalValue(v, w.Value)
+	}
+}
+
+// 比较两个取值，数值统一转换为float64
+func equalValue(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// 校验谓词
+func (w *Where) validate() error {
+	switch w.Op {
+	case "", Eq, Ne, In, Exists, Gt, Lt:
+	default:
+		return errors.New("unknown where op " + string(w.Op))
+	}
+	if w.Key == "" && w.Op != "" {
+		return errors.New("where op requires a key")
+	}
+	for i := range w.And {
+		if err := w.And[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range w.Or {
+		if err := w.Or[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BroadcastWhere 向满足谓词的会话广播消息.
+func (p *Pigeon) BroadcastWhere(msg []byte, w Where) error {
+	return p.broadcastWhere(&envelope{t: websocket.TextMessage, message: copyBytes(msg)}, w)
+}
+
+// BroadcastBinaryWhere 向满足谓词的会话广播二进制消息.
+func (p *Pigeon) BroadcastBinaryWhere(msg []byte, w Where) error {
+	return p.broadcastWhere(&envelope{t: websocket.BinaryMessage, message: copyBytes(msg)}, w)
+}
+
+// BroadcastRoomWhere 向房间内满足谓词的会话广播消息.
+func (p *Pigeon) BroadcastRoomWhere(room string, msg []byte, w Where) error {
+	return p.broadcastWhere(&envelope{t: websocket.TextMessage, message: copyBytes(msg), rooms: []string{room}}, w)
+}
+
+func (p *Pigeon) broadcastWhere(m *envelope, w Where) error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	m.where = &w
+	m.filter = w.Match
+	return p.dispatch(m)
+}
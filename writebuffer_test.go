@@ -0,0 +1,72 @@
+package pigeon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 空闲连接的内存开销，每次迭代建立一个连接并写入一条信息后保持空闲.
+// allocs/op和B/op为建立连接的分配，heap-B/conn为全部连接空闲时每个连接常驻的堆内存，
+// 包括同一进程中客户端的部分，客户端使用写缓冲池以免掩盖服务端的差异.
+func BenchmarkIdleConnection(b *testing.B) {
+	for _, disable := range []bool{false, true} {
+		name := "pool"
+		if disable {
+			name = "nopool"
+		}
+		b.Run(name, func(b *testing.B) {
+			conf := DefaultConfig()
+			conf.DisableWriteBufferPool = disable
+			p := New(conf)
+			sessions := make(chan *Session, 1)
+			p.HandleConnect(func(s *Session) { sessions <- s })
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p.HandleRequest(w, r)
+			}))
+			defer srv.Close()
+			defer p.Close()
+
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+			dialer := &websocket.Dialer{WriteBufferPool: &sync.Pool{}}
+			conns := make([]*websocket.Conn, 0, b.N)
+			defer func() {
+				for _, c := range conns {
+					c.Close()
+				}
+			}()
+			ctx := context.Background()
+			msg := []byte("hello")
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, _, err := dialer.Dial(url, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				conns = append(conns, conn)
+				// 无缓冲池时写缓冲区在连接建立时分配，有缓冲池时只在写入期间持有
+				if err := (<-sessions).WriteSync(ctx, msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := conn.ReadMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(int64(after.HeapInuse)-int64(before.HeapInuse))/float64(b.N), "heap-B/conn")
+		})
+	}
+}
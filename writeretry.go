@@ -0,0 +1,150 @@
+package pigeon
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 默认的写入重试间隔，每次重试翻倍
+const defaultWriteRetryBackoff = 50 * time.Millisecond
+
+// WriteError 写入连接失败，交给HandleError的处理方法，可通过errors.As获取.
+type WriteError struct {
+	Err       error
+	Retryable bool // 是否为超时、系统缓冲区不足等暂时性错误，为false时连接已不可用.
+	Attempts  int  // 失败前的写入次数，含按Config.WriteRetries进行的重试.
+}
+
+func (e *WriteError) Error() string {
+	return "write failed after " + strconv.Itoa(e.Attempts) + " attempt(s): " + e.Err.Error()
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable 判断错误是否为可重试的暂时性写入错误.
+func IsRetryable(err error) bool {
+	var we *WriteError
+	if errors.As(err, &we) {
+		return we.Retryable
+	}
+	return transientWriteError(err)
+}
+
+// 判断底层连接的写入错误是否为暂时性的
+func transientWriteError(err error) bool {
+	if isTimeout(err) {
+		return true
+	}
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOMEM)
+}
+
+// 将写入错误包装为WriteError
+func writeError(err error) *WriteError {
+	var we *WriteError
+	if errors.As(err, &we) {
+		return we
+	}
+	return &WriteError{Err: err, Retryable: transientWriteError(err), Attempts: 1}
+}
+
+// 在底层连接上重试暂时性的写入错误. websocket连接在写入出错后不再可用，因此须在其下层重试，
+// 已写出的部分不会重复写入，帧保持完整. TLS连接写入出错后不再可用，不包装
+type retryConn struct {
+	net.Conn
+	retries   int
+	backoff   time.Duration
+	writeWait time.Duration
+	clock     Clock
+	counter   *uint64
+}
+
+func (c *retryConn) Write(b []byte) (int, error) {
+	written := 0
+	for attempt := 1; ; attempt++ {
+		n, err := c.Conn.Write(b[written:])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		transient := transientWriteError(err)
+		if !transient || attempt > c.retries {
+			// 不实现net.Error，websocket原样返回，保留重试次数和错误分类
+			return written, &WriteError{Err: err, Retryable: transient, Attempts: attempt}
+		}
+		atomic.AddUint64(c.counter, 1)
+		t := c.clock.NewTimer(c.backoff << (attempt - 1))
+		<-t.C()
+		// 底层连接的期限总是真实时间
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+	}
+}
+
+// 劫持连接时包装为retryConn
+type retryHijacker struct {
+	http.ResponseWriter
+	p *Pigeon
+}
+
+func (w *retryHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return conn, rw, nil
+	}
+	conf := w.p.Config
+	backoff := conf.WriteRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultWriteRetryBackoff
+	}
+	return &retryConn{Conn: conn, retries: conf.WriteRetries, backoff: backoff, writeWait: conf.WriteWait, clock: w.p.clock, counter: &w.p.counters.writeRetries}, rw, nil
+}
+
+// 开启写入重试时包装ResponseWriter
+func (p *Pigeon) retryWriter(w http.ResponseWriter) http.ResponseWriter {
+	if p.Config.WriteRetries <= 0 {
+		return w
+	}
+	return &retryHijacker{ResponseWriter: w, p: p}
+}
+
+// 记录写入失败，返回交给错误处理方法的WriteError
+func (s *Session) writeFailed(err error) *WriteError {
+	we := writeError(err)
+	s.mu.Lock()
+	if s.writeErr == nil {
+		s.writeErr = we
+	}
+	s.mu.Unlock()
+	return we
+}
+
+// 会话因暂时性写入错误结束时，按Config.WriteRetryGrace保留完整的会话状态等待客户端恢复，返回是否已保留
+func (s *Session) holdForResume() bool {
+	grace := s.pigeon.Config.WriteRetryGrace
+	if grace <= 0 {
+		return false
+	}
+	s.mu.RLock()
+	we := s.writeErr
+	s.mu.RUnlock()
+	if we == nil || !we.Retryable {
+		return false
+	}
+	s.pigeon.resumes.put(s.State(), s.pigeon.now().Add(grace))
+	return true
+}
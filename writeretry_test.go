@@ -0,0 +1,89 @@
+package pigeon
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 记录等待时长、立即到期的时钟
+type instantClock struct {
+	realClock
+	waits []time.Duration
+}
+
+func (c *instantClock) NewTimer(d time.Duration) ClockTimer {
+	c.waits = append(c.waits, d)
+	return realTimer{time.NewTimer(0)}
+}
+
+// 前几次写入返回EAGAIN的连接
+type flakyConn struct {
+	net.Conn
+	failures int
+	written  []byte
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	if c.failures > 0 {
+		c.failures--
+		return 0, syscall.EAGAIN
+	}
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func (c *flakyConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestRetryConnUsesClock(t *testing.T) {
+	clock := &instantClock{}
+	var counter uint64
+	flaky := &flakyConn{failures: 2}
+	c := &retryConn{Conn: flaky, retries: 3, backoff: time.Hour, writeWait: time.Second, clock: clock, counter: &counter}
+	if n, err := c.Write([]byte("hello")); err != nil || n != 5 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if string(flaky.written) != "hello" || counter != 2 {
+		t.Fatalf("written %q after %d retries", flaky.written, counter)
+	}
+	if want := []time.Duration{time.Hour, 2 * time.Hour}; len(clock.waits) != 2 || clock.waits[0] != want[0] || clock.waits[1] != want[1] {
+		t.Fatalf("waits = %v, want %v", clock.waits, want)
+	}
+}
+
+// 劫持时返回指定连接的ResponseWriter
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+func TestRetrySkipsTLS(t *testing.T) {
+	conf := DefaultConfig()
+	conf.WriteRetries = 3
+	p := New(conf)
+	defer p.Close()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	for _, conn := range []net.Conn{a, tls.Server(a, &tls.Config{})} {
+		w := p.retryWriter(&hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: conn})
+		got, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, retried := got.(*retryConn)
+		if _, isTLS := conn.(*tls.Conn); retried == isTLS {
+			t.Errorf("%T wrapped = %v", conn, retried)
+		}
+	}
+}
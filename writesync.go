@@ -0,0 +1,49 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// WriteSync 写入普通文本信息并等待其写入连接，与缓冲区中的信息保持顺序.
+// ctx结束时返回ctx.Err()，此时信息仍可能在之后被写入.
+func (s *Session) WriteSync(ctx context.Context, msg []byte) error {
+	return s.writeSync(ctx, &envelope{t: websocket.TextMessage, message: msg})
+}
+
+// WriteBinarySync 写入二进制信息并等待其写入连接.
+func (s *Session) WriteBinarySync(ctx context.Context, msg []byte) error {
+	return s.writeSync(ctx, &envelope{t: websocket.BinaryMessage, message: msg})
+}
+
+func (s *Session) writeSync(ctx context.Context, m *envelope) error {
+	m.done = make(chan error, 1)
+	if err := s.enqueue(m); err != nil {
+		return err
+	}
+	select {
+	case err := <-m.done:
+		return err
+	case <-s.closedCh:
+		// 关闭前可能刚好写完
+		select {
+		case err := <-m.done:
+			return err
+		default:
+			return errors.New("session closed before the message was written")
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 通知等待写入结果的调用方
+func notifyWritten(batch []*envelope, err error) {
+	for _, m := range batch {
+		if m.done != nil {
+			m.done <- err
+		}
+	}
+}